@@ -0,0 +1,906 @@
+// Package acme implements an ACME (RFC 8555) server that issues
+// certificates with a signer.Signer.
+//
+// Accounts, orders and authorizations are kept in memory, and forgotten
+// once they expire or, for accounts, go unused; certificates are recorded in the cert db of the signer,
+// if any. Challenges are validated synchronously when the client responds
+// to them, by the Validator registered for their type.
+package acme
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ucosty/cfssl/info"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/signer"
+)
+
+// Status values of ACME resources.
+const (
+	StatusPending     = "pending"
+	StatusReady       = "ready"
+	StatusProcessing  = "processing"
+	StatusValid       = "valid"
+	StatusInvalid     = "invalid"
+	StatusExpired     = "expired"
+	StatusDeactivated = "deactivated"
+)
+
+// OrderLifetime is how long an order and its authorizations are kept,
+// and so how long they may be completed and their certificate fetched.
+const OrderLifetime = 7 * 24 * time.Hour
+
+// AccountLifetime is how long an account without orders is kept after it
+// was last used. Deactivated accounts are kept, so that their keys cannot
+// register again.
+const AccountLifetime = 30 * 24 * time.Hour
+
+// maxNonces bounds the outstanding nonces; the oldest are dropped first.
+const maxNonces = 10000
+
+// maxAccounts bounds the accounts, as anyone may create one.
+const maxAccounts = 10000
+
+// maxOrdersPerAccount bounds the unexpired orders of an account.
+const maxOrdersPerAccount = 100
+
+// maxBodySize bounds the size of a JWS request.
+const maxBodySize = 1 << 16
+
+var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+// An Identifier is a name a certificate is requested for. Only "dns"
+// identifiers are supported.
+type Identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type account struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+
+	id         string
+	key        *JSONWebKey
+	thumbprint string
+	lastUsed   time.Time
+	orders     int
+}
+
+type order struct {
+	Status         string       `json:"status"`
+	Expires        time.Time    `json:"expires"`
+	Identifiers    []Identifier `json:"identifiers"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *Problem     `json:"error,omitempty"`
+
+	id        string
+	accountID string
+	authzIDs  []string
+	cert      []byte
+}
+
+type authorization struct {
+	Identifier Identifier   `json:"identifier"`
+	Status     string       `json:"status"`
+	Expires    time.Time    `json:"expires"`
+	Challenges []*challenge `json:"challenges"`
+	Wildcard   bool         `json:"wildcard,omitempty"`
+
+	id        string
+	accountID string
+}
+
+type challenge struct {
+	Type      string     `json:"type"`
+	URL       string     `json:"url"`
+	Status    string     `json:"status"`
+	Token     string     `json:"token"`
+	Validated *time.Time `json:"validated,omitempty"`
+	Error     *Problem   `json:"error,omitempty"`
+
+	id      string
+	authzID string
+}
+
+// A Server is an http.Handler serving the ACME resources under a path
+// prefix.
+type Server struct {
+	// Validators maps challenge types to the Validators that check
+	// them. Each authorization offers a challenge of every type in the
+	// map; wildcard names are only offered dns-01.
+	Validators map[string]Validator
+	// BaseURL, if not empty, is the external URL of the path prefix,
+	// used in resource URLs instead of the scheme and host of each
+	// request.
+	BaseURL string
+
+	signer  signer.Signer
+	profile string
+	prefix  string
+
+	mu          sync.Mutex
+	nonces      map[string]bool
+	nonceQueue  []string
+	accounts    map[string]*account
+	accountKeys map[string]string
+	orders      map[string]*order
+	authzs      map[string]*authorization
+	challenges  map[string]*challenge
+}
+
+// NewServer returns a Server serving ACME under prefix, which issues
+// certificates with s and the signing profile named profile. It validates
+// http-01 and dns-01 challenges against the network.
+func NewServer(s signer.Signer, profile, prefix string) *Server {
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &Server{
+		Validators: map[string]Validator{
+			ChallengeHTTP01: &HTTP01Validator{},
+			ChallengeDNS01:  &DNS01Validator{},
+		},
+		signer:      s,
+		profile:     profile,
+		prefix:      prefix,
+		nonces:      map[string]bool{},
+		accounts:    map[string]*account{},
+		accountKeys: map[string]string{},
+		orders:      map[string]*order{},
+		authzs:      map[string]*authorization{},
+		challenges:  map[string]*challenge{},
+	}
+}
+
+// A request is a verified JWS request for a resource.
+type request struct {
+	payload []byte
+	base    string
+	id      string
+	jwk     *JSONWebKey
+	account *account
+}
+
+// postAsGet reports whether the request is a POST-as-GET, which has an
+// empty payload.
+func (req *request) postAsGet() bool {
+	return len(req.payload) == 0
+}
+
+// ServeHTTP dispatches requests for the directory, new-nonce, new-account,
+// new-order, account, order, authz, chall, finalize and cert resources.
+func (srv *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !strings.HasPrefix(r.URL.Path, srv.prefix) {
+		writeProblem(w, notFound())
+		return
+	}
+	path := strings.TrimPrefix(r.URL.Path, srv.prefix)
+	resource, id := path, ""
+	if i := strings.Index(path, "/"); i >= 0 {
+		resource, id = path[:i], path[i+1:]
+	}
+
+	base := srv.base(r)
+	w.Header().Set("Link", fmt.Sprintf("<%sdirectory>;rel=\"index\"", base))
+
+	switch resource {
+	case "directory":
+		if r.Method != "GET" {
+			srv.methodNotAllowed(w, "GET")
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"newNonce":   base + "new-nonce",
+			"newAccount": base + "new-account",
+			"newOrder":   base + "new-order",
+			"meta":       map[string]interface{}{"externalAccountRequired": false},
+		})
+		return
+	case "new-nonce":
+		if r.Method != "GET" && r.Method != "HEAD" {
+			srv.methodNotAllowed(w, "GET, HEAD")
+			return
+		}
+		w.Header().Set("Replay-Nonce", srv.newNonce())
+		w.Header().Set("Cache-Control", "no-store")
+		if r.Method == "GET" {
+			w.WriteHeader(http.StatusNoContent)
+		}
+		return
+	}
+
+	handlers := map[string]func(http.ResponseWriter, *request) error{
+		"new-account": srv.handleNewAccount,
+		"account":     srv.handleAccount,
+		"new-order":   srv.handleNewOrder,
+		"order":       srv.handleOrder,
+		"authz":       srv.handleAuthorization,
+		"chall":       srv.handleChallenge,
+		"finalize":    srv.handleFinalize,
+		"cert":        srv.handleCertificate,
+	}
+	handler, ok := handlers[resource]
+	if strings.HasPrefix(resource, "new-") {
+		ok = ok && id == ""
+	} else {
+		ok = ok && id != ""
+	}
+	if !ok {
+		writeProblem(w, notFound())
+		return
+	}
+	if r.Method != "POST" {
+		srv.methodNotAllowed(w, "POST")
+		return
+	}
+
+	w.Header().Set("Replay-Nonce", srv.newNonce())
+	req, err := srv.verify(r, base, path)
+	if err == nil {
+		req.id = id
+		err = handler(w, req)
+	}
+	if err != nil {
+		writeProblem(w, err)
+	}
+}
+
+// base returns the URL of the path prefix.
+func (srv *Server) base(r *http.Request) string {
+	if srv.BaseURL != "" {
+		return strings.TrimSuffix(srv.BaseURL, "/") + "/"
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + srv.prefix
+}
+
+func (srv *Server) methodNotAllowed(w http.ResponseWriter, allow string) {
+	w.Header().Set("Allow", allow)
+	writeProblem(w, problem(errMalformed, http.StatusMethodNotAllowed, "method not allowed"))
+}
+
+// verify checks the JWS of a POST request for the resource at path: its
+// URL and nonce, and its signature by the key of its account or, for new
+// accounts, by the key it carries.
+func (srv *Server) verify(r *http.Request, base, path string) (*request, error) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, problem(errMalformed, http.StatusUnsupportedMediaType, "unsupported content type %q", ct)
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, r.Body, maxBodySize))
+	if err != nil {
+		return nil, malformed("failed to read the request: %v", err)
+	}
+
+	jws, hdr, err := parseJWS(body)
+	if err != nil {
+		return nil, malformed("invalid JWS: %v", err)
+	}
+	if hdr.URL != base+path {
+		return nil, unauthorized("the JWS url %q does not match the request", hdr.URL)
+	}
+	if !srv.useNonce(hdr.Nonce) {
+		return nil, problem(errBadNonce, http.StatusBadRequest, "invalid or reused nonce")
+	}
+
+	req := &request{base: base}
+	if path == "new-account" {
+		if hdr.JWK == nil {
+			return nil, malformed("a new account request must carry a jwk")
+		}
+		req.jwk = hdr.JWK
+	} else {
+		if hdr.KID == "" {
+			return nil, malformed("the request must be signed by an account key (kid)")
+		}
+		srv.mu.Lock()
+		acct := srv.accounts[strings.TrimPrefix(hdr.KID, base+"account/")]
+		srv.mu.Unlock()
+		if acct == nil || hdr.KID != base+"account/"+acct.id {
+			return nil, problem(errAccountDoesNotExist, http.StatusBadRequest, "unknown account %q", hdr.KID)
+		}
+		if acct.Status != StatusValid {
+			return nil, unauthorized("the account is %s", acct.Status)
+		}
+		req.jwk = acct.key
+		req.account = acct
+	}
+
+	pub, err := req.jwk.PublicKey()
+	if err != nil {
+		return nil, problem(errBadSignatureAlgorithm, http.StatusBadRequest, "unsupported key: %v", err)
+	}
+	req.payload, err = jws.verify(hdr.Alg, pub)
+	if err != nil {
+		return nil, malformed("JWS verification failed: %v", err)
+	}
+	if req.account != nil {
+		srv.mu.Lock()
+		req.account.lastUsed = time.Now()
+		srv.mu.Unlock()
+	}
+	return req, nil
+}
+
+func (srv *Server) newNonce() string {
+	nonce := randomID()
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.nonces[nonce] = true
+	srv.nonceQueue = append(srv.nonceQueue, nonce)
+	if len(srv.nonceQueue) > maxNonces {
+		delete(srv.nonces, srv.nonceQueue[0])
+		srv.nonceQueue = srv.nonceQueue[1:]
+	}
+	return nonce
+}
+
+func (srv *Server) useNonce(nonce string) bool {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if !srv.nonces[nonce] {
+		return false
+	}
+	delete(srv.nonces, nonce)
+	return true
+}
+
+func (srv *Server) handleNewAccount(w http.ResponseWriter, req *request) error {
+	var payload struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		return malformed("invalid new account request: %v", err)
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	thumbprint := req.jwk.Thumbprint()
+	if id, ok := srv.accountKeys[thumbprint]; ok {
+		acct := srv.accounts[id]
+		if acct.Status != StatusValid {
+			return unauthorized("the account of the key is %s", acct.Status)
+		}
+		acct.lastUsed = time.Now()
+		w.Header().Set("Location", req.base+"account/"+id)
+		writeJSON(w, http.StatusOK, acct)
+		return nil
+	}
+	if payload.OnlyReturnExisting {
+		return problem(errAccountDoesNotExist, http.StatusBadRequest, "no account exists for the key")
+	}
+	srv.prune()
+	if len(srv.accounts) >= maxAccounts {
+		return problem(errRateLimited, http.StatusTooManyRequests, "too many accounts")
+	}
+
+	acct := &account{
+		Status:     StatusValid,
+		Contact:    payload.Contact,
+		id:         randomID(),
+		key:        req.jwk,
+		thumbprint: thumbprint,
+		lastUsed:   time.Now(),
+	}
+	srv.accounts[acct.id] = acct
+	srv.accountKeys[thumbprint] = acct.id
+	log.Infof("created ACME account %s", acct.id)
+
+	w.Header().Set("Location", req.base+"account/"+acct.id)
+	writeJSON(w, http.StatusCreated, acct)
+	return nil
+}
+
+func (srv *Server) handleAccount(w http.ResponseWriter, req *request) error {
+	if req.id != req.account.id {
+		return unauthorized("the request is not signed by the key of the account")
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	acct := req.account
+	if !req.postAsGet() {
+		var payload struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			return malformed("invalid account update: %v", err)
+		}
+		switch payload.Status {
+		case "":
+		case StatusDeactivated:
+			acct.Status = StatusDeactivated
+			log.Infof("deactivated ACME account %s", acct.id)
+		default:
+			return malformed("an account can only be deactivated")
+		}
+		if payload.Contact != nil {
+			acct.Contact = payload.Contact
+		}
+	}
+
+	writeJSON(w, http.StatusOK, acct)
+	return nil
+}
+
+func (srv *Server) handleNewOrder(w http.ResponseWriter, req *request) error {
+	var payload struct {
+		Identifiers []Identifier `json:"identifiers"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		return malformed("invalid new order request: %v", err)
+	}
+	if len(payload.Identifiers) == 0 {
+		return malformed("an order needs at least one identifier")
+	}
+
+	var names []string
+	seen := map[string]bool{}
+	for _, ident := range payload.Identifiers {
+		if ident.Type != "dns" {
+			return problem(errUnsupportedIdentifier, http.StatusBadRequest, "unsupported identifier type %q", ident.Type)
+		}
+		name := strings.ToLower(strings.TrimSuffix(ident.Value, "."))
+		if err := checkDNSName(name); err != nil {
+			return problem(errRejectedIdentifier, http.StatusBadRequest, "%q: %v", ident.Value, err)
+		}
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	var types []string
+	for typ := range srv.Validators {
+		types = append(types, typ)
+	}
+	sort.Strings(types)
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	srv.prune()
+	if req.account.orders >= maxOrdersPerAccount {
+		return problem(errRateLimited, http.StatusTooManyRequests, "too many pending orders")
+	}
+
+	o := &order{
+		Status:    StatusPending,
+		Expires:   time.Now().Add(OrderLifetime).UTC().Truncate(time.Second),
+		id:        randomID(),
+		accountID: req.account.id,
+	}
+	var authzs []*authorization
+	for _, name := range names {
+		authz := &authorization{
+			Identifier: Identifier{Type: "dns", Value: strings.TrimPrefix(name, "*.")},
+			Status:     StatusPending,
+			Expires:    o.Expires,
+			Wildcard:   strings.HasPrefix(name, "*."),
+			id:         randomID(),
+			accountID:  req.account.id,
+		}
+		for _, typ := range types {
+			if authz.Wildcard && typ != ChallengeDNS01 {
+				continue
+			}
+			authz.Challenges = append(authz.Challenges, &challenge{
+				Type:    typ,
+				Status:  StatusPending,
+				Token:   randomID(),
+				id:      randomID(),
+				authzID: authz.id,
+			})
+		}
+		if len(authz.Challenges) == 0 {
+			return problem(errRejectedIdentifier, http.StatusBadRequest, "no challenge can validate %q", name)
+		}
+		authzs = append(authzs, authz)
+		o.Identifiers = append(o.Identifiers, Identifier{Type: "dns", Value: name})
+	}
+
+	for _, authz := range authzs {
+		srv.authzs[authz.id] = authz
+		for _, chal := range authz.Challenges {
+			srv.challenges[chal.id] = chal
+		}
+		o.authzIDs = append(o.authzIDs, authz.id)
+	}
+	srv.orders[o.id] = o
+	req.account.orders++
+
+	w.Header().Set("Location", req.base+"order/"+o.id)
+	writeJSON(w, http.StatusCreated, srv.orderView(req.base, o))
+	return nil
+}
+
+// prune forgets orders, and their authorizations, once they have
+// expired, and then valid accounts without orders that have not been used
+// for AccountLifetime. The caller must hold srv.mu.
+func (srv *Server) prune() {
+	now := time.Now()
+	for id, o := range srv.orders {
+		if now.Before(o.Expires) {
+			continue
+		}
+		for _, authzID := range o.authzIDs {
+			if authz, ok := srv.authzs[authzID]; ok {
+				for _, chal := range authz.Challenges {
+					delete(srv.challenges, chal.id)
+				}
+				delete(srv.authzs, authzID)
+			}
+		}
+		delete(srv.orders, id)
+		if acct, ok := srv.accounts[o.accountID]; ok {
+			acct.orders--
+		}
+	}
+
+	for id, acct := range srv.accounts {
+		if acct.Status == StatusValid && acct.orders == 0 && now.Sub(acct.lastUsed) > AccountLifetime {
+			delete(srv.accountKeys, acct.thumbprint)
+			delete(srv.accounts, id)
+		}
+	}
+}
+
+// checkDNSName checks that name is a DNS name a certificate can be issued
+// for, allowing a leading wildcard label.
+func checkDNSName(name string) error {
+	if net.ParseIP(name) != nil {
+		return fmt.Errorf("IP addresses are not DNS names")
+	}
+	labels := strings.Split(strings.TrimPrefix(name, "*."), ".")
+	if len(name) > 253 || len(labels) < 2 {
+		return fmt.Errorf("not a fully qualified domain name")
+	}
+	for _, label := range labels {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid label %q", label)
+		}
+		for _, c := range label {
+			if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+				return fmt.Errorf("invalid character %q", c)
+			}
+		}
+	}
+	return nil
+}
+
+// updateOrder brings the status of a pending order up to date with its
+// authorizations. The caller must hold srv.mu.
+func (srv *Server) updateOrder(o *order) {
+	if o.Status != StatusPending {
+		return
+	}
+	if time.Now().After(o.Expires) {
+		o.Status = StatusInvalid
+		return
+	}
+	ready := true
+	for _, id := range o.authzIDs {
+		switch srv.authzs[id].Status {
+		case StatusValid:
+		case StatusPending:
+			ready = false
+		default:
+			o.Status = StatusInvalid
+			return
+		}
+	}
+	if ready {
+		o.Status = StatusReady
+	}
+}
+
+// orderView returns a copy of o with its resource URLs. The caller must
+// hold srv.mu.
+func (srv *Server) orderView(base string, o *order) *order {
+	srv.updateOrder(o)
+	view := *o
+	view.Authorizations = nil
+	for _, id := range o.authzIDs {
+		view.Authorizations = append(view.Authorizations, base+"authz/"+id)
+	}
+	view.Finalize = base + "finalize/" + o.id
+	if o.cert != nil {
+		view.Certificate = base + "cert/" + o.id
+	}
+	return &view
+}
+
+// update expires a pending authorization past its expiry. The caller must
+// hold srv.mu.
+func (authz *authorization) update() {
+	if authz.Status == StatusPending && time.Now().After(authz.Expires) {
+		authz.Status = StatusExpired
+	}
+}
+
+// authzView returns a copy of authz with its challenge URLs. The caller
+// must hold srv.mu.
+func (srv *Server) authzView(base string, authz *authorization) *authorization {
+	authz.update()
+	view := *authz
+	view.Challenges = nil
+	for _, chal := range authz.Challenges {
+		view.Challenges = append(view.Challenges, challengeView(base, chal))
+	}
+	return &view
+}
+
+func challengeView(base string, chal *challenge) *challenge {
+	view := *chal
+	view.URL = base + "chall/" + chal.id
+	return &view
+}
+
+func (srv *Server) handleOrder(w http.ResponseWriter, req *request) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	o, ok := srv.orders[req.id]
+	if !ok || o.accountID != req.account.id {
+		return notFound()
+	}
+	writeJSON(w, http.StatusOK, srv.orderView(req.base, o))
+	return nil
+}
+
+func (srv *Server) handleAuthorization(w http.ResponseWriter, req *request) error {
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+
+	authz, ok := srv.authzs[req.id]
+	if !ok || authz.accountID != req.account.id {
+		return notFound()
+	}
+
+	if !req.postAsGet() {
+		var payload struct {
+			Status string `json:"status"`
+		}
+		if err := json.Unmarshal(req.payload, &payload); err != nil {
+			return malformed("invalid authorization update: %v", err)
+		}
+		if payload.Status != StatusDeactivated {
+			return malformed("an authorization can only be deactivated")
+		}
+		if authz.Status != StatusPending && authz.Status != StatusValid {
+			return malformed("the authorization is %s", authz.Status)
+		}
+		authz.Status = StatusDeactivated
+	}
+
+	writeJSON(w, http.StatusOK, srv.authzView(req.base, authz))
+	return nil
+}
+
+// handleChallenge validates a challenge when the client responds to it
+// with an empty JSON object, and returns its state otherwise.
+func (srv *Server) handleChallenge(w http.ResponseWriter, req *request) error {
+	srv.mu.Lock()
+	chal, ok := srv.challenges[req.id]
+	var authz *authorization
+	if ok {
+		authz = srv.authzs[chal.authzID]
+	}
+	if !ok || authz.accountID != req.account.id {
+		srv.mu.Unlock()
+		return notFound()
+	}
+	authz.update()
+
+	validate := !req.postAsGet() && chal.Status == StatusPending && authz.Status == StatusPending
+	if validate {
+		chal.Status = StatusProcessing
+	}
+	srv.mu.Unlock()
+
+	if validate {
+		keyAuthorization := chal.Token + "." + req.account.thumbprint
+		err := srv.Validators[chal.Type].Validate(authz.Identifier.Value, chal.Token, keyAuthorization)
+
+		srv.mu.Lock()
+		if err != nil {
+			log.Infof("ACME %s challenge for %s failed: %v", chal.Type, authz.Identifier.Value, err)
+			chal.Status = StatusInvalid
+			chal.Error = problem(errIncorrectResponse, http.StatusForbidden, "%v", err)
+			authz.Status = StatusInvalid
+		} else {
+			validated := time.Now().UTC().Truncate(time.Second)
+			chal.Status = StatusValid
+			chal.Validated = &validated
+			if authz.Status == StatusPending {
+				authz.Status = StatusValid
+			}
+		}
+		srv.mu.Unlock()
+	}
+
+	srv.mu.Lock()
+	view := challengeView(req.base, chal)
+	srv.mu.Unlock()
+
+	w.Header().Add("Link", fmt.Sprintf("<%sauthz/%s>;rel=\"up\"", req.base, authz.id))
+	writeJSON(w, http.StatusOK, view)
+	return nil
+}
+
+// handleFinalize issues the certificate of a ready order for the CSR in
+// the request, which must name exactly the identifiers of the order.
+func (srv *Server) handleFinalize(w http.ResponseWriter, req *request) error {
+	var payload struct {
+		CSR string `json:"csr"`
+	}
+	if err := json.Unmarshal(req.payload, &payload); err != nil {
+		return malformed("invalid finalize request: %v", err)
+	}
+	der, err := decode(payload.CSR)
+	if err != nil {
+		return problem(errBadCSR, http.StatusBadRequest, "invalid CSR encoding: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return problem(errBadCSR, http.StatusBadRequest, "invalid CSR: %v", err)
+	}
+	if err = csr.CheckSignature(); err != nil {
+		return problem(errBadCSR, http.StatusBadRequest, "invalid CSR signature: %v", err)
+	}
+	if csrKey, err := NewJSONWebKey(csr.PublicKey); err == nil && csrKey.Thumbprint() == req.jwk.Thumbprint() {
+		return problem(errBadCSR, http.StatusBadRequest, "the CSR must not use the account key")
+	}
+
+	srv.mu.Lock()
+	o, ok := srv.orders[req.id]
+	if !ok || o.accountID != req.account.id {
+		srv.mu.Unlock()
+		return notFound()
+	}
+	srv.updateOrder(o)
+	if o.Status != StatusReady {
+		srv.mu.Unlock()
+		return problem(errOrderNotReady, http.StatusForbidden, "the order is %s", o.Status)
+	}
+
+	var names []string
+	for _, ident := range o.Identifiers {
+		names = append(names, ident.Value)
+	}
+	if err = checkCSRNames(csr, names); err != nil {
+		srv.mu.Unlock()
+		return problem(errBadCSR, http.StatusBadRequest, "%v", err)
+	}
+	o.Status = StatusProcessing
+	srv.mu.Unlock()
+
+	cert, err := srv.signer.Sign(signer.SignRequest{
+		Hosts:     names,
+		Request:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		Profile:   srv.profile,
+		Requester: req.base + "account/" + req.account.id,
+	})
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if err != nil {
+		log.Errorf("failed to issue the certificate of ACME order %s: %v", o.id, err)
+		o.Status = StatusInvalid
+		o.Error = problem(errBadCSR, http.StatusBadRequest, "the CA refused to issue the certificate: %v", err)
+		return o.Error
+	}
+
+	if resp, err := srv.signer.Info(info.Req{Profile: srv.profile}); err == nil && resp.Certificate != "" {
+		cert = append(cert, '\n')
+		cert = append(cert, strings.TrimSpace(resp.Certificate)...)
+		cert = append(cert, '\n')
+	}
+	o.cert = cert
+	o.Status = StatusValid
+	log.Infof("issued the certificate of ACME order %s for %s", o.id, strings.Join(names, ", "))
+
+	w.Header().Set("Location", req.base+"order/"+o.id)
+	writeJSON(w, http.StatusOK, srv.orderView(req.base, o))
+	return nil
+}
+
+// checkCSRNames checks that the common name and DNS names of csr are
+// exactly names, and that it asks for no other kind of name. The subject
+// may only hold a common name: the signer copies the other attributes,
+// such as the organization, which an ACME CA cannot vouch for.
+func checkCSRNames(csr *x509.CertificateRequest, names []string) error {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return fmt.Errorf("the CSR may only contain DNS names")
+	}
+	for _, attr := range csr.Subject.Names {
+		if !attr.Type.Equal(oidCommonName) {
+			return fmt.Errorf("the CSR subject may only contain a common name, not %v", attr.Type)
+		}
+	}
+
+	want := map[string]bool{}
+	for _, name := range names {
+		want[name] = true
+	}
+	got := map[string]bool{}
+	for _, name := range csr.DNSNames {
+		got[strings.ToLower(name)] = true
+	}
+	if cn := csr.Subject.CommonName; cn != "" {
+		if !want[strings.ToLower(cn)] {
+			return fmt.Errorf("the CSR common name %q is not in the order", cn)
+		}
+		got[strings.ToLower(cn)] = true
+	}
+
+	for name := range got {
+		if !want[name] {
+			return fmt.Errorf("the CSR names %q, which is not in the order", name)
+		}
+	}
+	for name := range want {
+		if !got[name] {
+			return fmt.Errorf("the CSR does not name %q", name)
+		}
+	}
+	return nil
+}
+
+func (srv *Server) handleCertificate(w http.ResponseWriter, req *request) error {
+	srv.mu.Lock()
+	o, ok := srv.orders[req.id]
+	if !ok || o.accountID != req.account.id || o.cert == nil {
+		srv.mu.Unlock()
+		return notFound()
+	}
+	cert := o.cert
+	srv.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	w.Write(cert)
+	return nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		writeProblem(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// randomID returns a random, URL-safe identifier of 128 bits.
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return encode(b)
+}
@@ -0,0 +1,563 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/signer/local"
+)
+
+const (
+	testCaFile    = "../../signer/local/testdata/ca.pem"
+	testCaKeyFile = "../../signer/local/testdata/ca_key.pem"
+)
+
+// standIn plays the domains being validated: it serves http-01 key
+// authorizations and answers dns-01 TXT lookups.
+type standIn struct {
+	mu   sync.Mutex
+	http map[string]string
+	txt  map[string][]string
+	ts   *httptest.Server
+}
+
+func newStandIn() *standIn {
+	si := &standIn{http: map[string]string{}, txt: map[string][]string{}}
+	si.ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		si.mu.Lock()
+		defer si.mu.Unlock()
+		body, ok := si.http[r.Host+r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(body))
+	}))
+	return si
+}
+
+func (si *standIn) lookupTXT(name string) ([]string, error) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+	return si.txt[name], nil
+}
+
+// client dials the stand-in whatever the domain.
+func (si *standIn) client() *http.Client {
+	addr := si.ts.Listener.Addr().String()
+	return &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, network, addr)
+		},
+	}}
+}
+
+// testClient is a minimal ACME client signing with an ES256 account key.
+type testClient struct {
+	t     *testing.T
+	key   *ecdsa.PrivateKey
+	jwk   *JSONWebKey
+	kid   string
+	dir   map[string]interface{}
+	nonce string
+}
+
+func newTestClient(t *testing.T, dirURL string) *testClient {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwk, err := NewJSONWebKey(key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Get(dirURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	c := &testClient{t: t, key: key, jwk: jwk}
+	if err = json.NewDecoder(resp.Body).Decode(&c.dir); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func (c *testClient) url(name string) string {
+	return c.dir[name].(string)
+}
+
+func (c *testClient) getNonce() string {
+	if c.nonce != "" {
+		nonce := c.nonce
+		c.nonce = ""
+		return nonce
+	}
+	resp, err := http.Head(c.url("newNonce"))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp.Header.Get("Replay-Nonce")
+}
+
+// sign returns the JWS of payload for url; a nil payload is a
+// POST-as-GET.
+func (c *testClient) sign(url string, payload interface{}, nonce string) []byte {
+	hdr := map[string]interface{}{"alg": "ES256", "nonce": nonce, "url": url}
+	if c.kid == "" {
+		hdr["jwk"] = c.jwk
+	} else {
+		hdr["kid"] = c.kid
+	}
+	protected, _ := json.Marshal(hdr)
+
+	var payloadJSON []byte
+	if payload != nil {
+		payloadJSON, _ = json.Marshal(payload)
+	}
+	jws := JWS{Protected: encode(protected), Payload: encode(payloadJSON)}
+
+	digest := sha256.Sum256([]byte(jws.Protected + "." + jws.Payload))
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	jws.Signature = encode(append(padded(r, 32), padded(s, 32)...))
+
+	body, _ := json.Marshal(jws)
+	return body
+}
+
+func (c *testClient) postWithNonce(url string, payload interface{}, nonce string) (*http.Response, []byte) {
+	resp, err := http.Post(url, "application/jose+json", bytes.NewReader(c.sign(url, payload, nonce)))
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	c.nonce = resp.Header.Get("Replay-Nonce")
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return resp, body
+}
+
+func (c *testClient) post(url string, payload interface{}) (*http.Response, []byte) {
+	return c.postWithNonce(url, payload, c.getNonce())
+}
+
+// postJSON posts and decodes a successful JSON response into v.
+func (c *testClient) postJSON(url string, payload interface{}, v interface{}) *http.Response {
+	resp, body := c.post(url, payload)
+	if resp.StatusCode >= 300 {
+		c.t.Fatalf("POST %s: %s: %s", url, resp.Status, body)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		c.t.Fatalf("POST %s: %v", url, err)
+	}
+	return resp
+}
+
+func (c *testClient) register() {
+	var acct map[string]interface{}
+	resp := c.postJSON(c.url("newAccount"), map[string]interface{}{"termsOfServiceAgreed": true}, &acct)
+	if resp.StatusCode != http.StatusCreated || acct["status"] != StatusValid {
+		c.t.Fatalf("unexpected new account response %s %v", resp.Status, acct)
+	}
+	c.kid = resp.Header.Get("Location")
+}
+
+func (c *testClient) keyAuthorization(token string) string {
+	return token + "." + c.jwk.Thumbprint()
+}
+
+// expectProblem checks that a response is a problem of the given type.
+func expectProblem(t *testing.T, resp *http.Response, body []byte, typ string) {
+	var p Problem
+	if err := json.Unmarshal(body, &p); err != nil {
+		t.Fatalf("expected a problem document, got %s: %s", resp.Status, body)
+	}
+	if p.Type != typ || resp.Header.Get("Content-Type") != "application/problem+json" {
+		t.Fatalf("expected a %s problem, got %s: %s", typ, resp.Status, body)
+	}
+}
+
+func newTestServer(t *testing.T, si *standIn) *httptest.Server {
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := NewServer(s, "", "/acme/")
+	srv.Validators = map[string]Validator{
+		ChallengeHTTP01: &HTTP01Validator{Client: si.client()},
+		ChallengeDNS01:  &DNS01Validator{LookupTXT: si.lookupTXT},
+	}
+	return httptest.NewServer(srv)
+}
+
+func newCSR(t *testing.T, cn string, names ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encode(der)
+}
+
+// newOrder creates an order for names and returns it with its URL.
+func (c *testClient) newOrder(names ...string) (order map[string]interface{}, url string) {
+	var idents []Identifier
+	for _, name := range names {
+		idents = append(idents, Identifier{Type: "dns", Value: name})
+	}
+	resp := c.postJSON(c.url("newOrder"), map[string]interface{}{"identifiers": idents}, &order)
+	if resp.StatusCode != http.StatusCreated || order["status"] != StatusPending {
+		c.t.Fatalf("unexpected new order response %s %v", resp.Status, order)
+	}
+	return order, resp.Header.Get("Location")
+}
+
+func TestIssuance(t *testing.T) {
+	si := newStandIn()
+	defer si.ts.Close()
+	ts := newTestServer(t, si)
+	defer ts.Close()
+
+	c := newTestClient(t, ts.URL+"/acme/directory")
+	c.register()
+
+	order, orderURL := c.newOrder("www.example.com", "*.example.com")
+
+	// Finalizing before the authorizations are complete fails.
+	resp, body := c.post(order["finalize"].(string), map[string]string{"csr": newCSR(t, "", "www.example.com", "*.example.com")})
+	expectProblem(t, resp, body, errOrderNotReady)
+
+	for _, authzURL := range order["authorizations"].([]interface{}) {
+		var authz struct {
+			Identifier Identifier
+			Wildcard   bool
+			Challenges []challenge
+		}
+		c.postJSON(authzURL.(string), nil, &authz)
+
+		var types []string
+		for _, chal := range authz.Challenges {
+			types = append(types, chal.Type)
+		}
+		sort.Strings(types)
+		want := []string{ChallengeDNS01, ChallengeHTTP01}
+		if authz.Wildcard {
+			want = []string{ChallengeDNS01}
+		}
+		if !reflect.DeepEqual(types, want) {
+			t.Fatalf("unexpected challenges %v for %s", types, authz.Identifier.Value)
+		}
+
+		for _, chal := range authz.Challenges {
+			if authz.Wildcard && chal.Type == ChallengeDNS01 {
+				si.mu.Lock()
+				si.txt["_acme-challenge."+authz.Identifier.Value] = []string{DNS01Digest(c.keyAuthorization(chal.Token))}
+				si.mu.Unlock()
+			} else if !authz.Wildcard && chal.Type == ChallengeHTTP01 {
+				si.mu.Lock()
+				si.http[authz.Identifier.Value+"/.well-known/acme-challenge/"+chal.Token] = c.keyAuthorization(chal.Token)
+				si.mu.Unlock()
+			} else {
+				continue
+			}
+
+			var result challenge
+			c.postJSON(chal.URL, map[string]interface{}{}, &result)
+			if result.Status != StatusValid || result.Validated == nil {
+				t.Fatalf("expected the %s challenge to be valid: %+v", chal.Type, result)
+			}
+		}
+	}
+
+	c.postJSON(orderURL, nil, &order)
+	if order["status"] != StatusReady {
+		t.Fatalf("expected the order to be ready, got %v", order["status"])
+	}
+
+	// The CSR must name exactly the identifiers of the order.
+	resp, body = c.post(order["finalize"].(string), map[string]string{"csr": newCSR(t, "", "www.example.com", "mail.example.com")})
+	expectProblem(t, resp, body, errBadCSR)
+
+	c.postJSON(order["finalize"].(string), map[string]string{"csr": newCSR(t, "www.example.com", "*.example.com")}, &order)
+	if order["status"] != StatusValid || order["certificate"] == nil {
+		t.Fatalf("expected the order to be valid, got %v", order)
+	}
+
+	resp, body = c.post(order["certificate"].(string), nil)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/pem-certificate-chain" {
+		t.Fatalf("unexpected certificate response %s", resp.Status)
+	}
+	certs, err := helpers.ParseCertificatesPEM(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 {
+		t.Fatalf("expected the certificate and its issuer, got %d certificates", len(certs))
+	}
+	names := certs[0].DNSNames
+	sort.Strings(names)
+	if !reflect.DeepEqual(names, []string{"*.example.com", "www.example.com"}) {
+		t.Fatalf("unexpected names %v", names)
+	}
+	if err = certs[0].CheckSignatureFrom(certs[1]); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another account cannot see the order.
+	other := newTestClient(t, ts.URL+"/acme/directory")
+	other.register()
+	resp, _ = other.post(orderURL, nil)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected another account to get 404 for the order, got %s", resp.Status)
+	}
+}
+
+func TestFailedChallenge(t *testing.T) {
+	si := newStandIn()
+	defer si.ts.Close()
+	ts := newTestServer(t, si)
+	defer ts.Close()
+
+	c := newTestClient(t, ts.URL+"/acme/directory")
+	c.register()
+	order, orderURL := c.newOrder("www.example.com")
+
+	var authz struct{ Challenges []challenge }
+	c.postJSON(order["authorizations"].([]interface{})[0].(string), nil, &authz)
+	for _, chal := range authz.Challenges {
+		if chal.Type != ChallengeHTTP01 {
+			continue
+		}
+		si.mu.Lock()
+		si.http["www.example.com/.well-known/acme-challenge/"+chal.Token] = "wrong"
+		si.mu.Unlock()
+
+		var result challenge
+		c.postJSON(chal.URL, map[string]interface{}{}, &result)
+		if result.Status != StatusInvalid || result.Error == nil || result.Error.Type != errIncorrectResponse {
+			t.Fatalf("expected the challenge to be invalid: %+v", result)
+		}
+	}
+
+	c.postJSON(orderURL, nil, &order)
+	if order["status"] != StatusInvalid {
+		t.Fatalf("expected the order to be invalid, got %v", order["status"])
+	}
+}
+
+func TestRequestChecks(t *testing.T) {
+	si := newStandIn()
+	defer si.ts.Close()
+	ts := newTestServer(t, si)
+	defer ts.Close()
+
+	c := newTestClient(t, ts.URL+"/acme/directory")
+
+	// Looking up a missing account fails.
+	resp, body := c.post(c.url("newAccount"), map[string]interface{}{"onlyReturnExisting": true})
+	expectProblem(t, resp, body, errAccountDoesNotExist)
+
+	c.register()
+
+	// Registering again returns the existing account.
+	var acct map[string]interface{}
+	c.kid = ""
+	resp = c.postJSON(c.url("newAccount"), map[string]interface{}{}, &acct)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected the existing account, got %s", resp.Status)
+	}
+	c.kid = resp.Header.Get("Location")
+
+	// A nonce can only be used once.
+	nonce := c.getNonce()
+	c.postWithNonce(c.kid, nil, nonce)
+	resp, body = c.postWithNonce(c.kid, nil, nonce)
+	expectProblem(t, resp, body, errBadNonce)
+	if resp.Header.Get("Replay-Nonce") == "" {
+		t.Fatal("a badNonce response must carry a fresh nonce")
+	}
+
+	// The JWS must be for the URL it is posted to.
+	resp, err := http.Post(c.url("newOrder"), "application/jose+json", bytes.NewReader(c.sign(c.kid, nil, c.getNonce())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	expectProblem(t, resp, body, errUnauthorized)
+
+	// Only DNS names are accepted.
+	resp, body = c.post(c.url("newOrder"), map[string]interface{}{
+		"identifiers": []Identifier{{Type: "ip", Value: "127.0.0.1"}},
+	})
+	expectProblem(t, resp, body, errUnsupportedIdentifier)
+	resp, body = c.post(c.url("newOrder"), map[string]interface{}{
+		"identifiers": []Identifier{{Type: "dns", Value: "not a name"}},
+	})
+	expectProblem(t, resp, body, errRejectedIdentifier)
+
+	// A deactivated account can no longer be used.
+	c.postJSON(c.kid, map[string]string{"status": StatusDeactivated}, &acct)
+	if acct["status"] != StatusDeactivated {
+		t.Fatalf("expected the account to be deactivated, got %v", acct)
+	}
+	resp, body = c.post(c.url("newOrder"), map[string]interface{}{
+		"identifiers": []Identifier{{Type: "dns", Value: "www.example.com"}},
+	})
+	expectProblem(t, resp, body, errUnauthorized)
+
+	// Nor can its key register again.
+	c.kid = ""
+	resp, body = c.post(c.url("newAccount"), map[string]interface{}{"termsOfServiceAgreed": true})
+	expectProblem(t, resp, body, errUnauthorized)
+}
+
+func TestPrune(t *testing.T) {
+	srv := NewServer(nil, "", "/acme/")
+	old := time.Now().Add(-AccountLifetime - time.Minute)
+	for _, acct := range []*account{
+		{Status: StatusValid, id: "idle", thumbprint: "idle", lastUsed: old},
+		{Status: StatusValid, id: "recent", thumbprint: "recent", lastUsed: time.Now()},
+		{Status: StatusValid, id: "ordering", thumbprint: "ordering", lastUsed: old, orders: 1},
+		{Status: StatusDeactivated, id: "deactivated", thumbprint: "deactivated", lastUsed: old},
+	} {
+		srv.accounts[acct.id] = acct
+		srv.accountKeys[acct.thumbprint] = acct.id
+	}
+	srv.orders["expired"] = &order{Expires: time.Now().Add(-time.Minute), accountID: "ordering"}
+
+	srv.prune()
+	if len(srv.orders) != 0 {
+		t.Fatal("expected the expired order to be forgotten")
+	}
+	for _, id := range []string{"recent", "deactivated"} {
+		if srv.accounts[id] == nil || srv.accountKeys[id] != id {
+			t.Fatalf("expected account %s to be kept", id)
+		}
+	}
+	// An account is idle once its last order has expired.
+	for _, id := range []string{"idle", "ordering"} {
+		if srv.accounts[id] != nil || srv.accountKeys[id] != "" {
+			t.Fatalf("expected account %s to be forgotten", id)
+		}
+	}
+}
+
+func TestJSONWebKey(t *testing.T) {
+	for _, key := range []crypto.Signer{
+		mustKey(t, elliptic.P256()),
+		mustKey(t, elliptic.P384()),
+	} {
+		jwk, err := NewJSONWebKey(key.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		pub, err := jwk.PublicKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(pub, key.Public()) {
+			t.Fatal("the JSON Web Key does not round-trip")
+		}
+		if !strings.HasPrefix(jwk.Crv, "P-") || len(jwk.Thumbprint()) != 43 {
+			t.Fatalf("unexpected JSON Web Key %+v", jwk)
+		}
+	}
+
+	if _, err := (&JSONWebKey{Kty: "EC", Crv: "P-256", X: encode([]byte{1}), Y: encode([]byte{2})}).PublicKey(); err == nil {
+		t.Fatal("expected a point off the curve to be rejected")
+	}
+}
+
+func mustKey(t *testing.T, curve elliptic.Curve) crypto.Signer {
+	key, err := ecdsa.GenerateKey(curve, rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestValidators(t *testing.T) {
+	si := newStandIn()
+	defer si.ts.Close()
+	si.http["example.com/.well-known/acme-challenge/token"] = "token.thumb\n"
+	si.txt["_acme-challenge.example.com"] = []string{"other", DNS01Digest("token.thumb")}
+
+	h := &HTTP01Validator{Client: si.client()}
+	if err := h.Validate("example.com", "token", "token.thumb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Validate("example.com", "token", "token.other"); err == nil {
+		t.Fatal("expected a wrong key authorization to fail")
+	}
+	if err := h.Validate("example.org", "token", "token.thumb"); err == nil {
+		t.Fatal("expected a missing key authorization to fail")
+	}
+
+	d := &DNS01Validator{LookupTXT: si.lookupTXT}
+	if err := d.Validate("*.example.com", "token", "token.thumb"); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Validate("example.com", "token", "token.other"); err == nil {
+		t.Fatal("expected a wrong digest to fail")
+	}
+}
+
+func TestCheckCSRSubject(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, subject := range []pkix.Name{
+		{CommonName: "www.example.com", Organization: []string{"Example Bank"}},
+		{OrganizationalUnit: []string{"Payments"}},
+		{CommonName: "www.example.com", SerialNumber: "1"},
+	} {
+		der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+			Subject:  subject,
+			DNSNames: []string{"www.example.com"},
+		}, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		csr, err := x509.ParseCertificateRequest(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = checkCSRNames(csr, []string{"www.example.com"}); err == nil {
+			t.Fatalf("expected the subject %v to be rejected", subject)
+		}
+	}
+}
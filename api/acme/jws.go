@@ -0,0 +1,221 @@
+package acme
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// A JSONWebKey is the public part of an RSA or EC JSON Web Key (RFC 7517),
+// as carried in the "jwk" header of a new account request.
+type JSONWebKey struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// NewJSONWebKey returns the JSON Web Key of an RSA or ECDSA public key.
+func NewJSONWebKey(pub crypto.PublicKey) (*JSONWebKey, error) {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return &JSONWebKey{
+			Kty: "RSA",
+			N:   encode(pub.N.Bytes()),
+			E:   encode(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return &JSONWebKey{
+			Kty: "EC",
+			Crv: pub.Curve.Params().Name,
+			X:   encode(padded(pub.X, size)),
+			Y:   encode(padded(pub.Y, size)),
+		}, nil
+	}
+	return nil, errors.New("unsupported public key type")
+}
+
+// PublicKey returns the public key of the JSON Web Key.
+func (k *JSONWebKey) PublicKey() (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 || n.BitLen() < 2048 {
+			return nil, errors.New("unacceptable RSA key")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, errors.New("EC point is not on the curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// Thumbprint returns the base64url-encoded SHA-256 JWK thumbprint of the
+// key (RFC 7638), which identifies an account in key authorizations.
+func (k *JSONWebKey) Thumbprint() string {
+	var canonical string
+	switch k.Kty {
+	case "RSA":
+		canonical = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	default:
+		canonical = fmt.Sprintf(`{"crv":%q,"kty":%q,"x":%q,"y":%q}`, k.Crv, k.Kty, k.X, k.Y)
+	}
+	sum := sha256.Sum256([]byte(canonical))
+	return encode(sum[:])
+}
+
+// A JWS is a flattened JSON Web Signature (RFC 7515), the body of every
+// ACME POST request.
+type JWS struct {
+	Protected string `json:"protected"`
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// protectedHeader holds the fields ACME requires in the protected header
+// of a JWS. Exactly one of JWK and KID is set.
+type protectedHeader struct {
+	Alg   string      `json:"alg"`
+	Nonce string      `json:"nonce"`
+	URL   string      `json:"url"`
+	JWK   *JSONWebKey `json:"jwk,omitempty"`
+	KID   string      `json:"kid,omitempty"`
+}
+
+// parseJWS decodes a flattened JWS and its protected header, without
+// verifying the signature.
+func parseJWS(body []byte) (*JWS, *protectedHeader, error) {
+	var jws JWS
+	if err := json.Unmarshal(body, &jws); err != nil {
+		return nil, nil, err
+	}
+	raw, err := decode(jws.Protected)
+	if err != nil {
+		return nil, nil, err
+	}
+	var hdr protectedHeader
+	if err = json.Unmarshal(raw, &hdr); err != nil {
+		return nil, nil, err
+	}
+	if (hdr.JWK == nil) == (hdr.KID == "") {
+		return nil, nil, errors.New("exactly one of jwk and kid must be present")
+	}
+	return &jws, &hdr, nil
+}
+
+// verify checks the signature of the JWS, made with alg by the key pub,
+// and returns its decoded payload.
+func (jws *JWS) verify(alg string, pub crypto.PublicKey) ([]byte, error) {
+	sig, err := decode(jws.Signature)
+	if err != nil {
+		return nil, err
+	}
+	signingInput := []byte(jws.Protected + "." + jws.Payload)
+
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if alg != "RS256" {
+			return nil, fmt.Errorf("algorithm %s does not match an RSA key", alg)
+		}
+		digest := sha256.Sum256(signingInput)
+		if err = rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest[:], sig); err != nil {
+			return nil, err
+		}
+	case *ecdsa.PublicKey:
+		var digest []byte
+		switch {
+		case alg == "ES256" && pub.Curve == elliptic.P256():
+			sum := sha256.Sum256(signingInput)
+			digest = sum[:]
+		case alg == "ES384" && pub.Curve == elliptic.P384():
+			sum := sha512.Sum384(signingInput)
+			digest = sum[:]
+		case alg == "ES512" && pub.Curve == elliptic.P521():
+			sum := sha512.Sum512(signingInput)
+			digest = sum[:]
+		default:
+			return nil, fmt.Errorf("algorithm %s does not match an EC key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return nil, errors.New("malformed EC signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, digest, r, s) {
+			return nil, errors.New("invalid EC signature")
+		}
+	default:
+		return nil, errors.New("unsupported public key type")
+	}
+
+	return decode(jws.Payload)
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := decode(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty integer in JSON Web Key")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// padded returns the big-endian bytes of n, left-padded to size.
+func padded(n *big.Int, size int) []byte {
+	b := n.Bytes()
+	if len(b) >= size {
+		return b
+	}
+	return append(make([]byte, size-len(b)), b...)
+}
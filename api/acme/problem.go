@@ -0,0 +1,71 @@
+package acme
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/ucosty/cfssl/log"
+)
+
+// ACME error types (RFC 8555, section 6.7).
+const (
+	errMalformed             = "urn:ietf:params:acme:error:malformed"
+	errBadNonce              = "urn:ietf:params:acme:error:badNonce"
+	errBadSignatureAlgorithm = "urn:ietf:params:acme:error:badSignatureAlgorithm"
+	errUnauthorized          = "urn:ietf:params:acme:error:unauthorized"
+	errAccountDoesNotExist   = "urn:ietf:params:acme:error:accountDoesNotExist"
+	errOrderNotReady         = "urn:ietf:params:acme:error:orderNotReady"
+	errRejectedIdentifier    = "urn:ietf:params:acme:error:rejectedIdentifier"
+	errUnsupportedIdentifier = "urn:ietf:params:acme:error:unsupportedIdentifier"
+	errBadCSR                = "urn:ietf:params:acme:error:badCSR"
+	errIncorrectResponse     = "urn:ietf:params:acme:error:incorrectResponse"
+	errRateLimited           = "urn:ietf:params:acme:error:rateLimited"
+	errServerInternal        = "urn:ietf:params:acme:error:serverInternal"
+)
+
+// A Problem is an ACME error, sent as an RFC 7807 problem document.
+type Problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail,omitempty"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+func problem(typ string, status int, format string, args ...interface{}) *Problem {
+	return &Problem{Type: typ, Detail: fmt.Sprintf(format, args...), Status: status}
+}
+
+func malformed(format string, args ...interface{}) *Problem {
+	return problem(errMalformed, http.StatusBadRequest, format, args...)
+}
+
+func unauthorized(format string, args ...interface{}) *Problem {
+	return problem(errUnauthorized, http.StatusForbidden, format, args...)
+}
+
+func notFound() *Problem {
+	return problem("about:blank", http.StatusNotFound, "no such resource")
+}
+
+// writeProblem sends err as a problem document. Errors that are not
+// problems are reported as internal server errors.
+func writeProblem(w http.ResponseWriter, err error) {
+	p, ok := err.(*Problem)
+	if !ok {
+		log.Errorf("ACME request failed: %v", err)
+		p = problem(errServerInternal, http.StatusInternalServerError, "%v", err)
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		log.Errorf("failed to marshal ACME problem: %v", err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	w.Write(body)
+}
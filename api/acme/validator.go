@@ -0,0 +1,109 @@
+package acme
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Challenge types.
+const (
+	ChallengeHTTP01 = "http-01"
+	ChallengeDNS01  = "dns-01"
+)
+
+// A Validator checks that the requester controls a domain, by looking for
+// the key authorization of a challenge token where the challenge type
+// says it should be published.
+type Validator interface {
+	Validate(domain, token, keyAuthorization string) error
+}
+
+// A ValidatorFunc is an ordinary function used as a Validator.
+type ValidatorFunc func(domain, token, keyAuthorization string) error
+
+// Validate calls f(domain, token, keyAuthorization).
+func (f ValidatorFunc) Validate(domain, token, keyAuthorization string) error {
+	return f(domain, token, keyAuthorization)
+}
+
+// An HTTP01Validator fetches the key authorization from
+// http://domain/.well-known/acme-challenge/token.
+type HTTP01Validator struct {
+	// Client makes the request; a client with a 10 second timeout is
+	// used if it is nil.
+	Client *http.Client
+	// Port, if not empty, replaces the standard port 80.
+	Port string
+}
+
+// Validate implements the http-01 challenge.
+func (v *HTTP01Validator) Validate(domain, token, keyAuthorization string) error {
+	client := v.Client
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+	host := domain
+	if v.Port != "" {
+		host = net.JoinHostPort(domain, v.Port)
+	}
+
+	resp, err := client.Get(fmt.Sprintf("http://%s/.well-known/acme-challenge/%s", host, token))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching the key authorization returned %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<10))
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(string(body)) != keyAuthorization {
+		return fmt.Errorf("the key authorization at %s is incorrect", host)
+	}
+	return nil
+}
+
+// A DNS01Validator looks up the digest of the key authorization in the TXT
+// records of _acme-challenge.domain.
+type DNS01Validator struct {
+	// LookupTXT resolves TXT records; net.LookupTXT is used if it is nil.
+	LookupTXT func(name string) ([]string, error)
+}
+
+// Validate implements the dns-01 challenge.
+func (v *DNS01Validator) Validate(domain, token, keyAuthorization string) error {
+	lookup := v.LookupTXT
+	if lookup == nil {
+		lookup = net.LookupTXT
+	}
+
+	name := "_acme-challenge." + strings.TrimPrefix(domain, "*.")
+	records, err := lookup(name)
+	if err != nil {
+		return err
+	}
+
+	want := DNS01Digest(keyAuthorization)
+	for _, record := range records {
+		if record == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("no TXT record of %s matches the key authorization", name)
+}
+
+// DNS01Digest returns the TXT record value of a dns-01 challenge: the
+// base64url-encoded SHA-256 digest of the key authorization.
+func DNS01Digest(keyAuthorization string) string {
+	sum := sha256.Sum256([]byte(keyAuthorization))
+	return encode(sum[:])
+}
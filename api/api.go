@@ -229,3 +229,18 @@ func SendResponseWithMessage(w http.ResponseWriter, result interface{}, message
 	err := enc.Encode(response)
 	return err
 }
+
+// Requester identifies the client that made r for issuance records: the
+// name of the auth key the request was verified with, or else the common
+// name of the client's TLS certificate. It returns "" for anonymous
+// requests.
+func Requester(r *http.Request, authKeyName string) string {
+	if authKeyName != "" {
+		return authKeyName
+	}
+
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		return r.TLS.PeerCertificates[0].Subject.CommonName
+	}
+	return ""
+}
@@ -0,0 +1,112 @@
+// Package certificates implements the HTTP handler for listing the
+// certificates recorded in the cert db.
+package certificates
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/errors"
+)
+
+// A Handler returns pages of certificate records matching a query.
+type Handler struct {
+	dbAccessor certdb.Accessor
+}
+
+// NewHandler returns a new http.Handler that lists certificates. A GET
+// request takes the query from URL parameters, a POST request from a JSON
+// encoded certdb.CertificateQuery body.
+func NewHandler(dbAccessor certdb.Accessor) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &Handler{
+			dbAccessor: dbAccessor,
+		},
+		Methods: []string{"GET", "POST"},
+	}
+}
+
+// parseTime parses an optional RFC 3339 timestamp parameter.
+func parseTime(values url.Values, name string) (time.Time, error) {
+	v := values.Get(name)
+	if v == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return time.Time{}, errors.NewBadRequestString("invalid " + name + ": expected an RFC 3339 timestamp")
+	}
+	return t, nil
+}
+
+// queryFromValues builds a certdb.CertificateQuery from URL parameters.
+func queryFromValues(values url.Values) (q certdb.CertificateQuery, err error) {
+	q.CALabel = values.Get("ca_label")
+	q.Status = values.Get("status")
+	q.AKI = values.Get("authority_key_identifier")
+	q.Hostname = values.Get("hostname")
+	q.Cursor = values.Get("cursor")
+
+	if q.ExpiresAfter, err = parseTime(values, "expires_after"); err != nil {
+		return
+	}
+	if q.ExpiresBefore, err = parseTime(values, "expires_before"); err != nil {
+		return
+	}
+	if q.IssuedAfter, err = parseTime(values, "issued_after"); err != nil {
+		return
+	}
+	if q.IssuedBefore, err = parseTime(values, "issued_before"); err != nil {
+		return
+	}
+
+	if limit := values.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil || q.Limit < 0 {
+			return q, errors.NewBadRequestString("invalid limit: expected a non-negative integer")
+		}
+	}
+	return q, nil
+}
+
+// Handle responds to certificate listing requests.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	var q certdb.CertificateQuery
+	var err error
+	if r.Method == "POST" {
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+		r.Body.Close()
+
+		if err = json.Unmarshal(body, &q); err != nil {
+			return errors.NewBadRequestString("Unable to parse certificate query")
+		}
+	} else {
+		q, err = queryFromValues(r.URL.Query())
+		if err != nil {
+			return err
+		}
+	}
+
+	if q.Cursor != "" {
+		if _, _, err = certdb.DecodeCursor(q.Cursor); err != nil {
+			return errors.NewBadRequestString("invalid cursor")
+		}
+	}
+
+	page, err := h.dbAccessor.ListCertificates(q)
+	if err != nil {
+		return err
+	}
+
+	return api.SendResponse(w, page)
+}
@@ -0,0 +1,150 @@
+package certificates
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+)
+
+const (
+	fakeAKI = "fake aki"
+)
+
+func prepDB(t *testing.T) certdb.Accessor {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+
+	for i := 0; i < 5; i++ {
+		cert := certdb.CertificateRecord{
+			Serial:   fmt.Sprintf("%d", i),
+			AKI:      fakeAKI,
+			CALabel:  "primary",
+			Status:   "good",
+			Expiry:   time.Now().AddDate(1, 0, 0),
+			IssuedAt: time.Now(),
+			PEM:      "unexpired cert",
+		}
+		if i%2 == 1 {
+			cert.CALabel = "backup"
+		}
+		if err := dbAccessor.InsertCertificate(cert); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dbAccessor
+}
+
+type response struct {
+	Success bool                   `json:"success"`
+	Result  certdb.CertificatePage `json:"result"`
+}
+
+func get(t *testing.T, ts *httptest.Server, query string) (int, response) {
+	resp, err := http.Get(ts.URL + "?" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decode(t, resp)
+}
+
+func decode(t *testing.T, resp *http.Response) (int, response) {
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var r response
+	if resp.StatusCode == http.StatusOK {
+		if err = json.Unmarshal(body, &r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return resp.StatusCode, r
+}
+
+func TestListCertificatesPaging(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(prepDB(t)))
+	defer ts.Close()
+
+	var serials []string
+	query := "limit=2"
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatal("too many pages")
+		}
+
+		status, r := get(t, ts, query)
+		if status != http.StatusOK {
+			t.Fatal("unexpected HTTP status code; expected OK", status)
+		}
+		for _, cr := range r.Result.Certificates {
+			serials = append(serials, cr.Serial)
+		}
+		if r.Result.NextCursor == "" {
+			break
+		}
+		query = "limit=2&cursor=" + r.Result.NextCursor
+	}
+
+	if fmt.Sprint(serials) != "[0 1 2 3 4]" {
+		t.Fatalf("expected every certificate exactly once in order, got %v", serials)
+	}
+}
+
+func TestListCertificatesFilters(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(prepDB(t)))
+	defer ts.Close()
+
+	status, r := get(t, ts, "ca_label=backup")
+	if status != http.StatusOK {
+		t.Fatal("unexpected HTTP status code; expected OK", status)
+	}
+	if len(r.Result.Certificates) != 2 {
+		t.Fatalf("expected 2 certificates for the backup CA, got %d", len(r.Result.Certificates))
+	}
+
+	status, r = get(t, ts, "expires_before="+time.Now().Format(time.RFC3339))
+	if status != http.StatusOK {
+		t.Fatal("unexpected HTTP status code; expected OK", status)
+	}
+	if len(r.Result.Certificates) != 0 {
+		t.Fatalf("expected no expired certificates, got %d", len(r.Result.Certificates))
+	}
+
+	body, _ := json.Marshal(certdb.CertificateQuery{
+		Status:      "good",
+		IssuedAfter: time.Now().Add(-time.Hour),
+	})
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, r = decode(t, resp)
+	if status != http.StatusOK {
+		t.Fatal("unexpected HTTP status code; expected OK", status)
+	}
+	if len(r.Result.Certificates) != 5 {
+		t.Fatalf("expected 5 recently issued certificates, got %d", len(r.Result.Certificates))
+	}
+}
+
+func TestListCertificatesBadRequest(t *testing.T) {
+	ts := httptest.NewServer(NewHandler(prepDB(t)))
+	defer ts.Close()
+
+	for _, query := range []string{"limit=-1", "limit=ten", "expires_after=yesterday", "cursor=!!!"} {
+		if status, _ := get(t, ts, query); status != http.StatusBadRequest {
+			t.Errorf("%s: expected bad request, got %d", query, status)
+		}
+	}
+}
@@ -1,6 +1,194 @@
 package client
 
+import (
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/csr"
+)
+
 // SignResult is the result of signing a CSR.
 type SignResult struct {
 	Certificate []byte `json:"certificate"`
 }
+
+// A BundleRequest asks for the bundle of a PEM certificate, optionally
+// with its private key, or of the certificate served by a domain. With a
+// certificate, Domain and IP are checked against it instead.
+type BundleRequest struct {
+	Certificate string `json:"certificate,omitempty"`
+	PrivateKey  string `json:"private_key,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+	IP          string `json:"ip,omitempty"`
+	Flavor      string `json:"flavor,omitempty"`
+}
+
+// BundleStatus describes how a bundle was built and any changes to it.
+type BundleStatus struct {
+	IsRebundled  bool     `json:"rebundled"`
+	ExpiringSKIs []string `json:"expiring_SKIs"`
+	Untrusted    []string `json:"untrusted_root_stores"`
+	Messages     []string `json:"messages"`
+	Code         int      `json:"code"`
+}
+
+// BundleResult is a certificate bundle, in the JSON form of
+// bundler.Bundle.
+type BundleResult struct {
+	Bundle      string        `json:"bundle"`
+	Root        string        `json:"root"`
+	Certificate string        `json:"crt"`
+	Key         string        `json:"key"`
+	KeyType     string        `json:"key_type"`
+	KeySize     int           `json:"key_size"`
+	Issuer      string        `json:"issuer"`
+	Subject     string        `json:"subject"`
+	Expires     time.Time     `json:"expires"`
+	LeafExpires time.Time     `json:"leaf_expires"`
+	Hostnames   []string      `json:"hostnames"`
+	OCSPSupport bool          `json:"ocsp_support"`
+	CRLSupport  bool          `json:"crl_support"`
+	OCSP        []string      `json:"ocsp"`
+	Signature   string        `json:"signature"`
+	Status      *BundleStatus `json:"status"`
+}
+
+// Sum holds the MD5 and SHA-1 digests of a certificate or CSR.
+type Sum struct {
+	MD5  string `json:"md5"`
+	SHA1 string `json:"sha-1"`
+}
+
+// NewKeyResult is a new private key and a CSR for it.
+type NewKeyResult struct {
+	PrivateKey         string         `json:"private_key"`
+	CertificateRequest string         `json:"certificate_request"`
+	Sums               map[string]Sum `json:"sums"`
+}
+
+// A NewCertRequest asks for a new private key and a certificate for it,
+// signed with the profile and CA label.
+type NewCertRequest struct {
+	Request *csr.CertificateRequest `json:"request"`
+	Profile string                  `json:"profile,omitempty"`
+	Label   string                  `json:"label,omitempty"`
+	Bundle  bool                    `json:"bundle,omitempty"`
+}
+
+// NewCertResult is a new private key, its CSR and certificate, and the
+// certificate's bundle if one was requested. Messages holds the server's
+// warnings, such as a certificate without hosts.
+type NewCertResult struct {
+	PrivateKey         string         `json:"private_key"`
+	CertificateRequest string         `json:"certificate_request"`
+	Certificate        string         `json:"certificate"`
+	Sums               map[string]Sum `json:"sums"`
+	Bundle             *BundleResult  `json:"bundle,omitempty"`
+	Messages           []string       `json:"-"`
+}
+
+// InitCAResult is the private key and self-signed certificate of a new
+// CA.
+type InitCAResult struct {
+	PrivateKey  string `json:"private_key"`
+	Certificate string `json:"certificate"`
+}
+
+// A ScanRequest asks for the host, optionally at IP, to be scanned with
+// the families and scanners matching the regular expressions Family and
+// Scanner. Timeout defaults to one minute on the server.
+type ScanRequest struct {
+	Host    string
+	IP      string
+	Family  string
+	Scanner string
+	Timeout time.Duration
+}
+
+// ScannerResult is the grade and output of one scanner.
+type ScannerResult struct {
+	Grade  string      `json:"grade"`
+	Output interface{} `json:"output,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// ScanResult holds the results of a scan, by family and scanner.
+type ScanResult map[string]map[string]ScannerResult
+
+// ScanFamily describes a family of scanners.
+type ScanFamily struct {
+	Description string `json:"description"`
+	Scanners    map[string]struct {
+		Description string `json:"description"`
+	} `json:"scanners"`
+}
+
+// A CertInfoRequest asks for the details of a PEM certificate or of the
+// certificate served by a domain.
+type CertInfoRequest struct {
+	Certificate string `json:"certificate,omitempty"`
+	Domain      string `json:"domain,omitempty"`
+}
+
+// A CRLRequest asks for a CRL of the cert db valid for Expiry, which
+// defaults to a week on the server, for one distribution point of a
+// partitioned CRL. With Delta, a delta CRL is generated.
+type CRLRequest struct {
+	Expiry            time.Duration
+	DistributionPoint string
+	Delta             bool
+}
+
+// A GenCRLRequest asks for a CRL of the serial numbers, in decimal,
+// signed with the PEM certificate and private key and valid for Expiry,
+// which defaults to a week on the server.
+type GenCRLRequest struct {
+	Certificate   string
+	SerialNumbers []string
+	PrivateKey    string
+	Expiry        time.Duration
+}
+
+// An OCSPSignRequest asks for an OCSP response for a PEM certificate. For
+// revoked certificates, RevokedAt is a date in the form 2006-01-02, or
+// "now". IssuerHash names the hash of the issuer in the response, such as
+// SHA256.
+type OCSPSignRequest struct {
+	Certificate string `json:"certificate"`
+	Status      string `json:"status,omitempty"`
+	Reason      int    `json:"reason,omitempty"`
+	RevokedAt   string `json:"revoked_at,omitempty"`
+	IssuerHash  string `json:"issuer_hash,omitempty"`
+}
+
+// A RevokeRequest asks for the certificate with the serial and AKI to be
+// revoked for the reason, such as keyCompromise.
+type RevokeRequest struct {
+	Serial string `json:"serial"`
+	AKI    string `json:"authority_key_id"`
+	Reason string `json:"reason"`
+}
+
+// A BulkRevokeRequest asks for every unrevoked certificate matching the
+// query to be revoked, or listed in a dry run.
+type BulkRevokeRequest struct {
+	certdb.CertificateQuery
+	Reason string `json:"reason"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// An UnholdRequest asks for the certificate with the serial and AKI,
+// revoked with reason certificateHold, to be released.
+type UnholdRequest struct {
+	Serial string `json:"serial"`
+	AKI    string `json:"authority_key_id"`
+}
+
+// A RevocationLogRequest asks for the revocation events of the
+// certificate with the serial and AKI, or of every certificate since a
+// time.
+type RevocationLogRequest struct {
+	Serial string
+	AKI    string
+	Since  time.Time
+}
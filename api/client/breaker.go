@@ -0,0 +1,63 @@
+package client
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// BreakerInterval and BreakerMaxDuration bound how long a failing server
+// is ejected from the groups that balance over it: BreakerInterval after
+// its first failure, doubling with each consecutive failure up to
+// BreakerMaxDuration.
+var (
+	BreakerInterval    = 5 * time.Second
+	BreakerMaxDuration = 5 * time.Minute
+)
+
+// A breaker is the circuit breaker of a server. A server fails when it
+// cannot be reached or answers with a server error; API errors, such as a
+// rejected CSR, are answers like any other.
+type breaker struct {
+	mu           sync.Mutex
+	backoff      *core.Backoff
+	lastFailure  time.Time
+	ejectedUntil time.Time
+}
+
+// newBreaker returns the closed breaker of a new server.
+func newBreaker() *breaker {
+	return &breaker{backoff: core.NewWithoutJitter(BreakerMaxDuration, BreakerInterval)}
+}
+
+// failure ejects the server for the next backoff duration.
+func (b *breaker) failure() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.lastFailure = time.Now()
+	b.ejectedUntil = b.lastFailure.Add(b.backoff.Duration())
+}
+
+// success closes the breaker and resets its backoff.
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.backoff.Reset()
+	b.ejectedUntil = time.Time{}
+}
+
+// ejected reports whether the server is currently ejected.
+func (b *breaker) ejected() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return time.Now().Before(b.ejectedUntil)
+}
+
+// failedAt returns the time of the last failure, which is zero if the
+// server has not failed.
+func (b *breaker) failedAt() time.Time {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.lastFailure
+}
@@ -3,6 +3,7 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	stderr "errors"
@@ -20,13 +21,48 @@ import (
 	"github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/info"
 	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/transport/core"
 )
 
+// DefaultTimeout bounds each attempt of the requests whose context has
+// no deadline, so that a hung server cannot block its caller forever.
+// Zero disables it.
+var DefaultTimeout = time.Minute
+
+// IdempotentRetries is how many times an idempotent request, such as
+// info, is retried after the server cannot be reached or answers with a
+// server error. The retries back off from RetryInterval up to
+// RetryMaxDuration.
+var (
+	IdempotentRetries = 2
+	RetryInterval     = 250 * time.Millisecond
+	RetryMaxDuration  = 5 * time.Second
+)
+
+// RequestIDHeader is the header carrying the ID of a request made with
+// a context from WithRequestID.
+const RequestIDHeader = "X-Request-Id"
+
+type requestIDKey struct{}
+
+// WithRequestID returns a copy of ctx whose requests carry id in the
+// RequestIDHeader header, to correlate them with the server's logs.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, id)
+}
+
+// RequestIDFromContext returns the request ID set with WithRequestID.
+func RequestIDFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(requestIDKey{}).(string)
+	return id, ok
+}
+
 // A server points to a single remote CFSSL instance.
 type server struct {
 	URL         string
 	TLSConfig   *tls.Config
 	reqModifier func(*http.Request, []byte)
+	health      *breaker
 }
 
 // A Remote points to at least one (but possibly multiple) remote
@@ -42,26 +78,87 @@ type Remote interface {
 	SetReqModifier(func(*http.Request, []byte))
 }
 
+// A ContextRemote is a Remote whose requests take a context, which
+// cancels them and whose deadline bounds them. Every Remote of this
+// package is a ContextRemote.
+type ContextRemote interface {
+	Remote
+	AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error)
+	SignContext(ctx context.Context, jsonData []byte) ([]byte, error)
+	InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error)
+}
+
+// AsContextRemote returns r as a ContextRemote. A Remote from elsewhere
+// is wrapped so that the context is only checked before each request.
+func AsContextRemote(r Remote) ContextRemote {
+	if cr, ok := r.(ContextRemote); ok {
+		return cr
+	}
+	return contextlessRemote{r}
+}
+
+// contextlessRemote adapts a Remote without context support.
+type contextlessRemote struct {
+	Remote
+}
+
+func (r contextlessRemote) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+	}
+	return r.AuthSign(req, id, provider)
+}
+
+func (r contextlessRemote) SignContext(ctx context.Context, jsonData []byte) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+	}
+	return r.Sign(jsonData)
+}
+
+func (r contextlessRemote) InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+	}
+	return r.Info(jsonData)
+}
+
 // NewServer sets up a new server target. The address should be of
 // The format [protocol:]name[:port] of the remote CFSSL instance.
 // If no protocol is given http is default. If no port
 // is specified, the CFSSL default port (8888) is used. If the name is
-// a comma-separated list of hosts, an ordered group will be returned.
+// a comma-separated list of hosts, an ordered group will be returned,
+// unless the list is prefixed with the name of another strategy and an
+// equals sign, as in round_robin=ca1.local,ca2.local. The group keeps
+// the health of its servers and, for round_robin, whose turn it is, so
+// it should be reused across requests. If addr is invalid, the error is
+// logged and nil is returned.
 func NewServer(addr string) Remote {
 	return NewServerTLS(addr, nil)
 }
 
 // NewServerTLS is the TLS version of NewServer
 func NewServerTLS(addr string, tlsConfig *tls.Config) Remote {
-	addrs := strings.Split(addr, ",")
+	strategy, hosts := splitStrategy(addr)
+	if strategy == StrategyInvalid {
+		log.Errorf("unknown strategy in remote %s", addr)
+		return nil
+	}
+	addrs := strings.Split(hosts, ",")
 
 	var remote Remote
 
 	if len(addrs) > 1 {
-		remote, _ = NewGroup(addrs, tlsConfig, StrategyOrderedList)
+		group, err := NewGroup(addrs, tlsConfig, strategy)
+		if err != nil {
+			log.Errorf("invalid remote %s: %v", addr, err)
+			return nil
+		}
+		remote = group
 	} else {
 		u, err := normalizeURL(addrs[0])
 		if err != nil {
+			log.Errorf("invalid remote %s: %v", addr, err)
 			return nil
 		}
 		srv, _ := newServer(u, tlsConfig)
@@ -82,7 +179,7 @@ func (srv *server) SetReqModifier(mod func(*http.Request, []byte)) {
 
 func newServer(u *url.URL, tlsConfig *tls.Config) (*server, error) {
 	URL := u.String()
-	return &server{URL, tlsConfig, nil}, nil
+	return &server{URL: URL, TLSConfig: tlsConfig, health: newBreaker()}, nil
 }
 
 func (srv *server) getURL(endpoint string) string {
@@ -96,55 +193,122 @@ func (srv *server) createTLSTransport() (transport *http.Transport) {
 	return &http.Transport{TLSClientConfig: tlsConfig}
 }
 
-// post connects to the remote server and returns a Response struct
-func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
+// post connects to the remote server and returns a Response struct.
+// Idempotent requests are retried after server failures.
+func (srv *server) post(ctx context.Context, url string, jsonData []byte, idempotent bool) (*api.Response, error) {
+	return srv.send(ctx, "POST", url, jsonData, idempotent)
+}
+
+// attemptTimeoutKey is the context key of the timeout of each attempt of
+// a request, when it should not be DefaultTimeout.
+type attemptTimeoutKey struct{}
+
+// send makes a request with the method to the remote server, which is a
+// POST of jsonData or a GET. When ctx has no deadline, each attempt is
+// bounded by DefaultTimeout; running out of it is a failure of the server,
+// and is retried, whereas the cancellation of ctx is not.
+func (srv *server) send(ctx context.Context, method, url string, jsonData []byte, idempotent bool) (*api.Response, error) {
+	var timeout time.Duration
+	if _, ok := ctx.Deadline(); !ok {
+		timeout = DefaultTimeout
+		if t, ok := ctx.Value(attemptTimeoutKey{}).(time.Duration); ok {
+			timeout = t
+		}
+	}
+
+	var retries *core.Backoff
+	for attempt := 0; ; attempt++ {
+		response, retry, err := srv.sendOnce(ctx, timeout, method, url, jsonData)
+		if err == nil || !retry || !idempotent || attempt >= IdempotentRetries {
+			return response, err
+		}
+		if retries == nil {
+			retries = core.New(RetryMaxDuration, RetryInterval)
+		}
+		log.Debugf("retrying request to %s: %v", url, err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(retries.Duration()):
+		}
+	}
+}
+
+// sendOnce makes a single request, bounded by timeout if it is not zero,
+// reporting whether it failed because of the server, so that it may be
+// retried.
+func (srv *server) sendOnce(ctx context.Context, timeout time.Duration, method, url string, jsonData []byte) (*api.Response, bool, error) {
+	reqCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		reqCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
 	var resp *http.Response
 	var err error
 	client := &http.Client{}
 	if srv.TLSConfig != nil {
 		client.Transport = srv.createTLSTransport()
 	}
-	req, err := http.NewRequest("POST", url, bytes.NewReader(jsonData))
+	req, err := http.NewRequestWithContext(reqCtx, method, url, bytes.NewReader(jsonData))
 	if err != nil {
-		err = fmt.Errorf("failed POST to %s: %v", url, err)
-		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+		err = fmt.Errorf("failed %s to %s: %v", method, url, err)
+		return nil, false, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
 	}
 	req.Close = true
 	req.Header.Set("content-type", "application/json")
+	if id, ok := RequestIDFromContext(ctx); ok {
+		req.Header.Set(RequestIDHeader, id)
+	}
 	if srv.reqModifier != nil {
 		srv.reqModifier(req, jsonData)
 	}
 	resp, err = client.Do(req)
 	if err != nil {
-		err = fmt.Errorf("failed POST to %s: %v", url, err)
-		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
+		// A request cancelled by the caller says nothing of the server,
+		// but one that ran out of our own timeout does.
+		if ctx.Err() == nil {
+			srv.health.failure()
+		}
+		err = fmt.Errorf("failed %s to %s: %v", method, url, err)
+		return nil, ctx.Err() == nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, err)
 	}
-	defer req.Body.Close()
+	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return nil, errors.Wrap(errors.APIClientError, errors.IOError, err)
+		if ctx.Err() == nil {
+			srv.health.failure()
+		}
+		return nil, ctx.Err() == nil, errors.Wrap(errors.APIClientError, errors.IOError, err)
+	}
+	serverError := resp.StatusCode >= http.StatusInternalServerError
+	if serverError {
+		srv.health.failure()
+	} else {
+		srv.health.success()
 	}
 
 	if resp.StatusCode != http.StatusOK {
 		log.Errorf("http error with %s", url)
-		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New(string(body)))
+		return nil, serverError, errors.Wrap(errors.APIClientError, errors.ClientHTTPError, stderr.New(string(body)))
 	}
 
 	var response api.Response
 	err = json.Unmarshal(body, &response)
 	if err != nil {
 		log.Debug("Unable to parse response body:", string(body))
-		return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
+		return nil, false, errors.Wrap(errors.APIClientError, errors.JSONError, err)
 	}
 
 	if !response.Success || response.Result == nil {
 		if len(response.Errors) > 0 {
-			return nil, errors.Wrap(errors.APIClientError, errors.ServerRequestFailed, stderr.New(response.Errors[0].Message))
+			return nil, false, errors.Wrap(errors.APIClientError, errors.ServerRequestFailed, stderr.New(response.Errors[0].Message))
 		}
-		return nil, errors.New(errors.APIClientError, errors.ServerRequestFailed)
+		return nil, false, errors.New(errors.APIClientError, errors.ServerRequestFailed)
 	}
 
-	return &response, nil
+	return &response, false, nil
 }
 
 // AuthSign fills out an authenticated signing request to the server,
@@ -152,7 +316,12 @@ func (srv *server) post(url string, jsonData []byte) (*api.Response, error) {
 // It takes the serialized JSON request to send, remote address and
 // authentication provider.
 func (srv *server) AuthSign(req, id []byte, provider auth.Provider) ([]byte, error) {
-	return srv.authReq(req, id, provider, "sign")
+	return srv.AuthSignContext(context.Background(), req, id, provider)
+}
+
+// AuthSignContext is AuthSign with a context.
+func (srv *server) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error) {
+	return srv.authReq(ctx, req, id, provider, "sign")
 }
 
 // AuthInfo fills out an authenticated info request to the server,
@@ -160,13 +329,13 @@ func (srv *server) AuthSign(req, id []byte, provider auth.Provider) ([]byte, err
 // It takes the serialized JSON request to send, remote address and
 // authentication provider.
 func (srv *server) AuthInfo(req, id []byte, provider auth.Provider) ([]byte, error) {
-	return srv.authReq(req, id, provider, "info")
+	return srv.authReq(context.Background(), req, id, provider, "info")
 }
 
 // authReq is the common logic for AuthSign and AuthInfo -- perform the given
 // request, and return the resultant certificate.
 // The target is either 'sign' or 'info'.
-func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string) ([]byte, error) {
+func (srv *server) authReq(ctx context.Context, req, ID []byte, provider auth.Provider, target string) ([]byte, error) {
 	url := srv.getURL("auth" + target)
 
 	token, err := provider.Token(req)
@@ -186,7 +355,7 @@ func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string
 		return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
 	}
 
-	response, err := srv.post(url, jsonData)
+	response, err := srv.post(ctx, url, jsonData, target == "info")
 	if err != nil {
 		return nil, err
 	}
@@ -208,14 +377,25 @@ func (srv *server) authReq(req, ID []byte, provider auth.Provider, target string
 // receiving a signed certificate or an error in response.
 // It takes the serialized JSON request to send.
 func (srv *server) Sign(jsonData []byte) ([]byte, error) {
-	return srv.request(jsonData, "sign")
+	return srv.SignContext(context.Background(), jsonData)
+}
+
+// SignContext is Sign with a context.
+func (srv *server) SignContext(ctx context.Context, jsonData []byte) ([]byte, error) {
+	return srv.request(ctx, jsonData, "sign")
 }
 
 // Info sends an info request to the remote CFSSL server, receiving a
 // response or an error in response.
 // It takes the serialized JSON request to send.
 func (srv *server) Info(jsonData []byte) (*info.Resp, error) {
-	res, err := srv.getResultMap(jsonData, "info")
+	return srv.InfoContext(context.Background(), jsonData)
+}
+
+// InfoContext is Info with a context. As info requests are idempotent,
+// they are retried after server failures.
+func (srv *server) InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error) {
+	res, err := srv.getResultMap(ctx, jsonData, "info")
 	if err != nil {
 		return nil, err
 	}
@@ -241,9 +421,9 @@ func (srv *server) Info(jsonData []byte) (*info.Resp, error) {
 	return info, nil
 }
 
-func (srv *server) getResultMap(jsonData []byte, target string) (result map[string]interface{}, err error) {
+func (srv *server) getResultMap(ctx context.Context, jsonData []byte, target string) (result map[string]interface{}, err error) {
 	url := srv.getURL(target)
-	response, err := srv.post(url, jsonData)
+	response, err := srv.post(ctx, url, jsonData, target == "info")
 	if err != nil {
 		return
 	}
@@ -257,8 +437,8 @@ func (srv *server) getResultMap(jsonData []byte, target string) (result map[stri
 
 // request performs the common logic for Sign and Info, performing the actual
 // request and returning the resultant certificate.
-func (srv *server) request(jsonData []byte, target string) ([]byte, error) {
-	result, err := srv.getResultMap(jsonData, target)
+func (srv *server) request(ctx context.Context, jsonData []byte, target string) ([]byte, error) {
+	result, err := srv.getResultMap(ctx, jsonData, target)
 	if err != nil {
 		return nil, err
 	}
@@ -291,6 +471,21 @@ func (ar *AuthRemote) Sign(req []byte) ([]byte, error) {
 	return ar.AuthSign(req, nil, ar.provider)
 }
 
+// SignContext is Sign with a context.
+func (ar *AuthRemote) SignContext(ctx context.Context, req []byte) ([]byte, error) {
+	return ar.AuthSignContext(ctx, req, nil, ar.provider)
+}
+
+// AuthSignContext is AuthSign with a context.
+func (ar *AuthRemote) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) ([]byte, error) {
+	return AsContextRemote(ar.Remote).AuthSignContext(ctx, req, id, provider)
+}
+
+// InfoContext is Info with a context.
+func (ar *AuthRemote) InfoContext(ctx context.Context, jsonData []byte) (*info.Resp, error) {
+	return AsContextRemote(ar.Remote).InfoContext(ctx, jsonData)
+}
+
 // nomalizeURL checks for http/https protocol, appends "http" as default protocol if not defiend in url
 func normalizeURL(addr string) (*url.URL, error) {
 	addr = strings.TrimSpace(addr)
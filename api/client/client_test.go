@@ -1,12 +1,18 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
+	"fmt"
 	"github.com/ucosty/cfssl/auth"
 	"github.com/ucosty/cfssl/helpers"
 	"net"
+	"net/http"
+	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 var (
@@ -201,3 +207,267 @@ func TestNewOGLGroup(t *testing.T) {
 		t.Fatalf("expected two remotes in the ordered group list but have %d", len(ogl.remotes))
 	}
 }
+
+func TestStrategyFromString(t *testing.T) {
+	for name, strategy := range map[string]Strategy{
+		"ordered_list":          StrategyOrderedList,
+		" Round_Robin ":         StrategyRoundRobin,
+		"random":                StrategyRandom,
+		"least_recently_failed": StrategyLeastRecentlyFailed,
+		"fastest":               StrategyInvalid,
+	} {
+		if s := StrategyFromString(name); s != strategy {
+			t.Fatalf("expected %q to be strategy %d, got %d", name, strategy, s)
+		}
+	}
+
+	for addr, strategy := range map[string]Strategy{
+		"ca1.local,ca2.local":             StrategyOrderedList,
+		"round_robin=ca1.local,ca2.local": StrategyRoundRobin,
+		"random=https://ca1.local:8888":   StrategyRandom,
+		"random:8888,ca2.local":           StrategyOrderedList,
+		"fastest=ca1.local,ca2.local":     StrategyInvalid,
+	} {
+		if s, _ := splitStrategy(addr); s != strategy {
+			t.Fatalf("expected %q to use strategy %d, got %d", addr, strategy, s)
+		}
+	}
+
+	rem := NewServer("round_robin=ca1.local, ca2.local")
+	if g, ok := rem.(*balancedGroup); !ok || g.strategy != StrategyRoundRobin || len(g.Hosts()) != 2 {
+		t.Fatalf("expected a round-robin group, got %T", rem)
+	}
+	if rem = NewServer("fastest=ca1.local, ca2.local"); rem != nil {
+		t.Fatalf("expected an unknown strategy to be rejected, got %T", rem)
+	}
+	if rem = NewServer("round-robin=ca1.local"); rem != nil {
+		t.Fatalf("expected an unknown strategy to be rejected, got %T", rem)
+	}
+}
+
+// testSigners starts servers answering sign requests with their index
+// as the certificate, or with a server error while failing is set.
+func testSigners(t *testing.T, n int) ([]*httptest.Server, []int32, []int32) {
+	servers := make([]*httptest.Server, n)
+	hits := make([]int32, n)
+	failing := make([]int32, n)
+	for i := range servers {
+		i := i
+		servers[i] = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			atomic.AddInt32(&hits[i], 1)
+			if atomic.LoadInt32(&failing[i]) != 0 {
+				http.Error(w, "unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			fmt.Fprintf(w, `{"success":true,"result":{"certificate":"%d"},"errors":[],"messages":[]}`, i)
+		}))
+	}
+	return servers, hits, failing
+}
+
+func groupAddr(strategy string, servers []*httptest.Server) string {
+	var urls []string
+	for _, srv := range servers {
+		urls = append(urls, srv.URL)
+	}
+	return strategy + "=" + strings.Join(urls, ",")
+}
+
+func TestRoundRobinGroup(t *testing.T) {
+	servers, hits, _ := testSigners(t, 3)
+	for _, srv := range servers {
+		defer srv.Close()
+	}
+
+	// Groups take turns independently.
+	rem, other := NewServer(groupAddr("round_robin", servers)), NewServer(groupAddr("round_robin", servers))
+	if _, err := other.Sign([]byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	hits[0] = 0
+
+	for i := 0; i < 6; i++ {
+		cert, err := rem.Sign([]byte("{}"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(cert) != fmt.Sprint(i%3) {
+			t.Fatalf("expected request %d to go to server %d, got %s", i, i%3, cert)
+		}
+	}
+	for i, n := range hits {
+		if n != 2 {
+			t.Fatalf("expected server %d to get 2 requests, got %d", i, n)
+		}
+	}
+}
+
+func TestGroupBreaker(t *testing.T) {
+	defer func(interval time.Duration) { BreakerInterval = interval }(BreakerInterval)
+	BreakerInterval = time.Hour
+
+	for _, strategy := range []string{"round_robin", "random", "least_recently_failed"} {
+		servers, hits, failing := testSigners(t, 2)
+		atomic.StoreInt32(&failing[0], 1)
+		rem := NewServer(groupAddr(strategy, servers))
+
+		for i := 0; i < 4; i++ {
+			cert, err := rem.Sign([]byte("{}"))
+			if err != nil || string(cert) != "1" {
+				t.Fatalf("%s: expected the healthy server to answer, got %s: %v", strategy, cert, err)
+			}
+		}
+		if hits[0] > 1 {
+			t.Fatalf("%s: expected the failing server to be ejected after one request, got %d", strategy, hits[0])
+		}
+
+		// With every server ejected, they are tried anyway.
+		atomic.StoreInt32(&failing[0], 0)
+		atomic.StoreInt32(&failing[1], 1)
+		if _, err := rem.Sign([]byte("{}")); err != nil {
+			t.Fatalf("%s: %v", strategy, err)
+		}
+		if _, err := rem.Sign([]byte("{}")); err != nil {
+			t.Fatalf("%s: expected the recovered server to be tried, got %v", strategy, err)
+		}
+
+		for _, srv := range servers {
+			srv.Close()
+		}
+	}
+}
+
+func TestBreakerOneFailurePerAttempt(t *testing.T) {
+	defer func(interval time.Duration) { BreakerInterval = interval }(BreakerInterval)
+	BreakerInterval = time.Minute
+
+	// A server error whose body is cut short fails twice over, but is a
+	// single failed attempt.
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Length", "100")
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("unavailable"))
+	}))
+	defer ts.Close()
+
+	u, err := normalizeURL(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv, _ := newServer(u, nil)
+	if _, _, err = srv.sendOnce(context.Background(), 0, "POST", srv.getURL("sign"), []byte("{}")); err == nil {
+		t.Fatal("expected the request to fail")
+	}
+	if ejected := srv.health.ejectedUntil.Sub(srv.health.lastFailure); ejected != BreakerInterval {
+		t.Fatalf("expected the server to be ejected for %v, got %v", BreakerInterval, ejected)
+	}
+}
+
+func TestContextDeadline(t *testing.T) {
+	hung := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	remote := NewServer(srv.URL).(ContextRemote)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := remote.SignContext(ctx, []byte("{}")); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+
+	defer func(timeout time.Duration) { DefaultTimeout = timeout }(DefaultTimeout)
+	DefaultTimeout = 50 * time.Millisecond
+	if _, err := remote.Info([]byte("{}")); err == nil {
+		t.Fatal("expected a request without a deadline to time out")
+	}
+}
+
+func TestDefaultTimeoutRetries(t *testing.T) {
+	defer func(interval time.Duration) { RetryInterval = interval }(RetryInterval)
+	RetryInterval = time.Millisecond
+	defer func(timeout time.Duration) { DefaultTimeout = timeout }(DefaultTimeout)
+	DefaultTimeout = 50 * time.Millisecond
+
+	hung := make(chan struct{})
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1) == 1 {
+			<-hung
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"result":{"certificate":"ca"},"errors":[],"messages":[]}`)
+	}))
+	defer srv.Close()
+	defer close(hung)
+
+	// Running out of DefaultTimeout is the server's failure: it is retried.
+	remote := NewServer(srv.URL).(ContextRemote)
+	if resp, err := remote.Info([]byte("{}")); err != nil || resp.Certificate != "ca" {
+		t.Fatalf("expected info to be retried after timing out, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 2 {
+		t.Fatalf("expected 2 requests, got %d", hits)
+	}
+
+	// The caller's own deadline is not.
+	atomic.StoreInt32(&hits, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := remote.InfoContext(ctx, []byte("{}")); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+}
+
+func TestIdempotentRetries(t *testing.T) {
+	defer func(interval time.Duration) { RetryInterval = interval }(RetryInterval)
+	RetryInterval = time.Millisecond
+
+	var hits int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&hits, 1)%3 != 0 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		fmt.Fprint(w, `{"success":true,"result":{"certificate":"ca"},"errors":[],"messages":[]}`)
+	}))
+	defer srv.Close()
+
+	remote := NewServer(srv.URL)
+	if resp, err := remote.Info([]byte("{}")); err != nil || resp.Certificate != "ca" {
+		t.Fatalf("expected info to be retried, got %v", err)
+	}
+	if atomic.LoadInt32(&hits) != 3 {
+		t.Fatalf("expected 3 requests, got %d", hits)
+	}
+
+	atomic.StoreInt32(&hits, 0)
+	if _, err := remote.Sign([]byte("{}")); err == nil {
+		t.Fatal("expected sign not to be retried")
+	}
+	if atomic.LoadInt32(&hits) != 1 {
+		t.Fatalf("expected 1 request, got %d", hits)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	ids := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids <- r.Header.Get(RequestIDHeader)
+		fmt.Fprint(w, `{"success":true,"result":{"certificate":"cert"},"errors":[],"messages":[]}`)
+	}))
+	defer srv.Close()
+
+	ctx := WithRequestID(context.Background(), "request-1")
+	if _, err := AsContextRemote(NewServer(srv.URL)).SignContext(ctx, []byte("{}")); err != nil {
+		t.Fatal(err)
+	}
+	if id := <-ids; id != "request-1" {
+		t.Fatalf("expected the request ID header, got %q", id)
+	}
+}
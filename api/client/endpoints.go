@@ -0,0 +1,347 @@
+package client
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	stderr "errors"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/api/renew"
+	"github.com/ucosty/cfssl/api/revoke"
+	"github.com/ucosty/cfssl/auth"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certinfo"
+	"github.com/ucosty/cfssl/csr"
+	"github.com/ucosty/cfssl/errors"
+)
+
+// A Client is a ContextRemote for every endpoint of the CFSSL API served
+// by cfssl serve. The EST, ACME and SCEP endpoints speak their own
+// protocols, for which standard clients should be used.
+type Client interface {
+	ContextRemote
+
+	// Bundle returns the bundle of a certificate.
+	Bundle(ctx context.Context, req BundleRequest) (*BundleResult, error)
+
+	// NewKey generates a private key and a CSR for it.
+	NewKey(ctx context.Context, req *csr.CertificateRequest) (*NewKeyResult, error)
+
+	// NewCert generates a private key and a signed certificate for it.
+	NewCert(ctx context.Context, req NewCertRequest) (*NewCertResult, error)
+
+	// InitCA generates the key and self-signed certificate of a new CA.
+	InitCA(ctx context.Context, req *csr.CertificateRequest) (*InitCAResult, error)
+
+	// Scan scans a host.
+	Scan(ctx context.Context, req ScanRequest) (ScanResult, error)
+
+	// ScanInfo returns the families of scanners, by name.
+	ScanInfo(ctx context.Context) (map[string]ScanFamily, error)
+
+	// CertInfo returns the details of a certificate.
+	CertInfo(ctx context.Context, req CertInfoRequest) (*certinfo.Certificate, error)
+
+	// CRL returns a DER encoded CRL of the cert db.
+	CRL(ctx context.Context, req CRLRequest) ([]byte, error)
+
+	// GenCRL returns a DER encoded CRL of the given serial numbers.
+	GenCRL(ctx context.Context, req GenCRLRequest) ([]byte, error)
+
+	// Certificates returns a page of the certificates of the cert db
+	// that match the query.
+	Certificates(ctx context.Context, q certdb.CertificateQuery) (*certdb.CertificatePage, error)
+
+	// OCSPSign returns a DER encoded OCSP response.
+	OCSPSign(ctx context.Context, req OCSPSignRequest) ([]byte, error)
+
+	// Revoke revokes a certificate.
+	Revoke(ctx context.Context, req RevokeRequest) error
+
+	// BulkRevoke revokes every certificate matching a query.
+	BulkRevoke(ctx context.Context, req BulkRevokeRequest) (*revoke.BulkResult, error)
+
+	// Unhold releases a certificate revoked with reason
+	// certificateHold.
+	Unhold(ctx context.Context, req UnholdRequest) error
+
+	// RevocationLog returns revocation events.
+	RevocationLog(ctx context.Context, req RevocationLogRequest) ([]certdb.RevocationEvent, error)
+
+	// RenewNonce returns a single-use nonce for a renewal request.
+	RenewNonce(ctx context.Context) (string, error)
+
+	// Renew reissues a certificate recorded in the cert db, returning
+	// the new PEM certificate.
+	Renew(ctx context.Context, req renew.Request) ([]byte, error)
+}
+
+// NewClient returns a Client of the CFSSL server at addr. Unlike
+// NewServerTLS, it does not take a group of servers.
+func NewClient(addr string, tlsConfig *tls.Config) (Client, error) {
+	u, err := normalizeURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	srv, _ := newServer(u, tlsConfig)
+	return srv, nil
+}
+
+// NewAuthClient returns a Client whose Sign requests are authenticated
+// with provider, as those of NewAuthServer.
+func NewAuthClient(addr string, tlsConfig *tls.Config, provider auth.Provider) (Client, error) {
+	c, err := NewClient(addr, tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &authClient{Client: c, auth: &AuthRemote{Remote: c, provider: provider}}, nil
+}
+
+// authClient is a Client that signs through an AuthRemote.
+type authClient struct {
+	Client
+	auth *AuthRemote
+}
+
+func (c *authClient) Sign(req []byte) ([]byte, error) {
+	return c.auth.Sign(req)
+}
+
+func (c *authClient) SignContext(ctx context.Context, req []byte) ([]byte, error) {
+	return c.auth.SignContext(ctx, req)
+}
+
+// call makes a request of the endpoint, a GET with the query if request
+// is nil or a POST of its JSON encoding otherwise, and decodes the
+// result of the response into result.
+func (srv *server) call(ctx context.Context, endpoint string, query url.Values, request interface{}, idempotent bool, result interface{}) (*api.Response, error) {
+	method := "GET"
+	var jsonData []byte
+	if request != nil {
+		var err error
+		method = "POST"
+		if jsonData, err = json.Marshal(request); err != nil {
+			return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
+		}
+	}
+
+	target := srv.getURL(endpoint)
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+	response, err := srv.send(ctx, method, target, jsonData, idempotent)
+	if err != nil {
+		return nil, err
+	}
+
+	if result != nil {
+		raw, err := json.Marshal(response.Result)
+		if err != nil {
+			return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
+		}
+		if err = json.Unmarshal(raw, result); err != nil {
+			return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
+		}
+	}
+	return response, nil
+}
+
+func (srv *server) Bundle(ctx context.Context, req BundleRequest) (*BundleResult, error) {
+	result := new(BundleResult)
+	if _, err := srv.call(ctx, "bundle", nil, req, true, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) NewKey(ctx context.Context, req *csr.CertificateRequest) (*NewKeyResult, error) {
+	result := new(NewKeyResult)
+	if _, err := srv.call(ctx, "newkey", nil, req, false, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) NewCert(ctx context.Context, req NewCertRequest) (*NewCertResult, error) {
+	result := new(NewCertResult)
+	response, err := srv.call(ctx, "newcert", nil, req, false, result)
+	if err != nil {
+		return nil, err
+	}
+	for _, msg := range response.Messages {
+		result.Messages = append(result.Messages, msg.Message)
+	}
+	return result, nil
+}
+
+func (srv *server) InitCA(ctx context.Context, req *csr.CertificateRequest) (*InitCAResult, error) {
+	result := new(InitCAResult)
+	if _, err := srv.call(ctx, "init_ca", nil, req, false, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Scan is given the scan's timeout on top of DefaultTimeout when ctx
+// has no deadline.
+func (srv *server) Scan(ctx context.Context, req ScanRequest) (ScanResult, error) {
+	query := url.Values{"host": {req.Host}}
+	for name, value := range map[string]string{"ip": req.IP, "family": req.Family, "scanner": req.Scanner} {
+		if value != "" {
+			query.Set(name, value)
+		}
+	}
+	timeout := time.Minute
+	if req.Timeout != 0 {
+		timeout = req.Timeout
+		query.Set("timeout", req.Timeout.String())
+	}
+	if DefaultTimeout > 0 {
+		ctx = context.WithValue(ctx, attemptTimeoutKey{}, timeout+DefaultTimeout)
+	}
+
+	var result ScanResult
+	if _, err := srv.call(ctx, "scan", query, nil, true, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) ScanInfo(ctx context.Context) (map[string]ScanFamily, error) {
+	var result map[string]ScanFamily
+	if _, err := srv.call(ctx, "scaninfo", nil, nil, true, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) CertInfo(ctx context.Context, req CertInfoRequest) (*certinfo.Certificate, error) {
+	result := new(certinfo.Certificate)
+	if _, err := srv.call(ctx, "certinfo", nil, req, true, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) CRL(ctx context.Context, req CRLRequest) ([]byte, error) {
+	query := url.Values{}
+	if req.Expiry != 0 {
+		query.Set("expiry", req.Expiry.String())
+	}
+	if req.DistributionPoint != "" {
+		query.Set("distribution_point", req.DistributionPoint)
+	}
+	if req.Delta {
+		query.Set("delta", "true")
+	}
+
+	// Each CRL takes the next CRL number, so requests are not retried.
+	var crl []byte
+	if _, err := srv.call(ctx, "crl", query, nil, false, &crl); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+func (srv *server) GenCRL(ctx context.Context, req GenCRLRequest) ([]byte, error) {
+	wire := struct {
+		Certificate  string   `json:"certificate"`
+		SerialNumber []string `json:"serialNumber"`
+		PrivateKey   string   `json:"issuingKey"`
+		ExpiryTime   string   `json:"expireTime,omitempty"`
+	}{req.Certificate, req.SerialNumbers, req.PrivateKey, ""}
+	if req.Expiry != 0 {
+		wire.ExpiryTime = strconv.FormatInt(int64(req.Expiry/time.Second), 10)
+	}
+
+	var crl []byte
+	if _, err := srv.call(ctx, "gencrl", nil, wire, false, &crl); err != nil {
+		return nil, err
+	}
+	return crl, nil
+}
+
+func (srv *server) Certificates(ctx context.Context, q certdb.CertificateQuery) (*certdb.CertificatePage, error) {
+	result := new(certdb.CertificatePage)
+	if _, err := srv.call(ctx, "certificates", nil, q, true, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) OCSPSign(ctx context.Context, req OCSPSignRequest) ([]byte, error) {
+	var result struct {
+		OCSPResponse string `json:"ocspResponse"`
+	}
+	if _, err := srv.call(ctx, "ocspsign", nil, req, false, &result); err != nil {
+		return nil, err
+	}
+	resp, err := base64.StdEncoding.DecodeString(result.OCSPResponse)
+	if err != nil {
+		return nil, errors.Wrap(errors.APIClientError, errors.JSONError, err)
+	}
+	return resp, nil
+}
+
+func (srv *server) Revoke(ctx context.Context, req RevokeRequest) error {
+	_, err := srv.call(ctx, "revoke", nil, req, false, nil)
+	return err
+}
+
+func (srv *server) BulkRevoke(ctx context.Context, req BulkRevokeRequest) (*revoke.BulkResult, error) {
+	result := new(revoke.BulkResult)
+	if _, err := srv.call(ctx, "bulkrevoke", nil, req, req.DryRun, result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (srv *server) Unhold(ctx context.Context, req UnholdRequest) error {
+	_, err := srv.call(ctx, "unhold", nil, req, false, nil)
+	return err
+}
+
+func (srv *server) RevocationLog(ctx context.Context, req RevocationLogRequest) ([]certdb.RevocationEvent, error) {
+	query := url.Values{}
+	switch {
+	case req.Serial != "" && !req.Since.IsZero():
+		return nil, errors.Wrap(errors.APIClientError, errors.ClientHTTPError,
+			stderr.New("serial and since are mutually exclusive"))
+	case req.Serial != "":
+		query.Set("serial", req.Serial)
+		query.Set("authority_key_id", req.AKI)
+	default:
+		query.Set("since", req.Since.Format(time.RFC3339))
+	}
+
+	var events []certdb.RevocationEvent
+	if _, err := srv.call(ctx, "revocationlog", query, nil, true, &events); err != nil {
+		return nil, err
+	}
+	return events, nil
+}
+
+func (srv *server) RenewNonce(ctx context.Context) (string, error) {
+	var result struct {
+		Nonce string `json:"nonce"`
+	}
+	if _, err := srv.call(ctx, "renew", nil, nil, true, &result); err != nil {
+		return "", err
+	}
+	return result.Nonce, nil
+}
+
+func (srv *server) Renew(ctx context.Context, req renew.Request) ([]byte, error) {
+	var result struct {
+		Certificate string `json:"certificate"`
+	}
+	if _, err := srv.call(ctx, "renew", nil, req, false, &result); err != nil {
+		return nil, err
+	}
+	return []byte(result.Certificate), nil
+}
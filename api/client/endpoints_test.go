@@ -0,0 +1,199 @@
+package client
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/api/certinfo"
+	"github.com/ucosty/cfssl/api/gencrl"
+	"github.com/ucosty/cfssl/api/initca"
+	"github.com/ucosty/cfssl/auth"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/csr"
+)
+
+func newTestClient(t *testing.T, handlers map[string]http.Handler) (Client, func()) {
+	mux := http.NewServeMux()
+	for endpoint, h := range handlers {
+		mux.Handle("/api/v1/cfssl/"+endpoint, h)
+	}
+	srv := httptest.NewServer(mux)
+	c, err := NewClient(srv.URL, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c, srv.Close
+}
+
+func TestClientGenerators(t *testing.T) {
+	c, done := newTestClient(t, map[string]http.Handler{
+		"init_ca":  initca.NewHandler(),
+		"certinfo": certinfo.NewHandler(),
+		"gencrl":   gencrl.NewHandler(),
+	})
+	defer done()
+	ctx := context.Background()
+
+	ca, err := c.InitCA(ctx, &csr.CertificateRequest{CN: "Test CA", KeyRequest: csr.NewBasicKeyRequest()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := c.CertInfo(ctx, CertInfoRequest{Certificate: ca.Certificate})
+	if err != nil || info.Subject.CommonName != "Test CA" {
+		t.Fatalf("expected the details of the CA, got %+v: %v", info, err)
+	}
+
+	der, err := c.GenCRL(ctx, GenCRLRequest{
+		Certificate:   ca.Certificate,
+		PrivateKey:    ca.PrivateKey,
+		SerialNumbers: []string{"42"},
+		Expiry:        time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseRevocationList(der)
+	if err != nil || len(crl.RevokedCertificateEntries) != 1 || crl.RevokedCertificateEntries[0].SerialNumber.Int64() != 42 {
+		t.Fatalf("expected a CRL revoking serial 42, got %v", err)
+	}
+}
+
+// recorder is a handler of an endpoint answering with a fixed result,
+// which records the last request.
+type recorder struct {
+	result string
+	method string
+	query  string
+	body   map[string]interface{}
+}
+
+func (rec *recorder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rec.method, rec.query, rec.body = r.Method, r.URL.RawQuery, nil
+	body, _ := ioutil.ReadAll(r.Body)
+	json.Unmarshal(body, &rec.body)
+	fmt.Fprintf(w, `{"success":true,"result":%s,"errors":[],"messages":[]}`, rec.result)
+}
+
+func TestClientCertDB(t *testing.T) {
+	recorders := map[string]*recorder{
+		"crl":           {result: `"MDA="`},
+		"certificates":  {result: `{"certificates":[{"serial":"1"}],"next_cursor":"next"}`},
+		"ocspsign":      {result: `{"ocspResponse":"MDA="}`},
+		"revoke":        {result: `{}`},
+		"bulkrevoke":    {result: `{"dry_run":true,"count":1,"certificates":[{"serial":"1"}]}`},
+		"unhold":        {result: `{}`},
+		"revocationlog": {result: `[{"serial":"1","action":"revoke"}]`},
+		"newkey":        {result: `{"private_key":"key","certificate_request":"csr","sums":{"certificate_request":{"md5":"a","sha-1":"b"}}}`},
+		"scaninfo":      {result: `{"Connectivity":{"description":"d","scanners":{"DNSLookup":{"description":"s"}}}}`},
+		"scan":          {result: `{"Connectivity":{"DNSLookup":{"grade":"Good"}}}`},
+	}
+	handlers := map[string]http.Handler{}
+	for endpoint, rec := range recorders {
+		handlers[endpoint] = rec
+	}
+	c, done := newTestClient(t, handlers)
+	defer done()
+	ctx := context.Background()
+
+	crl, err := c.CRL(ctx, CRLRequest{DistributionPoint: "http://crl.example.com/1.crl", Delta: true})
+	if err != nil || string(crl) != "00" {
+		t.Fatalf("unexpected CRL %q: %v", crl, err)
+	}
+	if rec := recorders["crl"]; rec.method != "GET" || rec.query != "delta=true&distribution_point=http%3A%2F%2Fcrl.example.com%2F1.crl" {
+		t.Fatalf("unexpected CRL request %s %s", rec.method, rec.query)
+	}
+
+	page, err := c.Certificates(ctx, certdb.CertificateQuery{CALabel: "ca"})
+	if err != nil || len(page.Certificates) != 1 || page.NextCursor != "next" {
+		t.Fatalf("unexpected page %+v: %v", page, err)
+	}
+	if recorders["certificates"].body["ca_label"] != "ca" {
+		t.Fatal("expected the query to be posted")
+	}
+
+	if resp, err := c.OCSPSign(ctx, OCSPSignRequest{Certificate: "cert", Status: "good"}); err != nil || string(resp) != "00" {
+		t.Fatalf("unexpected OCSP response %q: %v", resp, err)
+	}
+
+	if err = c.Revoke(ctx, RevokeRequest{Serial: "1", AKI: "aki", Reason: "keyCompromise"}); err != nil {
+		t.Fatal(err)
+	}
+	if body := recorders["revoke"].body; body["serial"] != "1" || body["authority_key_id"] != "aki" || body["reason"] != "keyCompromise" {
+		t.Fatalf("unexpected revocation request %v", body)
+	}
+
+	bulk, err := c.BulkRevoke(ctx, BulkRevokeRequest{CertificateQuery: certdb.CertificateQuery{AKI: "aki"}, DryRun: true})
+	if err != nil || bulk.Count != 1 || !bulk.DryRun {
+		t.Fatalf("unexpected bulk revocation %+v: %v", bulk, err)
+	}
+	if body := recorders["bulkrevoke"].body; body["authority_key_identifier"] != "aki" || body["dry_run"] != true {
+		t.Fatalf("unexpected bulk revocation request %v", body)
+	}
+
+	key, err := c.NewKey(ctx, &csr.CertificateRequest{CN: "example.com"})
+	if err != nil || key.PrivateKey != "key" || key.Sums["certificate_request"].SHA1 != "b" {
+		t.Fatalf("unexpected key %+v: %v", key, err)
+	}
+	if recorders["newkey"].body["CN"] != "example.com" {
+		t.Fatal("expected the certificate request to be posted")
+	}
+
+	families, err := c.ScanInfo(ctx)
+	if err != nil || families["Connectivity"].Scanners["DNSLookup"].Description != "s" {
+		t.Fatalf("unexpected scan info %+v: %v", families, err)
+	}
+	scan, err := c.Scan(ctx, ScanRequest{Host: "example.com", Family: "Connectivity", Timeout: 10 * time.Second})
+	if err != nil || scan["Connectivity"]["DNSLookup"].Grade != "Good" {
+		t.Fatalf("unexpected scan %+v: %v", scan, err)
+	}
+	if rec := recorders["scan"]; rec.method != "GET" || rec.query != "family=Connectivity&host=example.com&timeout=10s" {
+		t.Fatalf("unexpected scan request %s %s", rec.method, rec.query)
+	}
+
+	if err = c.Unhold(ctx, UnholdRequest{Serial: "1", AKI: "aki"}); err != nil {
+		t.Fatal(err)
+	}
+
+	events, err := c.RevocationLog(ctx, RevocationLogRequest{Serial: "1", AKI: "aki"})
+	if err != nil || len(events) != 1 || events[0].Action != "revoke" {
+		t.Fatalf("unexpected events %+v: %v", events, err)
+	}
+	if rec := recorders["revocationlog"]; rec.method != "GET" || rec.query != "authority_key_id=aki&serial=1" {
+		t.Fatalf("unexpected revocation log request %s %s", rec.method, rec.query)
+	}
+	if _, err = c.RevocationLog(ctx, RevocationLogRequest{Serial: "1", Since: time.Now()}); err == nil {
+		t.Fatal("expected serial and since to be mutually exclusive")
+	}
+}
+
+func TestAuthClient(t *testing.T) {
+	sign := &recorder{result: `{"certificate":"cert"}`}
+	authSign := &recorder{result: `{"certificate":"cert"}`}
+	mux := http.NewServeMux()
+	mux.Handle("/api/v1/cfssl/sign", sign)
+	mux.Handle("/api/v1/cfssl/authsign", authSign)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	provider, err := auth.New(testKey, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewAuthClient(srv.URL, nil, provider)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.SignContext(context.Background(), []byte(`{}`)); err != nil {
+		t.Fatal(err)
+	}
+	if authSign.method != "POST" || sign.method != "" {
+		t.Fatal("expected the request to be authenticated")
+	}
+}
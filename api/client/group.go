@@ -1,10 +1,14 @@
 package client
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"math/rand"
 	"net/http"
+	"sort"
 	"strings"
+	"sync"
 
 	"github.com/ucosty/cfssl/auth"
 	"github.com/ucosty/cfssl/info"
@@ -24,13 +28,31 @@ const (
 	// client will proceed in this manner until the list of
 	// servers is exhausted, and then an error is returned.
 	StrategyOrderedList
+
+	// StrategyRoundRobin sends each request to the next server in
+	// turn, trying the following servers if it fails.
+	StrategyRoundRobin
+
+	// StrategyRandom tries the servers in a random order for each
+	// request.
+	StrategyRandom
+
+	// StrategyLeastRecentlyFailed tries the servers that have never
+	// failed first, in order, then the others from the one that
+	// failed longest ago.
+	StrategyLeastRecentlyFailed
 )
 
 var strategyStrings = map[string]Strategy{
-	"ordered_list": StrategyOrderedList,
+	"ordered_list":          StrategyOrderedList,
+	"round_robin":           StrategyRoundRobin,
+	"random":                StrategyRandom,
+	"least_recently_failed": StrategyLeastRecentlyFailed,
 }
 
-// StrategyFromString takes a string describing a
+// StrategyFromString takes a string describing a strategy, such as
+// "round_robin", and returns the Strategy, or StrategyInvalid if there
+// is no such strategy.
 func StrategyFromString(s string) Strategy {
 	s = strings.TrimSpace(strings.ToLower(s))
 	strategy, ok := strategyStrings[s]
@@ -40,8 +62,23 @@ func StrategyFromString(s string) Strategy {
 	return strategy
 }
 
+// splitStrategy splits the strategy prefix from a list of addresses such
+// as round_robin=ca1.local,ca2.local, returning StrategyOrderedList if
+// there is none and StrategyInvalid if the strategy is unknown. Host
+// names and ports cannot contain an equals sign, so the prefix is
+// never mistaken for an address.
+func splitStrategy(addr string) (Strategy, string) {
+	i := strings.Index(addr, "=")
+	if i < 0 || strings.ContainsAny(addr[:i], ":/,") {
+		return StrategyOrderedList, addr
+	}
+	return StrategyFromString(addr[:i]), addr[i+1:]
+}
+
 // NewGroup will use the collection of remotes specified with the
-// given strategy.
+// given strategy. Except for StrategyOrderedList, the strategies skip
+// servers that their circuit breaker has ejected after a failure, unless
+// every server is ejected.
 func NewGroup(remotes []string, tlsConfig *tls.Config, strategy Strategy) (Remote, error) {
 	var servers = make([]*server, len(remotes))
 	for i := range remotes {
@@ -55,6 +92,11 @@ func NewGroup(remotes []string, tlsConfig *tls.Config, strategy Strategy) (Remot
 	switch strategy {
 	case StrategyOrderedList:
 		return newOrdererdListGroup(servers)
+	case StrategyRoundRobin, StrategyRandom, StrategyLeastRecentlyFailed:
+		if len(servers) == 0 {
+			return nil, errors.New("no remotes in group")
+		}
+		return &balancedGroup{remotes: servers, strategy: strategy}, nil
 	default:
 		return nil, errors.New("unrecognised strategy")
 	}
@@ -80,10 +122,16 @@ func newOrdererdListGroup(remotes []*server) (Remote, error) {
 }
 
 func (g *orderedListGroup) AuthSign(req, id []byte, provider auth.Provider) (resp []byte, err error) {
+	return g.AuthSignContext(context.Background(), req, id, provider)
+}
+
+// AuthSignContext is AuthSign with a context. No further server is tried
+// once the context is done.
+func (g *orderedListGroup) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) (resp []byte, err error) {
 	for i := range g.remotes {
-		resp, err = g.remotes[i].AuthSign(req, id, provider)
-		if err == nil {
-			return resp, nil
+		resp, err = g.remotes[i].AuthSignContext(ctx, req, id, provider)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
 		}
 	}
 
@@ -91,10 +139,16 @@ func (g *orderedListGroup) AuthSign(req, id []byte, provider auth.Provider) (res
 }
 
 func (g *orderedListGroup) Sign(jsonData []byte) (resp []byte, err error) {
+	return g.SignContext(context.Background(), jsonData)
+}
+
+// SignContext is Sign with a context. No further server is tried
+// once the context is done.
+func (g *orderedListGroup) SignContext(ctx context.Context, jsonData []byte) (resp []byte, err error) {
 	for i := range g.remotes {
-		resp, err = g.remotes[i].Sign(jsonData)
-		if err == nil {
-			return resp, nil
+		resp, err = g.remotes[i].SignContext(ctx, jsonData)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
 		}
 	}
 
@@ -102,10 +156,16 @@ func (g *orderedListGroup) Sign(jsonData []byte) (resp []byte, err error) {
 }
 
 func (g *orderedListGroup) Info(jsonData []byte) (resp *info.Resp, err error) {
+	return g.InfoContext(context.Background(), jsonData)
+}
+
+// InfoContext is Info with a context. No further server is tried
+// once the context is done.
+func (g *orderedListGroup) InfoContext(ctx context.Context, jsonData []byte) (resp *info.Resp, err error) {
 	for i := range g.remotes {
-		resp, err = g.remotes[i].Info(jsonData)
-		if err == nil {
-			return resp, nil
+		resp, err = g.remotes[i].InfoContext(ctx, jsonData)
+		if err == nil || ctx.Err() != nil {
+			return resp, err
 		}
 	}
 
@@ -116,3 +176,117 @@ func (g *orderedListGroup) Info(jsonData []byte) (resp *info.Resp, err error) {
 func (g *orderedListGroup) SetReqModifier(mod func(*http.Request, []byte)) {
 	// noop
 }
+
+// A balancedGroup spreads requests over its servers in the order its
+// strategy picks, skipping ejected servers.
+type balancedGroup struct {
+	remotes  []*server
+	strategy Strategy
+
+	// mu guards next, the server to start the next round-robin
+	// request with.
+	mu   sync.Mutex
+	next int
+}
+
+func (g *balancedGroup) Hosts() []string {
+	var hosts = make([]string, 0, len(g.remotes))
+	for _, srv := range g.remotes {
+		hosts = append(hosts, srv.URL)
+	}
+	return hosts
+}
+
+// candidates returns the servers in the order to try them, with the
+// ejected servers last.
+func (g *balancedGroup) candidates() []*server {
+	n := len(g.remotes)
+	order := make([]*server, 0, n)
+	switch g.strategy {
+	case StrategyRoundRobin:
+		g.mu.Lock()
+		start := g.next
+		g.next = (start + 1) % n
+		g.mu.Unlock()
+		for i := 0; i < n; i++ {
+			order = append(order, g.remotes[(start+i)%n])
+		}
+	case StrategyRandom:
+		for _, i := range rand.Perm(n) {
+			order = append(order, g.remotes[i])
+		}
+	case StrategyLeastRecentlyFailed:
+		order = append(order, g.remotes...)
+		sort.SliceStable(order, func(i, j int) bool {
+			return order[i].health.failedAt().Before(order[j].health.failedAt())
+		})
+	}
+
+	available := order[:0:0]
+	var ejected []*server
+	for _, srv := range order {
+		if srv.health.ejected() {
+			ejected = append(ejected, srv)
+		} else {
+			available = append(available, srv)
+		}
+	}
+	return append(available, ejected...)
+}
+
+// do performs op against the candidates until it succeeds or ctx is
+// done, returning the last error otherwise.
+func (g *balancedGroup) do(ctx context.Context, op func(*server) error) (err error) {
+	for _, srv := range g.candidates() {
+		if err = op(srv); err == nil || ctx.Err() != nil {
+			return err
+		}
+	}
+	return err
+}
+
+func (g *balancedGroup) AuthSign(req, id []byte, provider auth.Provider) (resp []byte, err error) {
+	return g.AuthSignContext(context.Background(), req, id, provider)
+}
+
+// AuthSignContext is AuthSign with a context.
+func (g *balancedGroup) AuthSignContext(ctx context.Context, req, id []byte, provider auth.Provider) (resp []byte, err error) {
+	err = g.do(ctx, func(srv *server) (err error) {
+		resp, err = srv.AuthSignContext(ctx, req, id, provider)
+		return err
+	})
+	return resp, err
+}
+
+func (g *balancedGroup) Sign(jsonData []byte) (resp []byte, err error) {
+	return g.SignContext(context.Background(), jsonData)
+}
+
+// SignContext is Sign with a context.
+func (g *balancedGroup) SignContext(ctx context.Context, jsonData []byte) (resp []byte, err error) {
+	err = g.do(ctx, func(srv *server) (err error) {
+		resp, err = srv.SignContext(ctx, jsonData)
+		return err
+	})
+	return resp, err
+}
+
+func (g *balancedGroup) Info(jsonData []byte) (resp *info.Resp, err error) {
+	return g.InfoContext(context.Background(), jsonData)
+}
+
+// InfoContext is Info with a context.
+func (g *balancedGroup) InfoContext(ctx context.Context, jsonData []byte) (resp *info.Resp, err error) {
+	err = g.do(ctx, func(srv *server) (err error) {
+		resp, err = srv.InfoContext(ctx, jsonData)
+		return err
+	})
+	return resp, err
+}
+
+// SetReqModifier sets the request modifier of every server of the group.
+func (g *balancedGroup) SetReqModifier(mod func(*http.Request, []byte)) {
+	for _, srv := range g.remotes {
+		srv.SetReqModifier(mod)
+	}
+}
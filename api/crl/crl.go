@@ -11,6 +11,7 @@ import (
 
 	"github.com/ucosty/cfssl/api"
 	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/crl"
 	"github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
@@ -23,10 +24,18 @@ type Handler struct {
 	dbAccessor certdb.Accessor
 	ca         *x509.Certificate
 	key        crypto.Signer
+	partitions *config.CRLPartitions
 }
 
 // NewHandler returns a new http.Handler that handles a revoke request.
 func NewHandler(dbAccessor certdb.Accessor, caPath string, caKeyPath string) (http.Handler, error) {
+	return NewPartitionedHandler(dbAccessor, caPath, caKeyPath, nil)
+}
+
+// NewPartitionedHandler returns a new http.Handler that handles CRL
+// requests for a CA whose CRL is split into partitions. Requests must
+// name the distribution point of a partition.
+func NewPartitionedHandler(dbAccessor certdb.Accessor, caPath string, caKeyPath string, partitions *config.CRLPartitions) (http.Handler, error) {
 	ca, err := ioutil.ReadFile(caPath)
 	if err != nil {
 		return nil, err
@@ -61,20 +70,19 @@ func NewHandler(dbAccessor certdb.Accessor, caPath string, caKeyPath string) (ht
 			dbAccessor: dbAccessor,
 			ca:         issuerCert,
 			key:        key,
+			partitions: partitions,
 		},
 		Methods: []string{"GET"},
 	}, nil
 }
 
-// Handle responds to revocation requests. It attempts to revoke
-// a certificate with a given serial number
+// Handle responds to CRL requests. It generates a CRL of the revoked and
+// unexpired certificates in the cert db, numbered with the next CRL number
+// of the CA and the optional distribution point. With delta=true, a delta
+// CRL of the revocations since the last full CRL is generated instead.
 func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	var newExpiryTime = 7 * helpers.OneDay
-
-	certs, err := h.dbAccessor.GetRevokedAndUnexpiredCertificates()
-	if err != nil {
-		return err
-	}
+	var err error
 
 	queryExpiryTime := r.URL.Query().Get("expiry")
 	if queryExpiryTime != "" {
@@ -85,7 +93,12 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 		}
 	}
 
-	result, err := crl.NewCRLFromDB(certs, h.ca, h.key, newExpiryTime)
+	result, err := crl.NewCRLFromAccessor(h.dbAccessor, h.ca, h.key, crl.Options{
+		Expiry:            newExpiryTime,
+		DistributionPoint: r.URL.Query().Get("distribution_point"),
+		Delta:             r.URL.Query().Get("delta") == "true",
+		Partitions:        h.partitions,
+	})
 	if err != nil {
 		return err
 	}
@@ -2,6 +2,8 @@ package crl
 
 import (
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/json"
 	"io/ioutil"
@@ -147,3 +149,168 @@ func TestCRLGenerationWithExpiry(t *testing.T) {
 		t.Fatal("cert was not correctly inserted in CRL, serial was ", cert.SerialNumber)
 	}
 }
+
+func TestCRLNumberAndDistributionPoint(t *testing.T) {
+	dbAccessor, err := prepDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for want := int64(1); want <= 2; want++ {
+		crl := getCRL(t, dbAccessor, "?distribution_point=http://crl.example.com/ca.crl")
+
+		var number, idp *pkix.Extension
+		for i, ext := range crl.TBSCertList.Extensions {
+			switch ext.Id.String() {
+			case "2.5.29.20":
+				number = &crl.TBSCertList.Extensions[i]
+			case "2.5.29.28":
+				idp = &crl.TBSCertList.Extensions[i]
+			}
+		}
+		if number == nil || idp == nil {
+			t.Fatal("CRL is missing the CRL Number or Issuing Distribution Point extension")
+		}
+
+		var got int64
+		if _, err = asn1.Unmarshal(number.Value, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("want CRL number %d, got %d", want, got)
+		}
+	}
+
+	entries := getCRL(t, dbAccessor, "").TBSCertList.RevokedCertificates
+	if len(entries) != 1 || len(entries[0].Extensions) != 1 {
+		t.Fatal("want one CRL entry with a reasonCode extension")
+	}
+}
+
+func getCRL(t *testing.T, dbAccessor certdb.Accessor, query string) *pkix.CertificateList {
+	handler, err := NewHandler(dbAccessor, testCaFile, testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := new(api.Response)
+	if err = json.Unmarshal(body, message); err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	der, err := base64.StdEncoding.DecodeString(message.Result.(string))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return crl
+}
+
+func TestDeltaCRL(t *testing.T) {
+	dbAccessor, err := prepDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A delta CRL needs a full CRL to be based on.
+	resp, _ := testGetCRLQuery(t, dbAccessor, "?delta=true")
+	if resp.StatusCode == http.StatusOK {
+		t.Fatal("Expected an error generating a delta CRL without a full CRL")
+	}
+
+	err = dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial:    "0",
+		AKI:       fakeAKI,
+		Expiry:    time.Now().AddDate(1, 0, 0),
+		PEM:       "long revoked cert",
+		Status:    "revoked",
+		RevokedAt: time.Now().Add(-time.Hour),
+		Reason:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	full := getCRL(t, dbAccessor, "")
+	if len(full.TBSCertList.RevokedCertificates) != 2 {
+		t.Fatal("Expected the full CRL to list the revoked certificates")
+	}
+
+	err = dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial:    "2",
+		AKI:       fakeAKI,
+		Expiry:    time.Now().AddDate(1, 0, 0),
+		PEM:       "recently revoked cert",
+		Status:    "revoked",
+		RevokedAt: time.Now().Add(time.Minute),
+		Reason:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Revocations in the same second as the full CRL may be repeated in
+	// the delta CRL, so only the older and newer ones are checked.
+	delta := getCRL(t, dbAccessor, "?delta=true")
+	var sawRecent bool
+	for _, entry := range delta.TBSCertList.RevokedCertificates {
+		switch entry.SerialNumber.Int64() {
+		case 0:
+			t.Fatal("Expected the delta CRL to omit revocations listed in the full CRL")
+		case 2:
+			sawRecent = true
+		}
+	}
+	if !sawRecent {
+		t.Fatal("Expected the delta CRL to list the recently revoked certificate")
+	}
+
+	var indicator *pkix.Extension
+	for i, ext := range delta.TBSCertList.Extensions {
+		if ext.Id.String() == "2.5.29.27" {
+			indicator = &delta.TBSCertList.Extensions[i]
+		}
+	}
+	if indicator == nil || !indicator.Critical {
+		t.Fatal("Expected a critical Delta CRL Indicator extension")
+	}
+	var base int64
+	if _, err = asn1.Unmarshal(indicator.Value, &base); err != nil {
+		t.Fatal(err)
+	}
+	if base != 1 {
+		t.Fatalf("want the delta CRL based on CRL 1, got %d", base)
+	}
+}
+
+func testGetCRLQuery(t *testing.T, dbAccessor certdb.Accessor, query string) (resp *http.Response, body []byte) {
+	handler, err := NewHandler(dbAccessor, testCaFile, testCaKeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ts := httptest.NewServer(handler)
+	defer ts.Close()
+
+	resp, err = http.Get(ts.URL + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err = ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
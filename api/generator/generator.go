@@ -260,8 +260,9 @@ func (cg *CertGeneratorHandler) Handle(w http.ResponseWriter, r *http.Request) e
 
 	signReq := signer.SignRequest{
 		Request: string(csr),
-		Profile: req.Profile,
-		Label:   req.Label,
+		Profile:   req.Profile,
+		Label:     req.Label,
+		Requester: api.Requester(r, ""),
 	}
 
 	certBytes, err := cg.signer.Sign(signReq)
@@ -0,0 +1,298 @@
+// Package renew implements the HTTP handler for the renew command.
+package renew
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	stderr "errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/ocsp"
+	"github.com/ucosty/cfssl/signer"
+)
+
+// NonceLifetime bounds how long a renewal nonce may be used for.
+const NonceLifetime = 5 * time.Minute
+
+// MaxNonces bounds the number of outstanding nonces of a NonceStore;
+// beyond it, the oldest are dropped.
+const MaxNonces = 4096
+
+// reasonSuperseded is the RFC 5280 reason code with which renewed
+// certificates are revoked.
+const reasonSuperseded = 4
+
+// RevokeOldFailedMessage warns that a certificate was renewed but the old
+// one could not be revoked.
+const RevokeOldFailedMessage = `The certificate was renewed, but the old certificate could not be revoked`
+
+// A Request asks for the certificate with the given serial and AKI to be
+// renewed. Possession of its private key is proven by a Signature over
+// Challenge(req) made with SignChallenge, where Nonce was issued by the
+// server for this request.
+type Request struct {
+	Serial    string `json:"serial"`
+	AKI       string `json:"authority_key_id"`
+	Nonce     string `json:"nonce"`
+	RevokeOld bool   `json:"revoke_old,omitempty"`
+	Signature []byte `json:"signature"`
+}
+
+// Challenge returns the message signed to prove possession of the key of
+// the certificate being renewed. It covers every field of req but the
+// signature.
+func Challenge(req Request) []byte {
+	return []byte(fmt.Sprintf("cfssl-renew\n%s\n%s\n%s\n%t", req.Serial, req.AKI, req.Nonce, req.RevokeOld))
+}
+
+// SignChallenge signs Challenge(req) with key: a PKCS #1 v1.5 or ECDSA
+// signature over its SHA-256 digest.
+func SignChallenge(key crypto.Signer, req Request) ([]byte, error) {
+	digest := sha256.Sum256(Challenge(req))
+	return key.Sign(rand.Reader, digest[:], crypto.SHA256)
+}
+
+// A NonceStore issues the single-use nonces of renewal requests. It is
+// held in memory, so renewal requests must reach the server that issued
+// their nonce.
+type NonceStore struct {
+	mu     sync.Mutex
+	nonces map[string]time.Time
+	order  []string
+}
+
+// NewNonceStore returns an empty NonceStore.
+func NewNonceStore() *NonceStore {
+	return &NonceStore{nonces: map[string]time.Time{}}
+}
+
+// prune drops the expired nonces, and the oldest beyond MaxNonces. As
+// every nonce has the same lifetime, they expire in the order issued.
+func (ns *NonceStore) prune(now time.Time) {
+	for len(ns.order) > 0 {
+		expires, ok := ns.nonces[ns.order[0]]
+		if ok && now.Before(expires) && len(ns.order) < MaxNonces {
+			break
+		}
+		delete(ns.nonces, ns.order[0])
+		ns.order = ns.order[1:]
+	}
+}
+
+// New issues a nonce, valid for NonceLifetime.
+func (ns *NonceStore) New() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, buf); err != nil {
+		return "", errors.Wrap(errors.CertificateError, errors.Unknown, err)
+	}
+	nonce := hex.EncodeToString(buf)
+
+	now := time.Now()
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.prune(now)
+	ns.nonces[nonce] = now.Add(NonceLifetime)
+	ns.order = append(ns.order, nonce)
+	return nonce, nil
+}
+
+// Redeem returns true if nonce was issued by ns and has not expired or
+// already been redeemed.
+func (ns *NonceStore) Redeem(nonce string) bool {
+	now := time.Now()
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	ns.prune(now)
+	if _, ok := ns.nonces[nonce]; !ok {
+		return false
+	}
+	delete(ns.nonces, nonce)
+	return true
+}
+
+// verifyPossession checks that the requester of req holds the private key
+// of cert, and redeems the nonce of req.
+func verifyPossession(cert *x509.Certificate, nonces *NonceStore, req Request) error {
+	if len(req.Signature) == 0 {
+		return errors.NewBadRequestString("a signed challenge is required")
+	}
+	if !nonces.Redeem(req.Nonce) {
+		return errors.NewBadRequestString("the nonce is unknown, expired or already used")
+	}
+
+	var algo x509.SignatureAlgorithm
+	switch cert.PublicKeyAlgorithm {
+	case x509.RSA:
+		algo = x509.SHA256WithRSA
+	case x509.ECDSA:
+		algo = x509.ECDSAWithSHA256
+	default:
+		return errors.New(errors.PrivateKeyError, errors.NotRSAOrECC)
+	}
+
+	err := cert.CheckSignature(algo, Challenge(req), req.Signature)
+	if err != nil {
+		return errors.Wrap(errors.PrivateKeyError, errors.KeyMismatch, err)
+	}
+	return nil
+}
+
+// Renew reissues the certificate recorded in the cert db under req's
+// serial and AKI with s, once the requester has proven possession of its
+// key with a nonce from nonces.
+// The new certificate has the subject, public key and subject alternative
+// names of the old one, and is signed with the profile and CA label
+// recorded for it. With req.RevokeOld, the old certificate is then
+// revoked as superseded on behalf of requester, and if ocspSigner is not
+// nil its OCSP response is updated; should that fail, the new certificate
+// is returned along with the error.
+func Renew(dbAccessor certdb.Accessor, s signer.Reissuer, ocspSigner ocsp.Signer, nonces *NonceStore, req Request, requester string) ([]byte, error) {
+	if req.Serial == "" {
+		return nil, errors.NewBadRequestString("serial number is required but not provided")
+	}
+
+	crs, err := dbAccessor.GetCertificate(req.Serial, req.AKI)
+	if err != nil {
+		return nil, err
+	}
+	if len(crs) != 1 {
+		return nil, errors.Wrap(errors.CertStoreError, errors.RecordNotFound,
+			stderr.New("failed to renew the certificate: certificate not found"))
+	}
+	cr := crs[0]
+
+	if cr.Status != "good" {
+		return nil, errors.Wrap(errors.CertStoreError, errors.InvalidRecordStatus,
+			stderr.New("failed to renew the certificate: certificate is revoked"))
+	}
+
+	old, err := helpers.ParseCertificatePEM([]byte(cr.PEM))
+	if err != nil {
+		return nil, err
+	}
+
+	if err = verifyPossession(old, nonces, req); err != nil {
+		return nil, err
+	}
+
+	cert, err := s.Reissue(old, signer.SignRequest{
+		Profile:   cr.Profile,
+		Label:     cr.CALabel,
+		Requester: requester,
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("renewed certificate with serial number %s", req.Serial)
+
+	if !req.RevokeOld {
+		return cert, nil
+	}
+
+	if err = certdb.Revoke(dbAccessor, req.Serial, req.AKI, reasonSuperseded, requester); err != nil {
+		return cert, err
+	}
+
+	if ocspSigner != nil {
+		crs, err = dbAccessor.GetCertificate(req.Serial, req.AKI)
+		if err != nil {
+			return cert, err
+		}
+		if len(crs) != 1 {
+			return cert, errors.Wrap(errors.CertStoreError, errors.RecordNotFound,
+				stderr.New("failed to update the OCSP response: certificate not found"))
+		}
+		if err = ocsp.StoreResponse(ocspSigner, dbAccessor, crs[0]); err != nil {
+			return cert, err
+		}
+	}
+	return cert, nil
+}
+
+// A Handler accepts requests to renew certificates recorded in the cert
+// db. A GET issues the nonce for a renewal request.
+type Handler struct {
+	dbAccessor certdb.Accessor
+	signer     signer.Reissuer
+	nonces     *NonceStore
+	OCSPSigner ocsp.Signer
+}
+
+// NewHandler returns a new http.Handler that handles renewal requests
+// with the signer s, which should record the new certificates in
+// dbAccessor.
+func NewHandler(dbAccessor certdb.Accessor, s signer.Reissuer) http.Handler {
+	return NewOCSPHandler(dbAccessor, s, nil)
+}
+
+// NewOCSPHandler returns a new http.Handler that handles renewal requests
+// and also stores a "revoked" OCSP response for the old certificates it
+// revokes.
+func NewOCSPHandler(dbAccessor certdb.Accessor, s signer.Reissuer, ocspSigner ocsp.Signer) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &Handler{
+			dbAccessor: dbAccessor,
+			signer:     s,
+			nonces:     NewNonceStore(),
+			OCSPSigner: ocspSigner,
+		},
+		Methods: []string{"GET", "POST"},
+	}
+}
+
+// Handle responds to GET requests with a new nonce, and to renewal
+// requests with the new certificate. Should revoking the old certificate
+// fail, the new one is still sent, with a message saying so.
+func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
+	if r.Method == "GET" {
+		nonce, err := h.nonces.New()
+		if err != nil {
+			return err
+		}
+		return api.SendResponse(w, map[string]string{"nonce": nonce})
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	var req Request
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errors.NewBadRequestString("Unable to parse renewal request")
+	}
+
+	cert, err := Renew(h.dbAccessor, h.signer, h.OCSPSigner, h.nonces, req, api.Requester(r, ""))
+	if err != nil && cert == nil {
+		return err
+	}
+
+	result := map[string]interface{}{"certificate": string(cert)}
+	if err != nil {
+		// The renewed certificate has been issued and recorded, so it is
+		// sent along with the failure to retire the old one.
+		log.Errorf("renewed certificate %s but failed to revoke the old certificate: %v", req.Serial, err)
+		code := errors.New(errors.CertStoreError, errors.Unknown).ErrorCode
+		if cerr, ok := err.(*errors.Error); ok {
+			code = cerr.ErrorCode
+		}
+		return api.SendResponseWithMessage(w, result, RevokeOldFailedMessage+": "+err.Error(), code)
+	}
+	return api.SendResponse(w, result)
+}
@@ -0,0 +1,290 @@
+package renew
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	stderr "errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/ocsp"
+	"github.com/ucosty/cfssl/signer"
+	"github.com/ucosty/cfssl/signer/local"
+)
+
+const (
+	testCaFile    = "../../signer/local/testdata/ca.pem"
+	testCaKeyFile = "../../signer/local/testdata/ca_key.pem"
+)
+
+func newKey(t *testing.T) crypto.Signer {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func newCSR(t *testing.T, key crypto.Signer) string {
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "renew.example.com"},
+		DNSNames: []string{"renew.example.com"},
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der}))
+}
+
+// setup returns a signer recording to a fresh cert db, and a certificate
+// it issued for key.
+func setup(t *testing.T, key crypto.Signer) (certdb.Accessor, *local.Signer, *x509.Certificate) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDBAccessor(dbAccessor)
+
+	certPEM, err := s.Sign(signer.SignRequest{Request: newCSR(t, key), Label: "ca"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dbAccessor, s, cert
+}
+
+func ids(cert *x509.Certificate) (serial, aki string) {
+	return cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId)
+}
+
+func challengeRequest(t *testing.T, key crypto.Signer, cert *x509.Certificate, nonce string, revokeOld bool) Request {
+	serial, aki := ids(cert)
+	req := Request{Serial: serial, AKI: aki, Nonce: nonce, RevokeOld: revokeOld}
+	sig, err := SignChallenge(key, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Signature = sig
+	return req
+}
+
+func newNonce(t *testing.T, nonces *NonceStore) string {
+	nonce, err := nonces.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return nonce
+}
+
+// call makes a request of the handler at url, returning the result.
+func call(t *testing.T, method, url string, req interface{}) (int, map[string]interface{}) {
+	var body io.Reader
+	if req != nil {
+		blob, err := json.Marshal(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body = bytes.NewReader(blob)
+	}
+	httpReq, err := http.NewRequest(method, url, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.DefaultClient.Do(httpReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response api.Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	result, _ := response.Result.(map[string]interface{})
+	return resp.StatusCode, result
+}
+
+func TestRenewWithChallenge(t *testing.T) {
+	key := newKey(t)
+	dbAccessor, s, old := setup(t, key)
+
+	ts := httptest.NewServer(NewHandler(dbAccessor, s))
+	defer ts.Close()
+
+	status, result := call(t, "GET", ts.URL, nil)
+	if status != http.StatusOK || result["nonce"] == "" {
+		t.Fatalf("unexpected nonce response %d: %v", status, result)
+	}
+	req := challengeRequest(t, key, old, result["nonce"].(string), true)
+
+	status, result = call(t, "POST", ts.URL, req)
+	if status != http.StatusOK {
+		t.Fatalf("unexpected HTTP status code %d", status)
+	}
+	cert, err := helpers.ParseCertificatePEM([]byte(result["certificate"].(string)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Subject.CommonName != "renew.example.com" || !bytes.Equal(cert.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo) {
+		t.Fatalf("unexpected renewed certificate for %v", cert.Subject)
+	}
+
+	serial, aki := ids(cert)
+	crs, err := dbAccessor.GetCertificate(serial, aki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 || crs[0].CALabel != "ca" {
+		t.Fatalf("unexpected record of the renewed certificate %+v", crs)
+	}
+
+	crs, err = dbAccessor.GetCertificate(req.Serial, req.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs[0].Status != "revoked" || crs[0].Reason != reasonSuperseded {
+		t.Fatalf("expected the old certificate to be revoked as superseded, got %s (%d)", crs[0].Status, crs[0].Reason)
+	}
+
+	events, err := dbAccessor.GetRevocationEvents(req.Serial, req.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 1 || events[0].Action != certdb.ActionRevoke {
+		t.Fatalf("unexpected revocation events %+v", events)
+	}
+
+	// A revoked certificate cannot be renewed again.
+	nonces := NewNonceStore()
+	req = challengeRequest(t, key, old, newNonce(t, nonces), false)
+	if _, err = Renew(dbAccessor, s, nil, nonces, req, ""); err == nil {
+		t.Fatal("expected renewing a revoked certificate to fail")
+	}
+}
+
+type failingOCSPSigner struct{}
+
+func (failingOCSPSigner) Sign(ocsp.SignRequest) ([]byte, error) {
+	return nil, stderr.New("no OCSP responder")
+}
+
+func TestRenewSendsCertificateWhenRevokeOldFails(t *testing.T) {
+	key := newKey(t)
+	dbAccessor, s, old := setup(t, key)
+
+	ts := httptest.NewServer(NewOCSPHandler(dbAccessor, s, failingOCSPSigner{}))
+	defer ts.Close()
+
+	_, result := call(t, "GET", ts.URL, nil)
+	req := challengeRequest(t, key, old, result["nonce"].(string), true)
+
+	blob, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var response api.Response
+	if err = json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || !response.Success {
+		t.Fatalf("unexpected response %d: %+v", resp.StatusCode, response)
+	}
+	if len(response.Messages) != 1 || !strings.HasPrefix(response.Messages[0].Message, RevokeOldFailedMessage) {
+		t.Fatalf("expected a message about the old certificate, got %+v", response.Messages)
+	}
+	result, _ = response.Result.(map[string]interface{})
+	certPEM, _ := result["certificate"].(string)
+	if _, err = helpers.ParseCertificatePEM([]byte(certPEM)); err != nil {
+		t.Fatalf("expected the renewed certificate to be sent: %v", err)
+	}
+}
+
+func TestRenewRejectsReplays(t *testing.T) {
+	key := newKey(t)
+	dbAccessor, s, old := setup(t, key)
+	nonces := NewNonceStore()
+
+	req := challengeRequest(t, key, old, newNonce(t, nonces), false)
+	if _, err := Renew(dbAccessor, s, nil, nonces, req, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Renew(dbAccessor, s, nil, nonces, req, ""); err == nil {
+		t.Fatal("expected a replayed request to be rejected")
+	}
+
+	crs, err := dbAccessor.GetCertificate(req.Serial, req.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs[0].Status != "good" {
+		t.Fatal("the old certificate should not be revoked without revoke_old")
+	}
+}
+
+func TestRenewRejectsBadChallenges(t *testing.T) {
+	key := newKey(t)
+	dbAccessor, s, old := setup(t, key)
+	nonces := NewNonceStore()
+	serial, aki := ids(old)
+
+	for name, req := range map[string]Request{
+		"other key":     challengeRequest(t, newKey(t), old, newNonce(t, nonces), false),
+		"unknown nonce": challengeRequest(t, key, old, "unknown", false),
+		"no proof":      {Serial: serial, AKI: aki, Nonce: newNonce(t, nonces)},
+		"unknown cert":  {Serial: "1", AKI: "unknown", Nonce: newNonce(t, nonces), Signature: []byte("x")},
+		"no serial":     {},
+		"altered revoke_old": func() Request {
+			r := challengeRequest(t, key, old, newNonce(t, nonces), false)
+			r.RevokeOld = true
+			return r
+		}(),
+	} {
+		if _, err := Renew(dbAccessor, s, nil, nonces, req, ""); err == nil {
+			t.Errorf("%s: expected the renewal to fail", name)
+		}
+	}
+}
+
+func TestNonceStore(t *testing.T) {
+	nonces := NewNonceStore()
+	first := newNonce(t, nonces)
+	for i := 0; i < MaxNonces; i++ {
+		newNonce(t, nonces)
+	}
+	if nonces.Redeem(first) {
+		t.Fatal("expected the oldest nonce to be dropped beyond MaxNonces")
+	}
+
+	nonce := newNonce(t, nonces)
+	if !nonces.Redeem(nonce) || nonces.Redeem(nonce) {
+		t.Fatal("expected a nonce to be redeemed exactly once")
+	}
+}
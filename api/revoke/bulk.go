@@ -0,0 +1,148 @@
+package revoke
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/ocsp"
+)
+
+// RevocationLogFailedMessage warns that certificates were revoked but the
+// revocations could not be recorded in the revocation log.
+const RevocationLogFailedMessage = `The certificates were revoked, but the revocations could not be recorded in the revocation log`
+
+// An OCSPFailure records a revoked certificate whose OCSP response could
+// not be regenerated.
+type OCSPFailure struct {
+	Serial string `json:"serial"`
+	AKI    string `json:"authority_key_id"`
+	Error  string `json:"error"`
+}
+
+// A BulkResult reports the certificates revoked by BulkRevoke, or that
+// would be revoked in a dry run. Their PEM is omitted.
+type BulkResult struct {
+	DryRun       bool                       `json:"dry_run"`
+	Count        int                        `json:"count"`
+	Certificates []certdb.CertificateRecord `json:"certificates"`
+	OCSPFailures []OCSPFailure              `json:"ocsp_failures,omitempty"`
+}
+
+// BulkRevoke revokes every unrevoked certificate matching q, whose status,
+// cursor and limit are ignored. The query must be selective, so that a
+// mistake cannot revoke the whole cert db. In a dry run, the certificates
+// are only listed. If signer is not nil, "revoked" OCSP responses are
+// stored for the revoked certificates; failing to do so does not undo the
+// revocation and is reported in the result. Each revocation is then
+// recorded in the revocation log on behalf of actor; if that fails, the
+// result is returned along with the error.
+func BulkRevoke(dbAccessor certdb.Accessor, signer ocsp.Signer, q certdb.CertificateQuery, reasonCode int, dryRun bool, actor string) (result BulkResult, err error) {
+	if !q.Selective() {
+		return result, errors.NewBadRequestString("bulk revocation needs a CA label, AKI, hostname or time window")
+	}
+
+	result.DryRun = dryRun
+	if dryRun {
+		q.Status = "good"
+		result.Certificates, err = certdb.ListAll(dbAccessor, q)
+	} else {
+		result.Certificates, err = dbAccessor.RevokeCertificates(q, reasonCode)
+	}
+	if err != nil {
+		return result, err
+	}
+
+	if !dryRun {
+		log.Infof("revoked %d certificates", len(result.Certificates))
+		if signer != nil {
+			for _, cr := range result.Certificates {
+				if err := ocsp.StoreResponse(signer, dbAccessor, cr); err != nil {
+					log.Errorf("failed to store OCSP response for serial %s (AKI %s): %v", cr.Serial, cr.AKI, err)
+					result.OCSPFailures = append(result.OCSPFailures, OCSPFailure{Serial: cr.Serial, AKI: cr.AKI, Error: err.Error()})
+				}
+			}
+		}
+		err = certdb.RecordRevocations(dbAccessor, result.Certificates, actor)
+	}
+
+	for i := range result.Certificates {
+		result.Certificates[i].PEM = ""
+	}
+	result.Count = len(result.Certificates)
+	return result, err
+}
+
+// A BulkHandler accepts requests to revoke every certificate matching a
+// query.
+type BulkHandler struct {
+	dbAccessor certdb.Accessor
+	Signer     ocsp.Signer
+}
+
+// NewBulkHandler returns a new http.Handler that handles bulk revocation
+// requests.
+func NewBulkHandler(dbAccessor certdb.Accessor) http.Handler {
+	return NewOCSPBulkHandler(dbAccessor, nil)
+}
+
+// NewOCSPBulkHandler returns a new http.Handler that handles bulk
+// revocation requests and also generates OCSP responses for the revoked
+// certificates.
+func NewOCSPBulkHandler(dbAccessor certdb.Accessor, signer ocsp.Signer) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &BulkHandler{
+			dbAccessor: dbAccessor,
+			Signer:     signer,
+		},
+		Methods: []string{"POST"},
+	}
+}
+
+// This type is meant to be unmarshalled from JSON
+type jsonBulkRevokeRequest struct {
+	certdb.CertificateQuery
+	Reason string `json:"reason"`
+	DryRun bool   `json:"dry_run"`
+}
+
+// Handle responds to bulk revocation requests. Should recording the
+// revocations fail, the result is still sent, with a message saying so.
+func (h *BulkHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	var req jsonBulkRevokeRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errors.NewBadRequestString("Unable to parse bulk revocation request")
+	}
+
+	reasonCode, err := ocsp.ReasonStringToCode(req.Reason)
+	if err != nil {
+		return errors.NewBadRequestString("Invalid reason code")
+	}
+
+	result, err := BulkRevoke(h.dbAccessor, h.Signer, req.CertificateQuery, reasonCode, req.DryRun, api.Requester(r, ""))
+	if err != nil && result.Count == 0 {
+		return err
+	}
+	if err != nil {
+		// The certificates are revoked already; only recording that in
+		// the revocation log failed.
+		code := errors.New(errors.CertStoreError, errors.Unknown).ErrorCode
+		if cerr, ok := err.(*errors.Error); ok {
+			code = cerr.ErrorCode
+		}
+		return api.SendResponseWithMessage(w, result, RevocationLogFailedMessage+": "+err.Error(), code)
+	}
+
+	return api.SendResponse(w, result)
+}
@@ -0,0 +1,186 @@
+package revoke
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+)
+
+func prepBulkDB(t *testing.T) certdb.Accessor {
+	dbAccessor, err := prepDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expirationTime := time.Now().AddDate(1, 0, 0)
+	for _, cr := range []certdb.CertificateRecord{
+		{Serial: "10", AKI: "bulk aki", Status: "good", CommonName: "a.example.com"},
+		{Serial: "11", AKI: "bulk aki", Status: "good", SANs: certdb.StringList{"b.example.com"}},
+		{Serial: "12", AKI: "bulk aki", Status: "revoked"},
+		{Serial: "13", AKI: "other aki", Status: "good", SANs: certdb.StringList{"www.example.com", "b.example.com"}},
+		{Serial: "14", AKI: "other aki", Status: "good", SANs: certdb.StringList{"sub.b.example.com"}},
+	} {
+		cr.Expiry = expirationTime
+		cr.PEM = "unexpired cert"
+		if err := dbAccessor.InsertCertificate(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dbAccessor
+}
+
+func testBulkRevoke(t *testing.T, dbAccessor certdb.Accessor, req map[string]interface{}) (*http.Response, BulkResult) {
+	ts := httptest.NewServer(NewBulkHandler(dbAccessor))
+	defer ts.Close()
+
+	blob, err := json.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var result BulkResult
+	message := &api.Response{Result: &result}
+	if err = json.Unmarshal(body, message); err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	return resp, result
+}
+
+func status(t *testing.T, dbAccessor certdb.Accessor, serial, aki string) string {
+	crs, err := dbAccessor.GetCertificate(serial, aki)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 {
+		t.Fatalf("failed to get certificate %s", serial)
+	}
+	return crs[0].Status
+}
+
+func TestBulkRevokeNeedsFilter(t *testing.T) {
+	dbAccessor := prepBulkDB(t)
+
+	resp, _ := testBulkRevoke(t, dbAccessor, map[string]interface{}{"reason": "keyCompromise"})
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a bad request revoking without a filter, got %d", resp.StatusCode)
+	}
+}
+
+func TestBulkRevokeDryRun(t *testing.T) {
+	dbAccessor := prepBulkDB(t)
+
+	resp, result := testBulkRevoke(t, dbAccessor, map[string]interface{}{
+		"authority_key_identifier": "bulk aki",
+		"reason":                   "keyCompromise",
+		"dry_run":                  true,
+	})
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected HTTP status code %d", resp.StatusCode)
+	}
+	if !result.DryRun || result.Count != 2 {
+		t.Fatalf("expected a dry run matching 2 certificates, got %+v", result)
+	}
+	if status(t, dbAccessor, "10", "bulk aki") != "good" {
+		t.Fatal("dry run revoked a certificate")
+	}
+}
+
+func TestBulkRevoke(t *testing.T) {
+	dbAccessor := prepBulkDB(t)
+
+	_, result := testBulkRevoke(t, dbAccessor, map[string]interface{}{
+		"hostname": "b.example.com",
+		"reason":   "keyCompromise",
+	})
+	if result.Count != 2 {
+		t.Fatalf("expected 2 certificates revoked by hostname, got %+v", result)
+	}
+	for _, cr := range result.Certificates {
+		if cr.PEM != "" {
+			t.Fatal("result includes the certificate PEM")
+		}
+	}
+	if status(t, dbAccessor, "11", "bulk aki") != "revoked" || status(t, dbAccessor, "13", "other aki") != "revoked" {
+		t.Fatal("certificates with the hostname were not revoked")
+	}
+	// Hostnames match exactly, not as substrings of other names.
+	if status(t, dbAccessor, "14", "other aki") != "good" {
+		t.Fatal("certificate with a subdomain of the hostname was revoked")
+	}
+
+	_, result = testBulkRevoke(t, dbAccessor, map[string]interface{}{
+		"authority_key_identifier": "bulk aki",
+		"reason":                   "superseded",
+	})
+	if result.Count != 1 || result.Certificates[0].Serial != "10" {
+		t.Fatalf("expected only the remaining good certificate to be revoked, got %+v", result)
+	}
+	crs, err := dbAccessor.GetCertificate("10", "bulk aki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if crs[0].Status != "revoked" || crs[0].Reason != 4 {
+		t.Fatal("cert was not correctly revoked")
+	}
+}
+
+// unloggedAccessor fails to record revocation events.
+type unloggedAccessor struct {
+	certdb.Accessor
+}
+
+func (unloggedAccessor) InsertRevocationEvent(certdb.RevocationEvent) error {
+	return errors.New("revocation log unavailable")
+}
+
+func TestBulkRevokeSendsResultWhenLoggingFails(t *testing.T) {
+	dbAccessor := prepBulkDB(t)
+
+	ts := httptest.NewServer(NewBulkHandler(unloggedAccessor{dbAccessor}))
+	defer ts.Close()
+
+	blob, err := json.Marshal(map[string]interface{}{
+		"authority_key_identifier": "bulk aki",
+		"reason":                   "keyCompromise",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result BulkResult
+	message := &api.Response{Result: &result}
+	if err = json.NewDecoder(resp.Body).Decode(message); err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK || result.Count != 2 {
+		t.Fatalf("expected the 2 revoked certificates to be reported, got %d: %+v", resp.StatusCode, result)
+	}
+	if len(message.Messages) != 1 || !strings.HasPrefix(message.Messages[0].Message, RevocationLogFailedMessage) {
+		t.Fatalf("expected a message about the revocation log, got %+v", message.Messages)
+	}
+	if status(t, dbAccessor, "10", "bulk aki") != "revoked" {
+		t.Fatal("certificate was not revoked")
+	}
+}
@@ -0,0 +1,60 @@
+package revoke
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/errors"
+)
+
+// An EventsHandler returns entries of the revocation log.
+type EventsHandler struct {
+	dbAccessor certdb.Accessor
+}
+
+// NewEventsHandler returns a new http.Handler that returns the revocation
+// events of one certificate, given its serial and authority_key_id URL
+// parameters, or of every certificate since the RFC 3339 time in the since
+// parameter.
+func NewEventsHandler(dbAccessor certdb.Accessor) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &EventsHandler{
+			dbAccessor: dbAccessor,
+		},
+		Methods: []string{"GET"},
+	}
+}
+
+// Handle responds to revocation log requests.
+func (h *EventsHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	serial := values.Get("serial")
+	since := values.Get("since")
+
+	var events []certdb.RevocationEvent
+	var err error
+	switch {
+	case serial != "" && since != "":
+		return errors.NewBadRequestString("serial and since are mutually exclusive")
+	case serial != "":
+		events, err = h.dbAccessor.GetRevocationEvents(serial, values.Get("authority_key_id"))
+	case since != "":
+		t, parseErr := time.Parse(time.RFC3339, since)
+		if parseErr != nil {
+			return errors.NewBadRequestString("invalid since: expected an RFC 3339 timestamp")
+		}
+		events, err = h.dbAccessor.ListRevocationEvents(t)
+	default:
+		return errors.NewBadRequestString("serial or since is required but not provided")
+	}
+	if err != nil {
+		return err
+	}
+
+	if events == nil {
+		events = []certdb.RevocationEvent{}
+	}
+	return api.SendResponse(w, events)
+}
@@ -5,16 +5,11 @@ import (
 	"encoding/json"
 	"io/ioutil"
 	"net/http"
-	"time"
-
 
 	"github.com/ucosty/cfssl/api"
 	"github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/errors"
-	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/ocsp"
-
-	stdocsp "golang.org/x/crypto/ocsp"
 )
 
 // A Handler accepts requests with a serial number parameter
@@ -54,7 +49,8 @@ type jsonRevokeRequest struct {
 }
 
 // Handle responds to revocation requests. It attempts to revoke
-// a certificate with a given serial number
+// a certificate with a given serial number, and records the revocation
+// in the revocation log of the cert db.
 func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
@@ -79,16 +75,14 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 		return errors.NewBadRequestString("Invalid reason code")
 	}
 
-	err = h.dbAccessor.RevokeCertificate(req.Serial, req.AKI, reasonCode)
+	err = certdb.Revoke(h.dbAccessor, req.Serial, req.AKI, reasonCode, api.Requester(r, ""))
 	if err != nil {
 		return err
 	}
 
-	// If we were given a signer, try and generate an OCSP
-	// response indicating revocation
+	// If we were given a signer, store an OCSP response indicating
+	// revocation so the responder answers "revoked" immediately.
 	if h.Signer != nil {
-		// TODO: should these errors be errors?
-		// Grab the certificate from the database
 		cr, err := h.dbAccessor.GetCertificate(req.Serial, req.AKI)
 		if err != nil {
 			return err
@@ -97,38 +91,7 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 			return errors.NewBadRequestString("No unique certificate found")
 		}
 
-		cert, err := helpers.ParseCertificatePEM([]byte(cr[0].PEM))
-		if err != nil {
-			return errors.NewBadRequestString("Unable to parse certificates from PEM data")
-		}
-
-		sr := ocsp.SignRequest{
-			Certificate: cert,
-			Status:      "revoked",
-			Reason:      reasonCode,
-			RevokedAt:   time.Now().UTC(),
-		}
-
-		ocspResponse, err := h.Signer.Sign(sr)
-		if err != nil {
-			return err
-		}
-
-		// We parse the OCSP repsonse in order to get the next
-		// update time/expiry time
-		ocspParsed, err := stdocsp.ParseResponse(ocspResponse, nil)
-		if err != nil {
-			return err
-		}
-
-		ocspRecord := certdb.OCSPRecord{
-			Serial: req.Serial,
-			AKI:    req.AKI,
-			Body:   string(ocspResponse),
-			Expiry: ocspParsed.NextUpdate,
-		}
-
-		if err = h.dbAccessor.InsertOCSP(ocspRecord); err != nil {
+		if err = ocsp.StoreResponse(h.Signer, h.dbAccessor, cr[0]); err != nil {
 			return err
 		}
 	}
@@ -13,6 +13,7 @@ import (
 	"math/big"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"testing"
 	"time"
 
@@ -293,4 +294,81 @@ func TestOCSPGeneration(t *testing.T) {
 	if len(ocsps) <= ocspCountBefore {
 		t.Fatal("No new OCSP response found")
 	}
+
+	ocspResp, err := stdocsp.ParseResponse([]byte(ocsps[0].Body), nil)
+	if err != nil {
+		t.Fatal("failed to parse stored OCSP response ", err)
+	}
+	if ocspResp.Status != stdocsp.Revoked || !ocsps[0].Expiry.Equal(ocspResp.NextUpdate) {
+		t.Fatal("stored OCSP response does not reflect the revocation")
+	}
+}
+
+func testUnholdCert(t *testing.T, dbAccessor certdb.Accessor, serial, aki string) *http.Response {
+	ts := httptest.NewServer(NewUnholdHandler(dbAccessor))
+	defer ts.Close()
+
+	blob, err := json.Marshal(map[string]string{"serial": serial, "authority_key_id": aki})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Post(ts.URL, "application/json", bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestUnhold(t *testing.T) {
+	dbAccessor, err := prepDB()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if resp := testUnholdCert(t, dbAccessor, "1", fakeAKI); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a bad request releasing a certificate not on hold, got %d", resp.StatusCode)
+	}
+
+	if resp, body := testRevokeCert(t, dbAccessor, "1", fakeAKI, "certificateHold"); resp.StatusCode != http.StatusOK {
+		t.Fatal("unexpected HTTP status code; expected OK", string(body))
+	}
+	if resp := testUnholdCert(t, dbAccessor, "1", fakeAKI); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected HTTP status code %d; expected OK", resp.StatusCode)
+	}
+
+	certs, err := dbAccessor.GetCertificate("1", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || certs[0].Status != "good" {
+		t.Fatalf("certificate was not released: %+v", certs)
+	}
+
+	ts := httptest.NewServer(NewEventsHandler(dbAccessor))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "?serial=1&authority_key_id=" + url.QueryEscape(fakeAKI))
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []certdb.RevocationEvent
+	if err = json.Unmarshal(body, &api.Response{Result: &events}); err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	if len(events) != 2 || events[0].Action != certdb.ActionRevoke || events[1].Action != certdb.ActionUnhold {
+		t.Fatalf("unexpected revocation events %+v", events)
+	}
+
+	resp, err = http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected a bad request without serial or since, got %d", resp.StatusCode)
+	}
 }
@@ -0,0 +1,86 @@
+package revoke
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/ocsp"
+)
+
+// An UnholdHandler accepts requests to release certificates revoked with
+// reason certificateHold.
+type UnholdHandler struct {
+	dbAccessor certdb.Accessor
+	Signer     ocsp.Signer
+}
+
+// NewUnholdHandler returns a new http.Handler that handles unhold
+// requests.
+func NewUnholdHandler(dbAccessor certdb.Accessor) http.Handler {
+	return NewOCSPUnholdHandler(dbAccessor, nil)
+}
+
+// NewOCSPUnholdHandler returns a new http.Handler that handles unhold
+// requests and also stores a "good" OCSP response for the released
+// certificate.
+func NewOCSPUnholdHandler(dbAccessor certdb.Accessor, signer ocsp.Signer) http.Handler {
+	return &api.HTTPHandler{
+		Handler: &UnholdHandler{
+			dbAccessor: dbAccessor,
+			Signer:     signer,
+		},
+		Methods: []string{"POST"},
+	}
+}
+
+// This type is meant to be unmarshalled from JSON
+type jsonUnholdRequest struct {
+	Serial string `json:"serial"`
+	AKI    string `json:"authority_key_id"`
+}
+
+// Handle responds to unhold requests. The release is recorded in the
+// revocation log of the cert db.
+func (h *UnholdHandler) Handle(w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+
+	var req jsonUnholdRequest
+	err = json.Unmarshal(body, &req)
+	if err != nil {
+		return errors.NewBadRequestString("Unable to parse unhold request")
+	}
+
+	if len(req.Serial) == 0 {
+		return errors.NewBadRequestString("serial number is required but not provided")
+	}
+
+	err = certdb.Unhold(h.dbAccessor, req.Serial, req.AKI, api.Requester(r, ""))
+	if err != nil {
+		return err
+	}
+
+	if h.Signer != nil {
+		cr, err := h.dbAccessor.GetCertificate(req.Serial, req.AKI)
+		if err != nil {
+			return err
+		}
+		if len(cr) != 1 {
+			return errors.NewBadRequestString("No unique certificate found")
+		}
+
+		if err = ocsp.StoreResponse(h.Signer, h.dbAccessor, cr[0]); err != nil {
+			return err
+		}
+	}
+
+	result := map[string]string{}
+	return api.SendResponse(w, result)
+}
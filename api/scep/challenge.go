@@ -0,0 +1,75 @@
+package scep
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// A ChallengeStore validates the challenge passwords of SCEP enrollment
+// requests.
+type ChallengeStore interface {
+	// Verify reports whether password authorizes the enrollment of csr.
+	Verify(password string, csr *x509.CertificateRequest) (bool, error)
+}
+
+// StaticChallenge is a ChallengeStore that accepts one shared password
+// for every request. An empty StaticChallenge accepts none.
+type StaticChallenge string
+
+// Verify compares password with the shared password.
+func (c StaticChallenge) Verify(password string, csr *x509.CertificateRequest) (bool, error) {
+	return c != "" && subtle.ConstantTimeCompare([]byte(c), []byte(password)) == 1, nil
+}
+
+// OneTimeChallenges is a ChallengeStore of passwords that each authorize
+// one enrollment until they expire.
+type OneTimeChallenges struct {
+	mu        sync.Mutex
+	passwords map[string]time.Time
+}
+
+// NewOneTimeChallenges returns an empty OneTimeChallenges.
+func NewOneTimeChallenges() *OneTimeChallenges {
+	return &OneTimeChallenges{passwords: map[string]time.Time{}}
+}
+
+// Add makes password valid for one enrollment within ttl.
+func (c *OneTimeChallenges) Add(password string, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.passwords[password] = time.Now().Add(ttl)
+}
+
+// Generate returns a new random password valid for one enrollment within
+// ttl.
+func (c *OneTimeChallenges) Generate(ttl time.Duration) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	password := hex.EncodeToString(b)
+	c.Add(password, ttl)
+	return password, nil
+}
+
+// Verify consumes password if it is valid.
+func (c *OneTimeChallenges) Verify(password string, csr *x509.CertificateRequest) (bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for p, expiry := range c.passwords {
+		if now.After(expiry) {
+			delete(c.passwords, p)
+		}
+	}
+	if _, ok := c.passwords[password]; !ok {
+		return false, nil
+	}
+	delete(c.passwords, password)
+	return true, nil
+}
@@ -0,0 +1,461 @@
+// Package scep implements a SCEP (RFC 8894) server for devices that can
+// only enroll with SCEP, issuing certificates with a signer.Signer.
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/crypto/pkcs7"
+	cferr "github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/signer"
+)
+
+// maxMessageSize bounds the size of a PKIOperation message.
+const maxMessageSize = 1 << 16
+
+// Capabilities are the GetCACaps response of the server.
+var Capabilities = []string{"POSTPKIOperation", "Renewal", "SHA-256", "AES", "DES3", "SCEPStandard"}
+
+// SCEP message types.
+const (
+	messageCertRep    = "3"
+	messageRenewalReq = "17"
+	messagePKCSReq    = "19"
+)
+
+// SCEP pkiStatus and failInfo values.
+const (
+	statusSuccess = "0"
+	statusFailure = "2"
+
+	failBadMessageCheck = "1"
+	failBadRequest      = "2"
+)
+
+var (
+	oidMessageType       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 2}
+	oidPKIStatus         = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 3}
+	oidFailInfo          = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 4}
+	oidSenderNonce       = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 5}
+	oidRecipientNonce    = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 6}
+	oidTransactionID     = asn1.ObjectIdentifier{2, 16, 840, 1, 113733, 1, 9, 7}
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+)
+
+// A Handler serves the SCEP GetCACert, GetCACaps and PKIOperation
+// operations. Requests are encrypted to, and responses signed by, a
+// registration authority (RA) certificate issued by the CA, whose RSA key
+// is kept apart from the CA key so that no SCEP client can use the CA key
+// to decrypt.
+//
+// Requests must carry a challenge password accepted by the
+// ChallengeStore, unless they are a RenewalReq signed by a certificate of
+// the CA that is currently valid, unrevoked in the certificate database,
+// and has the subject and subject alternative names of the new request.
+// Certificates are never left pending, so there is no polling.
+type Handler struct {
+	signer     signer.Signer
+	db         certdb.Accessor
+	caCert     *x509.Certificate
+	raCert     *x509.Certificate
+	raKey      *rsa.PrivateKey
+	challenges ChallengeStore
+	profile    string
+}
+
+// NewHandler returns a SCEP handler issuing with the signer and the
+// signing profile, whose CA has the certificate caCert. raCert and raKey
+// are the RA certificate, which must be issued by the CA, and its RSA
+// key. Renewals are checked against dbAccessor, and refused if it is nil.
+func NewHandler(dbAccessor certdb.Accessor, s signer.Signer, caCert, raCert *x509.Certificate, raKey crypto.Signer, challenges ChallengeStore, profile string) (http.Handler, error) {
+	if s.Policy() == nil {
+		return nil, cferr.New(cferr.PolicyError, cferr.InvalidPolicy)
+	}
+	key, ok := raKey.(*rsa.PrivateKey)
+	if !ok {
+		return nil, cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+	}
+	if !key.PublicKey.Equal(raCert.PublicKey) {
+		return nil, cferr.New(cferr.PrivateKeyError, cferr.KeyMismatch)
+	}
+	if caCert.Equal(raCert) || raCert.CheckSignatureFrom(caCert) != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed,
+			errors.New("the SCEP RA certificate must be a separate certificate issued by the CA"))
+	}
+	return &Handler{
+		signer:     s,
+		db:         dbAccessor,
+		caCert:     caCert,
+		raCert:     raCert,
+		raKey:      key,
+		challenges: challenges,
+		profile:    profile,
+	}, nil
+}
+
+// NewHandlerFromFile is NewHandler with the CA certificate, and the RA
+// certificate and key, read from PEM files.
+func NewHandlerFromFile(dbAccessor certdb.Accessor, s signer.Signer, caFile, raFile, raKeyFile string, challenges ChallengeStore, profile string) (http.Handler, error) {
+	caCert, err := readCertificate(caFile)
+	if err != nil {
+		return nil, err
+	}
+	raCert, err := readCertificate(raFile)
+	if err != nil {
+		return nil, err
+	}
+
+	keyPEM, err := ioutil.ReadFile(raKeyFile)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.ReadFailed, err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return nil, err
+	}
+	return NewHandler(dbAccessor, s, caCert, raCert, key, challenges, profile)
+}
+
+func readCertificate(file string) (*x509.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+	return helpers.ParseCertificatePEM(certPEM)
+}
+
+// ServeHTTP dispatches the SCEP operations.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch op := r.URL.Query().Get("operation"); op {
+	case "GetCACert":
+		// The RA certificate comes first, so that clients encrypt to it.
+		certs, err := pkcs7.DegenerateCertificates([]*x509.Certificate{h.raCert, h.caCert})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-x509-ca-ra-cert")
+		w.Write(certs)
+	case "GetCACaps":
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Join(Capabilities, "\n")))
+	case "PKIOperation":
+		var msg []byte
+		var err error
+		switch r.Method {
+		case "GET":
+			// A + left unescaped in the query decodes as a space.
+			msg, err = base64.StdEncoding.DecodeString(strings.Replace(r.URL.Query().Get("message"), " ", "+", -1))
+		case "POST":
+			msg, err = ioutil.ReadAll(io.LimitReader(r.Body, maxMessageSize))
+			r.Body.Close()
+		default:
+			w.Header().Set("Allow", "GET, POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		if err != nil {
+			http.Error(w, "malformed message", http.StatusBadRequest)
+			return
+		}
+
+		resp, err := h.pkiOperation(r, msg)
+		if err != nil {
+			log.Warningf("failed to process SCEP message: %v", err)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-pki-message")
+		w.Write(resp)
+	default:
+		http.Error(w, "unknown SCEP operation "+op, http.StatusBadRequest)
+	}
+}
+
+// pkiMessage is a verified SCEP request.
+type pkiMessage struct {
+	messageType   string
+	transactionID string
+	senderNonce   []byte
+	signer        *x509.Certificate
+	content       []byte
+}
+
+// parsePKIMessage verifies the signature of a SCEP request and parses its
+// attributes. The signer certificate is not checked against the CA.
+func parsePKIMessage(der []byte) (*pkiMessage, error) {
+	p7, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		return nil, err
+	}
+	if p7.ContentInfo != "SignedData" {
+		return nil, errors.New("the message is not signed data")
+	}
+	sd := p7.Content.SignedData
+	signers, err := sd.Verify()
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &pkiMessage{signer: signers[0], content: sd.Content}
+	si := &sd.SignerInfos[0]
+	if err = si.UnmarshalAttribute(oidMessageType, &msg.messageType); err != nil {
+		return nil, err
+	}
+	if err = si.UnmarshalAttribute(oidTransactionID, &msg.transactionID); err != nil {
+		return nil, err
+	}
+	if err = si.UnmarshalAttribute(oidSenderNonce, &msg.senderNonce); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// pkiOperation answers a SCEP request with a CertRep message. Requests
+// that cannot be parsed or verified are not answered, as there is no
+// transaction to reply to.
+func (h *Handler) pkiOperation(r *http.Request, der []byte) ([]byte, error) {
+	msg, err := parsePKIMessage(der)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("SCEP message type %s received for transaction %s", msg.messageType, msg.transactionID)
+
+	switch msg.messageType {
+	case messagePKCSReq, messageRenewalReq:
+	default:
+		return h.certRep(msg, statusFailure, failBadRequest, nil)
+	}
+
+	// Every failure to decrypt or parse the request gets the same
+	// answer, so that the RA key cannot be used as a padding oracle.
+	var ed pkcs7.EnvelopedData
+	var csrDER []byte
+	var csr *x509.CertificateRequest
+	envelope, err := pkcs7.ParsePKCS7(msg.content)
+	if err == nil && envelope.ContentInfo != "EnvelopedData" {
+		err = errors.New("the message content is not enveloped data")
+	}
+	if err == nil {
+		ed = envelope.Content.EnvelopedData
+		csrDER, err = ed.Decrypt(h.raCert, h.raKey)
+	}
+	if err == nil {
+		csr, err = x509.ParseCertificateRequest(csrDER)
+	}
+	if err == nil {
+		err = csr.CheckSignature()
+	}
+	if err != nil {
+		log.Warningf("failed to decrypt SCEP transaction %s", msg.transactionID)
+		return h.certRep(msg, statusFailure, failBadMessageCheck, nil)
+	}
+
+	if err = h.authorize(msg, csr); err != nil {
+		log.Warningf("refused SCEP transaction %s: %v", msg.transactionID, err)
+		return h.certRep(msg, statusFailure, failBadRequest, nil)
+	}
+
+	certPEM, err := h.signer.Sign(signer.SignRequest{
+		Request:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})),
+		Profile:   h.profile,
+		Requester: api.Requester(r, ""),
+	})
+	if err != nil {
+		log.Warningf("failed to sign SCEP request: %v", err)
+		return h.certRep(msg, statusFailure, failBadRequest, nil)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, err
+	}
+
+	certs, err := pkcs7.DegenerateCertificates([]*x509.Certificate{cert})
+	if err != nil {
+		return nil, err
+	}
+	content, err := pkcs7.Encrypt(certs, []*x509.Certificate{msg.signer}, ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	return h.certRep(msg, statusSuccess, "", content)
+}
+
+// authorize checks that the request carries a challenge password the
+// store accepts, or is a renewal the CA should grant.
+func (h *Handler) authorize(msg *pkiMessage, csr *x509.CertificateRequest) error {
+	password, err := challengePassword(csr)
+	if err == nil {
+		ok, err := h.challenges.Verify(password, csr)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errors.New("invalid challenge password")
+		}
+		return nil
+	}
+	if msg.messageType != messageRenewalReq {
+		return err
+	}
+	return h.authorizeRenewal(msg.signer, csr)
+}
+
+// authorizeRenewal checks that cert, which signed a RenewalReq, is a
+// valid and unrevoked certificate of the CA for the same subject and
+// subject alternative names as csr.
+func (h *Handler) authorizeRenewal(cert *x509.Certificate, csr *x509.CertificateRequest) error {
+	if cert.CheckSignatureFrom(h.caCert) != nil {
+		return errors.New("the renewal request is not signed with a certificate of the CA")
+	}
+	now := time.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return errors.New("the certificate signing the renewal request is not valid")
+	}
+
+	if h.db == nil {
+		return errors.New("renewals need a certificate database to check revocation")
+	}
+	crs, err := h.db.GetCertificate(cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId))
+	if err != nil {
+		return err
+	}
+	if len(crs) != 1 || crs[0].Status != "good" {
+		return errors.New("the certificate signing the renewal request is revoked or unknown")
+	}
+
+	if !bytes.Equal(cert.RawSubject, csr.RawSubject) {
+		return errors.New("the renewal request does not have the subject of its certificate")
+	}
+	if !sameNames(cert.DNSNames, csr.DNSNames) || !sameNames(cert.EmailAddresses, csr.EmailAddresses) ||
+		!sameNames(ipStrings(cert.IPAddresses), ipStrings(csr.IPAddresses)) ||
+		!sameNames(uriStrings(cert.URIs), uriStrings(csr.URIs)) {
+		return errors.New("the renewal request does not have the subject alternative names of its certificate")
+	}
+	return nil
+}
+
+// sameNames reports whether a and b hold the same set of names.
+func sameNames(a, b []string) bool {
+	set := map[string]bool{}
+	for _, name := range a {
+		set[name] = true
+	}
+	for _, name := range b {
+		if !set[name] {
+			return false
+		}
+	}
+	other := map[string]bool{}
+	for _, name := range b {
+		other[name] = true
+	}
+	return len(set) == len(other)
+}
+
+func ipStrings(ips []net.IP) []string {
+	var names []string
+	for _, ip := range ips {
+		names = append(names, ip.String())
+	}
+	return names
+}
+
+func uriStrings(uris []*url.URL) []string {
+	var names []string
+	for _, uri := range uris {
+		names = append(names, uri.String())
+	}
+	return names
+}
+
+// attributeValue is the value of an authenticated attribute of a response.
+type attributeValue struct {
+	oid   asn1.ObjectIdentifier
+	value interface{}
+}
+
+// certRep returns a CertRep message answering msg with the status, the
+// failure reason and the enveloped certificates.
+func (h *Handler) certRep(msg *pkiMessage, status, failInfo string, content []byte) ([]byte, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	values := []attributeValue{
+		{oidMessageType, messageCertRep},
+		{oidPKIStatus, status},
+		{oidTransactionID, msg.transactionID},
+		{oidSenderNonce, nonce},
+		{oidRecipientNonce, msg.senderNonce},
+	}
+	if failInfo != "" {
+		values = append(values, attributeValue{oidFailInfo, failInfo})
+	}
+
+	var attrs []pkcs7.Attribute
+	for _, v := range values {
+		attr, err := pkcs7.NewAttribute(v.oid, v.value)
+		if err != nil {
+			return nil, err
+		}
+		attrs = append(attrs, attr)
+	}
+	return pkcs7.SignData(content, h.raCert, h.raKey, crypto.SHA256, attrs)
+}
+
+// tbsCertificateRequest is the part of a CSR holding its attributes.
+type tbsCertificateRequest struct {
+	Version       int
+	Subject       asn1.RawValue
+	PublicKey     asn1.RawValue
+	RawAttributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+// challengePassword returns the challengePassword attribute of csr, which
+// crypto/x509 does not parse.
+func challengePassword(csr *x509.CertificateRequest) (string, error) {
+	var tbs tbsCertificateRequest
+	if _, err := asn1.Unmarshal(csr.RawTBSCertificateRequest, &tbs); err != nil {
+		return "", err
+	}
+	for _, raw := range tbs.RawAttributes {
+		var attr struct {
+			Type   asn1.ObjectIdentifier
+			Values []asn1.RawValue `asn1:"set"`
+		}
+		if _, err := asn1.Unmarshal(raw.FullBytes, &attr); err != nil {
+			return "", err
+		}
+		if !attr.Type.Equal(oidChallengePassword) || len(attr.Values) == 0 {
+			continue
+		}
+		var password string
+		if _, err := asn1.Unmarshal(attr.Values[0].FullBytes, &password); err != nil {
+			return "", err
+		}
+		return password, nil
+	}
+	return "", errors.New("the request has no challenge password")
+}
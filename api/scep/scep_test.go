@@ -0,0 +1,347 @@
+package scep
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/crypto/pkcs7"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/signer"
+	"github.com/ucosty/cfssl/signer/local"
+)
+
+const (
+	testCaFile    = "../../signer/local/testdata/ca.pem"
+	testCaKeyFile = "../../signer/local/testdata/ca_key.pem"
+)
+
+// newTestHandler returns a handler whose signer records to a fresh cert
+// db, with an RA certificate issued by the test CA.
+func newTestHandler(t *testing.T, challenges ChallengeStore) *Handler {
+	dbAccessor := sql.NewAccessor(testdb.SQLiteDB("../../certdb/testdb/certstore_development.db"))
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetDBAccessor(dbAccessor)
+
+	caCert, err := readCertificate(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{Subject: pkix.Name{CommonName: "SCEP RA"}}, raKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	raPEM, err := s.Sign(signer.SignRequest{Request: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raCert, err := helpers.ParseCertificatePEM(raPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewHandler(dbAccessor, s, caCert, caCert, raKey, challenges, ""); err == nil {
+		t.Fatal("expected the CA certificate to be refused as the RA certificate")
+	}
+	h, err := NewHandler(dbAccessor, s, caCert, raCert, raKey, challenges, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.(*Handler)
+}
+
+// device is a SCEP client with an RSA key and a self-signed certificate.
+type device struct {
+	key  *rsa.PrivateKey
+	cert *x509.Certificate
+}
+
+func newDevice(t *testing.T) *device {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "device"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &device{key: key, cert: cert}
+}
+
+// csr returns a CSR for the device key with a challenge password, which
+// crypto/x509 cannot encode.
+func (d *device) csr(t *testing.T, password string) []byte {
+	return d.csrFor(t, "device.example.com", password)
+}
+
+// csrFor is csr with the common name cn.
+func (d *device) csrFor(t *testing.T, cn, password string) []byte {
+	subject, err := asn1.Marshal(pkix.Name{CommonName: cn}.ToRDNSequence())
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub, err := x509.MarshalPKIXPublicKey(d.key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var attrs []byte
+	if password != "" {
+		value, _ := asn1.Marshal(password)
+		attrs, _ = asn1.Marshal(struct {
+			Type   asn1.ObjectIdentifier
+			Values asn1.RawValue
+		}{oidChallengePassword, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: value}})
+	}
+	tbs, err := asn1.Marshal(struct {
+		Version                       int
+		Subject, PublicKey, Attribute asn1.RawValue
+	}{0, asn1.RawValue{FullBytes: subject}, asn1.RawValue{FullBytes: pub},
+		asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrs}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	digest := sha256.Sum256(tbs)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, d.key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(struct {
+		TBS       asn1.RawValue
+		Algorithm pkix.AlgorithmIdentifier
+		Signature asn1.BitString
+	}{asn1.RawValue{FullBytes: tbs},
+		pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, Parameters: asn1.NullRawValue},
+		asn1.BitString{Bytes: sig, BitLength: len(sig) * 8}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// envelope returns csr encrypted to the RA.
+func (d *device) envelope(t *testing.T, h *Handler, csr []byte) []byte {
+	envelope, err := pkcs7.Encrypt(csr, []*x509.Certificate{h.raCert}, pkcs7.OIDEncryptionAlgorithmAES128CBC)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return envelope
+}
+
+// request sends a PKIOperation message of the type, holding csr, and
+// returns the status, failure reason and certificate of the CertRep.
+func (d *device) request(t *testing.T, h *Handler, messageType string, csr []byte) (string, string, *x509.Certificate) {
+	return d.send(t, h, messageType, d.envelope(t, h, csr))
+}
+
+// send is request with the enveloped CSR.
+func (d *device) send(t *testing.T, h *Handler, messageType string, envelope []byte) (string, string, *x509.Certificate) {
+	nonce := []byte("0123456789abcdef")
+	var attrs []pkcs7.Attribute
+	for _, v := range []attributeValue{
+		{oidMessageType, messageType},
+		{oidTransactionID, "transaction"},
+		{oidSenderNonce, nonce},
+	} {
+		attr, err := pkcs7.NewAttribute(v.oid, v.value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		attrs = append(attrs, attr)
+	}
+	msg, err := pkcs7.SignData(envelope, d.cert, d.key, crypto.SHA256, attrs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/scep/pkiclient.exe?operation=PKIOperation", bytes.NewReader(msg)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("PKIOperation failed with %d: %s", w.Code, w.Body)
+	}
+
+	rep, err := parsePKIMessage(w.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rep.signer.Equal(h.raCert) || rep.messageType != messageCertRep || rep.transactionID != "transaction" {
+		t.Fatalf("unexpected CertRep %+v", rep)
+	}
+	p7, _ := pkcs7.ParsePKCS7(w.Body.Bytes())
+	si := &p7.Content.SignedData.SignerInfos[0]
+	var status, failInfo string
+	var recipientNonce []byte
+	if err = si.UnmarshalAttribute(oidPKIStatus, &status); err != nil {
+		t.Fatal(err)
+	}
+	if err = si.UnmarshalAttribute(oidRecipientNonce, &recipientNonce); err != nil || !bytes.Equal(recipientNonce, nonce) {
+		t.Fatal("expected the sender nonce to be returned")
+	}
+	if status != statusSuccess {
+		si.UnmarshalAttribute(oidFailInfo, &failInfo)
+		return status, failInfo, nil
+	}
+
+	envelopedCerts, err := pkcs7.ParsePKCS7(rep.content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := envelopedCerts.Content.EnvelopedData.Decrypt(d.cert, d.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs.Content.SignedData.Certificates) != 1 {
+		t.Fatal("expected one certificate")
+	}
+	return status, "", certs.Content.SignedData.Certificates[0]
+}
+
+func TestGetCACertAndCaps(t *testing.T) {
+	h := newTestHandler(t, StaticChallenge("secret"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/scep/pkiclient.exe?operation=GetCACert", nil))
+	p7, err := pkcs7.ParsePKCS7(w.Body.Bytes())
+	if err != nil || w.Header().Get("Content-Type") != "application/x-x509-ca-ra-cert" {
+		t.Fatalf("expected a degenerate PKCS #7: %v", err)
+	}
+	if certs := p7.Content.SignedData.Certificates; len(certs) != 2 || !certs[0].Equal(h.raCert) || !certs[1].Equal(h.caCert) {
+		t.Fatal("expected the RA and CA certificates")
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/scep/pkiclient.exe?operation=GetCACaps", nil))
+	if !bytes.Contains(w.Body.Bytes(), []byte("POSTPKIOperation")) {
+		t.Fatalf("unexpected capabilities %s", w.Body)
+	}
+
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("GET", "/scep/pkiclient.exe?operation=Nothing", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected an unknown operation to be 400, got %d", w.Code)
+	}
+}
+
+func TestPKCSReq(t *testing.T) {
+	challenges := NewOneTimeChallenges()
+	password, err := challenges.Generate(time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newTestHandler(t, challenges)
+	d := newDevice(t)
+
+	status, failInfo, _ := d.request(t, h, messagePKCSReq, d.csr(t, "wrong"))
+	if status != statusFailure || failInfo != failBadRequest {
+		t.Fatalf("expected a wrong password to fail, got %s/%s", status, failInfo)
+	}
+
+	status, _, cert := d.request(t, h, messagePKCSReq, d.csr(t, password))
+	if status != statusSuccess || cert.Subject.CommonName != "device.example.com" || cert.CheckSignatureFrom(h.caCert) != nil {
+		t.Fatalf("expected a certificate of the CA, got status %s", status)
+	}
+
+	if status, _, _ = d.request(t, h, messagePKCSReq, d.csr(t, password)); status != statusFailure {
+		t.Fatal("expected a one-time password to be consumed")
+	}
+}
+
+func TestRenewalReq(t *testing.T) {
+	h := newTestHandler(t, StaticChallenge("secret"))
+	d := newDevice(t)
+
+	if status, _, _ := d.request(t, h, messageRenewalReq, d.csr(t, "")); status != statusFailure {
+		t.Fatal("expected a renewal signed with a self-signed certificate to fail")
+	}
+
+	_, _, cert := d.request(t, h, messagePKCSReq, d.csr(t, "secret"))
+	if cert == nil {
+		t.Fatal("enrollment failed")
+	}
+	d.cert = cert
+	if status, _, _ := d.request(t, h, messagePKCSReq, d.csr(t, "")); status != statusFailure {
+		t.Fatal("expected a PKCSReq without a password to fail, whatever its signer")
+	}
+	if status, _, _ := d.request(t, h, messageRenewalReq, d.csrFor(t, "other.example.com", "")); status != statusFailure {
+		t.Fatal("expected a renewal for another subject to fail")
+	}
+	status, _, renewed := d.request(t, h, messageRenewalReq, d.csr(t, ""))
+	if status != statusSuccess || renewed.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+		t.Fatalf("expected a new certificate, got status %s", status)
+	}
+
+	err := certdb.Revoke(h.db, cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId), 1, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, _, _ = d.request(t, h, messageRenewalReq, d.csr(t, "")); status != statusFailure {
+		t.Fatal("expected a renewal signed with a revoked certificate to fail")
+	}
+}
+
+func TestUndecryptableRequest(t *testing.T) {
+	h := newTestHandler(t, StaticChallenge("secret"))
+	d := newDevice(t)
+
+	// A corrupted key and corrupted content fail alike.
+	envelope := d.envelope(t, h, d.csr(t, "secret"))
+	p7, err := pkcs7.ParsePKCS7(envelope)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed := p7.Content.EnvelopedData
+	for _, part := range [][]byte{ed.RecipientInfos[0].EncryptedKey, ed.EncryptedContentInfo.EncryptedContent} {
+		corrupted := append([]byte{}, envelope...)
+		corrupted[bytes.Index(envelope, part)+len(part)/2] ^= 0xff
+		status, failInfo, _ := d.send(t, h, messagePKCSReq, corrupted)
+		if status != statusFailure || failInfo != failBadMessageCheck {
+			t.Fatalf("expected badMessageCheck, got %s/%s", status, failInfo)
+		}
+	}
+}
+
+func TestMalformedMessage(t *testing.T) {
+	h := newTestHandler(t, StaticChallenge("secret"))
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, httptest.NewRequest("POST", "/scep/pkiclient.exe?operation=PKIOperation", bytes.NewReader([]byte("garbage"))))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed message to be 400, got %d", w.Code)
+	}
+}
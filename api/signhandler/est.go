@@ -0,0 +1,269 @@
+package signhandler
+
+import (
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/auth"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/config"
+	"github.com/ucosty/cfssl/crypto/pkcs7"
+	"github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/info"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/signer"
+)
+
+// ESTPrefix is the path under which the EST operations are served.
+const ESTPrefix = "/.well-known/est/"
+
+// maxESTRequestSize bounds the size of a base64 encoded CSR.
+const maxESTRequestSize = 1 << 16
+
+// An ESTHandler serves the EST (RFC 7030) cacerts, simpleenroll and
+// simplereenroll operations. An optional label before the operation, as
+// in /.well-known/est/label/simpleenroll, names the signing profile.
+//
+// Enrollment follows the rules of the sign and authsign endpoints.
+// Profiles without an auth provider issue to any client the server
+// accepts, which with mutual TLS means clients with a trusted
+// certificate. Profiles with one require HTTP basic auth, whose password
+// is the hex-encoded token of the provider over the user name.
+// Reenrollment also requires the client to authenticate with the
+// certificate being renewed, which must be valid and not revoked in the
+// cert db, and whose subject and SANs the CSR must repeat.
+type ESTHandler struct {
+	dbAccessor certdb.Accessor
+	signer     signer.Signer
+}
+
+// NewESTHandler returns a new http.Handler serving EST with the signer.
+// Reenrollment is refused without a cert db to check revocation in.
+func NewESTHandler(dbAccessor certdb.Accessor, s signer.Signer) (http.Handler, error) {
+	if s.Policy() == nil {
+		return nil, errors.New(errors.PolicyError, errors.InvalidPolicy)
+	}
+	return &ESTHandler{dbAccessor: dbAccessor, signer: s}, nil
+}
+
+// ServeHTTP dispatches the EST operations.
+func (h *ESTHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, ESTPrefix)
+	label, op := "", path
+	if i := strings.Index(path, "/"); i >= 0 {
+		label, op = path[:i], path[i+1:]
+	}
+
+	var err error
+	switch op {
+	case "cacerts":
+		if r.Method != "GET" {
+			w.Header().Set("Allow", "GET")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		err = h.caCerts(w, label)
+	case "simpleenroll", "simplereenroll":
+		if r.Method != "POST" {
+			w.Header().Set("Allow", "POST")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		err = h.enroll(w, r, label, op == "simplereenroll")
+	default:
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		writeESTError(w, err)
+	}
+}
+
+// profile returns the signing profile named by an EST label.
+func (h *ESTHandler) profile(label string) (*config.SigningProfile, error) {
+	if label != "" {
+		if policy := h.signer.Policy(); policy.Profiles[label] == nil {
+			return nil, errors.NewHTTPError(http.StatusNotFound, "unknown EST label")
+		}
+	}
+	return signer.Profile(h.signer, label)
+}
+
+// caCertificates returns the issuer certificate of the profile.
+func (h *ESTHandler) caCertificates(label string) ([]*x509.Certificate, error) {
+	resp, err := h.signer.Info(info.Req{Profile: label})
+	if err != nil {
+		return nil, err
+	}
+	return helpers.ParseCertificatesPEM([]byte(resp.Certificate))
+}
+
+// caCerts responds with the CA certificate as a certs-only message.
+func (h *ESTHandler) caCerts(w http.ResponseWriter, label string) error {
+	if _, err := h.profile(label); err != nil {
+		return err
+	}
+	certs, err := h.caCertificates(label)
+	if err != nil {
+		return err
+	}
+	der, err := pkcs7.DegenerateCertificates(certs)
+	if err != nil {
+		return err
+	}
+	writePKCS7(w, "application/pkcs7-mime", der)
+	return nil
+}
+
+// enroll signs the base64 encoded PKCS #10 request in the body of r and
+// responds with the certificate as a certs-only message.
+func (h *ESTHandler) enroll(w http.ResponseWriter, r *http.Request, label string, reenroll bool) error {
+	log.Info("EST enrollment request received")
+
+	profile, err := h.profile(label)
+	if err != nil {
+		return err
+	}
+
+	requester := api.Requester(r, "")
+	if profile.Provider != nil {
+		user, password, ok := r.BasicAuth()
+		token, err := hex.DecodeString(password)
+		if !ok || err != nil || !profile.Provider.Verify(&auth.AuthenticatedRequest{Request: []byte(user), Token: token}) {
+			log.Warning("received EST request with invalid credentials")
+			w.Header().Set("WWW-Authenticate", `Basic realm="cfssl"`)
+			return errors.NewHTTPError(http.StatusUnauthorized, "authentication required")
+		}
+		requester = user
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxESTRequestSize))
+	if err != nil {
+		return err
+	}
+	r.Body.Close()
+	der, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(string(body)), ""))
+	if err != nil {
+		return errors.NewBadRequestString("the request is not base64 encoded")
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return errors.Wrap(errors.CSRError, errors.ParseFailed, err)
+	}
+
+	if reenroll {
+		if err = h.checkReenrollment(r, label, csr); err != nil {
+			return err
+		}
+	}
+
+	cert, err := h.signer.Sign(signer.SignRequest{
+		Request:   string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})),
+		Profile:   label,
+		Requester: requester,
+	})
+	if err != nil {
+		log.Warningf("failed to sign EST request: %v", err)
+		return err
+	}
+
+	parsed, err := helpers.ParseCertificatePEM(cert)
+	if err != nil {
+		return err
+	}
+	p7, err := pkcs7.DegenerateCertificates([]*x509.Certificate{parsed})
+	if err != nil {
+		return err
+	}
+	writePKCS7(w, "application/pkcs7-mime; smime-type=certs-only", p7)
+	return nil
+}
+
+// checkReenrollment checks that the client of r authenticated with a
+// valid, unrevoked certificate of the CA, whose subject and SANs the CSR
+// repeats.
+func (h *ESTHandler) checkReenrollment(r *http.Request, label string, csr *x509.CertificateRequest) error {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return errors.NewHTTPError(http.StatusForbidden, "reenrollment requires the certificate being renewed")
+	}
+	current := r.TLS.PeerCertificates[0]
+
+	cas, err := h.caCertificates(label)
+	if err != nil {
+		return err
+	}
+	if len(cas) == 0 || current.CheckSignatureFrom(cas[0]) != nil {
+		return errors.NewHTTPError(http.StatusForbidden, "the client certificate was not issued by this CA")
+	}
+	now := time.Now()
+	if now.Before(current.NotBefore) || now.After(current.NotAfter) {
+		return errors.NewHTTPError(http.StatusForbidden, "the client certificate is not valid")
+	}
+
+	if h.dbAccessor == nil {
+		return errors.NewHTTPError(http.StatusForbidden, "reenrollment needs a cert db to check revocation")
+	}
+	crs, err := h.dbAccessor.GetCertificate(current.SerialNumber.String(), hex.EncodeToString(current.AuthorityKeyId))
+	if err != nil {
+		return err
+	}
+	if len(crs) != 1 || crs[0].Status != "good" {
+		return errors.NewHTTPError(http.StatusForbidden, "the client certificate is revoked or unknown")
+	}
+
+	if csr.Subject.String() != current.Subject.String() ||
+		!sameStrings(csr.DNSNames, current.DNSNames) ||
+		!sameStrings(csr.EmailAddresses, current.EmailAddresses) ||
+		len(csr.IPAddresses) != len(current.IPAddresses) {
+		return errors.NewBadRequestString("the CSR must repeat the subject and SANs of the certificate being renewed")
+	}
+	for i, ip := range csr.IPAddresses {
+		if !ip.Equal(current.IPAddresses[i]) {
+			return errors.NewBadRequestString("the CSR must repeat the subject and SANs of the certificate being renewed")
+		}
+	}
+	return nil
+}
+
+// sameStrings reports whether a and b hold the same strings in any order.
+func sameStrings(a, b []string) bool {
+	a = append([]string(nil), a...)
+	b = append([]string(nil), b...)
+	sort.Strings(a)
+	sort.Strings(b)
+	return reflect.DeepEqual(a, b)
+}
+
+// writePKCS7 sends a DER encoded PKCS #7 message in base64, as EST does.
+func writePKCS7(w http.ResponseWriter, contentType string, der []byte) {
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Transfer-Encoding", "base64")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(base64.StdEncoding.EncodeToString(der)))
+}
+
+// writeESTError sends err as a plain text EST error response.
+func writeESTError(w http.ResponseWriter, err error) {
+	status := http.StatusInternalServerError
+	msg := err.Error()
+	switch err := err.(type) {
+	case *errors.HTTPError:
+		status = err.StatusCode
+	case *errors.Error:
+		status = http.StatusBadRequest
+		msg = err.Message
+	}
+	http.Error(w, msg, status)
+}
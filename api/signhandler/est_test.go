@@ -0,0 +1,219 @@
+package signhandler
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/config"
+	"github.com/ucosty/cfssl/crypto/pkcs7"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/signer/local"
+)
+
+const testAuthKey = "0123456789ABCDEF0123456789ABCDEF"
+
+var estConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature", "client auth"],
+			"expiry": "10m"
+		},
+		"profiles": {
+			"device": {
+				"usages": ["digital signature", "client auth"],
+				"expiry": "10m",
+				"auth_key": "device-key"
+			}
+		}
+	},
+	"auth_keys": {
+		"device-key": {
+			"type": "standard",
+			"key": "` + testAuthKey + `"
+		}
+	}
+}`
+
+func newESTHandler(t *testing.T) (http.Handler, certdb.Accessor) {
+	conf, err := config.LoadConfig([]byte(estConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, conf.Signing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dbAccessor := sql.NewAccessor(testdb.SQLiteDB("../../certdb/testdb/certstore_development.db"))
+	s.SetDBAccessor(dbAccessor)
+	h, err := NewESTHandler(dbAccessor, s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, dbAccessor
+}
+
+func newESTCSR(t *testing.T, cn string, names ...string) string {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// estRequest serves an EST request and returns the certificates of a
+// successful response.
+func estRequest(t *testing.T, h http.Handler, r *http.Request) (*httptest.ResponseRecorder, []*x509.Certificate) {
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusOK {
+		return w, nil
+	}
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "application/pkcs7-mime") {
+		t.Fatalf("unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+	der, err := base64.StdEncoding.DecodeString(w.Body.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg, err := pkcs7.ParsePKCS7(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w, msg.Content.SignedData.Certificates
+}
+
+func enrollRequest(path, csr string) *http.Request {
+	r := httptest.NewRequest("POST", ESTPrefix+path, strings.NewReader(csr))
+	r.Header.Set("Content-Type", "application/pkcs10")
+	return r
+}
+
+func TestESTCACerts(t *testing.T) {
+	h, _ := newESTHandler(t)
+
+	_, certs := estRequest(t, h, httptest.NewRequest("GET", ESTPrefix+"cacerts", nil))
+	caPEM, err := ioutil.ReadFile(testCaFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 1 || !certs[0].Equal(ca) {
+		t.Fatalf("expected the CA certificate, got %d certificates", len(certs))
+	}
+
+	w, _ := estRequest(t, h, httptest.NewRequest("GET", ESTPrefix+"nosuchlabel/cacerts", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("expected an unknown label to be 404, got %d", w.Code)
+	}
+	w, _ = estRequest(t, h, httptest.NewRequest("POST", ESTPrefix+"cacerts", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("expected POST to cacerts to be 405, got %d", w.Code)
+	}
+}
+
+func TestESTSimpleEnroll(t *testing.T) {
+	h, _ := newESTHandler(t)
+
+	_, certs := estRequest(t, h, enrollRequest("simpleenroll", newESTCSR(t, "device.example.com", "device.example.com")))
+	if len(certs) != 1 || certs[0].Subject.CommonName != "device.example.com" {
+		t.Fatalf("unexpected enrollment result %v", certs)
+	}
+
+	w, _ := estRequest(t, h, enrollRequest("simpleenroll", "not base64!"))
+	if w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a malformed request to be 400, got %d", w.Code)
+	}
+}
+
+func TestESTAuthenticatedEnroll(t *testing.T) {
+	h, _ := newESTHandler(t)
+	csr := newESTCSR(t, "device.example.com")
+
+	w, _ := estRequest(t, h, enrollRequest("device/simpleenroll", csr))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") == "" {
+		t.Fatalf("expected a basic auth challenge, got %d", w.Code)
+	}
+
+	r := enrollRequest("device/simpleenroll", csr)
+	r.SetBasicAuth("device-1", "00")
+	if w, _ = estRequest(t, h, r); w.Code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be rejected, got %d", w.Code)
+	}
+
+	key, _ := hex.DecodeString(testAuthKey)
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("device-1"))
+	r = enrollRequest("device/simpleenroll", csr)
+	r.SetBasicAuth("device-1", hex.EncodeToString(mac.Sum(nil)))
+	w, certs := estRequest(t, h, r)
+	if w.Code != http.StatusOK || len(certs) != 1 {
+		t.Fatalf("expected the enrollment to succeed, got %d: %s", w.Code, w.Body)
+	}
+}
+
+func TestESTSimpleReenroll(t *testing.T) {
+	h, dbAccessor := newESTHandler(t)
+
+	_, certs := estRequest(t, h, enrollRequest("simpleenroll", newESTCSR(t, "device.example.com", "device.example.com")))
+	if len(certs) != 1 {
+		t.Fatal("enrollment failed")
+	}
+	current := certs[0]
+
+	csr := newESTCSR(t, "device.example.com", "device.example.com")
+	w, _ := estRequest(t, h, enrollRequest("simplereenroll", csr))
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expected reenrollment without a client certificate to be 403, got %d", w.Code)
+	}
+
+	r := enrollRequest("simplereenroll", newESTCSR(t, "other.example.com", "other.example.com"))
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{current}}
+	if w, _ = estRequest(t, h, r); w.Code != http.StatusBadRequest {
+		t.Fatalf("expected a CSR for another subject to be 400, got %d", w.Code)
+	}
+
+	r = enrollRequest("simplereenroll", csr)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{current}}
+	w, certs = estRequest(t, h, r)
+	if w.Code != http.StatusOK || len(certs) != 1 || certs[0].SerialNumber.Cmp(current.SerialNumber) == 0 {
+		t.Fatalf("expected a new certificate, got %d: %s", w.Code, w.Body)
+	}
+
+	// A revoked certificate cannot be renewed.
+	err := dbAccessor.RevokeCertificate(current.SerialNumber.String(), hex.EncodeToString(current.AuthorityKeyId), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r = enrollRequest("simplereenroll", csr)
+	r.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{current}}
+	if w, _ = estRequest(t, h, r); w.Code != http.StatusForbidden {
+		t.Fatalf("expected reenrollment with a revoked certificate to be 403, got %d", w.Code)
+	}
+}
@@ -145,6 +145,8 @@ func (h *Handler) Handle(w http.ResponseWriter, r *http.Request) error {
 		return errors.NewBadRequestString("authentication required")
 	}
 
+	signReq.Requester = api.Requester(r, "")
+
 	cert, err = h.signer.Sign(signReq)
 	if err != nil {
 		log.Warningf("failed to sign request: %v", err)
@@ -269,6 +271,7 @@ func (h *AuthHandler) Handle(w http.ResponseWriter, r *http.Request) error {
 	if signReq.Request == "" {
 		return errors.NewBadRequestString("missing parameter 'certificate_request'")
 	}
+	signReq.Requester = api.Requester(r, profile.AuthKeyName)
 
 	cert, err := h.signer.Sign(signReq)
 	if err != nil {
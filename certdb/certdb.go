@@ -1,29 +1,285 @@
 package certdb
 
 import (
+	"database/sql/driver"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"time"
 )
 
 // CertificateRecord encodes a certificate and its metadata
 // that will be recorded in a database.
+//
+// The issuance metadata (CommonName through Fingerprint) is recorded by the
+// signer so that certificates can be searched without parsing PEM. Records
+// written before it was introduced leave those fields empty.
 type CertificateRecord struct {
-	Serial    string    `sql:"serial_number" json:"serial,omitempty"`
-	AKI       string    `sql:"authority_key_identifier" json:"authority_key_identifier,omitempty"`
-	CALabel   string    `sql:"ca_label" json:"ca_label,omitempty"`
-	Status    string    `sql:"status" json:"status,omitempty"`
-	Reason    int       `sql:"reason" json:"reason,omitempty"`
-	Expiry    time.Time `sql:"expiry" json:"expiry,omitempty"`
-	RevokedAt time.Time `sql:"revoked_at" json:"revoked_at,omitempty"`
-	PEM       string    `sql:"pem" json:"pem,omitempty"`
+	Serial      string     `db:"serial_number" json:"serial,omitempty"`
+	AKI         string     `db:"authority_key_identifier" json:"authority_key_identifier,omitempty"`
+	CALabel     string     `db:"ca_label" json:"ca_label,omitempty"`
+	Status      string     `db:"status" json:"status,omitempty"`
+	Reason      int        `db:"reason" json:"reason,omitempty"`
+	Expiry      time.Time  `db:"expiry" json:"expiry,omitempty"`
+	RevokedAt   time.Time  `db:"revoked_at" json:"revoked_at,omitempty"`
+	IssuedAt    time.Time  `db:"issued_at" json:"issued_at,omitempty"`
+	CommonName  string     `db:"common_name" json:"common_name,omitempty"`
+	SANs        StringList `db:"sans" json:"sans,omitempty"`
+	Profile     string     `db:"profile" json:"profile,omitempty"`
+	NotBefore   time.Time  `db:"not_before" json:"not_before,omitempty"`
+	Requester   string     `db:"requester" json:"requester,omitempty"`
+	Fingerprint string     `db:"sha256_fingerprint" json:"sha256_fingerprint,omitempty"`
+	PEM         string     `db:"pem" json:"pem,omitempty"`
+}
+
+// CRLRecord tracks the CRL numbers issued by one issuer for one CRL
+// distribution point. DistributionPoint is empty for CRLs that do not name
+// one in an Issuing Distribution Point extension. BaseNumber and
+// BaseThisUpdate identify the last full CRL, which delta CRLs refer to;
+// BaseNumber is zero until one has been issued.
+type CRLRecord struct {
+	AKI               string    `db:"authority_key_identifier" json:"authority_key_identifier"`
+	DistributionPoint string    `db:"distribution_point" json:"distribution_point"`
+	Number            int64     `db:"crl_number" json:"crl_number"`
+	BaseNumber        int64     `db:"base_crl_number" json:"base_crl_number"`
+	BaseThisUpdate    time.Time `db:"base_this_update" json:"base_this_update"`
+}
+
+// Revocation event actions.
+const (
+	ActionRevoke = "revoke"
+	ActionUnhold = "unhold"
+)
+
+// RevocationEvent records one change to the revocation status of a
+// certificate: who made it, when, with which reason, and the status and
+// reason the certificate had before. Events are only ever appended.
+type RevocationEvent struct {
+	Serial         string    `db:"serial_number" json:"serial"`
+	AKI            string    `db:"authority_key_identifier" json:"authority_key_identifier"`
+	Action         string    `db:"action" json:"action"`
+	Actor          string    `db:"actor" json:"actor,omitempty"`
+	Reason         int       `db:"reason" json:"reason"`
+	PreviousStatus string    `db:"previous_status" json:"previous_status"`
+	PreviousReason int       `db:"previous_reason" json:"previous_reason"`
+	CreatedAt      time.Time `db:"created_at" json:"created_at"`
+}
+
+// StringList is a list of strings that SQL backends store in a single
+// column as a JSON array.
+type StringList []string
+
+// Value implements driver.Valuer.
+func (l StringList) Value() (driver.Value, error) {
+	if len(l) == 0 {
+		return "[]", nil
+	}
+
+	b, err := json.Marshal([]string(l))
+	if err != nil {
+		return nil, err
+	}
+	return string(b), nil
+}
+
+// Scan implements sql.Scanner.
+func (l *StringList) Scan(src interface{}) error {
+	var b []byte
+	switch v := src.(type) {
+	case nil:
+		*l = nil
+		return nil
+	case []byte:
+		b = v
+	case string:
+		b = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a StringList", src)
+	}
+
+	if len(b) == 0 {
+		*l = nil
+		return nil
+	}
+
+	var list []string
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	if len(list) == 0 {
+		list = nil
+	}
+	*l = list
+	return nil
 }
 
 // OCSPRecord encodes a OCSP response body and its metadata
 // that will be recorded in a database.
 type OCSPRecord struct {
-	Serial string    `sql:"serial_number" json:"serial,omitempty"`
-	AKI    string    `sql:"authority_key_identifier" json:"authority_key_identifier,omitempty"`
-	Body   string    `sql:"body" json:"body,omitempty"`
-	Expiry time.Time `sql:"expiry" json:"expiry,omitempty"`
+	Serial string    `db:"serial_number" json:"serial,omitempty"`
+	AKI    string    `db:"authority_key_identifier" json:"authority_key_identifier,omitempty"`
+	Body   string    `db:"body" json:"body,omitempty"`
+	Expiry time.Time `db:"expiry" json:"expiry,omitempty"`
+}
+
+// Page sizes used by Accessor.ListCertificates.
+const (
+	DefaultPageSize = 100
+	MaxPageSize     = 1000
+)
+
+// CertificateQuery selects the certificate records returned by
+// Accessor.ListCertificates. Zero-valued fields do not filter. Hostname
+// matches the common name or any SAN exactly. Records are returned ordered
+// by serial number and then AKI; Cursor resumes a listing after the last
+// record of a previous page.
+type CertificateQuery struct {
+	CALabel       string    `json:"ca_label,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	AKI           string    `json:"authority_key_identifier,omitempty"`
+	Hostname      string    `json:"hostname,omitempty"`
+	ExpiresAfter  time.Time `json:"expires_after,omitempty"`
+	ExpiresBefore time.Time `json:"expires_before,omitempty"`
+	IssuedAfter   time.Time `json:"issued_after,omitempty"`
+	IssuedBefore  time.Time `json:"issued_before,omitempty"`
+	Cursor        string    `json:"cursor,omitempty"`
+	Limit         int       `json:"limit,omitempty"`
+}
+
+// CertificatePage is one page of a certificate listing. NextCursor is
+// empty on the last page.
+type CertificatePage struct {
+	Certificates []CertificateRecord `json:"certificates"`
+	NextCursor   string              `json:"next_cursor,omitempty"`
+}
+
+// PageSize returns the number of records a page should hold, applying the
+// default and maximum page sizes to q.Limit.
+func (q CertificateQuery) PageSize() int {
+	switch {
+	case q.Limit <= 0:
+		return DefaultPageSize
+	case q.Limit > MaxPageSize:
+		return MaxPageSize
+	}
+	return q.Limit
+}
+
+// Matches reports whether cr satisfies every filter in q. The cursor is
+// not considered. It lets backends without a query language filter records
+// in memory.
+func (q CertificateQuery) Matches(cr CertificateRecord) bool {
+	switch {
+	case q.CALabel != "" && cr.CALabel != q.CALabel:
+		return false
+	case q.Status != "" && cr.Status != q.Status:
+		return false
+	case q.AKI != "" && cr.AKI != q.AKI:
+		return false
+	case !q.ExpiresAfter.IsZero() && !cr.Expiry.After(q.ExpiresAfter):
+		return false
+	case !q.ExpiresBefore.IsZero() && !cr.Expiry.Before(q.ExpiresBefore):
+		return false
+	case !q.IssuedAfter.IsZero() && !cr.IssuedAt.After(q.IssuedAfter):
+		return false
+	case !q.IssuedBefore.IsZero() && !cr.IssuedAt.Before(q.IssuedBefore):
+		return false
+	case q.Hostname != "" && !hasHostname(cr, q.Hostname):
+		return false
+	}
+	return true
+}
+
+func hasHostname(cr CertificateRecord, hostname string) bool {
+	if cr.CommonName == hostname {
+		return true
+	}
+	for _, san := range cr.SANs {
+		if san == hostname {
+			return true
+		}
+	}
+	return false
+}
+
+// Selective reports whether q filters on anything besides the status, so
+// that it cannot match every certificate of the db.
+func (q CertificateQuery) Selective() bool {
+	return q.CALabel != "" || q.AKI != "" || q.Hostname != "" ||
+		!q.ExpiresAfter.IsZero() || !q.ExpiresBefore.IsZero() ||
+		!q.IssuedAfter.IsZero() || !q.IssuedBefore.IsZero()
+}
+
+// ListAll gets every certificate matching q, ignoring its cursor and
+// limit, by reading all pages of dbAccessor.ListCertificates.
+func ListAll(dbAccessor Accessor, q CertificateQuery) ([]CertificateRecord, error) {
+	var crs []CertificateRecord
+	q.Cursor = ""
+	q.Limit = MaxPageSize
+	for {
+		page, err := dbAccessor.ListCertificates(q)
+		if err != nil {
+			return nil, err
+		}
+		crs = append(crs, page.Certificates...)
+		if page.NextCursor == "" {
+			return crs, nil
+		}
+		q.Cursor = page.NextCursor
+	}
+}
+
+// RevokeAll revokes the unrevoked certificates matching q one at a time,
+// for accessors without transactions across certificates, and returns the
+// revoked records. If revoking one fails, the certificates revoked before
+// it are returned along with the error.
+func RevokeAll(dbAccessor Accessor, q CertificateQuery, reasonCode int) ([]CertificateRecord, error) {
+	q.Status = "good"
+	crs, err := ListAll(dbAccessor, q)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, cr := range crs {
+		if err = dbAccessor.RevokeCertificate(cr.Serial, cr.AKI, reasonCode); err != nil {
+			return crs[:i], err
+		}
+		revoked, err := dbAccessor.GetCertificate(cr.Serial, cr.AKI)
+		if err != nil {
+			return crs[:i], err
+		}
+		if len(revoked) == 1 {
+			crs[i] = revoked[0]
+		}
+	}
+	return crs, nil
+}
+
+type cursor struct {
+	Serial string `json:"s"`
+	AKI    string `json:"a"`
+}
+
+// EncodeCursor returns an opaque cursor positioned after the record with
+// the given serial and AKI.
+func EncodeCursor(serial, aki string) string {
+	b, _ := json.Marshal(cursor{Serial: serial, AKI: aki})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// DecodeCursor returns the serial and AKI encoded by EncodeCursor.
+func DecodeCursor(s string) (serial, aki string, err error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return "", "", errors.New("malformed cursor")
+	}
+
+	var c cursor
+	if err = json.Unmarshal(b, &c); err != nil {
+		return "", "", errors.New("malformed cursor")
+	}
+	return c.Serial, c.AKI, nil
 }
 
 // Accessor abstracts the CRUD of certdb objects from a DB.
@@ -32,10 +288,35 @@ type Accessor interface {
 	GetCertificate(serial, aki string) ([]CertificateRecord, error)
 	GetUnexpiredCertificates() ([]CertificateRecord, error)
 	GetRevokedAndUnexpiredCertificates() ([]CertificateRecord, error)
+	ListCertificates(q CertificateQuery) (CertificatePage, error)
 	RevokeCertificate(serial, aki string, reasonCode int) error
+	// RevokeCertificates revokes every certificate with status "good"
+	// matching q, ignoring its status filter, cursor and limit, and
+	// returns the revoked records. SQL backends revoke them in a single
+	// transaction.
+	RevokeCertificates(q CertificateQuery, reasonCode int) ([]CertificateRecord, error)
+	// UnholdCertificate marks a certificate revoked with reason
+	// certificateHold as good again. Certificates in any other state are
+	// left alone and an InvalidRecordStatus error is returned.
+	UnholdCertificate(serial, aki string) error
+	InsertRevocationEvent(ev RevocationEvent) error
+	// GetRevocationEvents gets the events of a certificate, oldest first.
+	GetRevocationEvents(serial, aki string) ([]RevocationEvent, error)
+	// ListRevocationEvents gets the events of every certificate created at
+	// or after since, oldest first.
+	ListRevocationEvents(since time.Time) ([]RevocationEvent, error)
 	InsertOCSP(rr OCSPRecord) error
 	GetOCSP(serial, aki string) ([]OCSPRecord, error)
 	GetUnexpiredOCSPs() ([]OCSPRecord, error)
 	UpdateOCSP(serial, aki, body string, expiry time.Time) error
 	UpsertOCSP(serial, aki, body string, expiry time.Time) error
+	// NextCRLNumber increments and returns the CRL number of the issuer
+	// and distribution point, starting at 1.
+	NextCRLNumber(aki, distributionPoint string) (int64, error)
+	// GetCRLRecord gets the CRL number and base CRL of the issuer and
+	// distribution point, or no record if no CRL number has been issued.
+	GetCRLRecord(aki, distributionPoint string) ([]CRLRecord, error)
+	// UpdateBaseCRL records the number and thisUpdate of the last full
+	// CRL of the issuer and distribution point.
+	UpdateBaseCRL(aki, distributionPoint string, number int64, thisUpdate time.Time) error
 }
@@ -0,0 +1,755 @@
+package consul
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/consul/api"
+	"github.com/ucosty/cfssl/certdb"
+)
+
+const (
+	fakeAKI = "fake_aki"
+)
+
+// fakeKV is an in-process stand-in for the Consul KV store that honours
+// modify indexes the same way the real agent does.
+type fakeKV struct {
+	sync.Mutex
+	index uint64
+	pairs map[string]*api.KVPair
+
+	// conflicts makes the next n CAS calls fail as if another writer won.
+	conflicts int
+	// err is returned from every call when set.
+	err error
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{pairs: map[string]*api.KVPair{}}
+}
+
+func (f *fakeKV) Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+
+	pair, ok := f.pairs[key]
+	if !ok {
+		return nil, nil, nil
+	}
+	cp := *pair
+	return &cp, nil, nil
+}
+
+func (f *fakeKV) List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return nil, nil, f.err
+	}
+
+	var keys []string
+	for key := range f.pairs {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	var pairs api.KVPairs
+	for _, key := range keys {
+		cp := *f.pairs[key]
+		pairs = append(pairs, &cp)
+	}
+	return pairs, nil, nil
+}
+
+func (f *fakeKV) CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error) {
+	f.Lock()
+	defer f.Unlock()
+	if f.err != nil {
+		return false, nil, f.err
+	}
+
+	if f.conflicts > 0 {
+		f.conflicts--
+		return false, nil, nil
+	}
+
+	existing, ok := f.pairs[p.Key]
+	if p.ModifyIndex == 0 && ok {
+		return false, nil, nil
+	}
+	if p.ModifyIndex != 0 && (!ok || existing.ModifyIndex != p.ModifyIndex) {
+		return false, nil, nil
+	}
+
+	f.index++
+	cp := *p
+	cp.ModifyIndex = f.index
+	if ok {
+		cp.CreateIndex = existing.CreateIndex
+	} else {
+		cp.CreateIndex = f.index
+	}
+	f.pairs[p.Key] = &cp
+	return true, nil, nil
+}
+
+func newTestAccessor() (*Accessor, *fakeKV) {
+	kv := newFakeKV()
+	return &Accessor{prefix: "cfssl", kv: kv}, kv
+}
+
+// roughlySameTime decides if t1 and t2 are close enough.
+func roughlySameTime(t1, t2 time.Time) bool {
+	// return true if the difference is smaller than 1 sec.
+	return math.Abs(float64(t1.Sub(t2))) < float64(time.Second)
+}
+
+func TestNoKV(t *testing.T) {
+	dba := &Accessor{}
+	_, err := dba.GetCertificate("foobar serial", "random aki")
+	if err == nil {
+		t.Fatal("should return error")
+	}
+}
+
+func TestLoadConfiguration(t *testing.T) {
+	dba := &Accessor{}
+	if err := dba.LoadConfiguration("testdata/db-config.json"); err != nil {
+		t.Fatal(err)
+	}
+	if dba.prefix != "cfssl" || dba.config["uri"] != "127.0.0.1:8500" {
+		t.Fatalf("unexpected configuration %+v", dba.config)
+	}
+
+	if err := (&Accessor{}).LoadConfiguration("testdata/bad-db-config.json"); err == nil {
+		t.Fatal("expected failure loading configuration without a prefix")
+	}
+
+	if err := (&Accessor{}).LoadConfiguration("nonexistent"); err == nil {
+		t.Fatal("expected failure loading nonexistent configuration file")
+	}
+}
+
+func TestKVErrorsPropagate(t *testing.T) {
+	dba, kv := newTestAccessor()
+	kv.err = errors.New("consul is down")
+
+	if _, err := dba.GetCertificate("serial", fakeAKI); err == nil {
+		t.Error("GetCertificate should return the KV error")
+	}
+	if _, err := dba.GetUnexpiredCertificates(); err == nil {
+		t.Error("GetUnexpiredCertificates should return the KV error")
+	}
+	if err := dba.InsertCertificate(certdb.CertificateRecord{Serial: "serial", AKI: fakeAKI}); err == nil {
+		t.Error("InsertCertificate should return the KV error")
+	}
+	if err := dba.UpsertOCSP("serial", fakeAKI, "body", time.Now()); err == nil {
+		t.Error("UpsertOCSP should return the KV error")
+	}
+}
+
+func TestConsul(t *testing.T) {
+	testInsertCertificateAndGetCertificate(t)
+	testInsertCertificateAndGetUnexpiredCertificate(t)
+	testInsertCertificateMetadata(t)
+	testInsertDuplicateCertificate(t)
+	testUpdateCertificateAndGetCertificate(t)
+	testGetRevokedAndUnexpiredCertificates(t)
+	testRevokeRetriesOnConflict(t)
+	testInsertOCSPAndGetOCSP(t)
+	testInsertOCSPAndGetUnexpiredOCSP(t)
+	testUpdateOCSPAndGetOCSP(t)
+	testUpsertOCSPAndGetOCSP(t)
+	testNextCRLNumber(t)
+	testUpdateBaseCRLAndGetCRLRecord(t)
+	testRevokeCertificates(t)
+	testUnholdAndRevocationEvents(t)
+}
+
+func testInsertCertificateAndGetCertificate(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	expiry := time.Date(2010, time.December, 25, 23, 0, 0, 0, time.UTC)
+	want := certdb.CertificateRecord{
+		PEM:    "fake cert data",
+		Serial: "fake serial",
+		AKI:    fakeAKI,
+		Status: "good",
+		Reason: 0,
+		Expiry: expiry,
+	}
+
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := dba.GetCertificate(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rets) != 1 {
+		t.Fatal("should only return one record.")
+	}
+
+	got := rets[0]
+
+	if want.Serial != got.Serial || want.Status != got.Status ||
+		want.AKI != got.AKI || !got.RevokedAt.IsZero() ||
+		want.PEM != got.PEM || !roughlySameTime(got.Expiry, expiry) {
+		t.Errorf("want Certificate %+v, got %+v", want, got)
+	}
+
+	rets, err = dba.GetCertificate("missing serial", want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 0 {
+		t.Error("should not return a record for a missing certificate")
+	}
+
+	unexpired, err := dba.GetUnexpiredCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unexpired) != 0 {
+		t.Error("should not have unexpired certificate record")
+	}
+}
+
+func testInsertCertificateMetadata(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	notBefore := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.FixedZone("PST", -8*3600))
+	want := certdb.CertificateRecord{
+		PEM:         "fake cert data",
+		Serial:      "fake serial",
+		AKI:         fakeAKI,
+		Status:      "good",
+		Expiry:      notBefore.AddDate(1, 0, 0),
+		CommonName:  "example.com",
+		SANs:        certdb.StringList{"example.com", "127.0.0.1"},
+		Profile:     "www",
+		NotBefore:   notBefore,
+		Requester:   "client-key",
+		Fingerprint: "d0e1",
+	}
+
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := dba.GetCertificate(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should only return one record.")
+	}
+
+	got := rets[0]
+	if got.CommonName != want.CommonName || got.Profile != want.Profile ||
+		got.Requester != want.Requester || got.Fingerprint != want.Fingerprint ||
+		!got.NotBefore.Equal(notBefore) || got.NotBefore.Location() != time.UTC ||
+		!reflect.DeepEqual(got.SANs, want.SANs) {
+		t.Errorf("want Certificate %+v, got %+v", want, got)
+	}
+}
+
+func testInsertCertificateAndGetUnexpiredCertificate(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	expiry := time.Now().Add(time.Minute)
+	want := certdb.CertificateRecord{
+		PEM:    "fake cert data",
+		Serial: "fake serial 2",
+		AKI:    fakeAKI,
+		Status: "good",
+		Reason: 0,
+		Expiry: expiry,
+	}
+
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// OCSP records share the prefix and must not show up as certificates.
+	if err := dba.InsertOCSP(certdb.OCSPRecord{Serial: want.Serial, AKI: fakeAKI, Expiry: expiry}); err != nil {
+		t.Fatal(err)
+	}
+
+	unexpired, err := dba.GetUnexpiredCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unexpired) != 1 {
+		t.Fatal("Should have 1 unexpired certificate record:", len(unexpired))
+	}
+
+	if unexpired[0].Serial != want.Serial || unexpired[0].PEM != want.PEM {
+		t.Errorf("want Certificate %+v, got %+v", want, unexpired[0])
+	}
+}
+
+func testInsertDuplicateCertificate(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	want := certdb.CertificateRecord{
+		PEM:    "fake cert data",
+		Serial: "fake serial",
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().Add(time.Minute),
+	}
+
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	want.PEM = "other cert data"
+	if err := dba.InsertCertificate(want); err == nil {
+		t.Fatal("inserting a duplicate certificate should fail")
+	}
+
+	rets, err := dba.GetCertificate(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 || rets[0].PEM != "fake cert data" {
+		t.Errorf("duplicate insert overwrote the original record: %+v", rets)
+	}
+}
+
+func testUpdateCertificateAndGetCertificate(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	expiry := time.Date(2010, time.December, 25, 23, 0, 0, 0, time.UTC)
+	want := certdb.CertificateRecord{
+		PEM:    "fake cert data",
+		Serial: "fake serial 3",
+		AKI:    fakeAKI,
+		Status: "good",
+		Reason: 0,
+		Expiry: expiry,
+	}
+
+	// Make sure the revoke on a non-existent cert fails
+	if err := dba.RevokeCertificate(want.Serial, want.AKI, 2); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	// reason 2 is CACompromise
+	if err := dba.RevokeCertificate(want.Serial, want.AKI, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := dba.GetCertificate(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got := rets[0]
+
+	if want.Serial != got.Serial || got.Status != "revoked" || got.Reason != 2 ||
+		want.AKI != got.AKI || got.RevokedAt.IsZero() ||
+		want.PEM != got.PEM {
+		t.Errorf("want Certificate %+v, got %+v", want, got)
+	}
+}
+
+func testGetRevokedAndUnexpiredCertificates(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	records := []certdb.CertificateRecord{
+		{Serial: "good unexpired", AKI: fakeAKI, Status: "good", Expiry: time.Now().Add(time.Hour)},
+		{Serial: "revoked unexpired", AKI: fakeAKI, Status: "good", Expiry: time.Now().Add(time.Hour)},
+		{Serial: "revoked expired", AKI: fakeAKI, Status: "good", Expiry: time.Now().Add(-time.Hour)},
+	}
+	for _, cr := range records {
+		if err := dba.InsertCertificate(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, serial := range []string{"revoked unexpired", "revoked expired"} {
+		if err := dba.RevokeCertificate(serial, fakeAKI, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	revoked, err := dba.GetRevokedAndUnexpiredCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(revoked) != 1 || revoked[0].Serial != "revoked unexpired" {
+		t.Errorf("expected only the revoked, unexpired certificate, got %+v", revoked)
+	}
+}
+
+func testRevokeRetriesOnConflict(t *testing.T) {
+	dba, kv := newTestAccessor()
+
+	want := certdb.CertificateRecord{
+		Serial: "fake serial",
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().Add(time.Hour),
+	}
+	if err := dba.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	kv.conflicts = maxCASAttempts - 1
+	if err := dba.RevokeCertificate(want.Serial, want.AKI, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	kv.conflicts = maxCASAttempts
+	if err := dba.RevokeCertificate(want.Serial, want.AKI, 1); err == nil {
+		t.Fatal("revocation should give up after repeated conflicts")
+	}
+}
+
+func testInsertOCSPAndGetOCSP(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	expiry := time.Date(2010, time.December, 25, 23, 0, 0, 0, time.UTC)
+	want := certdb.OCSPRecord{
+		Serial: "fake serial",
+		AKI:    fakeAKI,
+		Body:   "fake body",
+		Expiry: expiry,
+	}
+
+	if err := dba.InsertOCSP(want); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dba.InsertOCSP(want); err == nil {
+		t.Fatal("inserting a duplicate OCSP record should fail")
+	}
+
+	rets, err := dba.GetOCSP(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got := rets[0]
+
+	if want.Serial != got.Serial || want.Body != got.Body ||
+		!roughlySameTime(want.Expiry, got.Expiry) {
+		t.Errorf("want OCSP %+v, got %+v", want, got)
+	}
+
+	unexpired, err := dba.GetUnexpiredOCSPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unexpired) != 0 {
+		t.Error("should not have unexpired OCSP record")
+	}
+}
+
+func testInsertOCSPAndGetUnexpiredOCSP(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	want := certdb.OCSPRecord{
+		Serial: "fake serial 2",
+		AKI:    fakeAKI,
+		Body:   "fake body",
+		Expiry: time.Now().Add(time.Minute),
+	}
+
+	if err := dba.InsertOCSP(want); err != nil {
+		t.Fatal(err)
+	}
+
+	unexpired, err := dba.GetUnexpiredOCSPs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unexpired) != 1 {
+		t.Error("should not have other than 1 unexpired OCSP record:", len(unexpired))
+	}
+}
+
+func testUpdateOCSPAndGetOCSP(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	want := certdb.OCSPRecord{
+		Serial: "fake serial 3",
+		AKI:    fakeAKI,
+		Body:   "fake body",
+		Expiry: time.Date(2010, time.December, 25, 23, 0, 0, 0, time.UTC),
+	}
+
+	// Make sure the update fails
+	if err := dba.UpdateOCSP(want.Serial, want.AKI, want.Body, want.Expiry); err == nil {
+		t.Fatal("Expected error")
+	}
+
+	if err := dba.InsertOCSP(want); err != nil {
+		t.Fatal(err)
+	}
+
+	newExpiry := time.Now().Add(time.Hour)
+	if err := dba.UpdateOCSP(want.Serial, want.AKI, "fake body revoked", newExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := dba.GetOCSP(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got := rets[0]
+
+	if want.Serial != got.Serial || got.Body != "fake body revoked" ||
+		!roughlySameTime(newExpiry, got.Expiry) {
+		t.Errorf("want OCSP %+v, got %+v", want, got)
+	}
+}
+
+func testUpsertOCSPAndGetOCSP(t *testing.T) {
+	dba, kv := newTestAccessor()
+
+	want := certdb.OCSPRecord{
+		Serial: "fake serial 3",
+		AKI:    fakeAKI,
+		Body:   "fake body",
+		Expiry: time.Date(2010, time.December, 25, 23, 0, 0, 0, time.UTC),
+	}
+
+	if err := dba.UpsertOCSP(want.Serial, want.AKI, want.Body, want.Expiry); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := dba.GetOCSP(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got := rets[0]
+
+	if want.Serial != got.Serial || want.Body != got.Body ||
+		!roughlySameTime(want.Expiry, got.Expiry) {
+		t.Errorf("want OCSP %+v, got %+v", want, got)
+	}
+
+	kv.conflicts = 1
+	newExpiry := time.Now().Add(time.Hour)
+	if err := dba.UpsertOCSP(want.Serial, want.AKI, "fake body revoked", newExpiry); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err = dba.GetOCSP(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should return exactly one record")
+	}
+
+	got = rets[0]
+
+	if want.Serial != got.Serial || got.Body != "fake body revoked" ||
+		!roughlySameTime(newExpiry, got.Expiry) {
+		t.Errorf("want OCSP %+v, got %+v", want, got)
+	}
+}
+
+func testNextCRLNumber(t *testing.T) {
+	dba, kv := newTestAccessor()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := dba.NextCRLNumber(fakeAKI, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("want CRL number %d, got %d", want, got)
+		}
+	}
+
+	// Each distribution point has its own sequence.
+	got, err := dba.NextCRLNumber(fakeAKI, "http://crl.example.com/1.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Fatalf("want CRL number 1 for a new distribution point, got %d", got)
+	}
+
+	kv.conflicts = 1
+	got, err = dba.NextCRLNumber(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 4 {
+		t.Fatalf("want CRL number 4 after a conflict, got %d", got)
+	}
+}
+
+func testUpdateBaseCRLAndGetCRLRecord(t *testing.T) {
+	dba, kv := newTestAccessor()
+
+	if err := dba.UpdateBaseCRL(fakeAKI, "", 1, time.Now()); err == nil {
+		t.Fatal("Expected an error updating the base CRL before issuing a CRL number")
+	}
+
+	number, err := dba.NextCRLNumber(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thisUpdate := time.Now()
+	kv.conflicts = 1
+	if err = dba.UpdateBaseCRL(fakeAKI, "", number, thisUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = dba.NextCRLNumber(fakeAKI, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err := dba.GetCRLRecord(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("want one CRL record, got %d", len(recs))
+	}
+	if recs[0].Number != number+1 || recs[0].BaseNumber != number ||
+		!roughlySameTime(recs[0].BaseThisUpdate, thisUpdate) {
+		t.Errorf("want CRL %d based on %d at %v, got %+v", number+1, number, thisUpdate, recs[0])
+	}
+}
+
+func testRevokeCertificates(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	expiry := time.Now().Add(time.Minute)
+	for _, cr := range []certdb.CertificateRecord{
+		{Serial: "1", CommonName: "www.example.com", Status: "good"},
+		{Serial: "2", SANs: certdb.StringList{"www.example.com"}, Status: "good"},
+		{Serial: "3", SANs: certdb.StringList{"example.com"}, Status: "good"},
+		{Serial: "4", CommonName: "www.example.com", Status: "revoked"},
+	} {
+		cr.AKI = fakeAKI
+		cr.Expiry = expiry
+		if err := dba.InsertCertificate(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	crs, err := dba.RevokeCertificates(certdb.CertificateQuery{Hostname: "www.example.com"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 2 {
+		t.Fatalf("want 2 certificates revoked, got %d", len(crs))
+	}
+	for _, cr := range crs {
+		if cr.Status != "revoked" || cr.Reason != 1 || cr.RevokedAt.IsZero() {
+			t.Errorf("certificate %s was not revoked: %+v", cr.Serial, cr)
+		}
+	}
+
+	got, err := dba.GetCertificate("3", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != "good" {
+		t.Errorf("certificate 3 was revoked: %+v", got)
+	}
+}
+
+func testUnholdAndRevocationEvents(t *testing.T) {
+	dba, _ := newTestAccessor()
+
+	cr := certdb.CertificateRecord{
+		Serial: "1",
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().Add(time.Minute),
+	}
+	if err := dba.InsertCertificate(cr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := dba.UnholdCertificate(cr.Serial, cr.AKI); err == nil {
+		t.Fatal("releasing a certificate that is not on hold should fail")
+	}
+
+	start := time.Now()
+	if err := certdb.Revoke(dba, cr.Serial, cr.AKI, certdb.ReasonCertificateHold, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := certdb.Unhold(dba, cr.Serial, cr.AKI, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := dba.GetCertificate(cr.Serial, cr.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != "good" || !got[0].RevokedAt.IsZero() {
+		t.Fatalf("certificate was not released: %+v", got)
+	}
+
+	// Events created in the same nanosecond are both kept.
+	ev := certdb.RevocationEvent{Serial: "2", AKI: fakeAKI, Action: certdb.ActionRevoke, CreatedAt: start}
+	for i := 0; i < 2; i++ {
+		if err := dba.InsertRevocationEvent(ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	evs, err := dba.GetRevocationEvents(cr.Serial, cr.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 || evs[0].Action != certdb.ActionRevoke || evs[1].Action != certdb.ActionUnhold || evs[1].Actor != "bob" {
+		t.Fatalf("unexpected revocation events %+v", evs)
+	}
+
+	evs, err = dba.ListRevocationEvents(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 4 || evs[0].Serial != "2" || evs[3].Action != certdb.ActionUnhold {
+		t.Fatalf("unexpected revocation events since %v: %+v", start, evs)
+	}
+}
@@ -1,123 +1,666 @@
+// Package consul implements the certdb.Accessor interface on top of the
+// Consul key/value store.
 package consul
 
 import (
-    "encoding/json"
-    "fmt"
-    "log"
-    "github.com/hashicorp/consul/api"
-    "github.com/ucosty/cfssl/certdb"
-    "io/ioutil"
-    "time"
-)
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
 
-type ConsulAccessor struct {
-    host string
-    prefix string
-    config map[string]string
-    consul *api.Client
-}
+	"github.com/hashicorp/consul/api"
+	"github.com/ucosty/cfssl/certdb"
+	cferr "github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/log"
+)
 
+// Records are stored as JSON documents under
+//
+//	<prefix>/certificate/<serial>/<aki>
+//	<prefix>/ocsp/<serial>/<aki>
+//	<prefix>/crl/<aki>/<escaped distribution point>
+//	<prefix>/revocation/<serial>/<aki>/<created at, in nanoseconds>
+//
+// so that every certificate (or OCSP response, or revocation event) can be
+// listed with a single prefix scan.
 const (
-    ocspPrefix            = `ocsp`
-    certificatePrefix     = `certificate`
-    ocspIdTemplate        = `%s/%s/%s/` + ocspPrefix
-    certificateIdTemplate = `%s/%s/%s/` + certificatePrefix
+	ocspPrefix            = `ocsp`
+	certificatePrefix     = `certificate`
+	ocspIDTemplate        = `%s/` + ocspPrefix + `/%s/%s`
+	certificateIDTemplate = `%s/` + certificatePrefix + `/%s/%s`
+	crlPrefix             = `crl`
+	crlIDTemplate         = `%s/` + crlPrefix + `/%s/%s`
+	revocationPrefix      = `revocation`
+	revocationIDTemplate  = `%s/` + revocationPrefix + `/%s/%s/%020d`
+
+	// maxCASAttempts bounds the number of read-modify-write cycles an update
+	// makes before giving up on a key that keeps changing underneath it.
+	maxCASAttempts = 5
 )
 
-func (d *ConsulAccessor) certificateId(serial, aki string) string {
-    return fmt.Sprintf(certificateIdTemplate, d.config["prefix"], serial, aki)
+// kv is the subset of the Consul KV API used by the accessor. It is
+// satisfied by *api.KV.
+type kv interface {
+	Get(key string, q *api.QueryOptions) (*api.KVPair, *api.QueryMeta, error)
+	List(prefix string, q *api.QueryOptions) (api.KVPairs, *api.QueryMeta, error)
+	CAS(p *api.KVPair, q *api.WriteOptions) (bool, *api.WriteMeta, error)
+}
+
+// Accessor implements certdb.Accessor interface.
+type Accessor struct {
+	prefix string
+	config map[string]string
+	kv     kv
+}
+
+func wrapConsulError(err error) error {
+	if err != nil {
+		return cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+	return nil
+}
+
+func (d *Accessor) checkKV() error {
+	if d.kv == nil {
+		return cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+			errors.New("unknown consul client, please check NewAccessor"))
+	}
+	return nil
+}
+
+func (d *Accessor) certificateID(serial, aki string) string {
+	return fmt.Sprintf(certificateIDTemplate, d.prefix, serial, aki)
+}
+
+func (d *Accessor) ocspID(serial, aki string) string {
+	return fmt.Sprintf(ocspIDTemplate, d.prefix, serial, aki)
+}
+
+// crlID escapes the distribution point, which is usually a URL, so that it
+// forms a single key segment.
+func (d *Accessor) crlID(aki, distributionPoint string) string {
+	return fmt.Sprintf(crlIDTemplate, d.prefix, aki, url.QueryEscape(distributionPoint))
+}
+
+// revocationID names an event by its creation time, so that the events of
+// a certificate sort oldest first.
+func (d *Accessor) revocationID(serial, aki string, createdAt time.Time) string {
+	return fmt.Sprintf(revocationIDTemplate, d.prefix, serial, aki, createdAt.UnixNano())
+}
+
+// NewAccessor returns a new Accessor configured from the JSON db config
+// file at path. The file must provide the Consul agent address as "uri" and
+// the key prefix under which records are stored as "prefix".
+func NewAccessor(path string) (*Accessor, error) {
+	accessor := new(Accessor)
+	if err := accessor.LoadConfiguration(path); err != nil {
+		return nil, err
+	}
+
+	settings := api.DefaultConfig()
+	settings.Address = accessor.config["uri"]
+
+	client, err := api.NewClient(settings)
+	if err != nil {
+		return nil, wrapConsulError(err)
+	}
+	log.Debugf("using consul at %s with prefix %s", settings.Address, accessor.prefix)
+
+	accessor.kv = client.KV()
+	return accessor, nil
+}
+
+// LoadConfiguration reads the Consul settings from the db config file at path.
+func (d *Accessor) LoadConfiguration(path string) error {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, errors.New("could not read configuration file"))
+	}
+
+	if err = json.Unmarshal(body, &d.config); err != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("failed to unmarshal configuration: "+err.Error()))
+	}
+
+	for _, option := range []string{"uri", "prefix"} {
+		if d.config[option] == "" {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				fmt.Errorf("could not find configuration option '%s' in %s", option, path))
+		}
+	}
+
+	d.prefix = strings.TrimSuffix(d.config["prefix"], "/")
+	return nil
+}
+
+// get fetches the key and decodes it into v. It returns a nil pair if the
+// key does not exist.
+func (d *Accessor) get(key string, v interface{}) (*api.KVPair, error) {
+	pair, _, err := d.kv.Get(key, nil)
+	if err != nil {
+		return nil, wrapConsulError(err)
+	}
+	if pair == nil {
+		return nil, nil
+	}
+
+	if err = json.Unmarshal(pair.Value, v); err != nil {
+		return nil, wrapConsulError(err)
+	}
+	return pair, nil
+}
+
+// cas stores v at key if the key has not been modified since modifyIndex.
+// A modifyIndex of zero only succeeds when the key does not exist yet.
+func (d *Accessor) cas(key string, modifyIndex uint64, v interface{}) (bool, error) {
+	value, err := json.Marshal(v)
+	if err != nil {
+		return false, wrapConsulError(err)
+	}
+
+	ok, _, err := d.kv.CAS(&api.KVPair{Key: key, ModifyIndex: modifyIndex, Value: value}, nil)
+	if err != nil {
+		return false, wrapConsulError(err)
+	}
+	return ok, nil
+}
+
+// listCertificates returns every certificate record for which keep returns true.
+func (d *Accessor) listCertificates(keep func(certdb.CertificateRecord) bool) (crs []certdb.CertificateRecord, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, _, err := d.kv.List(d.prefix+"/"+certificatePrefix+"/", nil)
+	if err != nil {
+		return nil, wrapConsulError(err)
+	}
+
+	for _, pair := range pairs {
+		var cr certdb.CertificateRecord
+		if err = json.Unmarshal(pair.Value, &cr); err != nil {
+			return nil, wrapConsulError(fmt.Errorf("malformed record at %s: %v", pair.Key, err))
+		}
+		if keep(cr) {
+			crs = append(crs, cr)
+		}
+	}
+
+	return crs, nil
+}
+
+// InsertCertificate puts a certdb.CertificateRecord into Consul. It fails if
+// a record with the same serial and AKI already exists.
+func (d *Accessor) InsertCertificate(cr certdb.CertificateRecord) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	cr.Expiry = cr.Expiry.UTC()
+	cr.RevokedAt = cr.RevokedAt.UTC()
+	cr.IssuedAt = cr.IssuedAt.UTC()
+	cr.NotBefore = cr.NotBefore.UTC()
+
+	ok, err := d.cas(d.certificateID(cr.Serial, cr.AKI), 0, &cr)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed,
+			fmt.Errorf("failed to insert the certificate record: certificate already exists"))
+	}
+
+	return nil
+}
+
+// GetCertificate gets a certdb.CertificateRecord indexed by serial and AKI.
+func (d *Accessor) GetCertificate(serial, aki string) (crs []certdb.CertificateRecord, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
+
+	var cr certdb.CertificateRecord
+	pair, err := d.get(d.certificateID(serial, aki), &cr)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair != nil {
+		crs = append(crs, cr)
+	}
+	return crs, nil
+}
+
+// GetUnexpiredCertificates gets all unexpired certificates from Consul.
+func (d *Accessor) GetUnexpiredCertificates() ([]certdb.CertificateRecord, error) {
+	now := time.Now()
+	return d.listCertificates(func(cr certdb.CertificateRecord) bool {
+		return now.Before(cr.Expiry)
+	})
+}
+
+// GetRevokedAndUnexpiredCertificates gets all revoked and unexpired
+// certificates from Consul (for CRLs).
+func (d *Accessor) GetRevokedAndUnexpiredCertificates() ([]certdb.CertificateRecord, error) {
+	now := time.Now()
+	return d.listCertificates(func(cr certdb.CertificateRecord) bool {
+		return cr.Status == "revoked" && now.Before(cr.Expiry)
+	})
 }
 
-func NewAccessor(config string) *ConsulAccessor {
-    // Load the database configuration
-    accessor := new(ConsulAccessor)
-    err := accessor.LoadConfiguration(config)
-    if err != nil {
-        return nil
-    }
+// ListCertificates gets one page of the certificates matching q. Consul
+// cannot filter on record contents, so the certificate prefix is scanned
+// and filtered in memory.
+func (d *Accessor) ListCertificates(q certdb.CertificateQuery) (page certdb.CertificatePage, err error) {
+	var afterSerial, afterAKI string
+	if q.Cursor != "" {
+		afterSerial, afterAKI, err = certdb.DecodeCursor(q.Cursor)
+		if err != nil {
+			return page, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+	}
+
+	crs, err := d.listCertificates(func(cr certdb.CertificateRecord) bool {
+		if q.Cursor != "" && !after(cr, afterSerial, afterAKI) {
+			return false
+		}
+		return q.Matches(cr)
+	})
+	if err != nil {
+		return page, err
+	}
 
+	sort.Slice(crs, func(i, j int) bool {
+		return after(crs[j], crs[i].Serial, crs[i].AKI)
+	})
 
-    settings := api.DefaultConfig()
-    settings.Address = accessor.config["uri"]
+	limit := q.PageSize()
+	if len(crs) > limit {
+		crs = crs[:limit]
+		last := crs[limit-1]
+		page.NextCursor = certdb.EncodeCursor(last.Serial, last.AKI)
+	}
+	page.Certificates = crs
+	return page, nil
+}
+
+// after reports whether cr sorts after the given serial and AKI.
+func after(cr certdb.CertificateRecord, serial, aki string) bool {
+	return cr.Serial > serial || (cr.Serial == serial && cr.AKI > aki)
+}
 
-    accessor.consul, err = api.NewClient(settings)
-    fmt.Println("Connecting to consul at " + settings.Address)
-    if err != nil {
-        return nil
-    }
+// RevokeCertificate updates a certificate with a given serial number and
+// marks it revoked.
+func (d *Accessor) RevokeCertificate(serial, aki string, reasonCode int) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	key := d.certificateID(serial, aki)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		var cr certdb.CertificateRecord
+		pair, err := d.get(key, &cr)
+		if err != nil {
+			return err
+		}
+
+		if pair == nil {
+			return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+				fmt.Errorf("failed to revoke the certificate: certificate not found"))
+		}
+
+		cr.Status = "revoked"
+		cr.Reason = reasonCode
+		cr.RevokedAt = time.Now().UTC()
+
+		ok, err := d.cas(key, pair.ModifyIndex, &cr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debugf("concurrent update of %s, retrying revocation", key)
+	}
+
+	return wrapConsulError(fmt.Errorf("failed to revoke the certificate: too many concurrent updates of %s", key))
+}
 
-    return accessor
+// RevokeCertificates revokes the unrevoked certificates matching q one at
+// a time, with certdb.RevokeAll, as Consul offers no transaction across
+// them.
+func (d *Accessor) RevokeCertificates(q certdb.CertificateQuery, reasonCode int) ([]certdb.CertificateRecord, error) {
+	return certdb.RevokeAll(d, q, reasonCode)
 }
 
-func (d *ConsulAccessor) LoadConfiguration(config string) error {
-    body, err := ioutil.ReadFile(config)
-    json.Unmarshal(body, &d.config)
+// UnholdCertificate marks a certificate revoked with reason
+// certificateHold as good again.
+func (d *Accessor) UnholdCertificate(serial, aki string) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
 
-    if _, ok := d.config["uri"]; !ok {
-        fmt.Println("Could not find configuration option 'uri' in " + config)
-        return nil
-    }
+	key := d.certificateID(serial, aki)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		var cr certdb.CertificateRecord
+		pair, err := d.get(key, &cr)
+		if err != nil {
+			return err
+		}
+
+		if pair == nil {
+			return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+				fmt.Errorf("failed to unhold the certificate: certificate not found"))
+		}
+
+		if cr.Status != "revoked" || cr.Reason != certdb.ReasonCertificateHold {
+			return cferr.Wrap(cferr.CertStoreError, cferr.InvalidRecordStatus,
+				fmt.Errorf("failed to unhold the certificate: certificate is not on hold"))
+		}
+
+		cr.Status = "good"
+		cr.Reason = 0
+		cr.RevokedAt = time.Time{}
+
+		ok, err := d.cas(key, pair.ModifyIndex, &cr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debugf("concurrent update of %s, retrying unhold", key)
+	}
+
+	return wrapConsulError(fmt.Errorf("failed to unhold the certificate: too many concurrent updates of %s", key))
+}
+
+// InsertRevocationEvent appends a certdb.RevocationEvent to Consul. Events
+// of a certificate created in the same nanosecond are kept apart by
+// bumping the later one's creation time.
+func (d *Accessor) InsertRevocationEvent(ev certdb.RevocationEvent) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	ev.CreatedAt = ev.CreatedAt.UTC()
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		ok, err := d.cas(d.revocationID(ev.Serial, ev.AKI, ev.CreatedAt), 0, &ev)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		ev.CreatedAt = ev.CreatedAt.Add(time.Nanosecond)
+	}
+
+	return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed,
+		fmt.Errorf("failed to insert the revocation event: too many events at %s", ev.CreatedAt))
+}
+
+// GetRevocationEvents gets the revocation events of a certificate from
+// Consul, oldest first.
+func (d *Accessor) GetRevocationEvents(serial, aki string) ([]certdb.RevocationEvent, error) {
+	prefix := fmt.Sprintf("%s/%s/%s/%s/", d.prefix, revocationPrefix, serial, aki)
+	return d.listRevocationEvents(prefix, func(certdb.RevocationEvent) bool { return true })
+}
 
-    if _, ok := d.config["prefix"]; !ok {
-        fmt.Println("Could not find configuration option 'prefix' in " + config)
-        return nil
-    }
+// ListRevocationEvents gets the revocation events created at or after
+// since from Consul, oldest first.
+func (d *Accessor) ListRevocationEvents(since time.Time) ([]certdb.RevocationEvent, error) {
+	evs, err := d.listRevocationEvents(d.prefix+"/"+revocationPrefix+"/", func(ev certdb.RevocationEvent) bool {
+		return !ev.CreatedAt.Before(since)
+	})
+	if err != nil {
+		return nil, err
+	}
 
-    return err
+	sort.SliceStable(evs, func(i, j int) bool {
+		return evs[i].CreatedAt.Before(evs[j].CreatedAt)
+	})
+	return evs, nil
 }
 
-func (d *ConsulAccessor) InsertCertificate(cr certdb.CertificateRecord) error {
-    log.Printf("Inserting certificate into " + d.certificateId(cr.Serial, cr.AKI))
-    certificate, err := json.Marshal(cr)
-    if err != nil {
-        return err
-    }
+// listRevocationEvents returns the events under prefix, in key order, for
+// which keep returns true.
+func (d *Accessor) listRevocationEvents(prefix string, keep func(certdb.RevocationEvent) bool) (evs []certdb.RevocationEvent, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
 
-    d.consul.KV().Put(&api.KVPair{Key: d.certificateId(cr.Serial, cr.AKI), Value: []byte(certificate)}, nil)
-    d.GetCertificate(cr.Serial, cr.AKI)
-    return err
+	pairs, _, err := d.kv.List(prefix, nil)
+	if err != nil {
+		return nil, wrapConsulError(err)
+	}
+
+	for _, pair := range pairs {
+		var ev certdb.RevocationEvent
+		if err = json.Unmarshal(pair.Value, &ev); err != nil {
+			return nil, wrapConsulError(fmt.Errorf("malformed record at %s: %v", pair.Key, err))
+		}
+		if keep(ev) {
+			evs = append(evs, ev)
+		}
+	}
+
+	return evs, nil
 }
 
-func (d *ConsulAccessor) GetCertificate(serial, aki string) (crs []certdb.CertificateRecord, err error) {
-    kv, _, err := d.consul.KV().Get(d.certificateId(serial, aki), nil)
-    if err != nil {
-        return nil, nil
-    }
-    certificate := new(certdb.CertificateRecord)
-    err = json.Unmarshal(kv.Value, &certificate)
-    if err != nil {
-        return nil, nil
-    }
+// InsertOCSP puts a new certdb.OCSPRecord into Consul.
+func (d *Accessor) InsertOCSP(rr certdb.OCSPRecord) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	rr.Expiry = rr.Expiry.UTC()
+
+	ok, err := d.cas(d.ocspID(rr.Serial, rr.AKI), 0, &rr)
+	if err != nil {
+		return err
+	}
 
-    crs = append(crs, *certificate)
-    return crs, err
+	if !ok {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed,
+			fmt.Errorf("failed to insert the OCSP record: record already exists"))
+	}
+
+	return nil
 }
 
-func (d *ConsulAccessor) GetUnexpiredCertificates() ([]certdb.CertificateRecord, error) {
-    return nil, nil
+// GetOCSP retrieves a certdb.OCSPRecord from Consul by serial and AKI.
+func (d *Accessor) GetOCSP(serial, aki string) (rrs []certdb.OCSPRecord, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
+
+	var rr certdb.OCSPRecord
+	pair, err := d.get(d.ocspID(serial, aki), &rr)
+	if err != nil {
+		return nil, err
+	}
+
+	if pair != nil {
+		rrs = append(rrs, rr)
+	}
+	return rrs, nil
 }
 
-func (d *ConsulAccessor) RevokeCertificate(serial, aki string, reasonCode int) error {
-    return nil
+// GetUnexpiredOCSPs retrieves all unexpired certdb.OCSPRecord from Consul.
+func (d *Accessor) GetUnexpiredOCSPs() (rrs []certdb.OCSPRecord, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
+
+	pairs, _, err := d.kv.List(d.prefix+"/"+ocspPrefix+"/", nil)
+	if err != nil {
+		return nil, wrapConsulError(err)
+	}
+
+	now := time.Now()
+	for _, pair := range pairs {
+		var rr certdb.OCSPRecord
+		if err = json.Unmarshal(pair.Value, &rr); err != nil {
+			return nil, wrapConsulError(fmt.Errorf("malformed record at %s: %v", pair.Key, err))
+		}
+		if now.Before(rr.Expiry) {
+			rrs = append(rrs, rr)
+		}
+	}
+
+	return rrs, nil
 }
 
-func (d *ConsulAccessor) InsertOCSP(rr certdb.OCSPRecord) error {
-    return nil
+// UpdateOCSP updates a ocsp response record with a given serial number.
+func (d *Accessor) UpdateOCSP(serial, aki, body string, expiry time.Time) error {
+	return d.storeOCSP(serial, aki, body, expiry, false)
 }
 
-func (d *ConsulAccessor) GetOCSP(serial, aki string) ([]certdb.OCSPRecord, error) {
-    return nil, nil
+// UpsertOCSP update a ocsp response record with a given serial number,
+// or insert the record if it doesn't yet exist in Consul.
+func (d *Accessor) UpsertOCSP(serial, aki, body string, expiry time.Time) error {
+	return d.storeOCSP(serial, aki, body, expiry, true)
 }
 
-func (d *ConsulAccessor) GetUnexpiredOCSPs() ([]certdb.OCSPRecord, error) {
-    return nil, nil
+// storeOCSP writes an OCSP response using check-and-set so that concurrent
+// writers never silently overwrite each other. Missing records are created
+// only when insert is set.
+func (d *Accessor) storeOCSP(serial, aki, body string, expiry time.Time, insert bool) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	key := d.ocspID(serial, aki)
+	rr := certdb.OCSPRecord{Serial: serial, AKI: aki, Body: body, Expiry: expiry.UTC()}
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		var existing certdb.OCSPRecord
+		pair, err := d.get(key, &existing)
+		if err != nil {
+			return err
+		}
+
+		var index uint64
+		if pair != nil {
+			index = pair.ModifyIndex
+		} else if !insert {
+			return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+				fmt.Errorf("failed to update the OCSP record"))
+		}
+
+		ok, err := d.cas(key, index, &rr)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debugf("concurrent update of %s, retrying", key)
+	}
+
+	return wrapConsulError(fmt.Errorf("failed to store the OCSP record: too many concurrent updates of %s", key))
 }
 
-func (d *ConsulAccessor) UpdateOCSP(serial, aki, body string, expiry time.Time) error {
-    return nil
+// NextCRLNumber increments and returns the CRL number of the issuer and
+// distribution point, starting at 1.
+func (d *Accessor) NextCRLNumber(aki, distributionPoint string) (int64, error) {
+	err := d.checkKV()
+	if err != nil {
+		return 0, err
+	}
+
+	key := d.crlID(aki, distributionPoint)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		rec := certdb.CRLRecord{AKI: aki, DistributionPoint: distributionPoint}
+		pair, err := d.get(key, &rec)
+		if err != nil {
+			return 0, err
+		}
+
+		var index uint64
+		if pair != nil {
+			index = pair.ModifyIndex
+		}
+		rec.Number++
+
+		ok, err := d.cas(key, index, &rec)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			return rec.Number, nil
+		}
+		log.Debugf("concurrent update of %s, retrying", key)
+	}
+
+	return 0, wrapConsulError(fmt.Errorf("failed to increment the CRL number: too many concurrent updates of %s", key))
 }
 
-func (d *ConsulAccessor) UpsertOCSP(serial, aki, body string, expiry time.Time) error {
-    return nil
+// GetCRLRecord gets the certdb.CRLRecord of the issuer and distribution
+// point from Consul.
+func (d *Accessor) GetCRLRecord(aki, distributionPoint string) (recs []certdb.CRLRecord, err error) {
+	err = d.checkKV()
+	if err != nil {
+		return nil, err
+	}
+
+	var rec certdb.CRLRecord
+	pair, err := d.get(d.crlID(aki, distributionPoint), &rec)
+	if err != nil || pair == nil {
+		return nil, err
+	}
+	return []certdb.CRLRecord{rec}, nil
 }
 
+// UpdateBaseCRL records the number and thisUpdate of the last full CRL of
+// the issuer and distribution point. The CRL number must have been taken
+// with NextCRLNumber.
+func (d *Accessor) UpdateBaseCRL(aki, distributionPoint string, number int64, thisUpdate time.Time) error {
+	err := d.checkKV()
+	if err != nil {
+		return err
+	}
+
+	key := d.crlID(aki, distributionPoint)
+	for attempt := 0; attempt < maxCASAttempts; attempt++ {
+		var rec certdb.CRLRecord
+		pair, err := d.get(key, &rec)
+		if err != nil {
+			return err
+		}
+
+		if pair == nil {
+			return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+				fmt.Errorf("failed to update the base CRL: no CRL number issued"))
+		}
+
+		rec.BaseNumber = number
+		rec.BaseThisUpdate = thisUpdate.UTC()
+
+		ok, err := d.cas(key, pair.ModifyIndex, &rec)
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+		log.Debugf("concurrent update of %s, retrying", key)
+	}
+
+	return wrapConsulError(fmt.Errorf("failed to update the base CRL: too many concurrent updates of %s", key))
+}
@@ -1,12 +1,16 @@
 package couchbase
 
 import (
+	"crypto/x509"
+	"io/ioutil"
 	"math"
 	"testing"
 	"time"
 
 	"github.com/couchbase/gocb"
 	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/crl"
+	"github.com/ucosty/cfssl/helpers"
 )
 
 const (
@@ -22,7 +26,10 @@ func TestNoDB(t *testing.T) {
 }
 
 func TestCouchbase(t *testing.T) {
-	dba := NewAccessor("testdata/db-config.json")
+	dba, err := NewAccessor("testdata/db-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
 
 	cleanup(dba.bucket)
 
@@ -36,6 +43,7 @@ func cleanup(bucket *gocb.Bucket) {
 	bucket.Remove("ocsp:fake serial:"+fakeAKI, 0)
 	bucket.Remove("ocsp:fake serial 2:"+fakeAKI, 0)
 	bucket.Remove("ocsp:fake serial 3:"+fakeAKI, 0)
+	bucket.Remove("certificate:1234:"+fakeAKI, 0)
 }
 
 // roughlySameTime decides if t1 and t2 are close enough.
@@ -52,6 +60,7 @@ func testEverything(dba certdb.Accessor, t *testing.T) {
 	testInsertOCSPAndGetUnexpiredOCSP(dba, t)
 	testUpdateOCSPAndGetOCSP(dba, t)
 	testUpsertOCSPAndGetOCSP(dba, t)
+	testRevokedCertificateInCRL(dba, t)
 }
 
 func testInsertCertificateAndGetCertificate(dba certdb.Accessor, t *testing.T) {
@@ -341,3 +350,51 @@ func testUpsertOCSPAndGetOCSP(dba certdb.Accessor, t *testing.T) {
 		t.Errorf("want OCSP %+v, got %+v", want, got)
 	}
 }
+
+func testRevokedCertificateInCRL(dba certdb.Accessor, t *testing.T) {
+	err := dba.InsertCertificate(certdb.CertificateRecord{
+		PEM:    "fake cert data",
+		Serial: "1234",
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().Add(time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = dba.RevokeCertificate("1234", fakeAKI, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := ioutil.ReadFile("../../signer/local/testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile("../../signer/local/testdata/ca_key.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crlBytes, err := crl.NewCRLFromAccessor(dba, issuer, key, crl.Options{Expiry: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	list, err := x509.ParseDERCRL(crlBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range list.TBSCertList.RevokedCertificates {
+		if entry.SerialNumber.Int64() == 1234 {
+			return
+		}
+	}
+	t.Fatal("the revoked certificate is not in the CRL")
+}
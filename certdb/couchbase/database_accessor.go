@@ -7,7 +7,9 @@ import (
     "github.com/couchbase/gocb"
     "github.com/ucosty/cfssl/certdb"
     cferr "github.com/ucosty/cfssl/errors"
+    "github.com/ucosty/cfssl/log"
     "io/ioutil"
+    "strings"
     "time"
 )
 
@@ -28,12 +30,30 @@ type OCSPWrapper struct {
     Record certdb.OCSPRecord `json:"record,omitempty"`
 }
 
+type RevocationEventWrapper struct {
+	Type   string                 `json:"type,omitempty"`
+	Record certdb.RevocationEvent `json:"record,omitempty"`
+}
+
+type CRLWrapper struct {
+	Type   string           `json:"type,omitempty"`
+	Record certdb.CRLRecord `json:"record,omitempty"`
+}
+
 const (
     ocspType = `ocsp`
     certificateType = `certificate`
     ocspIdTemplate = ocspType + `:%s:%s`
     certificateIdTemplate = certificateType + `:%s:%s`
+	crlType               = `crl`
+	crlIdTemplate         = crlType + `:%s:%s`
+	crlNumberIdTemplate   = `crl_number:%s:%s`
     getUnexpiredN1QL = `SELECT * FROM %s WHERE type='%s' AND STR_TO_MILLIS(record.expiry) > NOW_MILLIS();`
+	listCertificatesN1QL  = `SELECT * FROM %s WHERE %s ORDER BY record.serial, record.authority_key_identifier LIMIT %d;`
+	revocationType        = `revocation`
+	revocationIdTemplate  = revocationType + `:%s:%s:%d`
+	listRevocationsN1QL   = `SELECT * FROM %s WHERE %s ORDER BY STR_TO_MILLIS(record.created_at);`
+	getRevokedN1QL        = `SELECT * FROM %s WHERE type='%s' AND record.status='revoked' AND STR_TO_MILLIS(record.expiry) > NOW_MILLIS();`
 )
 
 func ocspId(serial, aki string) string {
@@ -44,6 +64,18 @@ func certificateId(serial, aki string) string {
     return fmt.Sprintf(certificateIdTemplate, serial, aki)
 }
 
+func crlId(aki, distributionPoint string) string {
+	return fmt.Sprintf(crlIdTemplate, aki, distributionPoint)
+}
+
+func crlNumberId(aki, distributionPoint string) string {
+	return fmt.Sprintf(crlNumberIdTemplate, aki, distributionPoint)
+}
+
+func revocationId(serial, aki string, createdAt time.Time) string {
+	return fmt.Sprintf(revocationIdTemplate, serial, aki, createdAt.UnixNano())
+}
+
 func (d *CouchbaseAccessor) closeBucket() {
     if d.bucket != nil {
         d.bucket.Close()
@@ -59,41 +91,44 @@ func (d *CouchbaseAccessor) checkBucket() error {
     return nil
 }
 
-func NewAccessor(config string) *CouchbaseAccessor {
+// NewAccessor returns a new CouchbaseAccessor configured from the JSON db
+// config file at path.
+func NewAccessor(config string) (*CouchbaseAccessor, error) {
     // Load the database configuration
     accessor := new(CouchbaseAccessor)
     err := accessor.LoadConfiguration(config)
     if err != nil {
-        return nil
+		return nil, err
     }
 
     cb, err := gocb.Connect(accessor.config["uri"])
     if err != nil {
-        return nil
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
     }
     accessor.SetCouchbase(cb)
-    return accessor
+	return accessor, nil
 }
 
+// LoadConfiguration reads the couchbase settings from the db config file.
 func (d *CouchbaseAccessor) LoadConfiguration(config string) error {
     body, err := ioutil.ReadFile(config)
-    json.Unmarshal(body, &d.config)
-
-    if _, ok := d.config["uri"]; !ok {
-        fmt.Println("Could not find configuration option 'uri' in " + config)
-        return nil
+	if err != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, errors.New("could not read configuration file"))
     }
 
-    if _, ok := d.config["bucket"]; !ok {
-        fmt.Println("Could not find configuration option 'bucket' in " + config)
-        return nil
+	if err = json.Unmarshal(body, &d.config); err != nil {
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			errors.New("failed to unmarshal configuration: "+err.Error()))
     }
 
-    if _, ok := d.config["password"]; !ok {
-        d.config["password"] = ""
+	for _, option := range []string{"uri", "bucket"} {
+		if d.config[option] == "" {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				fmt.Errorf("could not find configuration option '%s' in %s", option, config))
+		}
     }
 
-    return err
+	return nil
 }
 
 func (d *CouchbaseAccessor) SetCouchbase(cb *gocb.Cluster) {
@@ -113,10 +148,10 @@ func (d *CouchbaseAccessor) InsertCertificate(cr certdb.CertificateRecord) error
     if err != nil {
         return err
     }
-    fmt.Printf("Inserting certificate into bucket %s\n", d.bucketName)
+	log.Debugf("inserting certificate into bucket %s", d.bucketName)
     _, err = d.bucket.Insert(certificateId(cr.Serial, cr.AKI), &CertificateWrapper{Type: certificateType, Record: cr}, 0)
     if err != nil {
-        fmt.Printf("Failed to insert document into couchbase: %s\n", err.Error())
+		log.Errorf("failed to insert document into couchbase: %v", err)
     }
     return err
 }
@@ -162,6 +197,93 @@ func (d *CouchbaseAccessor) GetUnexpiredCertificates() (crs []certdb.Certificate
     return crs, nil
 }
 
+// ListCertificates gets one page of the certificates matching q.
+func (d *CouchbaseAccessor) ListCertificates(q certdb.CertificateQuery) (page certdb.CertificatePage, err error) {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err = d.checkBucket()
+	if err != nil {
+		return page, err
+	}
+
+	conds := []string{"type = $1"}
+	params := []interface{}{certificateType}
+	addCond := func(cond string, param interface{}) {
+		params = append(params, param)
+		conds = append(conds, fmt.Sprintf(cond, len(params)))
+	}
+
+	if q.CALabel != "" {
+		addCond("record.ca_label = $%d", q.CALabel)
+	}
+	if q.Status != "" {
+		addCond("record.status = $%d", q.Status)
+	}
+	if q.AKI != "" {
+		addCond("record.authority_key_identifier = $%d", q.AKI)
+	}
+	if !q.ExpiresAfter.IsZero() {
+		addCond("STR_TO_MILLIS(record.expiry) > $%d", millis(q.ExpiresAfter))
+	}
+	if !q.ExpiresBefore.IsZero() {
+		addCond("STR_TO_MILLIS(record.expiry) < $%d", millis(q.ExpiresBefore))
+	}
+	if !q.IssuedAfter.IsZero() {
+		addCond("STR_TO_MILLIS(record.issued_at) > $%d", millis(q.IssuedAfter))
+	}
+	if !q.IssuedBefore.IsZero() {
+		addCond("STR_TO_MILLIS(record.issued_at) < $%d", millis(q.IssuedBefore))
+	}
+	if q.Hostname != "" {
+		addCond("(record.common_name = $%[1]d OR ARRAY_CONTAINS(record.sans, $%[1]d))", q.Hostname)
+	}
+	if q.Cursor != "" {
+		serial, aki, err := certdb.DecodeCursor(q.Cursor)
+		if err != nil {
+			return page, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		params = append(params, serial, aki)
+		conds = append(conds, fmt.Sprintf("(record.serial > $%d OR (record.serial = $%d AND record.authority_key_identifier > $%d))",
+			len(params)-1, len(params)-1, len(params)))
+	}
+
+	// Fetch one record more than requested to learn whether another page follows.
+	limit := q.PageSize()
+	statement := fmt.Sprintf(listCertificatesN1QL, d.bucketName, strings.Join(conds, " AND "), limit+1)
+	query := gocb.NewN1qlQuery(statement).Consistency(gocb.RequestPlus)
+	records, err := d.bucket.ExecuteN1qlQuery(query, params)
+	if err != nil {
+		return page, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+
+	var row interface{}
+	for records.Next(&row) {
+		certificateData, _ := row.(map[string]interface{})
+		var certificate CertificateWrapper
+		certificateJSON, _ := json.Marshal(certificateData[d.bucketName])
+		if err = json.Unmarshal(certificateJSON, &certificate); err != nil {
+			records.Close()
+			return page, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		page.Certificates = append(page.Certificates, certificate.Record)
+	}
+	if err = records.Close(); err != nil {
+		return page, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+
+	if len(page.Certificates) > limit {
+		page.Certificates = page.Certificates[:limit]
+		last := page.Certificates[limit-1]
+		page.NextCursor = certdb.EncodeCursor(last.Serial, last.AKI)
+	}
+	return page, nil
+}
+
+// millis converts t to the epoch milliseconds used by N1QL date functions.
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
 func (d *CouchbaseAccessor) RevokeCertificate(serial, aki string, reasonCode int) error {
     d.SetBucket(d.config["bucket"], d.config["password"])
     defer d.closeBucket()
@@ -181,6 +303,104 @@ func (d *CouchbaseAccessor) RevokeCertificate(serial, aki string, reasonCode int
     return err
 }
 
+// RevokeCertificates revokes the unrevoked certificates matching q one at
+// a time, with certdb.RevokeAll.
+func (d *CouchbaseAccessor) RevokeCertificates(q certdb.CertificateQuery, reasonCode int) ([]certdb.CertificateRecord, error) {
+	return certdb.RevokeAll(d, q, reasonCode)
+}
+
+// UnholdCertificate marks a certificate revoked with reason
+// certificateHold as good again.
+func (d *CouchbaseAccessor) UnholdCertificate(serial, aki string) error {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err := d.checkBucket()
+	if err != nil {
+		return err
+	}
+
+	certificateId := certificateId(serial, aki)
+	certificate := new(CertificateWrapper)
+	cas, err := d.bucket.Get(certificateId, &certificate)
+	if err == gocb.ErrKeyNotFound {
+		return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+			fmt.Errorf("failed to unhold the certificate: certificate not found"))
+	}
+	if err != nil {
+		return err
+	}
+
+	if certificate.Record.Status != "revoked" || certificate.Record.Reason != certdb.ReasonCertificateHold {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InvalidRecordStatus,
+			fmt.Errorf("failed to unhold the certificate: certificate is not on hold"))
+	}
+	certificate.Record.Status = "good"
+	certificate.Record.RevokedAt = time.Time{}
+	certificate.Record.Reason = 0
+
+	_, err = d.bucket.Replace(certificateId, certificate, cas, 0)
+	return err
+}
+
+// InsertRevocationEvent appends a certdb.RevocationEvent to the bucket.
+func (d *CouchbaseAccessor) InsertRevocationEvent(ev certdb.RevocationEvent) error {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err := d.checkBucket()
+	if err != nil {
+		return err
+	}
+
+	ev.CreatedAt = ev.CreatedAt.UTC()
+	_, err = d.bucket.Insert(revocationId(ev.Serial, ev.AKI, ev.CreatedAt), &RevocationEventWrapper{Type: revocationType, Record: ev}, 0)
+	return err
+}
+
+// GetRevocationEvents gets the revocation events of a certificate, oldest
+// first.
+func (d *CouchbaseAccessor) GetRevocationEvents(serial, aki string) ([]certdb.RevocationEvent, error) {
+	return d.listRevocationEvents("type = $1 AND record.serial = $2 AND record.authority_key_identifier = $3",
+		[]interface{}{revocationType, serial, aki})
+}
+
+// ListRevocationEvents gets the revocation events created at or after
+// since, oldest first.
+func (d *CouchbaseAccessor) ListRevocationEvents(since time.Time) ([]certdb.RevocationEvent, error) {
+	return d.listRevocationEvents("type = $1 AND STR_TO_MILLIS(record.created_at) >= $2",
+		[]interface{}{revocationType, millis(since)})
+}
+
+func (d *CouchbaseAccessor) listRevocationEvents(where string, params []interface{}) (evs []certdb.RevocationEvent, err error) {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err = d.checkBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	query := gocb.NewN1qlQuery(fmt.Sprintf(listRevocationsN1QL, d.bucketName, where)).Consistency(gocb.RequestPlus)
+	records, err := d.bucket.ExecuteN1qlQuery(query, params)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+
+	var row interface{}
+	for records.Next(&row) {
+		eventData, _ := row.(map[string]interface{})
+		var event RevocationEventWrapper
+		eventJSON, _ := json.Marshal(eventData[d.bucketName])
+		if err = json.Unmarshal(eventJSON, &event); err != nil {
+			records.Close()
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		evs = append(evs, event.Record)
+	}
+	if err = records.Close(); err != nil {
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+	return evs, nil
+}
+
 func (d *CouchbaseAccessor) InsertOCSP(rr certdb.OCSPRecord) error {
     d.SetBucket(d.config["bucket"], d.config["password"])
     defer d.closeBucket()
@@ -275,6 +495,118 @@ func (d *CouchbaseAccessor) UpsertOCSP(serial, aki, body string, expiry time.Tim
     return err
 }
 
-func (d *CouchbaseAccessor) GetRevokedAndUnexpiredCertificates() ([]certdb.CertificateRecord, error) {
-    return nil, nil
-}
\ No newline at end of file
+// GetRevokedAndUnexpiredCertificates gets the revoked certificates that
+// have not expired, which a CRL lists.
+func (d *CouchbaseAccessor) GetRevokedAndUnexpiredCertificates() (crs []certdb.CertificateRecord, err error) {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err = d.checkBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	query := gocb.NewN1qlQuery(fmt.Sprintf(getRevokedN1QL, d.bucketName, certificateType)).Consistency(gocb.RequestPlus).AdHoc(false)
+	records, err := d.bucket.ExecuteN1qlQuery(query, nil)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+
+	var row interface{}
+	for records.Next(&row) {
+		certificateData, _ := row.(map[string]interface{})
+		var certificate CertificateWrapper
+		certificateJSON, _ := json.Marshal(certificateData[d.bucketName])
+		if err = json.Unmarshal(certificateJSON, &certificate); err != nil {
+			records.Close()
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		crs = append(crs, certificate.Record)
+	}
+	if err = records.Close(); err != nil {
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+	return crs, nil
+}
+
+// NextCRLNumber increments and returns the CRL number of the issuer and
+// distribution point, starting at 1. It uses a Couchbase counter document,
+// which is incremented atomically by the server.
+func (d *CouchbaseAccessor) NextCRLNumber(aki, distributionPoint string) (int64, error) {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err := d.checkBucket()
+	if err != nil {
+		return 0, err
+	}
+
+	number, _, err := d.bucket.Counter(crlNumberId(aki, distributionPoint), 1, 1, 0)
+	if err != nil {
+		return 0, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+	return int64(number), nil
+}
+
+// GetCRLRecord combines the CRL number counter of the issuer and
+// distribution point with the document recording its base CRL.
+func (d *CouchbaseAccessor) GetCRLRecord(aki, distributionPoint string) ([]certdb.CRLRecord, error) {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err := d.checkBucket()
+	if err != nil {
+		return nil, err
+	}
+
+	var number uint64
+	_, err = d.bucket.Get(crlNumberId(aki, distributionPoint), &number)
+	if err == gocb.ErrKeyNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var crl CRLWrapper
+	_, err = d.bucket.Get(crlId(aki, distributionPoint), &crl)
+	if err != nil && err != gocb.ErrKeyNotFound {
+		return nil, err
+	}
+
+	rec := crl.Record
+	rec.AKI = aki
+	rec.DistributionPoint = distributionPoint
+	rec.Number = int64(number)
+	return []certdb.CRLRecord{rec}, nil
+}
+
+// UpdateBaseCRL records the number and thisUpdate of the last full CRL
+// of the issuer and distribution point, whose CRL number counter must
+// exist.
+func (d *CouchbaseAccessor) UpdateBaseCRL(aki, distributionPoint string, number int64, thisUpdate time.Time) error {
+	d.SetBucket(d.config["bucket"], d.config["password"])
+	defer d.closeBucket()
+	err := d.checkBucket()
+	if err != nil {
+		return err
+	}
+
+	var counter uint64
+	_, err = d.bucket.Get(crlNumberId(aki, distributionPoint), &counter)
+	if err == gocb.ErrKeyNotFound {
+		return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+			fmt.Errorf("failed to update the base CRL: no CRL number issued"))
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = d.bucket.Upsert(crlId(aki, distributionPoint), &CRLWrapper{
+		Type: crlType,
+		Record: certdb.CRLRecord{
+			AKI:               aki,
+			DistributionPoint: distributionPoint,
+			BaseNumber:        number,
+			BaseThisUpdate:    thisUpdate.UTC(),
+		},
+	}, 0)
+	return err
+}
@@ -3,17 +3,60 @@ package dbconf
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io/ioutil"
 
+	"github.com/jmoiron/sqlx"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/log"
-	"github.com/jmoiron/sqlx"
 )
 
-// DBConfig contains the database driver name and configuration to be passed to Open
+// Supported cert db engines.
+const (
+	EngineSQL       = "sql"
+	EngineCouchbase = "couchbase"
+	EngineConsul    = "consul"
+)
+
+// DBConfig describes the certificate database. Engine selects the backend
+// and defaults to "sql"; the remaining fields are engine specific:
+//
+//	sql:       driver, data_source
+//	couchbase: uri, bucket and optionally password
+//	consul:    uri, prefix
 type DBConfig struct {
+	Engine         string `json:"engine"`
 	DriverName     string `json:"driver"`
 	DataSourceName string `json:"data_source"`
+	URI            string `json:"uri"`
+	Bucket         string `json:"bucket"`
+	Password       string `json:"password"`
+	Prefix         string `json:"prefix"`
+}
+
+// Validate checks that the options required by the configured engine are
+// present.
+func (cfg *DBConfig) Validate() error {
+	var required map[string]string
+	switch cfg.Engine {
+	case EngineSQL:
+		required = map[string]string{"driver": cfg.DriverName, "data_source": cfg.DataSourceName}
+	case EngineCouchbase:
+		required = map[string]string{"uri": cfg.URI, "bucket": cfg.Bucket}
+	case EngineConsul:
+		required = map[string]string{"uri": cfg.URI, "prefix": cfg.Prefix}
+	default:
+		return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			fmt.Errorf("unsupported cert db engine %q (expected %s, %s or %s)", cfg.Engine, EngineSQL, EngineCouchbase, EngineConsul))
+	}
+
+	for option, value := range required {
+		if value == "" {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				fmt.Errorf("Invalid DB configuration: %s engine requires %q", cfg.Engine, option))
+		}
+	}
+	return nil
 }
 
 // LoadFile attempts to load the db configuration file stored at the path
@@ -37,13 +80,30 @@ func LoadFile(path string) (cfg *DBConfig, err error) {
 			errors.New("Failed to unmarshal configuration: "+err.Error()))
 	}
 
-	if cfg.DataSourceName == "" || cfg.DriverName == "" {
-		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy, errors.New("Invalid DB configuration"))
+	if cfg.Engine == "" {
+		cfg.Engine = EngineSQL
+	}
+
+	if err = cfg.Validate(); err != nil {
+		return nil, err
 	}
 
 	return
 }
 
+// DBFromConfig opens a sqlx.DB from the settings in a db config file. It
+// fails if the file describes a non-SQL engine.
 func DBFromConfig(path string) (db *sqlx.DB, err error) {
-	return nil, nil
+	var dbCfg *DBConfig
+	dbCfg, err = LoadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if dbCfg.Engine != EngineSQL {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+			fmt.Errorf("db config %s describes a %s cert db, not a SQL database", path, dbCfg.Engine))
+	}
+
+	return sqlx.Open(dbCfg.DriverName, dbCfg.DataSourceName)
 }
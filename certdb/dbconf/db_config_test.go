@@ -1,6 +1,7 @@
 package dbconf
 
 import (
+	"strings"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3" // import just to initialize SQLite testing
@@ -18,6 +19,26 @@ func TestLoadFile(t *testing.T) {
 	}
 }
 
+func TestLoadFileEngine(t *testing.T) {
+	config, err := LoadFile("testdata/db-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Engine != EngineSQL {
+		t.Fatalf("expected engine to default to %s, got %s", EngineSQL, config.Engine)
+	}
+
+	_, err = LoadFile("testdata/consul-missing-prefix.json")
+	if err == nil || !strings.Contains(err.Error(), "prefix") {
+		t.Fatalf("expected missing prefix error, got %v", err)
+	}
+
+	_, err = LoadFile("testdata/unknown-engine.json")
+	if err == nil || !strings.Contains(err.Error(), "sqll") {
+		t.Fatalf("expected the unsupported engine to be named in the error, got %v", err)
+	}
+}
+
 func TestDBFromConfig(t *testing.T) {
 	db, err := DBFromConfig("testdata/db-config.json")
 	if err != nil || db == nil {
@@ -1,31 +1,51 @@
+// Package certdbfactory builds a certdb.Accessor for whichever cert db
+// engine a db config file describes.
 package certdbfactory
 
 import (
-	// "database/sql"
-	"encoding/json"
+	"fmt"
+
 	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/consul"
 	"github.com/ucosty/cfssl/certdb/couchbase"
-    // "github.com/ucosty/cfssl/certdb/consul"
-	// cfsslsql "github.com/ucosty/cfssl/certdb/sql"
-	"io/ioutil"
+	"github.com/ucosty/cfssl/certdb/dbconf"
+	certsql "github.com/ucosty/cfssl/certdb/sql"
+	cferr "github.com/ucosty/cfssl/errors"
 )
 
-func NewAccessor(config string) certdb.Accessor {
-	var options map[string]string
-	body, err := ioutil.ReadFile(config)
+// NewAccessor loads the db config file at path and returns an accessor for
+// the engine it names ("sql" when unset). Any problem with the config or
+// with connecting to the backend is returned as an error, never as a nil
+// accessor.
+func NewAccessor(path string) (certdb.Accessor, error) {
+	cfg, err := dbconf.LoadFile(path)
 	if err != nil {
-		return nil
+		return nil, err
 	}
-	json.Unmarshal(body, &options)
 
-	switch options["engine"] {
-	case "couchbase":
-		return couchbase.NewAccessor(config)
-	// case "sql":
-	// 	db, err := sql.Open(options["driver"], options["data_source"])
-	// 	if err == nil {
-	// 		return cfsslsql.NewAccessor(db)
-	// 	}
+	switch cfg.Engine {
+	case dbconf.EngineSQL:
+		db, err := dbconf.DBFromConfig(path)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		return certsql.NewAccessor(db), nil
+	case dbconf.EngineCouchbase:
+		accessor, err := couchbase.NewAccessor(path)
+		if err != nil {
+			return nil, err
+		}
+		return accessor, nil
+	case dbconf.EngineConsul:
+		accessor, err := consul.NewAccessor(path)
+		if err != nil {
+			return nil, err
+		}
+		return accessor, nil
 	}
-	return nil
+
+	// dbconf.LoadFile rejects unknown engines, so this is only reached if
+	// a new engine is added there but not here.
+	return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+		fmt.Errorf("cert db engine %q is not supported by this build", cfg.Engine))
 }
@@ -0,0 +1,51 @@
+package certdbfactory
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ucosty/cfssl/certdb/consul"
+	certsql "github.com/ucosty/cfssl/certdb/sql"
+
+	_ "github.com/mattn/go-sqlite3" // import just to initialize SQLite testing
+)
+
+func TestNewAccessorSQL(t *testing.T) {
+	dba, err := NewAccessor("testdata/sqlite-db-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dba.(*certsql.Accessor); !ok {
+		t.Fatalf("expected a SQL accessor, got %T", dba)
+	}
+}
+
+func TestNewAccessorConsul(t *testing.T) {
+	dba, err := NewAccessor("testdata/consul-db-config.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := dba.(*consul.Accessor); !ok {
+		t.Fatalf("expected a consul accessor, got %T", dba)
+	}
+}
+
+func TestNewAccessorErrors(t *testing.T) {
+	for path, want := range map[string]string{
+		"testdata/nonexistent.json":              "could not read",
+		"testdata/unknown-engine-db-config.json": "mongodb",
+		"testdata/bad-driver-db-config.json":     "unknown driver",
+	} {
+		dba, err := NewAccessor(path)
+		if err == nil {
+			t.Errorf("%s: expected an error", path)
+			continue
+		}
+		if dba != nil {
+			t.Errorf("%s: expected a nil accessor alongside the error", path)
+		}
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: expected error mentioning %q, got %v", path, want, err)
+		}
+	}
+}
@@ -0,0 +1,397 @@
+// Package migrate applies the cert db schema migrations shipped in
+// certdb/{pg,mysql,sqlite}/migrations. The migration files are embedded
+// with go.rice, so a cfssl binary can bring a database up to date without
+// the goose tool. The applied version is tracked in goose's own
+// goose_db_version table, so databases already managed by goose can switch
+// to this package and back.
+package migrate
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/GeertJohan/go.rice"
+	"github.com/jmoiron/sqlx"
+	cferr "github.com/ucosty/cfssl/errors"
+)
+
+// A Migration is one numbered migration file.
+type Migration struct {
+	Version int64
+	Name    string
+	Up      []string
+	Down    []string
+}
+
+// A MigrationStatus reports whether a migration has been applied.
+type MigrationStatus struct {
+	Version int64  `json:"version"`
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+}
+
+// versionTableSQL creates goose's version table for each supported driver.
+var versionTableSQL = map[string]string{
+	"postgres": `CREATE TABLE goose_db_version (
+	id serial NOT NULL,
+	version_id bigint NOT NULL,
+	is_applied boolean NOT NULL,
+	tstamp timestamp NULL default now(),
+	PRIMARY KEY(id)
+);`,
+	"mysql": `CREATE TABLE goose_db_version (
+	id serial NOT NULL,
+	version_id bigint NOT NULL,
+	is_applied boolean NOT NULL,
+	tstamp timestamp NULL default now(),
+	PRIMARY KEY(id)
+);`,
+	"sqlite3": `CREATE TABLE goose_db_version (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	version_id INTEGER NOT NULL,
+	is_applied INTEGER NOT NULL,
+	tstamp TIMESTAMP DEFAULT (datetime('now'))
+);`,
+}
+
+// versionTableExistsSQL counts the version tables of the current database
+// for each supported driver.
+var versionTableExistsSQL = map[string]string{
+	"postgres": `SELECT count(*) FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = 'goose_db_version';`,
+	"mysql":    `SELECT count(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'goose_db_version';`,
+	"sqlite3":  `SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = 'goose_db_version';`,
+}
+
+const (
+	selectVersionsSQL = `SELECT version_id, is_applied FROM goose_db_version ORDER BY id DESC;`
+	insertVersionSQL  = `INSERT INTO goose_db_version (version_id, is_applied) VALUES (?, ?);`
+)
+
+// findBox returns the embedded migrations for a database driver. The box
+// names must be string literals for rice embed-go to find them.
+func findBox(driver string) (*rice.Box, error) {
+	switch driver {
+	case "postgres":
+		return rice.FindBox("../pg/migrations")
+	case "mysql":
+		return rice.FindBox("../mysql/migrations")
+	case "sqlite3":
+		return rice.FindBox("../sqlite/migrations")
+	}
+	return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+		fmt.Errorf("no cert db migrations for driver %q", driver))
+}
+
+// Migrations returns the migrations for a database driver, ordered by
+// version.
+func Migrations(driver string) ([]Migration, error) {
+	box, err := findBox(driver)
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := box.Open("")
+	if err != nil {
+		return nil, wrap(err)
+	}
+	defer dir.Close()
+
+	files, err := dir.Readdir(-1)
+	if err != nil {
+		return nil, wrap(err)
+	}
+
+	var migrations []Migration
+	for _, file := range files {
+		name := file.Name()
+		if file.IsDir() || filepath.Ext(name) != ".sql" {
+			continue
+		}
+
+		prefix := strings.SplitN(name, "_", 2)[0]
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+				fmt.Errorf("migration %s does not start with a version number", name))
+		}
+
+		body, err := box.Bytes(name)
+		if err != nil {
+			return nil, wrap(err)
+		}
+
+		m, err := parse(body)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, fmt.Errorf("migration %s: %v", name, err))
+		}
+		m.Version = version
+		m.Name = name
+		migrations = append(migrations, m)
+	}
+
+	sort.Sort(byVersion(migrations))
+	return migrations, nil
+}
+
+type byVersion []Migration
+
+func (ms byVersion) Len() int           { return len(ms) }
+func (ms byVersion) Less(i, j int) bool { return ms[i].Version < ms[j].Version }
+func (ms byVersion) Swap(i, j int)      { ms[i], ms[j] = ms[j], ms[i] }
+
+// parse splits a goose SQL migration into its Up and Down statements.
+// Statements end with a semicolon at the end of a line, unless they are
+// wrapped in "-- +goose StatementBegin" and "-- +goose StatementEnd".
+func parse(body []byte) (m Migration, err error) {
+	var section *[]string
+	var buf bytes.Buffer
+	var inStatement bool
+
+	scanner := bufio.NewScanner(bytes.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		trimmed := strings.TrimSpace(line)
+
+		if strings.HasPrefix(trimmed, "-- +goose") {
+			switch strings.TrimSpace(strings.TrimPrefix(trimmed, "-- +goose")) {
+			case "Up":
+				section = &m.Up
+			case "Down":
+				section = &m.Down
+			case "StatementBegin":
+				inStatement = true
+			case "StatementEnd":
+				inStatement = false
+				*section = append(*section, buf.String())
+				buf.Reset()
+			}
+			continue
+		}
+
+		if section == nil || (strings.HasPrefix(trimmed, "--") && !inStatement) {
+			continue
+		}
+
+		buf.WriteString(line + "\n")
+		if !inStatement && strings.HasSuffix(trimmed, ";") {
+			*section = append(*section, buf.String())
+			buf.Reset()
+		}
+	}
+	if err = scanner.Err(); err != nil {
+		return
+	}
+
+	if strings.TrimSpace(buf.String()) != "" {
+		return m, errors.New("statement is missing a terminating semicolon")
+	}
+	if m.Up == nil {
+		return m, errors.New("no -- +goose Up section")
+	}
+	return m, nil
+}
+
+// hasVersionTable reports whether the version table exists, without
+// writing to db.
+func hasVersionTable(db *sqlx.DB) (bool, error) {
+	query, ok := versionTableExistsSQL[db.DriverName()]
+	if !ok {
+		return false, cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+			fmt.Errorf("no cert db migrations for driver %q", db.DriverName()))
+	}
+
+	var count int
+	if err := db.Get(&count, query); err != nil {
+		return false, wrap(err)
+	}
+	return count > 0, nil
+}
+
+// ensureVersionTable creates the version table if it does not exist.
+func ensureVersionTable(db *sqlx.DB) error {
+	exists, err := hasVersionTable(db)
+	if err != nil || exists {
+		return err
+	}
+	create := versionTableSQL[db.DriverName()]
+	tx, err := db.Beginx()
+	if err != nil {
+		return wrap(err)
+	}
+	if _, err = tx.Exec(create); err != nil {
+		tx.Rollback()
+		return wrap(err)
+	}
+	if _, err = tx.Exec(tx.Rebind(insertVersionSQL), 0, true); err != nil {
+		tx.Rollback()
+		return wrap(err)
+	}
+	return wrap(tx.Commit())
+}
+
+// CurrentVersion returns the version of the newest applied migration.
+// Like goose, only the most recent row for each version counts, so a
+// rolled back migration is not current. It does not write to db: without
+// a version table, no migration has been applied.
+func CurrentVersion(db *sqlx.DB) (int64, error) {
+	exists, err := hasVersionTable(db)
+	if err != nil || !exists {
+		return 0, err
+	}
+
+	rows, err := db.Query(selectVersionsSQL)
+	if err != nil {
+		return 0, wrap(err)
+	}
+	defer rows.Close()
+
+	seen := map[int64]bool{}
+	for rows.Next() {
+		var version int64
+		var applied bool
+		if err = rows.Scan(&version, &applied); err != nil {
+			return 0, wrap(err)
+		}
+
+		if seen[version] {
+			continue
+		}
+		seen[version] = true
+
+		if applied {
+			return version, nil
+		}
+	}
+	return 0, wrap(rows.Err())
+}
+
+// Status reports which of the driver's migrations have been applied to db.
+func Status(db *sqlx.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations(db.DriverName())
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	status := make([]MigrationStatus, len(migrations))
+	for i, m := range migrations {
+		status[i] = MigrationStatus{Version: m.Version, Name: m.Name, Applied: m.Version <= current}
+	}
+	return status, nil
+}
+
+// Pending returns the migrations that have not been applied to db yet,
+// without writing to it.
+func Pending(db *sqlx.DB) ([]Migration, error) {
+	migrations, err := Migrations(db.DriverName())
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, m := range migrations {
+		if m.Version > current {
+			pending = append(pending, m)
+		}
+	}
+	return pending, nil
+}
+
+// Up applies every pending migration in order and returns the ones it
+// applied. Each migration runs in its own transaction together with its
+// version table update, so a failure leaves the database at the last
+// migration that succeeded.
+func Up(db *sqlx.DB) ([]Migration, error) {
+	if err := ensureVersionTable(db); err != nil {
+		return nil, err
+	}
+
+	pending, err := Pending(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, m := range pending {
+		if err = run(db, m, m.Up, true); err != nil {
+			return pending[:i], err
+		}
+	}
+	return pending, nil
+}
+
+// Down rolls back the newest applied migration and returns it. It returns
+// nil if no migration has been applied.
+func Down(db *sqlx.DB) (*Migration, error) {
+	migrations, err := Migrations(db.DriverName())
+	if err != nil {
+		return nil, err
+	}
+
+	current, err := CurrentVersion(db)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := len(migrations) - 1; i >= 0; i-- {
+		m := migrations[i]
+		if m.Version != current {
+			continue
+		}
+
+		if err = run(db, m, m.Down, false); err != nil {
+			return nil, err
+		}
+		return &m, nil
+	}
+
+	if current != 0 {
+		return nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+			fmt.Errorf("cert db is at version %d, which has no migration file", current))
+	}
+	return nil, nil
+}
+
+// run executes statements and records the new state of m in one
+// transaction.
+func run(db *sqlx.DB, m Migration, statements []string, applied bool) error {
+	tx, err := db.Beginx()
+	if err != nil {
+		return wrap(err)
+	}
+
+	for _, statement := range statements {
+		if _, err = tx.Exec(statement); err != nil {
+			tx.Rollback()
+			return cferr.Wrap(cferr.CertStoreError, cferr.Unknown,
+				fmt.Errorf("migration %s: %v", m.Name, err))
+		}
+	}
+
+	if _, err = tx.Exec(tx.Rebind(insertVersionSQL), m.Version, applied); err != nil {
+		tx.Rollback()
+		return wrap(err)
+	}
+	return wrap(tx.Commit())
+}
+
+func wrap(err error) error {
+	if err != nil {
+		return cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package migrate
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	_ "github.com/mattn/go-sqlite3" // register sqlite3 driver
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+)
+
+func newSQLiteDB(t *testing.T) (*sqlx.DB, func()) {
+	dir, err := ioutil.TempDir("", "cfssl-migrate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	db, err := sqlx.Open("sqlite3", filepath.Join(dir, "certstore.db"))
+	if err != nil {
+		os.RemoveAll(dir)
+		t.Fatal(err)
+	}
+	return db, func() {
+		db.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestParse(t *testing.T) {
+	m, err := parse([]byte(`-- +goose Up
+-- a comment
+CREATE TABLE a (
+  id int
+);
+-- +goose StatementBegin
+CREATE TRIGGER t AFTER INSERT ON a BEGIN
+  DELETE FROM a;
+END;
+-- +goose StatementEnd
+
+-- +goose Down
+DROP TABLE a;
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Up) != 2 || len(m.Down) != 1 {
+		t.Fatalf("expected 2 up and 1 down statements, got %q and %q", m.Up, m.Down)
+	}
+
+	if _, err = parse([]byte("-- +goose Up\nCREATE TABLE a (id int)\n")); err == nil {
+		t.Fatal("expected an error for an unterminated statement")
+	}
+	if _, err = parse([]byte("-- +goose Down\nDROP TABLE a;\n")); err == nil {
+		t.Fatal("expected an error for a missing Up section")
+	}
+}
+
+func TestMigrations(t *testing.T) {
+	for _, driver := range []string{"postgres", "mysql", "sqlite3"} {
+		migrations, err := Migrations(driver)
+		if err != nil {
+			t.Fatalf("%s: %v", driver, err)
+		}
+		if len(migrations) == 0 || migrations[0].Version != 1 {
+			t.Fatalf("%s: expected migrations starting at version 1, got %+v", driver, migrations)
+		}
+		for i := 1; i < len(migrations); i++ {
+			if migrations[i].Version <= migrations[i-1].Version {
+				t.Fatalf("%s: migrations are not ordered by version", driver)
+			}
+		}
+	}
+
+	if _, err := Migrations("oracle"); err == nil {
+		t.Fatal("expected an error for an unsupported driver")
+	}
+}
+
+func TestUpDownStatus(t *testing.T) {
+	db, cleanup := newSQLiteDB(t)
+	defer cleanup()
+
+	migrations, err := Migrations("sqlite3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].Version
+
+	pending, err := Pending(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != len(migrations) {
+		t.Fatalf("expected %d pending migrations on an empty db, got %d", len(migrations), len(pending))
+	}
+	if exists, err := hasVersionTable(db); err != nil || exists {
+		t.Fatalf("expected checking the schema not to create the version table: %v", err)
+	}
+
+	applied, err := Up(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(applied) != len(migrations) {
+		t.Fatalf("expected %d applied migrations, got %d", len(migrations), len(applied))
+	}
+
+	// The migrated schema must fit the current CertificateRecord.
+	dba := sql.NewAccessor(db)
+	err = dba.InsertCertificate(certdb.CertificateRecord{
+		Serial:     "1",
+		AKI:        "aki",
+		Status:     "good",
+		Expiry:     time.Now().Add(time.Hour),
+		CommonName: "example.com",
+		SANs:       certdb.StringList{"example.com"},
+		PEM:        "pem",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if applied, err = Up(db); err != nil || len(applied) != 0 {
+		t.Fatalf("expected a no-op, got %d migrations and error %v", len(applied), err)
+	}
+
+	m, err := Down(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil || m.Version != latest {
+		t.Fatalf("expected to roll back version %d, got %+v", latest, m)
+	}
+
+	status, err := Status(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range status {
+		if s.Applied != (s.Version < latest) {
+			t.Fatalf("unexpected status %+v", s)
+		}
+	}
+
+	// The rolled back data survives and the migration can be reapplied.
+	if applied, err = Up(db); err != nil || len(applied) != 1 {
+		t.Fatalf("expected one migration, got %d and error %v", len(applied), err)
+	}
+	crs, err := dba.GetCertificate("1", "aki")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 {
+		t.Fatal("certificate record lost across down and up migrations")
+	}
+
+	for range migrations {
+		if _, err = Down(db); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if m, err = Down(db); err != nil || m != nil {
+		t.Fatalf("expected nothing to roll back, got %+v and error %v", m, err)
+	}
+	current, err := CurrentVersion(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current != 0 {
+		t.Fatalf("expected version 0, got %d", current)
+	}
+}
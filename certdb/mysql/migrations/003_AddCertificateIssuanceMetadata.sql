@@ -0,0 +1,21 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE certificates
+  ADD COLUMN common_name        varbinary(1024) NOT NULL DEFAULT '',
+  ADD COLUMN sans               varbinary(4096) NOT NULL DEFAULT '[]',
+  ADD COLUMN profile            varbinary(128) NOT NULL DEFAULT '',
+  ADD COLUMN not_before         timestamp DEFAULT '0000-00-00 00:00:00',
+  ADD COLUMN requester          varbinary(1024) NOT NULL DEFAULT '',
+  ADD COLUMN sha256_fingerprint varbinary(64) NOT NULL DEFAULT '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE certificates
+  DROP COLUMN common_name,
+  DROP COLUMN sans,
+  DROP COLUMN profile,
+  DROP COLUMN not_before,
+  DROP COLUMN requester,
+  DROP COLUMN sha256_fingerprint;
@@ -0,0 +1,13 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE crl_numbers
+  ADD COLUMN base_crl_number  bigint NOT NULL DEFAULT 0,
+  ADD COLUMN base_this_update timestamp DEFAULT '0000-00-00 00:00:00';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE crl_numbers
+  DROP COLUMN base_crl_number,
+  DROP COLUMN base_this_update;
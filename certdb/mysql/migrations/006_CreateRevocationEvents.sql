@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE revocation_events (
+  id                       bigint NOT NULL AUTO_INCREMENT,
+  serial_number            varbinary(128) NOT NULL,
+  authority_key_identifier varbinary(128) NOT NULL,
+  action                   varbinary(16) NOT NULL,
+  actor                    varbinary(255) NOT NULL DEFAULT '',
+  reason                   int NOT NULL DEFAULT 0,
+  previous_status          varbinary(128) NOT NULL,
+  previous_reason          int NOT NULL DEFAULT 0,
+  created_at               timestamp NOT NULL DEFAULT '0000-00-00 00:00:00',
+  PRIMARY KEY(id),
+  INDEX revocation_events_certificate (serial_number, authority_key_identifier),
+  INDEX revocation_events_created_at (created_at)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE revocation_events;
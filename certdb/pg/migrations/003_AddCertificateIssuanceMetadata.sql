@@ -0,0 +1,19 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE certificates ADD COLUMN common_name bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN sans bytea NOT NULL DEFAULT '[]';
+ALTER TABLE certificates ADD COLUMN profile bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN not_before timestamptz NOT NULL DEFAULT '0001-01-01 00:00:00+00';
+ALTER TABLE certificates ADD COLUMN requester bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN sha256_fingerprint bytea NOT NULL DEFAULT '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE certificates DROP COLUMN sha256_fingerprint;
+ALTER TABLE certificates DROP COLUMN requester;
+ALTER TABLE certificates DROP COLUMN not_before;
+ALTER TABLE certificates DROP COLUMN profile;
+ALTER TABLE certificates DROP COLUMN sans;
+ALTER TABLE certificates DROP COLUMN common_name;
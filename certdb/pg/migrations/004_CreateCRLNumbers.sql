@@ -0,0 +1,14 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE crl_numbers (
+  authority_key_identifier bytea NOT NULL,
+  distribution_point       bytea NOT NULL DEFAULT '',
+  crl_number               bigint NOT NULL,
+  PRIMARY KEY(authority_key_identifier, distribution_point)
+);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE crl_numbers;
@@ -0,0 +1,11 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE crl_numbers ADD COLUMN base_crl_number bigint NOT NULL DEFAULT 0;
+ALTER TABLE crl_numbers ADD COLUMN base_this_update timestamptz NOT NULL DEFAULT '0001-01-01 00:00:00+00';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+ALTER TABLE crl_numbers DROP COLUMN base_this_update;
+ALTER TABLE crl_numbers DROP COLUMN base_crl_number;
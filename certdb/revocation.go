@@ -0,0 +1,117 @@
+package certdb
+
+import (
+	"fmt"
+	"time"
+
+	cferr "github.com/ucosty/cfssl/errors"
+)
+
+// ReasonCertificateHold is the RFC 5280 reason code of a revocation that
+// may later be lifted with Unhold.
+const ReasonCertificateHold = 6
+
+// RevocationRecorder is implemented by accessors that can change the
+// status of a certificate and append the event in a single transaction.
+type RevocationRecorder interface {
+	RevokeCertificateAndRecord(serial, aki string, reasonCode int, ev RevocationEvent) error
+	UnholdCertificateAndRecord(serial, aki string, ev RevocationEvent) error
+}
+
+// Revoke revokes a certificate on behalf of actor and records the
+// revocation event. If dbAccessor is a RevocationRecorder, both happen in
+// one transaction; otherwise the event is appended after the status has
+// changed and, if that fails, the certificate stays revoked and the error
+// is returned.
+func Revoke(dbAccessor Accessor, serial, aki string, reasonCode int, actor string) error {
+	prev, err := getUnique(dbAccessor, serial, aki)
+	if err != nil {
+		return err
+	}
+
+	ev := RevocationEvent{
+		Serial:         serial,
+		AKI:            aki,
+		Action:         ActionRevoke,
+		Actor:          actor,
+		Reason:         reasonCode,
+		PreviousStatus: prev.Status,
+		PreviousReason: prev.Reason,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if rr, ok := dbAccessor.(RevocationRecorder); ok {
+		return rr.RevokeCertificateAndRecord(serial, aki, reasonCode, ev)
+	}
+
+	if err = dbAccessor.RevokeCertificate(serial, aki, reasonCode); err != nil {
+		return err
+	}
+	return dbAccessor.InsertRevocationEvent(ev)
+}
+
+// Unhold releases a certificate from certificateHold on behalf of actor
+// and records the event, in one transaction if dbAccessor is a
+// RevocationRecorder. Only certificates revoked with reason
+// certificateHold can be released.
+func Unhold(dbAccessor Accessor, serial, aki, actor string) error {
+	prev, err := getUnique(dbAccessor, serial, aki)
+	if err != nil {
+		return err
+	}
+
+	if prev.Status != "revoked" || prev.Reason != ReasonCertificateHold {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InvalidRecordStatus,
+			fmt.Errorf("failed to unhold the certificate: certificate is not on hold"))
+	}
+
+	ev := RevocationEvent{
+		Serial:         serial,
+		AKI:            aki,
+		Action:         ActionUnhold,
+		Actor:          actor,
+		PreviousStatus: prev.Status,
+		PreviousReason: prev.Reason,
+		CreatedAt:      time.Now().UTC(),
+	}
+	if rr, ok := dbAccessor.(RevocationRecorder); ok {
+		return rr.UnholdCertificateAndRecord(serial, aki, ev)
+	}
+
+	if err = dbAccessor.UnholdCertificate(serial, aki); err != nil {
+		return err
+	}
+	return dbAccessor.InsertRevocationEvent(ev)
+}
+
+// RecordRevocations appends a revocation event for each of the records,
+// which were revoked by actor from status "good", as
+// Accessor.RevokeCertificates does.
+func RecordRevocations(dbAccessor Accessor, crs []CertificateRecord, actor string) error {
+	for _, cr := range crs {
+		err := dbAccessor.InsertRevocationEvent(RevocationEvent{
+			Serial:         cr.Serial,
+			AKI:            cr.AKI,
+			Action:         ActionRevoke,
+			Actor:          actor,
+			Reason:         cr.Reason,
+			PreviousStatus: "good",
+			CreatedAt:      cr.RevokedAt.UTC(),
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func getUnique(dbAccessor Accessor, serial, aki string) (CertificateRecord, error) {
+	crs, err := dbAccessor.GetCertificate(serial, aki)
+	if err != nil {
+		return CertificateRecord{}, err
+	}
+	if len(crs) != 1 {
+		return CertificateRecord{}, cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+			fmt.Errorf("certificate not found"))
+	}
+	return crs[0], nil
+}
@@ -1,8 +1,10 @@
 package sql
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -18,8 +20,10 @@ func init() {
 
 const (
 	insertSQL = `
-INSERT INTO certificates (serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, pem)
-	VALUES (:serial_number, :authority_key_identifier, :ca_label, :status, :reason, :expiry, :revoked_at, :pem);`
+INSERT INTO certificates (serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, issued_at,
+		common_name, sans, profile, not_before, requester, sha256_fingerprint, pem)
+	VALUES (:serial_number, :authority_key_identifier, :ca_label, :status, :reason, :expiry, :revoked_at, :issued_at,
+		:common_name, :sans, :profile, :not_before, :requester, :sha256_fingerprint, :pem);`
 
 	selectSQL = `
 SELECT %s FROM certificates
@@ -33,11 +37,48 @@ SELECT %s FROM certificates
 SELECT %s FROM certificates
 	WHERE CURRENT_TIMESTAMP < expiry AND status='revoked';`
 
+	selectPageSQL = `
+SELECT %s FROM certificates
+	%s
+	ORDER BY serial_number, authority_key_identifier
+	LIMIT ?;`
+
 	updateRevokeSQL = `
 UPDATE certificates
 	SET status='revoked', revoked_at=CURRENT_TIMESTAMP, reason=:reason
 	WHERE (serial_number = :serial_number AND authority_key_identifier = :authority_key_identifier);`
 
+	selectMatchingSQL = `
+SELECT %s FROM certificates
+	%s
+	ORDER BY serial_number, authority_key_identifier;`
+
+	updateRevokeAtSQL = `
+UPDATE certificates
+	SET status='revoked', revoked_at=?, reason=?
+	WHERE (serial_number = ? AND authority_key_identifier = ? AND status = 'good');`
+
+	updateUnholdSQL = `
+UPDATE certificates
+	SET status='good', revoked_at=?, reason=0
+	WHERE (serial_number = ? AND authority_key_identifier = ? AND status = 'revoked' AND reason = ?);`
+
+	insertRevocationEventSQL = `
+INSERT INTO revocation_events (serial_number, authority_key_identifier, action, actor, reason,
+		previous_status, previous_reason, created_at)
+	VALUES (:serial_number, :authority_key_identifier, :action, :actor, :reason,
+		:previous_status, :previous_reason, :created_at);`
+
+	selectRevocationEventsSQL = `
+SELECT %s FROM revocation_events
+	WHERE (serial_number = ? AND authority_key_identifier = ?)
+	ORDER BY created_at, id;`
+
+	selectRevocationEventsSinceSQL = `
+SELECT %s FROM revocation_events
+	WHERE created_at >= ?
+	ORDER BY created_at, id;`
+
 	insertOCSPSQL = `
 INSERT INTO ocsp_responses (serial_number, authority_key_identifier, body, expiry)
   VALUES (:serial_number, :authority_key_identifier, :body, :expiry);`
@@ -54,6 +95,28 @@ SELECT %s FROM ocsp_responses
 	selectOCSPSQL = `
 SELECT %s FROM ocsp_responses
   WHERE (serial_number = ? AND authority_key_identifier = ?);`
+
+	incrementCRLNumberSQL = `
+UPDATE crl_numbers
+	SET crl_number = crl_number + 1
+	WHERE (authority_key_identifier = ? AND distribution_point = ?);`
+
+	insertCRLNumberSQL = `
+INSERT INTO crl_numbers (authority_key_identifier, distribution_point, crl_number)
+	VALUES (?, ?, 1);`
+
+	selectCRLNumberSQL = `
+SELECT crl_number FROM crl_numbers
+	WHERE (authority_key_identifier = ? AND distribution_point = ?);`
+
+	selectCRLSQL = `
+SELECT %s FROM crl_numbers
+	WHERE (authority_key_identifier = ? AND distribution_point = ?);`
+
+	updateBaseCRLSQL = `
+UPDATE crl_numbers
+	SET base_crl_number = :base_crl_number, base_this_update = :base_this_update
+	WHERE (authority_key_identifier = :authority_key_identifier AND distribution_point = :distribution_point);`
 )
 
 // Accessor implements certdb.Accessor interface.
@@ -95,14 +158,21 @@ func (d *Accessor) InsertCertificate(cr certdb.CertificateRecord) error {
 	}
 
 	res, err := d.db.NamedExec(insertSQL, &certdb.CertificateRecord{
-		Serial:    cr.Serial,
-		AKI:       cr.AKI,
-		CALabel:   cr.CALabel,
-		Status:    cr.Status,
-		Reason:    cr.Reason,
-		Expiry:    cr.Expiry.UTC(),
-		RevokedAt: cr.RevokedAt.UTC(),
-		PEM:       cr.PEM,
+		Serial:      cr.Serial,
+		AKI:         cr.AKI,
+		CALabel:     cr.CALabel,
+		Status:      cr.Status,
+		Reason:      cr.Reason,
+		Expiry:      cr.Expiry.UTC(),
+		RevokedAt:   cr.RevokedAt.UTC(),
+		IssuedAt:    cr.IssuedAt.UTC(),
+		CommonName:  cr.CommonName,
+		SANs:        cr.SANs,
+		Profile:     cr.Profile,
+		NotBefore:   cr.NotBefore.UTC(),
+		Requester:   cr.Requester,
+		Fingerprint: cr.Fingerprint,
+		PEM:         cr.PEM,
 	})
 	if err != nil {
 		return wrapSQLError(err)
@@ -166,6 +236,96 @@ func (d *Accessor) GetRevokedAndUnexpiredCertificates() (crs []certdb.Certificat
 	return crs, nil
 }
 
+// ListCertificates gets one page of the certificates matching q from db.
+func (d *Accessor) ListCertificates(q certdb.CertificateQuery) (page certdb.CertificatePage, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return page, err
+	}
+
+	where, args, err := whereClause(q)
+	if err != nil {
+		return page, err
+	}
+
+	// Fetch one record more than requested to learn whether another page follows.
+	limit := q.PageSize()
+	args = append(args, limit+1)
+
+	var crs []certdb.CertificateRecord
+	query := fmt.Sprintf(selectPageSQL, sqlstruct.Columns(certdb.CertificateRecord{}), where)
+	err = d.db.Select(&crs, d.db.Rebind(query), args...)
+	if err != nil {
+		return page, wrapSQLError(err)
+	}
+
+	if len(crs) > limit {
+		crs = crs[:limit]
+		last := crs[limit-1]
+		page.NextCursor = certdb.EncodeCursor(last.Serial, last.AKI)
+	}
+	page.Certificates = crs
+	return page, nil
+}
+
+// whereClause builds the WHERE clause, and its arguments, selecting the
+// certificates matching q.
+func whereClause(q certdb.CertificateQuery) (string, []interface{}, error) {
+	var conds []string
+	var args []interface{}
+	if q.CALabel != "" {
+		conds = append(conds, "ca_label = ?")
+		args = append(args, q.CALabel)
+	}
+	if q.Status != "" {
+		conds = append(conds, "status = ?")
+		args = append(args, q.Status)
+	}
+	if q.AKI != "" {
+		conds = append(conds, "authority_key_identifier = ?")
+		args = append(args, q.AKI)
+	}
+	if q.Hostname != "" {
+		// SANs are stored as a JSON array, so match the quoted name.
+		quoted, _ := json.Marshal(q.Hostname)
+		conds = append(conds, "(common_name = ? OR sans LIKE ? ESCAPE '!')")
+		args = append(args, q.Hostname, "%"+likeEscaper.Replace(string(quoted))+"%")
+	}
+	if !q.ExpiresAfter.IsZero() {
+		conds = append(conds, "expiry > ?")
+		args = append(args, q.ExpiresAfter.UTC())
+	}
+	if !q.ExpiresBefore.IsZero() {
+		conds = append(conds, "expiry < ?")
+		args = append(args, q.ExpiresBefore.UTC())
+	}
+	if !q.IssuedAfter.IsZero() {
+		conds = append(conds, "issued_at > ?")
+		args = append(args, q.IssuedAfter.UTC())
+	}
+	if !q.IssuedBefore.IsZero() {
+		conds = append(conds, "issued_at < ?")
+		args = append(args, q.IssuedBefore.UTC())
+	}
+	if q.Cursor != "" {
+		serial, aki, err := certdb.DecodeCursor(q.Cursor)
+		if err != nil {
+			return "", nil, cferr.Wrap(cferr.CertStoreError, cferr.Unknown, err)
+		}
+		conds = append(conds, "(serial_number > ? OR (serial_number = ? AND authority_key_identifier > ?))")
+		args = append(args, serial, serial, aki)
+	}
+
+	if len(conds) == 0 {
+		return "", args, nil
+	}
+	return "WHERE " + strings.Join(conds, " AND "), args, nil
+}
+
+// likeEscaper escapes the wildcards of a LIKE pattern with '!', which,
+// unlike a backslash, needs no quoting in any of the supported SQL dialects.
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
+
 // RevokeCertificate updates a certificate with a given serial number and marks it revoked.
 func (d *Accessor) RevokeCertificate(serial, aki string, reasonCode int) error {
 	err := d.checkDB()
@@ -173,7 +333,27 @@ func (d *Accessor) RevokeCertificate(serial, aki string, reasonCode int) error {
 		return err
 	}
 
-	result, err := d.db.NamedExec(updateRevokeSQL, &certdb.CertificateRecord{
+	return revokeCertificate(d.db, serial, aki, reasonCode)
+}
+
+// RevokeCertificateAndRecord revokes a certificate and appends ev in a
+// single transaction.
+func (d *Accessor) RevokeCertificateAndRecord(serial, aki string, reasonCode int, ev certdb.RevocationEvent) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	return d.inTx(func(tx *sqlx.Tx) error {
+		if err := revokeCertificate(tx, serial, aki, reasonCode); err != nil {
+			return err
+		}
+		return insertRevocationEvent(tx, ev)
+	})
+}
+
+func revokeCertificate(e sqlx.Ext, serial, aki string, reasonCode int) error {
+	result, err := sqlx.NamedExec(e, updateRevokeSQL, &certdb.CertificateRecord{
 		AKI:    aki,
 		Reason: reasonCode,
 		Serial: serial,
@@ -195,6 +375,186 @@ func (d *Accessor) RevokeCertificate(serial, aki string, reasonCode int) error {
 	return err
 }
 
+// RevokeCertificates revokes the unrevoked certificates matching q in a
+// single transaction and returns them.
+func (d *Accessor) RevokeCertificates(q certdb.CertificateQuery, reasonCode int) (crs []certdb.CertificateRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	q.Status = "good"
+	q.Cursor = ""
+	where, args, err := whereClause(q)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	query := fmt.Sprintf(selectMatchingSQL, sqlstruct.Columns(certdb.CertificateRecord{}), where)
+	if err = tx.Select(&crs, tx.Rebind(query), args...); err != nil {
+		tx.Rollback()
+		return nil, wrapSQLError(err)
+	}
+
+	revokedAt := time.Now().UTC()
+	for i := range crs {
+		result, err := tx.Exec(tx.Rebind(updateRevokeAtSQL), revokedAt, reasonCode, crs[i].Serial, crs[i].AKI)
+		if err != nil {
+			tx.Rollback()
+			return nil, wrapSQLError(err)
+		}
+		numRowsAffected, err := result.RowsAffected()
+		if err != nil {
+			tx.Rollback()
+			return nil, wrapSQLError(err)
+		}
+		// The certificate was revoked by someone else since it was read.
+		if numRowsAffected != 1 {
+			tx.Rollback()
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.InvalidRecordStatus, fmt.Errorf("failed to revoke the certificate %s: it is no longer good", crs[i].Serial))
+		}
+		crs[i].Status = "revoked"
+		crs[i].Reason = reasonCode
+		crs[i].RevokedAt = revokedAt
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, wrapSQLError(err)
+	}
+	return crs, nil
+}
+
+// UnholdCertificate marks a certificate revoked with reason
+// certificateHold as good again.
+func (d *Accessor) UnholdCertificate(serial, aki string) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	return unholdCertificate(d.db, serial, aki)
+}
+
+// UnholdCertificateAndRecord releases a certificate from certificateHold
+// and appends ev in a single transaction.
+func (d *Accessor) UnholdCertificateAndRecord(serial, aki string, ev certdb.RevocationEvent) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	return d.inTx(func(tx *sqlx.Tx) error {
+		if err := unholdCertificate(tx, serial, aki); err != nil {
+			return err
+		}
+		return insertRevocationEvent(tx, ev)
+	})
+}
+
+func unholdCertificate(e sqlx.Ext, serial, aki string) error {
+	// A released certificate has the revoked_at of one that was never
+	// revoked, as InsertCertificate writes it.
+	var notRevoked time.Time
+	result, err := e.Exec(e.Rebind(updateUnholdSQL), notRevoked.UTC(), serial, aki, certdb.ReasonCertificateHold)
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	if numRowsAffected == 0 {
+		var crs []certdb.CertificateRecord
+		err = sqlx.Select(e, &crs, fmt.Sprintf(e.Rebind(selectSQL), sqlstruct.Columns(certdb.CertificateRecord{})), serial, aki)
+		if err != nil {
+			return wrapSQLError(err)
+		}
+		if len(crs) == 0 {
+			return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound, fmt.Errorf("failed to unhold the certificate: certificate not found"))
+		}
+		return cferr.Wrap(cferr.CertStoreError, cferr.InvalidRecordStatus, fmt.Errorf("failed to unhold the certificate: certificate is not on hold"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return nil
+}
+
+// InsertRevocationEvent appends a certdb.RevocationEvent to the db.
+func (d *Accessor) InsertRevocationEvent(ev certdb.RevocationEvent) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	return insertRevocationEvent(d.db, ev)
+}
+
+func insertRevocationEvent(e sqlx.Ext, ev certdb.RevocationEvent) error {
+	ev.CreatedAt = ev.CreatedAt.UTC()
+	_, err := sqlx.NamedExec(e, insertRevocationEventSQL, &ev)
+	if err != nil {
+		return cferr.Wrap(cferr.CertStoreError, cferr.InsertionFailed, err)
+	}
+	return nil
+}
+
+// inTx runs f in a transaction, which is committed if f succeeds and
+// rolled back otherwise.
+func (d *Accessor) inTx(f func(tx *sqlx.Tx) error) error {
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	if err = f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return wrapSQLError(tx.Commit())
+}
+
+// GetRevocationEvents gets the revocation events of a certificate from db,
+// oldest first.
+func (d *Accessor) GetRevocationEvents(serial, aki string) (evs []certdb.RevocationEvent, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&evs, fmt.Sprintf(d.db.Rebind(selectRevocationEventsSQL), sqlstruct.Columns(certdb.RevocationEvent{})), serial, aki)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return evs, nil
+}
+
+// ListRevocationEvents gets the revocation events created at or after
+// since from db, oldest first.
+func (d *Accessor) ListRevocationEvents(since time.Time) (evs []certdb.RevocationEvent, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&evs, fmt.Sprintf(d.db.Rebind(selectRevocationEventsSinceSQL), sqlstruct.Columns(certdb.RevocationEvent{})), since.UTC())
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return evs, nil
+}
+
 // InsertOCSP puts a new certdb.OCSPRecord into the db.
 func (d *Accessor) InsertOCSP(rr certdb.OCSPRecord) error {
 	err := d.checkDB()
@@ -330,3 +690,93 @@ func (d *Accessor) UpsertOCSP(serial, aki, body string, expiry time.Time) error
 
 	return err
 }
+
+// NextCRLNumber increments and returns the CRL number of the issuer and
+// distribution point, starting at 1. The increment and read happen in one
+// transaction, so concurrent CRL generators never share a number.
+func (d *Accessor) NextCRLNumber(aki, distributionPoint string) (int64, error) {
+	err := d.checkDB()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := d.db.Beginx()
+	if err != nil {
+		return 0, wrapSQLError(err)
+	}
+
+	result, err := tx.Exec(tx.Rebind(incrementCRLNumberSQL), aki, distributionPoint)
+	if err != nil {
+		tx.Rollback()
+		return 0, wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return 0, wrapSQLError(err)
+	}
+
+	if numRowsAffected == 0 {
+		if _, err = tx.Exec(tx.Rebind(insertCRLNumberSQL), aki, distributionPoint); err != nil {
+			tx.Rollback()
+			return 0, wrapSQLError(err)
+		}
+	}
+
+	var number int64
+	if err = tx.Get(&number, tx.Rebind(selectCRLNumberSQL), aki, distributionPoint); err != nil {
+		tx.Rollback()
+		return 0, wrapSQLError(err)
+	}
+
+	return number, wrapSQLError(tx.Commit())
+}
+
+// GetCRLRecord gets the certdb.CRLRecord of the issuer and distribution
+// point from db.
+func (d *Accessor) GetCRLRecord(aki, distributionPoint string) (recs []certdb.CRLRecord, err error) {
+	err = d.checkDB()
+	if err != nil {
+		return nil, err
+	}
+
+	err = d.db.Select(&recs, fmt.Sprintf(d.db.Rebind(selectCRLSQL), sqlstruct.Columns(certdb.CRLRecord{})), aki, distributionPoint)
+	if err != nil {
+		return nil, wrapSQLError(err)
+	}
+
+	return recs, nil
+}
+
+// UpdateBaseCRL records the number and thisUpdate of the last full CRL of
+// the issuer and distribution point. The CRL number must have been taken
+// with NextCRLNumber.
+func (d *Accessor) UpdateBaseCRL(aki, distributionPoint string, number int64, thisUpdate time.Time) error {
+	err := d.checkDB()
+	if err != nil {
+		return err
+	}
+
+	result, err := d.db.NamedExec(updateBaseCRLSQL, &certdb.CRLRecord{
+		AKI:               aki,
+		DistributionPoint: distributionPoint,
+		BaseNumber:        number,
+		BaseThisUpdate:    thisUpdate.UTC(),
+	})
+	if err != nil {
+		return wrapSQLError(err)
+	}
+
+	numRowsAffected, err := result.RowsAffected()
+
+	if numRowsAffected == 0 {
+		return cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound, fmt.Errorf("failed to update the base CRL: no CRL number issued"))
+	}
+
+	if numRowsAffected != 1 {
+		return wrapSQLError(fmt.Errorf("%d rows are affected, should be 1 row", numRowsAffected))
+	}
+
+	return err
+}
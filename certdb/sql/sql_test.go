@@ -2,6 +2,7 @@ package sql
 
 import (
 	"math"
+	"reflect"
 	"testing"
 	"time"
 
@@ -51,11 +52,16 @@ func roughlySameTime(t1, t2 time.Time) bool {
 func testEverything(ta TestAccessor, t *testing.T) {
 	testInsertCertificateAndGetCertificate(ta, t)
 	testInsertCertificateAndGetUnexpiredCertificate(ta, t)
+	testInsertCertificateMetadata(ta, t)
 	testUpdateCertificateAndGetCertificate(ta, t)
 	testInsertOCSPAndGetOCSP(ta, t)
 	testInsertOCSPAndGetUnexpiredOCSP(ta, t)
 	testUpdateOCSPAndGetOCSP(ta, t)
 	testUpsertOCSPAndGetOCSP(ta, t)
+	testNextCRLNumber(ta, t)
+	testUpdateBaseCRLAndGetCRLRecord(ta, t)
+	testRevokeCertificates(ta, t)
+	testUnholdAndRevocationEvents(ta, t)
 }
 
 func testInsertCertificateAndGetCertificate(ta TestAccessor, t *testing.T) {
@@ -104,6 +110,46 @@ func testInsertCertificateAndGetCertificate(ta TestAccessor, t *testing.T) {
 	}
 }
 
+func testInsertCertificateMetadata(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+
+	notBefore := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	want := certdb.CertificateRecord{
+		PEM:         "fake cert data",
+		Serial:      "fake serial",
+		AKI:         fakeAKI,
+		Status:      "good",
+		Expiry:      notBefore.AddDate(1, 0, 0),
+		IssuedAt:    notBefore,
+		CommonName:  "example.com",
+		SANs:        certdb.StringList{"example.com", "www.example.com", "127.0.0.1"},
+		Profile:     "www",
+		NotBefore:   notBefore,
+		Requester:   "client-key",
+		Fingerprint: "d0e1",
+	}
+
+	if err := ta.Accessor.InsertCertificate(want); err != nil {
+		t.Fatal(err)
+	}
+
+	rets, err := ta.Accessor.GetCertificate(want.Serial, want.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rets) != 1 {
+		t.Fatal("should only return one record.")
+	}
+
+	got := rets[0]
+	if got.CommonName != want.CommonName || got.Profile != want.Profile ||
+		got.Requester != want.Requester || got.Fingerprint != want.Fingerprint ||
+		!roughlySameTime(got.NotBefore, notBefore) || !roughlySameTime(got.IssuedAt, notBefore) ||
+		!reflect.DeepEqual(got.SANs, want.SANs) {
+		t.Errorf("want Certificate %+v, got %+v", want, got)
+	}
+}
+
 func testInsertCertificateAndGetUnexpiredCertificate(ta TestAccessor, t *testing.T) {
 	ta.Truncate()
 
@@ -389,3 +435,200 @@ func setupGoodCert(ta TestAccessor, t *testing.T, r certdb.OCSPRecord) {
 		t.Fatal(err)
 	}
 }
+
+func testNextCRLNumber(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+
+	for want := int64(1); want <= 3; want++ {
+		got, err := ta.Accessor.NextCRLNumber(fakeAKI, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("want CRL number %d, got %d", want, got)
+		}
+	}
+
+	// Each distribution point has its own sequence.
+	got, err := ta.Accessor.NextCRLNumber(fakeAKI, "http://crl.example.com/1.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != 1 {
+		t.Fatalf("want CRL number 1 for a new distribution point, got %d", got)
+	}
+}
+
+func testUpdateBaseCRLAndGetCRLRecord(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+
+	// No CRL number has been issued to base the CRL on yet.
+	if err := ta.Accessor.UpdateBaseCRL(fakeAKI, "", 1, time.Now()); err == nil {
+		t.Fatal("Expected an error updating the base CRL before issuing a CRL number")
+	}
+
+	recs, err := ta.Accessor.GetCRLRecord(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 0 {
+		t.Fatalf("Expected no CRL record, got %d", len(recs))
+	}
+
+	number, err := ta.Accessor.NextCRLNumber(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	thisUpdate := time.Now().Round(time.Second)
+	if err = ta.Accessor.UpdateBaseCRL(fakeAKI, "", number, thisUpdate); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ta.Accessor.NextCRLNumber(fakeAKI, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	recs, err = ta.Accessor.GetCRLRecord(fakeAKI, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recs) != 1 {
+		t.Fatalf("Expected one CRL record, got %d", len(recs))
+	}
+	if recs[0].Number != number+1 || recs[0].BaseNumber != number ||
+		!recs[0].BaseThisUpdate.Equal(thisUpdate) {
+		t.Errorf("want CRL %d based on %d at %v, got %+v", number+1, number, thisUpdate, recs[0])
+	}
+}
+
+func testRevokeCertificates(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+
+	expiry := time.Now().Add(time.Minute)
+	for _, cr := range []certdb.CertificateRecord{
+		{Serial: "1", SANs: certdb.StringList{"a_b.example.com"}},
+		{Serial: "2", SANs: certdb.StringList{"axb.example.com"}},
+		{Serial: "3", CommonName: "a_b.example.com", Status: "revoked"},
+	} {
+		cr.AKI = fakeAKI
+		cr.Expiry = expiry
+		cr.PEM = "fake cert data"
+		if cr.Status == "" {
+			cr.Status = "good"
+		}
+		if err := ta.Accessor.InsertCertificate(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The underscore in the hostname is not a LIKE wildcard, and
+	// certificates already revoked are left alone.
+	crs, err := ta.Accessor.RevokeCertificates(certdb.CertificateQuery{Hostname: "a_b.example.com"}, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 || crs[0].Serial != "1" || crs[0].Status != "revoked" || crs[0].Reason != 1 {
+		t.Fatalf("want certificate 1 revoked, got %+v", crs)
+	}
+
+	got, err := ta.Accessor.GetCertificate("1", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != "revoked" || got[0].Reason != 1 {
+		t.Fatalf("certificate 1 was not revoked: %+v", got)
+	}
+
+	got, err = ta.Accessor.GetCertificate("2", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != "good" {
+		t.Fatalf("certificate 2 was revoked: %+v", got)
+	}
+}
+
+func testUnholdAndRevocationEvents(ta TestAccessor, t *testing.T) {
+	ta.Truncate()
+
+	cr := certdb.CertificateRecord{
+		Serial: "1",
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().Add(time.Minute),
+		PEM:    "fake cert data",
+	}
+	if err := ta.Accessor.InsertCertificate(cr); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := certdb.Unhold(ta.Accessor, cr.Serial, cr.AKI, "alice"); err == nil {
+		t.Fatal("releasing a certificate that is not on hold should fail")
+	}
+	if err := ta.Accessor.UnholdCertificate(cr.Serial, cr.AKI); err == nil {
+		t.Fatal("releasing a certificate that is not on hold should fail")
+	}
+	if err := ta.Accessor.UnholdCertificate("2", cr.AKI); err == nil {
+		t.Fatal("releasing a missing certificate should fail")
+	}
+
+	// The status change and its event are recorded in one transaction.
+	rr, ok := ta.Accessor.(certdb.RevocationRecorder)
+	if !ok {
+		t.Fatal("the SQL accessor should be a certdb.RevocationRecorder")
+	}
+	ev := certdb.RevocationEvent{Serial: "2", AKI: cr.AKI, Action: certdb.ActionRevoke, Actor: "alice", CreatedAt: time.Now()}
+	if err := rr.RevokeCertificateAndRecord("2", cr.AKI, 1, ev); err == nil {
+		t.Fatal("revoking a missing certificate should fail")
+	}
+	if evs, err := ta.Accessor.GetRevocationEvents("2", cr.AKI); err != nil || len(evs) != 0 {
+		t.Fatalf("the event of a failed revocation was recorded: %+v %v", evs, err)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if err := certdb.Revoke(ta.Accessor, cr.Serial, cr.AKI, certdb.ReasonCertificateHold, "alice"); err != nil {
+		t.Fatal(err)
+	}
+	if err := certdb.Unhold(ta.Accessor, cr.Serial, cr.AKI, "bob"); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ta.Accessor.GetCertificate(cr.Serial, cr.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Status != "good" || got[0].Reason != 0 || !got[0].RevokedAt.IsZero() {
+		t.Fatalf("certificate was not released: %+v", got)
+	}
+
+	evs, err := ta.Accessor.GetRevocationEvents(cr.Serial, cr.AKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 {
+		t.Fatalf("want 2 revocation events, got %+v", evs)
+	}
+	if ev := evs[0]; ev.Action != certdb.ActionRevoke || ev.Actor != "alice" || ev.Reason != certdb.ReasonCertificateHold ||
+		ev.PreviousStatus != "good" || !roughlySameTime(ev.CreatedAt, time.Now()) {
+		t.Errorf("unexpected revocation event %+v", ev)
+	}
+	if ev := evs[1]; ev.Action != certdb.ActionUnhold || ev.Actor != "bob" ||
+		ev.PreviousStatus != "revoked" || ev.PreviousReason != certdb.ReasonCertificateHold {
+		t.Errorf("unexpected unhold event %+v", ev)
+	}
+
+	evs, err = ta.Accessor.ListRevocationEvents(start)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 2 {
+		t.Fatalf("want 2 revocation events since %v, got %+v", start, evs)
+	}
+
+	evs, err = ta.Accessor.ListRevocationEvents(time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(evs) != 0 {
+		t.Fatalf("want no revocation events in the future, got %+v", evs)
+	}
+}
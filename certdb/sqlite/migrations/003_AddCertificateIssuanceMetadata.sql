@@ -0,0 +1,30 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE certificates ADD COLUMN common_name bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN sans bytea NOT NULL DEFAULT '[]';
+ALTER TABLE certificates ADD COLUMN profile bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN not_before timestamp NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';
+ALTER TABLE certificates ADD COLUMN requester bytea NOT NULL DEFAULT '';
+ALTER TABLE certificates ADD COLUMN sha256_fingerprint bytea NOT NULL DEFAULT '';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+-- SQLite cannot drop columns, so rebuild the table without the metadata.
+CREATE TABLE certificates_backup (
+  serial_number            bytea NOT NULL,
+  authority_key_identifier bytea NOT NULL,
+  ca_label                 bytea,
+  status                   bytea NOT NULL,
+  reason                   int,
+  expiry                   timestamp,
+  revoked_at               timestamp,
+  issued_at                timestamp NOT NULL DEFAULT '0001-01-01 00:00:00+00:00',
+  pem                      bytea NOT NULL,
+  PRIMARY KEY(serial_number, authority_key_identifier)
+);
+INSERT INTO certificates_backup
+  SELECT serial_number, authority_key_identifier, ca_label, status, reason, expiry, revoked_at, issued_at, pem FROM certificates;
+DROP TABLE certificates;
+ALTER TABLE certificates_backup RENAME TO certificates;
@@ -0,0 +1,20 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+ALTER TABLE crl_numbers ADD COLUMN base_crl_number bigint NOT NULL DEFAULT 0;
+ALTER TABLE crl_numbers ADD COLUMN base_this_update timestamp NOT NULL DEFAULT '0001-01-01 00:00:00+00:00';
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+-- SQLite cannot drop columns, so rebuild the table without the base CRL.
+CREATE TABLE crl_numbers_backup (
+  authority_key_identifier bytea NOT NULL,
+  distribution_point       bytea NOT NULL DEFAULT '',
+  crl_number               bigint NOT NULL,
+  PRIMARY KEY(authority_key_identifier, distribution_point)
+);
+INSERT INTO crl_numbers_backup
+  SELECT authority_key_identifier, distribution_point, crl_number FROM crl_numbers;
+DROP TABLE crl_numbers;
+ALTER TABLE crl_numbers_backup RENAME TO crl_numbers;
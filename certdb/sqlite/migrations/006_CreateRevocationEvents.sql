@@ -0,0 +1,22 @@
+-- +goose Up
+-- SQL in section 'Up' is executed when this migration is applied
+
+CREATE TABLE revocation_events (
+  id                       integer PRIMARY KEY,
+  serial_number            bytea NOT NULL,
+  authority_key_identifier bytea NOT NULL,
+  action                   bytea NOT NULL,
+  actor                    bytea NOT NULL DEFAULT '',
+  reason                   int NOT NULL DEFAULT 0,
+  previous_status          bytea NOT NULL,
+  previous_reason          int NOT NULL DEFAULT 0,
+  created_at               timestamp NOT NULL
+);
+
+CREATE INDEX revocation_events_certificate ON revocation_events (serial_number, authority_key_identifier);
+CREATE INDEX revocation_events_created_at ON revocation_events (created_at);
+
+-- +goose Down
+-- SQL section 'Down' is executed when this migration is rolled back
+
+DROP TABLE revocation_events;
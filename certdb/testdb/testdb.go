@@ -14,6 +14,8 @@ const (
 	mysqlTruncateTables = `
 TRUNCATE certificates;
 TRUNCATE ocsp_responses;
+TRUNCATE crl_numbers;
+TRUNCATE revocation_events;
 `
 
 	pgTruncateTables = `
@@ -37,6 +39,8 @@ SELECT truncate_tables();
 	sqliteTruncateTables = `
 DELETE FROM certificates;
 DELETE FROM ocsp_responses;
+DELETE FROM crl_numbers;
+DELETE FROM revocation_events;
 `
 )
 
@@ -0,0 +1,245 @@
+// Package certdb implements the certdb command.
+package certdb
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/dbconf"
+	"github.com/ucosty/cfssl/certdb/factory"
+	"github.com/ucosty/cfssl/certdb/migrate"
+	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/log"
+)
+
+// Usage text of 'cfssl certdb'
+var certdbUsageText = `cfssl certdb -- inspect and maintain the certificate database
+
+Usage of certdb:
+        cfssl certdb -db-config db-config [-label label] [-status status] [-aki aki] \
+                     [-hostname name] [-expires-after time] [-expires-before time] \
+                     [-issued-after time] [-issued-before time] [-cursor cursor] [-limit n] list
+        cfssl certdb -db-config db-config [-serial serial -aki aki | -since time] events
+        cfssl certdb -db-config db-config migrate [up|down|status]
+
+Subcommands:
+        list    print one page of matching certificate records as JSON; pass
+                the returned next_cursor to -cursor to fetch the next page.
+                -label filters on the CA label, -hostname on the common name
+                or any SAN, -status all disables the status filter, and times
+                are RFC 3339 timestamps.
+        events  print the revocation log as JSON: the revocations and
+                releases from hold of one certificate, or of every
+                certificate since an RFC 3339 timestamp, oldest first.
+        migrate up      apply every pending schema migration (the default).
+        migrate down    roll back the newest applied schema migration.
+        migrate status  print each schema migration and whether it is applied.
+                Migrations only apply to SQL cert dbs.
+
+Flags:
+`
+
+// Flags of 'cfssl certdb'
+var certdbFlags = []string{"db-config", "label", "status", "aki", "hostname", "expires-after", "expires-before",
+	"issued-after", "issued-before", "cursor", "limit", "serial", "since"}
+
+// parseTime parses an optional RFC 3339 timestamp flag.
+func parseTime(name, value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -%s: expected an RFC 3339 timestamp", name)
+	}
+	return t, nil
+}
+
+// QueryFromConfig builds a certdb.CertificateQuery from the command line flags.
+func QueryFromConfig(c cli.Config) (q certdb.CertificateQuery, err error) {
+	q = certdb.CertificateQuery{
+		CALabel:  c.Label,
+		Status:   c.Status,
+		AKI:      c.AKI,
+		Hostname: c.Hostname,
+		Cursor:   c.Cursor,
+		Limit:    c.Limit,
+	}
+	if q.Status == "all" {
+		q.Status = ""
+	}
+
+	if q.ExpiresAfter, err = parseTime("expires-after", c.ExpiresAfter); err != nil {
+		return
+	}
+	if q.ExpiresBefore, err = parseTime("expires-before", c.ExpiresBefore); err != nil {
+		return
+	}
+	if q.IssuedAfter, err = parseTime("issued-after", c.IssuedAfter); err != nil {
+		return
+	}
+	if q.IssuedBefore, err = parseTime("issued-before", c.IssuedBefore); err != nil {
+		return
+	}
+	return q, nil
+}
+
+// listMain prints one page of certificate records.
+func listMain(args []string, c cli.Config) error {
+	if len(args) > 0 {
+		return errors.New("too many arguments are provided, please check with usage")
+	}
+
+	q, err := QueryFromConfig(c)
+	if err != nil {
+		return err
+	}
+
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	page, err := dbAccessor.ListCertificates(q)
+	if err != nil {
+		return err
+	}
+
+	out, err := json.Marshal(page)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// eventsMain prints the revocation events of one certificate, or of every
+// certificate since a time.
+func eventsMain(args []string, c cli.Config) error {
+	if len(args) > 0 {
+		return errors.New("too many arguments are provided, please check with usage")
+	}
+	if (c.Serial == "") == (c.Since == "") {
+		return errors.New("exactly one of -serial and -since is required")
+	}
+
+	since, err := parseTime("since", c.Since)
+	if err != nil {
+		return err
+	}
+
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	var events []certdb.RevocationEvent
+	if c.Serial != "" {
+		events, err = dbAccessor.GetRevocationEvents(c.Serial, c.AKI)
+	} else {
+		events, err = dbAccessor.ListRevocationEvents(since)
+	}
+	if err != nil {
+		return err
+	}
+
+	if events == nil {
+		events = []certdb.RevocationEvent{}
+	}
+	out, err := json.Marshal(events)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+	return nil
+}
+
+// migrateMain applies, rolls back or reports the schema migrations of a SQL
+// cert db.
+func migrateMain(args []string, c cli.Config) error {
+	direction := "up"
+	if len(args) > 0 {
+		var err error
+		direction, args, err = cli.PopFirstArgument(args)
+		if err != nil {
+			return err
+		}
+	}
+	if len(args) > 0 {
+		return errors.New("too many arguments are provided, please check with usage")
+	}
+
+	db, err := dbconf.DBFromConfig(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch direction {
+	case "up":
+		applied, err := migrate.Up(db)
+		for _, m := range applied {
+			log.Infof("applied migration %s", m.Name)
+		}
+		if err != nil {
+			return err
+		}
+		if len(applied) == 0 {
+			log.Info("cert db schema is up to date")
+		}
+		return nil
+	case "down":
+		m, err := migrate.Down(db)
+		if err != nil {
+			return err
+		}
+		if m == nil {
+			log.Info("no migrations to roll back")
+		} else {
+			log.Infof("rolled back migration %s", m.Name)
+		}
+		return nil
+	case "status":
+		status, err := migrate.Status(db)
+		if err != nil {
+			return err
+		}
+
+		out, err := json.Marshal(status)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", out)
+		return nil
+	}
+	return fmt.Errorf("unknown migrate direction %q (expected up, down or status)", direction)
+}
+
+// certdbMain dispatches to the requested subcommand.
+func certdbMain(args []string, c cli.Config) error {
+	subcommand, args, err := cli.PopFirstArgument(args)
+	if err != nil {
+		return err
+	}
+
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	switch subcommand {
+	case "list":
+		return listMain(args, c)
+	case "events":
+		return eventsMain(args, c)
+	case "migrate":
+		return migrateMain(args, c)
+	}
+	return fmt.Errorf("unknown certdb subcommand %q", subcommand)
+}
+
+// Command assembles the definition of Command 'certdb'
+var Command = &cli.Command{UsageText: certdbUsageText, Flags: certdbFlags, Main: certdbMain}
@@ -0,0 +1,119 @@
+package certdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/cli"
+)
+
+const testDBConfig = "../testdata/db-config.json"
+
+func prepDB(t *testing.T) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+	for _, serial := range []string{"1", "2", "3"} {
+		err := dbAccessor.InsertCertificate(certdb.CertificateRecord{
+			Serial:   serial,
+			AKI:      "aki",
+			CALabel:  "ca",
+			Status:   "good",
+			Expiry:   time.Now().Add(time.Hour),
+			IssuedAt: time.Now(),
+			PEM:      "pem",
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestQueryFromConfig(t *testing.T) {
+	q, err := QueryFromConfig(cli.Config{
+		Label:        "ca",
+		Status:       "all",
+		ExpiresAfter: "2017-01-02T15:04:05Z",
+		Limit:        10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if q.Status != "" || q.CALabel != "ca" || q.Limit != 10 {
+		t.Fatalf("unexpected query %+v", q)
+	}
+	if !q.ExpiresAfter.Equal(time.Date(2017, 1, 2, 15, 4, 5, 0, time.UTC)) {
+		t.Fatalf("unexpected expires_after %v", q.ExpiresAfter)
+	}
+
+	if _, err = QueryFromConfig(cli.Config{IssuedAfter: "yesterday"}); err == nil {
+		t.Fatal("expected an error for a malformed timestamp")
+	}
+}
+
+func TestList(t *testing.T) {
+	prepDB(t)
+
+	c := cli.Config{DBConfigFile: testDBConfig, Status: "good", Limit: 2}
+	if err := certdbMain([]string{"list"}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := certdbMain([]string{"list", "extra"}, c); err == nil {
+		t.Fatal("expected an error for extra arguments")
+	}
+
+	if err := certdbMain([]string{"frobnicate"}, c); err == nil {
+		t.Fatal("expected an error for an unknown subcommand")
+	}
+
+	if err := certdbMain([]string{"list"}, cli.Config{}); err == nil {
+		t.Fatal("expected an error without a db config")
+	}
+
+	c.Cursor = "not a cursor"
+	if err := certdbMain([]string{"list"}, c); err == nil {
+		t.Fatal("expected an error for a malformed cursor")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	c := cli.Config{DBConfigFile: testDBConfig}
+	if err := certdbMain([]string{"migrate", "status"}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := certdbMain([]string{"migrate", "sideways"}, c); err == nil {
+		t.Fatal("expected an error for an unknown direction")
+	}
+
+	if err := certdbMain([]string{"migrate", "up", "extra"}, c); err == nil {
+		t.Fatal("expected an error for extra arguments")
+	}
+}
+
+func TestEvents(t *testing.T) {
+	prepDB(t)
+
+	c := cli.Config{DBConfigFile: testDBConfig, Serial: "1", AKI: "aki"}
+	if err := certdbMain([]string{"events"}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	c = cli.Config{DBConfigFile: testDBConfig, Since: "2017-01-02T15:04:05Z"}
+	if err := certdbMain([]string{"events"}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Serial = "1"
+	if err := certdbMain([]string{"events"}, c); err == nil {
+		t.Fatal("expected an error for both -serial and -since")
+	}
+
+	c = cli.Config{DBConfigFile: testDBConfig, Since: "yesterday"}
+	if err := certdbMain([]string{"events"}, c); err == nil {
+		t.Fatal("expected an error for a malformed timestamp")
+	}
+}
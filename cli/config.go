@@ -4,6 +4,7 @@ import (
 	"flag"
 	"time"
 
+	"github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
@@ -50,6 +51,9 @@ type Config struct {
 	Reason            string
 	RevokedAt         string
 	Interval          time.Duration
+	StaleWindow       time.Duration
+	Daemon            bool
+	RefreshInterval   time.Duration
 	List              bool
 	Family            string
 	Timeout           time.Duration
@@ -58,6 +62,7 @@ type Config struct {
 	NumWorkers        int
 	MaxHosts          int
 	Responses         string
+	IssuersFile       string
 	Path              string
 	CRL               string
 	Usage             string
@@ -67,7 +72,28 @@ type Config struct {
 	CNOverride        string
 	AKI               string
 	DBConfigFile      string
+	DBRequireSchema   bool
 	CRLExpiration     time.Duration
+	Delta             bool
+	CRLDir            string
+	ExpiresAfter      string
+	ExpiresBefore     string
+	IssuedAfter       string
+	IssuedBefore      string
+	DryRun            bool
+	Bulk              bool
+	Unhold            bool
+	Since             string
+	RevokeOld         bool
+	EST               bool
+	ACME              bool
+	ACMEProfile       string
+	SCEPChallenge     string
+	SCEPProfile       string
+	SCEPRACert        string
+	SCEPRAKey         string
+	Cursor            string
+	Limit             int
 }
 
 // registerFlags defines all cfssl command flags and associates their values with variables.
@@ -108,14 +134,18 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.StringVar(&c.Reason, "reason", "0", "Reason code for revocation")
 	f.StringVar(&c.RevokedAt, "revoked-at", "now", "Date of revocation (YYYY-MM-DD)")
 	f.DurationVar(&c.Interval, "interval", 4*helpers.OneDay, "Interval between OCSP updates (default: 96h)")
+	f.DurationVar(&c.StaleWindow, "stale-window", helpers.OneDay, "refresh stored OCSP responses expiring within this window (default: 24h)")
+	f.BoolVar(&c.Daemon, "daemon", false, "keep running and refresh OCSP responses every refresh-interval")
+	f.DurationVar(&c.RefreshInterval, "refresh-interval", time.Hour, "time between OCSP refresh runs in daemon mode, or CRL publications (default: 1h)")
 	f.BoolVar(&c.List, "list", false, "list possible scanners")
 	f.StringVar(&c.Family, "family", "", "scanner family regular expression")
 	f.StringVar(&c.Scanner, "scanner", "", "scanner regular expression")
 	f.DurationVar(&c.Timeout, "timeout", 5*time.Minute, "duration (ns, us, ms, s, m, h) to scan each host before timing out")
 	f.StringVar(&c.CSVFile, "csv", "", "file containing CSV of hosts")
-	f.IntVar(&c.NumWorkers, "num-workers", 10, "number of workers to use for scan")
+	f.IntVar(&c.NumWorkers, "num-workers", 10, "number of workers to use for scan or ocsprefresh")
 	f.IntVar(&c.MaxHosts, "max-hosts", 100, "maximum number of hosts to scan")
 	f.StringVar(&c.Responses, "responses", "", "file to load OCSP responses from")
+	f.StringVar(&c.IssuersFile, "issuers", "", "JSON file listing the issuers an OCSP responder serves")
 	f.StringVar(&c.Path, "path", "/", "Path on which the server will listen")
 	f.StringVar(&c.CRL, "crl", "", "CRL URL Override")
 	f.StringVar(&c.Password, "password", "0", "Password for accessing PKCS #12 data passed to bundler")
@@ -126,7 +156,28 @@ func registerFlags(c *Config, f *flag.FlagSet) {
 	f.StringVar(&c.CNOverride, "cn", "", "certificate common name (CN)")
 	f.StringVar(&c.AKI, "aki", "", "certificate issuer (authority) key identifier")
 	f.StringVar(&c.DBConfigFile, "db-config", "", "certificate db configuration file")
+	f.BoolVar(&c.DBRequireSchema, "db-require-schema", false, "refuse to start when the SQL certificate db has pending schema migrations")
 	f.DurationVar(&c.CRLExpiration, "expiry", 7*helpers.OneDay, "time from now after which the CRL will expire (default: one week)")
+	f.StringVar(&c.CRLDir, "crl-dir", "", "directory to write published CRLs to")
+	f.BoolVar(&c.Delta, "delta", false, "generate a delta CRL of the revocations since the last full CRL")
+	f.StringVar(&c.ExpiresAfter, "expires-after", "", "only list certificates expiring after this RFC 3339 time")
+	f.StringVar(&c.ExpiresBefore, "expires-before", "", "only list certificates expiring before this RFC 3339 time")
+	f.StringVar(&c.IssuedAfter, "issued-after", "", "only list certificates issued after this RFC 3339 time")
+	f.StringVar(&c.IssuedBefore, "issued-before", "", "only list certificates issued before this RFC 3339 time")
+	f.BoolVar(&c.Bulk, "bulk", false, "revoke every certificate matching -label, -aki, -hostname and the issued-after/-before window")
+	f.BoolVar(&c.DryRun, "dry-run", false, "list the certificates a bulk revocation would revoke without revoking them")
+	f.BoolVar(&c.Unhold, "unhold", false, "release a certificate revoked with reason certificateHold instead of revoking it")
+	f.StringVar(&c.Since, "since", "", "only list revocation events created at or after this RFC 3339 time")
+	f.BoolVar(&c.RevokeOld, "revoke-old", false, "revoke the renewed certificate with reason superseded")
+	f.BoolVar(&c.EST, "est", false, "serve EST (RFC 7030) under /.well-known/est/ with the signer")
+	f.BoolVar(&c.ACME, "acme", false, "serve an ACME (RFC 8555) server under /acme/ that issues with the signer")
+	f.StringVar(&c.ACMEProfile, "acme-profile", "", "signing profile of the certificates issued over ACME")
+	f.StringVar(&c.SCEPChallenge, "scep-challenge", "", "serve SCEP under /scep/, requiring this challenge password for enrollment")
+	f.StringVar(&c.SCEPProfile, "scep-profile", "", "signing profile of the certificates issued over SCEP")
+	f.StringVar(&c.SCEPRACert, "scep-ra-cert", "", "RA certificate, issued by the CA, that SCEP requests are encrypted to")
+	f.StringVar(&c.SCEPRAKey, "scep-ra-key", "", "RSA private key of the SCEP RA certificate")
+	f.StringVar(&c.Cursor, "cursor", "", "resume a certificate listing from the cursor of a previous page")
+	f.IntVar(&c.Limit, "limit", certdb.DefaultPageSize, "maximum number of certificates to list per page")
 	f.IntVar(&log.Level, "loglevel", log.LevelInfo, "Log level (0 = DEBUG, 5 = FATAL)")
 }
 
@@ -141,3 +192,13 @@ func RootFromConfig(c *Config) universal.Root {
 		ForceRemote: c.Remote != "",
 	}
 }
+
+// CRLPartitionsFromConfig returns the CRL partitions of the -profile
+// signing profile in the -config file, or nil if the CRL is not
+// partitioned.
+func CRLPartitionsFromConfig(c *Config) *config.CRLPartitions {
+	if c.CFG == nil {
+		return nil
+	}
+	return c.CFG.Signing.PartitionsFor(c.Profile)
+}
@@ -2,68 +2,85 @@
 package crl
 
 import (
+	"crypto"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 
-	"github.com/ucosty/cfssl/certdb/dbconf"
-	certsql "github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/cli"
 	"github.com/ucosty/cfssl/crl"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
-
-	"github.com/jmoiron/sqlx"
 )
 
 var crlUsageText = `cfssl crl -- generate a new Certificate Revocation List from Database
 
+The CRL is numbered with the next CRL number stored in the database for the
+CA and distribution point. If -crl is given, the CRL names it in an Issuing
+Distribution Point extension.
+
+If the signing profile in -config (selected with -profile) splits the CRL
+into partitions, -crl selects the partition to generate. Without it, a CRL is
+generated for every partition, and they are printed as a JSON object mapping
+each partition's URL to its base64 encoded CRL.
+
+With -delta, a delta CRL is generated instead: it lists only the certificates
+revoked since the last full CRL of the distribution point, whose CRL number it
+references in a Delta CRL Indicator extension.
+
 Usage of crl:
-        cfssl crl
+        cfssl crl -db-config db-config -ca cert -ca-key key [-expiry 168h] [-crl url] [-delta]
+        cfssl crl -db-config db-config -ca cert -ca-key key -config config [-profile profile] [-expiry 168h] [-delta]
 
 Flags:
 `
-var crlFlags = []string{"db-config", "ca", "ca-key", "expiry"}
+var crlFlags = []string{"db-config", "ca", "ca-key", "expiry", "crl", "delta", "config", "profile"}
 
-func generateCRL(c cli.Config) (crlBytes []byte, err error) {
+// loadIssuer opens the cert db and loads the CA certificate and key that
+// sign the CRLs.
+func loadIssuer(c cli.Config) (certdb.Accessor, *x509.Certificate, crypto.Signer, error) {
 	if c.CAFile == "" {
 		log.Error("need CA certificate (provide one with -ca)")
-		return
+		return nil, nil, nil, errors.New("need CA certificate (provide one with -ca)")
 	}
 
 	if c.CAKeyFile == "" {
 		log.Error("need CA key (provide one with -ca-key)")
-		return
+		return nil, nil, nil, errors.New("need CA key (provide one with -ca-key)")
 	}
 
-	var db *sqlx.DB
-	if c.DBConfigFile != "" {
-		db, err = dbconf.DBFromConfig(c.DBConfigFile)
-		if err != nil {
-			return nil, err
-		}
-	} else {
+	if c.DBConfigFile == "" {
 		log.Error("no Database specified!")
-		return nil, err
+		return nil, nil, nil, errors.New("need DB config file (provide with -db-config)")
 	}
 
-	dbAccessor := certsql.NewAccessor(db)
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
 	log.Debug("loading CA: ", c.CAFile)
 	ca, err := ioutil.ReadFile(c.CAFile)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 	log.Debug("loading CA key: ", c.CAKeyFile)
 	cakey, err := ioutil.ReadFile(c.CAKeyFile)
 	if err != nil {
-		return nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+		return nil, nil, nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
 	}
 
 	// Parse the PEM encoded certificate
 	issuerCert, err := helpers.ParseCertificatePEM(ca)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, err
 	}
 
 	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
@@ -76,23 +93,61 @@ func generateCRL(c cli.Config) (crlBytes []byte, err error) {
 	key, err := helpers.ParsePrivateKeyPEMWithPassword(cakey, password)
 	if err != nil {
 		log.Debug("malformed private key %v", err)
-		return nil, err
+		return nil, nil, nil, err
 	}
 
-	certs, err := dbAccessor.GetRevokedAndUnexpiredCertificates()
+	return dbAccessor, issuerCert, key, nil
+}
+
+func crlOptions(c cli.Config) crl.Options {
+	return crl.Options{
+		Expiry:            c.CRLExpiration,
+		DistributionPoint: c.CRL,
+		Delta:             c.Delta,
+		Partitions:        cli.CRLPartitionsFromConfig(&c),
+	}
+}
+
+func generateCRL(c cli.Config) (crlBytes []byte, err error) {
+	dbAccessor, issuerCert, key, err := loadIssuer(c)
 	if err != nil {
 		return nil, err
 	}
 
-	req, err := crl.NewCRLFromDB(certs, issuerCert, key, c.CRLExpiration)
+	return crl.NewCRLFromAccessor(dbAccessor, issuerCert, key, crlOptions(c))
+}
+
+// generatePartitionedCRLs generates a CRL for every partition of the CRL,
+// keyed by the partition's URL.
+func generatePartitionedCRLs(c cli.Config) (map[string][]byte, error) {
+	dbAccessor, issuerCert, key, err := loadIssuer(c)
 	if err != nil {
 		return nil, err
 	}
 
-	return req, nil
+	opts := crlOptions(c)
+	return crl.NewPartitionedCRLsFromAccessor(dbAccessor, issuerCert, key, opts, opts.Partitions)
 }
 
 func crlMain(args []string, c cli.Config) (err error) {
+	if partitions := cli.CRLPartitionsFromConfig(&c); partitions != nil && c.CRL == "" {
+		crls, err := generatePartitionedCRLs(c)
+		if err != nil {
+			return err
+		}
+
+		out := make(map[string]string, len(crls))
+		for url, crlBytes := range crls {
+			out[url] = base64.StdEncoding.EncodeToString(crlBytes)
+		}
+		jsonOut, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("%s\n", jsonOut)
+		return nil
+	}
+
 	req, err := generateCRL(c)
 	if err != nil {
 		return err
@@ -0,0 +1,185 @@
+// Package crlserve implements the crlserve command.
+package crlserve
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/ucosty/cfssl/certdb/factory"
+	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/crl"
+	cferr "github.com/ucosty/cfssl/errors"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+)
+
+// Usage text of 'cfssl crlserve'
+var crlserveUsageText = `cfssl crlserve -- publish the CRL of a CA from the certificate database
+and serve it over HTTP
+
+A new CRL is generated every -refresh-interval, valid for -expiry. With
+-crl-dir, it is also written to crl.der (DER) and crl.pem (PEM) in that
+directory; each file is replaced atomically. The CRL is served as DER
+with the application/pkix-crl content type, or as PEM for request paths
+ending in ".pem" (the path with ".pem" appended, for a path without a
+trailing slash), and may be cached until the next publication.
+
+If -crl is given, the CRL names it in an Issuing Distribution Point
+extension; it should be the URL the CRL is served at.
+
+If the signing profile in -config (selected with -profile) splits the CRL
+into partitions and -crl is not given, a CRL is published for every
+partition and served at the path of the partition's URL. With -crl-dir, the
+files of the i-th partition are written to the subdirectory named i.
+
+Usage of crlserve:
+        cfssl crlserve -db-config db-config -ca cert -ca-key key [-address address] [-port port] \
+                       [-path path] [-crl url] [-crl-dir dir] [-expiry 168h] [-refresh-interval 1h] \
+                       [-config config [-profile profile]]
+
+Flags:
+`
+
+// Flags of 'cfssl crlserve'
+var crlserveFlags = []string{"address", "port", "path", "db-config", "ca", "ca-key", "crl", "crl-dir",
+	"expiry", "refresh-interval", "config", "profile"}
+
+// crlserveMain is the command line entry point to the CRL publisher.
+func crlserveMain(args []string, c cli.Config) error {
+	if len(args) > 0 {
+		return errors.New("argument is provided but not defined; please refer to the usage by flag -h")
+	}
+
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	if c.CAFile == "" {
+		return errors.New("need CA certificate (provide with -ca)")
+	}
+
+	if c.CAKeyFile == "" {
+		return errors.New("need CA key (provide with -ca-key)")
+	}
+
+	if c.RefreshInterval <= 0 {
+		return errors.New("need a positive refresh interval (provide with -refresh-interval)")
+	}
+
+	if c.CRLExpiration <= c.RefreshInterval {
+		log.Warningf("CRLs expire after %s, before the next one is published in %s", c.CRLExpiration, c.RefreshInterval)
+	}
+
+	publisher, handlers, err := publisherFromConfig(c)
+	if err != nil {
+		return err
+	}
+
+	// Publish once before listening so the first requests are served.
+	if err = publisher.Publish(); err != nil {
+		return err
+	}
+	go publisher.Run(nil)
+
+	for path, handler := range handlers {
+		log.Info("Registering CRL handler at ", path)
+		http.Handle(path, handler)
+		// A path without a trailing slash only matches itself, so the PEM
+		// CRL needs its own route.
+		if !strings.HasSuffix(path, "/") {
+			http.Handle(path+".pem", handler)
+		}
+	}
+
+	addr := fmt.Sprintf("%s:%d", c.Address, c.Port)
+	log.Info("Now listening on ", addr)
+	return http.ListenAndServe(addr, nil)
+}
+
+// publisherFromConfig loads the CA certificate and key and creates the
+// crl.Publisher of their CRLs, along with the handlers serving them keyed
+// by the path they are served at. Partitioned CRLs are all published by
+// the one Publisher, from a single read of the cert db.
+func publisherFromConfig(c cli.Config) (*crl.Publisher, map[string]http.Handler, error) {
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ca, err := ioutil.ReadFile(c.CAFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	cakey, err := ioutil.ReadFile(c.CAKeyFile)
+	if err != nil {
+		return nil, nil, cferr.Wrap(cferr.CertificateError, cferr.ReadFailed, err)
+	}
+
+	issuerCert, err := helpers.ParseCertificatePEM(ca)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	strPassword := os.Getenv("CFSSL_CA_PK_PASSWORD")
+	password := []byte(strPassword)
+	if strPassword == "" {
+		password = nil
+	}
+
+	key, err := helpers.ParsePrivateKeyPEMWithPassword(cakey, password)
+	if err != nil {
+		log.Debugf("malformed private key %v", err)
+		return nil, nil, err
+	}
+
+	opts := crl.Options{
+		Expiry:            c.CRLExpiration,
+		DistributionPoint: c.CRL,
+		Partitions:        cli.CRLPartitionsFromConfig(&c),
+	}
+	if opts.Partitions == nil || c.CRL != "" {
+		publisher := crl.NewPublisher(dbAccessor, issuerCert, key, opts, c.CRLDir, c.RefreshInterval)
+		return publisher, map[string]http.Handler{c.Path: publisher}, nil
+	}
+
+	paths := make(map[string]string, len(opts.Partitions.URLs))
+	dirs := make(map[string]string, len(opts.Partitions.URLs))
+	for i, partition := range opts.Partitions.URLs {
+		u, err := url.Parse(partition)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !strings.HasPrefix(u.Path, "/") {
+			return nil, nil, fmt.Errorf("CRL partition %s has no path to serve it at", partition)
+		}
+		if _, ok := paths[u.Path]; ok {
+			return nil, nil, fmt.Errorf("CRL partitions share the path %s", u.Path)
+		}
+		paths[u.Path] = partition
+
+		if c.CRLDir != "" {
+			dir := filepath.Join(c.CRLDir, strconv.Itoa(i))
+			if err = os.MkdirAll(dir, 0755); err != nil {
+				return nil, nil, err
+			}
+			dirs[partition] = dir
+		}
+	}
+
+	publisher := crl.NewPartitionedPublisher(dbAccessor, issuerCert, key, opts, dirs, c.RefreshInterval)
+	handlers := make(map[string]http.Handler, len(paths))
+	for path, partition := range paths {
+		handlers[path] = publisher.Handler(partition)
+	}
+	return publisher, handlers, nil
+}
+
+// Command assembles the definition of Command 'crlserve'
+var Command = &cli.Command{UsageText: crlserveUsageText, Flags: crlserveFlags, Main: crlserveMain}
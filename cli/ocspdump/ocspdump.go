@@ -29,7 +29,11 @@ func ocspdumpMain(args []string, c cli.Config) error {
 		return errors.New("need DB config file (provide with -db-config)")
 	}
 
-	dbAccessor := certdbfactory.NewAccessor(c.DBConfigFile)
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
 	records, err := dbAccessor.GetUnexpiredOCSPs()
 	if err != nil {
 		return err
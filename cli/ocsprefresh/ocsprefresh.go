@@ -2,29 +2,158 @@
 package ocsprefresh
 
 import (
-	"encoding/hex"
 	"errors"
+	"fmt"
+	"sync"
 	"time"
 
+	"github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/cli"
-	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
 	"github.com/ucosty/cfssl/ocsp"
+	stdocsp "golang.org/x/crypto/ocsp"
 )
 
 // Usage text of 'cfssl ocsprefresh'
 var ocsprefreshUsageText = `cfssl ocsprefresh -- refreshes the ocsp_responses table
-with new OCSP responses for all known unexpired certificates
+with new OCSP responses for known unexpired certificates
+
+Only certificates without a stored response, whose stored response expires
+within the staleness window, or whose stored response no longer matches
+their status are re-signed. Failures are reported per certificate and do
+not stop the run.
 
 Usage of ocsprefresh:
-        cfssl ocsprefresh -db-config db-config -ca cert -responder cert -responder-key key [-interval 96h]
+        cfssl ocsprefresh -db-config db-config -ca cert -responder cert -responder-key key [-interval 96h] \
+                          [-stale-window 24h] [-num-workers 10] [-daemon [-refresh-interval 1h]]
 
 Flags:
 `
 
 // Flags of 'cfssl ocsprefresh'
-var ocsprefreshFlags = []string{"ca", "responder", "responder-key", "db-config", "interval"}
+var ocsprefreshFlags = []string{"ca", "responder", "responder-key", "db-config", "interval", "stale-window",
+	"num-workers", "daemon", "refresh-interval"}
+
+// A failure records a certificate whose OCSP response could not be
+// refreshed.
+type failure struct {
+	Serial string
+	AKI    string
+	Err    error
+}
+
+// A summary reports the outcome of one refresh run.
+type summary struct {
+	Checked   int
+	Refreshed int
+	Failures  []failure
+}
+
+// needsRefresh reports whether the stored response for cr, if any, must be
+// replaced: it is missing, expires before the staleness deadline, or
+// reports a different status than the certificate record.
+func needsRefresh(cr certdb.CertificateRecord, stored *certdb.OCSPRecord, deadline time.Time) bool {
+	if stored == nil || stored.Expiry.Before(deadline) {
+		return true
+	}
+
+	resp, err := stdocsp.ParseResponse([]byte(stored.Body), nil)
+	if err != nil {
+		return true
+	}
+	return resp.Status != ocsp.StatusCode[cr.Status]
+}
+
+// refresh signs and stores new OCSP responses for the unexpired
+// certificates that need one, using workers concurrent signers. Only
+// failing to read the cert db is returned as an error; per-certificate
+// failures are collected in the summary.
+func refresh(s ocsp.Signer, dbAccessor certdb.Accessor, staleWindow time.Duration, workers int) (sum summary, err error) {
+	certs, err := dbAccessor.GetUnexpiredCertificates()
+	if err != nil {
+		return sum, err
+	}
+
+	ocsps, err := dbAccessor.GetUnexpiredOCSPs()
+	if err != nil {
+		return sum, err
+	}
+
+	stored := make(map[[2]string]*certdb.OCSPRecord, len(ocsps))
+	for i := range ocsps {
+		stored[[2]string{ocsps[i].Serial, ocsps[i].AKI}] = &ocsps[i]
+	}
+
+	deadline := time.Now().Add(staleWindow)
+	var stale []certdb.CertificateRecord
+	for _, cr := range certs {
+		if needsRefresh(cr, stored[[2]string{cr.Serial, cr.AKI}], deadline) {
+			stale = append(stale, cr)
+		}
+	}
+	sum.Checked = len(certs)
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan certdb.CertificateRecord)
+	results := make(chan *failure)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for cr := range jobs {
+				if err := ocsp.StoreResponse(s, dbAccessor, cr); err != nil {
+					results <- &failure{Serial: cr.Serial, AKI: cr.AKI, Err: err}
+					continue
+				}
+				results <- nil
+			}
+		}()
+	}
+
+	go func() {
+		for _, cr := range stale {
+			jobs <- cr
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	for f := range results {
+		if f != nil {
+			sum.Failures = append(sum.Failures, *f)
+			continue
+		}
+		sum.Refreshed++
+	}
+	return sum, nil
+}
+
+// runOnce performs one refresh run and logs its summary. It returns an
+// error if the run could not complete or any certificate failed.
+func runOnce(s ocsp.Signer, dbAccessor certdb.Accessor, c cli.Config) error {
+	sum, err := refresh(s, dbAccessor, c.StaleWindow, c.NumWorkers)
+	if err != nil {
+		log.Errorf("OCSP refresh failed: %v", err)
+		return err
+	}
+
+	for _, f := range sum.Failures {
+		log.Errorf("failed to refresh OCSP response for serial %s (AKI %s): %v", f.Serial, f.AKI, f.Err)
+	}
+	log.Infof("OCSP refresh: checked %d certificates, refreshed %d, %d failed",
+		sum.Checked, sum.Refreshed, len(sum.Failures))
+
+	if len(sum.Failures) > 0 {
+		return fmt.Errorf("failed to refresh %d of %d OCSP responses", len(sum.Failures), sum.Refreshed+len(sum.Failures))
+	}
+	return nil
+}
 
 // ocsprefreshMain is the main CLI of OCSP refresh functionality.
 func ocsprefreshMain(args []string, c cli.Config) error {
@@ -44,51 +173,31 @@ func ocsprefreshMain(args []string, c cli.Config) error {
 		return errors.New("need CA certificate (provide with -ca)")
 	}
 
+	if c.Daemon && c.RefreshInterval <= 0 {
+		return errors.New("need a positive refresh interval in daemon mode (provide with -refresh-interval)")
+	}
+
 	s, err := SignerFromConfig(c)
 	if err != nil {
 		log.Critical("Unable to create OCSP signer: ", err)
 		return err
 	}
 
-	dbAccessor := certdbfactory.NewAccessor(c.DBConfigFile)
-	certs, err := dbAccessor.GetUnexpiredCertificates()
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
 	if err != nil {
 		return err
 	}
 
-	// Set an expiry timestamp for all certificates refreshed in this batch
-	ocspExpiry := time.Now().Add(c.Interval)
-	for _, certRecord := range certs {
-		cert, err := helpers.ParseCertificatePEM([]byte(certRecord.PEM))
-		if err != nil {
-			log.Critical("Unable to parse certificate: ", err)
-			return err
-		}
-
-		req := ocsp.SignRequest{
-			Certificate: cert,
-			Status:      certRecord.Status,
-		}
-
-		if certRecord.Status == "revoked" {
-			req.Reason = int(certRecord.Reason)
-			req.RevokedAt = certRecord.RevokedAt
-		}
-
-		resp, err := s.Sign(req)
-		if err != nil {
-			log.Critical("Unable to sign OCSP response: ", err)
-			return err
-		}
-
-		err = dbAccessor.UpsertOCSP(cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId), string(resp), ocspExpiry)
-		if err != nil {
-			log.Critical("Unable to save OCSP response: ", err)
-			return err
-		}
+	if !c.Daemon {
+		return runOnce(s, dbAccessor, c)
 	}
 
-	return nil
+	log.Infof("refreshing OCSP responses every %s", c.RefreshInterval)
+	for {
+		// Errors are logged by runOnce and retried on the next run.
+		runOnce(s, dbAccessor, c)
+		time.Sleep(c.RefreshInterval)
+	}
 }
 
 // SignerFromConfig creates a signer from a cli.Config as a helper for cli and serve
@@ -10,6 +10,7 @@ import (
 	"github.com/ucosty/cfssl/certdb/testdb"
 	"github.com/ucosty/cfssl/cli"
 	"github.com/ucosty/cfssl/helpers"
+	cfocsp "github.com/ucosty/cfssl/ocsp"
 	"golang.org/x/crypto/ocsp"
 	"io/ioutil"
 )
@@ -107,3 +108,84 @@ func TestOCSPRefreshMain(t *testing.T) {
 		t.Fatal("Expected cert status 'revoked'")
 	}
 }
+
+func TestRefreshIncremental(t *testing.T) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor = sql.NewAccessor(db)
+
+	certPEM, err := ioutil.ReadFile("../../ocsp/testdata/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expirationTime := time.Now().AddDate(1, 0, 0)
+	records := []certdb.CertificateRecord{
+		{
+			Serial: cert.SerialNumber.String(),
+			AKI:    hex.EncodeToString(cert.AuthorityKeyId),
+			Expiry: expirationTime,
+			PEM:    string(certPEM),
+			Status: "good",
+		},
+		{
+			Serial: "bad",
+			AKI:    "bad",
+			Expiry: expirationTime,
+			PEM:    "not a certificate",
+			Status: "good",
+		},
+	}
+	for _, cr := range records {
+		if err = dbAccessor.InsertCertificate(cr); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := cfocsp.NewSignerFromFile("../../ocsp/testdata/ca.pem", "../../ocsp/testdata/server.crt",
+		"../../ocsp/testdata/server.key", helpers.OneDay)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The malformed record fails without stopping the run.
+	sum, err := refresh(s, dbAccessor, time.Hour, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Checked != 2 || sum.Refreshed != 1 || len(sum.Failures) != 1 || sum.Failures[0].Serial != "bad" {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+
+	// The stored response is fresh, so only the failing record is retried.
+	sum, err = refresh(s, dbAccessor, time.Hour, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Refreshed != 0 || len(sum.Failures) != 1 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+
+	// A window longer than the response lifetime refreshes it again.
+	sum, err = refresh(s, dbAccessor, 2*helpers.OneDay, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sum.Refreshed != 1 {
+		t.Fatalf("unexpected summary %+v", sum)
+	}
+
+	err = ocsprefreshMain([]string{}, cli.Config{
+		CAFile:           "../../ocsp/testdata/ca.pem",
+		ResponderFile:    "../../ocsp/testdata/server.crt",
+		ResponderKeyFile: "../../ocsp/testdata/server.key",
+		DBConfigFile:     "../testdata/db-config.json",
+		Interval:         helpers.OneDay,
+	})
+	if err == nil {
+		t.Fatal("expected an error reporting the failed certificate")
+	}
+}
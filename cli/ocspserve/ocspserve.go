@@ -4,24 +4,46 @@ package ocspserve
 import (
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
 	"github.com/ucosty/cfssl/ocsp"
+	ocspConfig "github.com/ucosty/cfssl/ocsp/config"
 )
 
 // Usage text of 'cfssl serve'
-var ocspServerUsageText = `cfssl ocspserve -- set up an HTTP server that handles OCSP requests from a file (see RFC 5019)
+var ocspServerUsageText = `cfssl ocspserve -- set up an HTTP server that handles OCSP requests from a file
+or a certificate database (see RFC 5019)
+
+With -db-config, pre-signed responses are served from the database. If a
+responder certificate and key are also given, requests carrying a nonce
+(RFC 8954) are answered with a response signed on demand that echoes it.
+
+With -ca or -issuers, only requests for the given issuers are answered;
+requests for any other issuer get an unauthorized response. The issuers
+file is a JSON array of objects with a "ca" certificate and, for signing
+on demand, a "responder" certificate and "responder_key":
+
+  [{"ca": "ca1.pem", "responder": "ocsp1.pem", "responder_key": "ocsp1-key.pem"},
+   {"ca": "ca2.pem"}]
 
   Usage of ocspserve:
           cfssl ocspserve [-address address] [-port port] [-responses file]
+          cfssl ocspserve [-address address] [-port port] -db-config db-config \
+                          [-ca cert -responder cert -responder-key key [-interval 96h]]
+          cfssl ocspserve [-address address] [-port port] {-responses file | -db-config db-config} \
+                          -issuers file [-interval 96h]
 
   Flags:
   `
 
 // Flags used by 'cfssl serve'
-var ocspServerFlags = []string{"address", "port", "responses"}
+var ocspServerFlags = []string{"address", "port", "responses", "db-config", "ca", "responder", "responder-key", "interval", "issuers"}
 
 // ocspServerMain is the command line entry point to the OCSP responder.
 // It sets up a new HTTP server that responds to OCSP requests.
@@ -31,13 +53,9 @@ func ocspServerMain(args []string, c cli.Config) error {
 		return errors.New("argument is provided but not defined; please refer to the usage by flag -h")
 	}
 
-	if c.Responses == "" {
-		return errors.New("no response file provided, please set the -responses flag")
-	}
-
-	src, err := ocsp.NewSourceFromFile(c.Responses)
+	src, err := sourceFromConfig(c)
 	if err != nil {
-		return errors.New("unable to read response file")
+		return err
 	}
 
 	log.Info("Registering OCSP responder handler")
@@ -48,5 +66,74 @@ func ocspServerMain(args []string, c cli.Config) error {
 	return http.ListenAndServe(addr, nil)
 }
 
+// sourceFromConfig creates the Source of OCSP responses selected by the
+// -responses and -db-config flags. If issuers are configured with -issuers
+// or -ca, requests are routed to them and requests for other issuers are
+// rejected.
+func sourceFromConfig(c cli.Config) (ocsp.Source, error) {
+	var base ocsp.Source
+	var dbAccessor certdb.Accessor
+	var err error
+	if c.DBConfigFile != "" {
+		dbAccessor, err = certdbfactory.NewAccessor(c.DBConfigFile)
+		if err != nil {
+			return nil, err
+		}
+		base = ocsp.NewDBSource(dbAccessor)
+	} else {
+		if c.Responses == "" {
+			return nil, errors.New("no response file provided, please set the -responses or -db-config flag")
+		}
+
+		base, err = ocsp.NewSourceFromFile(c.Responses)
+		if err != nil {
+			return nil, errors.New("unable to read response file")
+		}
+	}
+
+	var issuers []ocspConfig.Issuer
+	if c.IssuersFile != "" {
+		issuers, err = ocspConfig.LoadIssuersFile(c.IssuersFile)
+		if err != nil {
+			return nil, err
+		}
+	} else if c.CAFile != "" {
+		issuers = []ocspConfig.Issuer{{
+			CACertFile:        c.CAFile,
+			ResponderCertFile: c.ResponderFile,
+			KeyFile:           c.ResponderKeyFile,
+		}}
+	} else {
+		return base, nil
+	}
+
+	src := ocsp.NewIssuerSource()
+	for _, issuer := range issuers {
+		certPEM, err := ioutil.ReadFile(issuer.CACertFile)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := helpers.ParseCertificatePEM(certPEM)
+		if err != nil {
+			return nil, err
+		}
+
+		issuerSource := base
+		if dbAccessor != nil && issuer.ResponderCertFile != "" {
+			signer, err := ocsp.NewSignerFromFile(issuer.CACertFile, issuer.ResponderCertFile, issuer.KeyFile, c.Interval)
+			if err != nil {
+				return nil, err
+			}
+			issuerSource = ocsp.NewLiveSource(signer, dbAccessor)
+		}
+
+		if err = src.AddIssuer(cert, issuerSource); err != nil {
+			return nil, err
+		}
+		log.Infof("Serving OCSP responses for %s", cert.Subject.CommonName)
+	}
+	return src, nil
+}
+
 // Command assembles the definition of Command 'ocspserve'
 var Command = &cli.Command{UsageText: ocspServerUsageText, Flags: ocspServerFlags, Main: ocspServerMain}
@@ -0,0 +1,170 @@
+// Package renew implements the renew command.
+package renew
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"os/user"
+
+	"github.com/ucosty/cfssl/api/client"
+	"github.com/ucosty/cfssl/api/renew"
+	certdbfactory "github.com/ucosty/cfssl/certdb/factory"
+	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/cli/ocspsign"
+	"github.com/ucosty/cfssl/cli/sign"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/ocsp"
+	"github.com/ucosty/cfssl/signer"
+)
+
+var renewUsageText = `cfssl renew -- reissue a certificate recorded in the certificate store
+
+Usage:
+
+	   cfssl renew -db-config config_file -ca cert -ca-key key [-config config] \
+	               -serial serial -aki authority_key_id -key key_file [-revoke-old] \
+	               [-responder cert -responder-key key]
+	   cfssl renew -remote remote_host -serial serial -aki authority_key_id -key key_file [-revoke-old]
+
+The new certificate has the subject, public key and subject alternative names
+of the old one, and is signed with the profile and CA label recorded for it.
+Possession of the private key is proven by signing a challenge with -key,
+which covers a single-use nonce issued by the server.
+
+With -revoke-old, the old certificate is revoked with reason superseded once
+the new one is issued. If a responder certificate and key are given, its OCSP
+response is updated as well.
+
+Flags:
+`
+
+var renewFlags = []string{"serial", "aki", "key", "revoke-old", "ca", "ca-key", "config",
+	"db-config", "remote", "responder", "responder-key", "interval"}
+
+// requester identifies the user running the command in the cert db.
+func requester() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
+
+// renewRequest builds the renewal request for nonce, proving possession
+// of the key named by -key.
+func renewRequest(c cli.Config, nonce string) (req renew.Request, err error) {
+	req = renew.Request{
+		Serial:    c.Serial,
+		AKI:       c.AKI,
+		Nonce:     nonce,
+		RevokeOld: c.RevokeOld,
+	}
+
+	keyPEM, err := ioutil.ReadFile(c.KeyFile)
+	if err != nil {
+		return req, err
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		return req, err
+	}
+	req.Signature, err = renew.SignChallenge(key, req)
+	return req, err
+}
+
+func renewMain(args []string, c cli.Config) error {
+	if len(args) > 0 {
+		return errors.New("argument is provided but not defined; please refer to the usage by flag -h")
+	}
+
+	if len(c.Serial) == 0 {
+		return errors.New("serial number is required but not provided")
+	}
+
+	if len(c.AKI) == 0 {
+		return errors.New("authority key id is required but not provided")
+	}
+
+	if c.KeyFile == "" {
+		return errors.New("need the private key of the certificate (provide with -key)")
+	}
+
+	if c.Remote != "" {
+		remote, err := client.NewClient(c.Remote, nil)
+		if err != nil {
+			return err
+		}
+		nonce, err := remote.RenewNonce(context.Background())
+		if err != nil {
+			return err
+		}
+		req, err := renewRequest(c, nonce)
+		if err != nil {
+			return err
+		}
+		cert, err := remote.Renew(context.Background(), req)
+		if err != nil {
+			return err
+		}
+		cli.PrintCert(nil, nil, cert)
+		return nil
+	}
+
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	if c.CFG == nil {
+		if c.CAFile == "" {
+			return errors.New("need CA certificate (provide one with -ca)")
+		}
+
+		if c.CAKeyFile == "" {
+			return errors.New("need CA key (provide one with -ca-key)")
+		}
+	}
+
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	s, err := sign.SignerFromConfigAndDB(c, dbAccessor)
+	if err != nil {
+		return err
+	}
+	reissuer, ok := s.(signer.Reissuer)
+	if !ok {
+		return errors.New("the signer cannot reissue certificates")
+	}
+
+	var ocspSigner ocsp.Signer
+	if c.RevokeOld && c.ResponderFile != "" {
+		ocspSigner, err = ocspsign.SignerFromConfig(c)
+		if err != nil {
+			log.Error("Unable to create OCSP signer: ", err)
+			return err
+		}
+	}
+
+	nonces := renew.NewNonceStore()
+	nonce, err := nonces.New()
+	if err != nil {
+		return err
+	}
+	req, err := renewRequest(c, nonce)
+	if err != nil {
+		return err
+	}
+
+	cert, err := renew.Renew(dbAccessor, reissuer, ocspSigner, nonces, req, requester())
+	if cert != nil {
+		cli.PrintCert(nil, nil, cert)
+	}
+	return err
+}
+
+// Command assembles the definition of Command 'renew'
+var Command = &cli.Command{UsageText: renewUsageText, Flags: renewFlags, Main: renewMain}
@@ -2,10 +2,18 @@
 package revoke
 
 import (
+	"encoding/json"
 	"errors"
-	// "fmt"
+	"fmt"
+	"os"
+	"os/user"
+
+	"github.com/ucosty/cfssl/api/revoke"
+	cfcertdb "github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/cli/certdb"
+	"github.com/ucosty/cfssl/cli/ocspsign"
 	"github.com/ucosty/cfssl/log"
 	"github.com/ucosty/cfssl/ocsp"
 )
@@ -15,20 +23,59 @@ var revokeUsageTxt = `cfssl revoke -- revoke a certificate in the certificate st
 Usage:
 
 Revoke a certificate:
-	   cfssl revoke -db-config config_file -serial serial -aki authority_key_id [-reason reason]
+	   cfssl revoke -db-config config_file -serial serial -aki authority_key_id [-reason reason] \
+	                [-ca cert -responder cert -responder-key key [-interval 96h]]
+
+Release a certificate revoked with reason certificateHold:
+	   cfssl revoke -db-config config_file -unhold -serial serial -aki authority_key_id \
+	                [-ca cert -responder cert -responder-key key [-interval 96h]]
+
+Revoke every matching certificate:
+	   cfssl revoke -db-config config_file -bulk [-aki authority_key_id] [-label ca_label] \
+	                [-hostname name] [-issued-after time] [-issued-before time] [-reason reason] \
+	                [-dry-run] [-ca cert -responder cert -responder-key key [-interval 96h]]
 
 Reason can be an integer code or a string in ReasonFlags in RFC 5280
 
+If a responder certificate and key are given, an OCSP response reflecting
+the new status is stored in the certificate store alongside the change.
+
+Every revocation and release is recorded, along with the user running the
+command, in the revocation log of the certificate store; see 'cfssl certdb
+events'. Only certificates on hold can be released.
+
+With -bulk, every certificate that is not yet revoked and matches all of the
+given filters is revoked; at least one filter is required. -hostname matches
+the common name or any SAN, and times are RFC 3339 timestamps. The revoked
+certificates are printed as JSON. With -dry-run, they are only listed. SQL
+certificate stores revoke them in a single transaction.
+
 Flags:
 `
 
-var revokeFlags = []string{"serial", "reason"}
+var revokeFlags = []string{"serial", "reason", "ca", "responder", "responder-key", "interval",
+	"bulk", "aki", "label", "hostname", "issued-after", "issued-before", "dry-run", "unhold"}
+
+// actor identifies the user running the command in the revocation log.
+func actor() string {
+	if u, err := user.Current(); err == nil {
+		return u.Username
+	}
+	return os.Getenv("USER")
+}
 
 func revokeMain(args []string, c cli.Config) error {
 	if len(args) > 0 {
 		return errors.New("argument is provided but not defined; please refer to the usage by flag -h")
 	}
 
+	if c.Bulk {
+		if c.Unhold {
+			return errors.New("-unhold cannot be combined with -bulk")
+		}
+		return bulkRevokeMain(c)
+	}
+
 	if len(c.Serial) == 0 {
 		return errors.New("serial number is required but not provided")
 	}
@@ -41,7 +88,10 @@ func revokeMain(args []string, c cli.Config) error {
 		return errors.New("need DB config file (provide with -db-config)")
 	}
 
-	dbAccessor := certdbfactory.NewAccessor(c.DBConfigFile)
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
 
 	reasonCode, err := ocsp.ReasonStringToCode(c.Reason)
 	if err != nil {
@@ -49,7 +99,88 @@ func revokeMain(args []string, c cli.Config) error {
 		return err
 	}
 
-	return dbAccessor.RevokeCertificate(c.Serial, c.AKI, reasonCode)
+	if c.Unhold {
+		err = cfcertdb.Unhold(dbAccessor, c.Serial, c.AKI, actor())
+	} else {
+		err = cfcertdb.Revoke(dbAccessor, c.Serial, c.AKI, reasonCode, actor())
+	}
+	if err != nil {
+		return err
+	}
+
+	if c.ResponderFile == "" {
+		return nil
+	}
+
+	ocspSigner, err := ocspsign.SignerFromConfig(c)
+	if err != nil {
+		log.Error("Unable to create OCSP signer: ", err)
+		return err
+	}
+
+	crs, err := dbAccessor.GetCertificate(c.Serial, c.AKI)
+	if err != nil {
+		return err
+	}
+	if len(crs) != 1 {
+		return errors.New("no unique certificate found")
+	}
+
+	return ocsp.StoreResponse(ocspSigner, dbAccessor, crs[0])
+}
+
+// bulkRevokeMain revokes every certificate matching the filter flags and
+// prints the result.
+func bulkRevokeMain(c cli.Config) error {
+	if c.DBConfigFile == "" {
+		return errors.New("need DB config file (provide with -db-config)")
+	}
+
+	q, err := certdb.QueryFromConfig(c)
+	if err != nil {
+		return err
+	}
+
+	reasonCode, err := ocsp.ReasonStringToCode(c.Reason)
+	if err != nil {
+		log.Error("Invalid reason code: ", err)
+		return err
+	}
+
+	dbAccessor, err := certdbfactory.NewAccessor(c.DBConfigFile)
+	if err != nil {
+		return err
+	}
+
+	var ocspSigner ocsp.Signer
+	if c.ResponderFile != "" && !c.DryRun {
+		ocspSigner, err = ocspsign.SignerFromConfig(c)
+		if err != nil {
+			log.Error("Unable to create OCSP signer: ", err)
+			return err
+		}
+	}
+
+	result, revokeErr := revoke.BulkRevoke(dbAccessor, ocspSigner, q, reasonCode, c.DryRun, actor())
+	if revokeErr != nil && result.Count == 0 {
+		return revokeErr
+	}
+
+	out, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s\n", out)
+
+	if revokeErr != nil {
+		log.Errorf("revoked %d certificates but failed to record them in the revocation log", result.Count)
+		return revokeErr
+	}
+
+	if len(result.OCSPFailures) > 0 {
+		return fmt.Errorf("failed to store OCSP responses for %d of %d revoked certificates", len(result.OCSPFailures), result.Count)
+	}
+	return nil
 }
 
 // Command assembles the definition of Command 'revoke'
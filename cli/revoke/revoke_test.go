@@ -1,6 +1,7 @@
 package revoke
 
 import (
+	"io/ioutil"
 	"testing"
 	"time"
 
@@ -8,6 +9,7 @@ import (
 	"github.com/ucosty/cfssl/certdb/sql"
 	"github.com/ucosty/cfssl/certdb/testdb"
 	"github.com/ucosty/cfssl/cli"
+	"github.com/ucosty/cfssl/helpers"
 	"golang.org/x/crypto/ocsp"
 )
 
@@ -127,3 +129,101 @@ func TestRevokeMain(t *testing.T) {
 		t.Fatal("Expected error from missing aki")
 	}
 }
+
+func TestRevokeMainStoresOCSP(t *testing.T) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor = sql.NewAccessor(db)
+
+	certPEM, err := ioutil.ReadFile("../testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := cert.SerialNumber.String()
+
+	err = dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial: serial,
+		AKI:    fakeAKI,
+		Status: "good",
+		Expiry: time.Now().AddDate(1, 0, 0),
+		PEM:    string(certPEM),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = revokeMain([]string{}, cli.Config{
+		Serial:           serial,
+		AKI:              fakeAKI,
+		Reason:           "keyCompromise",
+		DBConfigFile:     "../testdata/db-config.json",
+		CAFile:           "../testdata/ca.pem",
+		ResponderFile:    "../testdata/ca.pem",
+		ResponderKeyFile: "../testdata/ca-key.pem",
+		Interval:         time.Hour,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ocsps, err := dbAccessor.GetOCSP(serial, fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocsps) != 1 {
+		t.Fatalf("expected one stored OCSP response, got %d", len(ocsps))
+	}
+
+	resp, err := ocsp.ParseResponse([]byte(ocsps[0].Body), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != ocsp.Revoked || resp.RevocationReason != ocsp.KeyCompromise {
+		t.Fatalf("unexpected OCSP response status %d, reason %d", resp.Status, resp.RevocationReason)
+	}
+}
+
+func TestRevokeMainUnhold(t *testing.T) {
+	if err := prepDB(); err != nil {
+		t.Fatal(err)
+	}
+	c := cli.Config{Serial: "1", AKI: fakeAKI, DBConfigFile: "../testdata/db-config.json"}
+
+	c.Unhold = true
+	if err := revokeMain([]string{}, c); err == nil {
+		t.Fatal("Expected error releasing a certificate that is not on hold")
+	}
+
+	c.Unhold = false
+	c.Reason = "certificateHold"
+	if err := revokeMain([]string{}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	c.Unhold = true
+	if err := revokeMain([]string{}, c); err != nil {
+		t.Fatal(err)
+	}
+
+	crs, err := dbAccessor.GetCertificate("1", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 || crs[0].Status != "good" || crs[0].Reason != 0 {
+		t.Fatalf("Certificate not released: %+v", crs)
+	}
+
+	events, err := dbAccessor.GetRevocationEvents("1", fakeAKI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 || events[0].Action != certdb.ActionRevoke || events[1].Action != certdb.ActionUnhold {
+		t.Fatalf("Unexpected revocation events: %+v", events)
+	}
+	if events[1].PreviousStatus != "revoked" || events[1].PreviousReason != ocsp.CertificateHold {
+		t.Fatalf("Unexpected unhold event: %+v", events[1])
+	}
+}
@@ -13,10 +13,11 @@ import (
 	"strconv"
 	"strings"
 
-	certdbfactory "github.com/ucosty/cfssl/certdb/factory"
 	rice "github.com/GeertJohan/go.rice"
 	"github.com/ucosty/cfssl/api"
+	"github.com/ucosty/cfssl/api/acme"
 	"github.com/ucosty/cfssl/api/bundle"
+	"github.com/ucosty/cfssl/api/certificates"
 	"github.com/ucosty/cfssl/api/certinfo"
 	"github.com/ucosty/cfssl/api/crl"
 	"github.com/ucosty/cfssl/api/gencrl"
@@ -24,12 +25,16 @@ import (
 	"github.com/ucosty/cfssl/api/info"
 	"github.com/ucosty/cfssl/api/initca"
 	apiocsp "github.com/ucosty/cfssl/api/ocsp"
+	"github.com/ucosty/cfssl/api/renew"
 	"github.com/ucosty/cfssl/api/revoke"
 	"github.com/ucosty/cfssl/api/scan"
+	"github.com/ucosty/cfssl/api/scep"
 	"github.com/ucosty/cfssl/api/signhandler"
 	"github.com/ucosty/cfssl/bundler"
-	// "github.com/ucosty/cfssl/certdb/dbconf"
-	certsql "github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/dbconf"
+	certdbfactory "github.com/ucosty/cfssl/certdb/factory"
+	"github.com/ucosty/cfssl/certdb/migrate"
 	"github.com/ucosty/cfssl/cli"
 	ocspsign "github.com/ucosty/cfssl/cli/ocspsign"
 	"github.com/ucosty/cfssl/cli/sign"
@@ -38,8 +43,6 @@ import (
 	"github.com/ucosty/cfssl/ocsp"
 	"github.com/ucosty/cfssl/signer"
 	"github.com/ucosty/cfssl/ubiquity"
-
-	"github.com/jmoiron/sqlx"
 )
 
 // Usage text of 'cfssl serve'
@@ -52,7 +55,26 @@ Usage of serve:
                     [-responder cert] [-responder-key key] [-tls-cert cert] [-tls-key key] \
                     [-mutual-tls-ca ca] [-mutual-tls-cn regex] \
                     [-tls-remote-ca ca] [-mutual-tls-client-cert cert] [-mutual-tls-client-key key] \
-                    [-db-config db-config]
+                    [-db-config db-config] [-db-require-schema] [-est] [-acme [-acme-profile profile]] \
+                    [-scep-challenge password -scep-ra-cert cert -scep-ra-key key \
+                    [-scep-profile profile]]
+
+With -est, the EST (RFC 7030) cacerts, simpleenroll and simplereenroll
+operations are served under /.well-known/est/, with the signing profile named
+by an optional label before the operation.
+
+With -acme, an ACME (RFC 8555) server is served under /acme/, with its
+directory at /acme/directory. It issues certificates with the signer and the
+signing profile named by -acme-profile (the default profile if empty), once
+the requester has completed an http-01 or dns-01 challenge for each name.
+
+With -scep-challenge, a SCEP (RFC 8894) server is served under /scep/, as
+/scep/pkiclient.exe for most clients. Devices enroll with the challenge
+password, or renew by signing with their current, unrevoked certificate, and
+are issued certificates with the signing profile named by -scep-profile.
+Requests are encrypted to the RA certificate given by -scep-ra-cert, which
+must be issued by the CA and have the RSA key given by -scep-ra-key; the CA
+key is never used to decrypt. Renewals need -db-config.
 
 Flags:
 `
@@ -60,13 +82,15 @@ Flags:
 // Flags used by 'cfssl serve'
 var serverFlags = []string{"address", "port", "ca", "ca-key", "ca-bundle", "int-bundle", "int-dir", "metadata",
 	"remote", "config", "responder", "responder-key", "tls-key", "tls-cert", "mutual-tls-ca", "mutual-tls-cn",
-	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "db-config"}
+	"tls-remote-ca", "mutual-tls-client-cert", "mutual-tls-client-key", "db-config",
+	"db-require-schema", "est", "acme", "acme-profile", "scep-challenge", "scep-profile",
+	"scep-ra-cert", "scep-ra-key"}
 
 var (
 	conf       cli.Config
 	s          signer.Signer
 	ocspSigner ocsp.Signer
-	db         *sqlx.DB
+	dbAccessor certdb.Accessor
 )
 
 // V1APIPrefix is the prefix of all CFSSL V1 API Endpoints.
@@ -114,6 +138,10 @@ var staticBox = &httpBox{
 }
 
 var errBadSigner = errors.New("signer not initialized")
+var errESTNotEnabled = errors.New("EST server not enabled (missing -est)")
+var errACMENotEnabled = errors.New("ACME server not enabled (missing -acme)")
+var errSCEPNotEnabled = errors.New("SCEP server not enabled (missing -scep-challenge)")
+var errSCEPNoRA = errors.New("SCEP server needs an RA certificate and key (missing -scep-ra-cert or -scep-ra-key)")
 var errNoCertDBConfigured = errors.New("cert db not configured (missing -db-config)")
 
 var endpoints = map[string]func() (http.Handler, error){
@@ -169,11 +197,18 @@ var endpoints = map[string]func() (http.Handler, error){
 			return nil, errBadSigner
 		}
 
-		if db == nil {
+		if dbAccessor == nil {
 			return nil, errNoCertDBConfigured
 		}
 
-		return crl.NewHandler(certsql.NewAccessor(db), conf.CAFile, conf.CAKeyFile)
+		return crl.NewPartitionedHandler(dbAccessor, conf.CAFile, conf.CAKeyFile, cli.CRLPartitionsFromConfig(&conf))
+	},
+
+	"certificates": func() (http.Handler, error) {
+		if dbAccessor == nil {
+			return nil, errNoCertDBConfigured
+		}
+		return certificates.NewHandler(dbAccessor), nil
 	},
 
 	"gencrl": func() (http.Handler, error) {
@@ -229,13 +264,89 @@ var endpoints = map[string]func() (http.Handler, error){
 	},
 
 	"revoke": func() (http.Handler, error) {
-		dbAccessor := certdbfactory.NewAccessor(conf.DBConfigFile)
 		if dbAccessor == nil {
 			return nil, errNoCertDBConfigured
 		}
+		if ocspSigner != nil {
+			return revoke.NewOCSPHandler(dbAccessor, ocspSigner), nil
+		}
 		return revoke.NewHandler(dbAccessor), nil
 	},
 
+	"bulkrevoke": func() (http.Handler, error) {
+		if dbAccessor == nil {
+			return nil, errNoCertDBConfigured
+		}
+		if ocspSigner != nil {
+			return revoke.NewOCSPBulkHandler(dbAccessor, ocspSigner), nil
+		}
+		return revoke.NewBulkHandler(dbAccessor), nil
+	},
+
+	"unhold": func() (http.Handler, error) {
+		if dbAccessor == nil {
+			return nil, errNoCertDBConfigured
+		}
+		if ocspSigner != nil {
+			return revoke.NewOCSPUnholdHandler(dbAccessor, ocspSigner), nil
+		}
+		return revoke.NewUnholdHandler(dbAccessor), nil
+	},
+
+	"revocationlog": func() (http.Handler, error) {
+		if dbAccessor == nil {
+			return nil, errNoCertDBConfigured
+		}
+		return revoke.NewEventsHandler(dbAccessor), nil
+	},
+
+	"renew": func() (http.Handler, error) {
+		reissuer, ok := s.(signer.Reissuer)
+		if !ok {
+			return nil, errBadSigner
+		}
+		if dbAccessor == nil {
+			return nil, errNoCertDBConfigured
+		}
+		if ocspSigner != nil {
+			return renew.NewOCSPHandler(dbAccessor, reissuer, ocspSigner), nil
+		}
+		return renew.NewHandler(dbAccessor, reissuer), nil
+	},
+
+	signhandler.ESTPrefix: func() (http.Handler, error) {
+		if !conf.EST {
+			return nil, errESTNotEnabled
+		}
+		if s == nil {
+			return nil, errBadSigner
+		}
+		return signhandler.NewESTHandler(dbAccessor, s)
+	},
+
+	"/acme/": func() (http.Handler, error) {
+		if !conf.ACME {
+			return nil, errACMENotEnabled
+		}
+		if s == nil {
+			return nil, errBadSigner
+		}
+		return acme.NewServer(s, conf.ACMEProfile, "/acme/"), nil
+	},
+
+	"/scep/": func() (http.Handler, error) {
+		if conf.SCEPChallenge == "" {
+			return nil, errSCEPNotEnabled
+		}
+		if s == nil {
+			return nil, errBadSigner
+		}
+		if conf.SCEPRACert == "" || conf.SCEPRAKey == "" {
+			return nil, errSCEPNoRA
+		}
+		return scep.NewHandlerFromFile(dbAccessor, s, conf.CAFile, conf.SCEPRACert, conf.SCEPRAKey, scep.StaticChallenge(conf.SCEPChallenge), conf.SCEPProfile)
+	},
+
 	"/": func() (http.Handler, error) {
 		if err := staticBox.findStaticBox(); err != nil {
 			return nil, err
@@ -263,6 +374,36 @@ func registerHandlers() {
 	log.Info("Handler set up complete.")
 }
 
+// checkDBSchema returns an error if the SQL cert db described by the db
+// config file at path has pending schema migrations. Other engines have no
+// schema to check.
+func checkDBSchema(path string) error {
+	cfg, err := dbconf.LoadFile(path)
+	if err != nil {
+		return err
+	}
+	if cfg.Engine != dbconf.EngineSQL {
+		log.Infof("cert db engine %s has no schema to check", cfg.Engine)
+		return nil
+	}
+
+	db, err := dbconf.DBFromConfig(path)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	pending, err := migrate.Pending(db)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("cert db schema is behind: %d migration(s) pending, starting with %s; run 'cfssl certdb -db-config %s migrate up'",
+			len(pending), pending[0].Name, path)
+	}
+	return nil
+}
+
 // serverMain is the command line entry point to the API server. It sets up a
 // new HTTP server to handle sign, bundle, and validate requests.
 func serverMain(args []string, c cli.Config) error {
@@ -279,9 +420,21 @@ func serverMain(args []string, c cli.Config) error {
 		return err
 	}
 
+	if conf.DBConfigFile != "" {
+		if conf.DBRequireSchema {
+			if err = checkDBSchema(conf.DBConfigFile); err != nil {
+				return err
+			}
+		}
+
+		if dbAccessor, err = certdbfactory.NewAccessor(conf.DBConfigFile); err != nil {
+			return err
+		}
+	}
+
 	log.Info("Initializing signer")
 
-	if s, err = sign.SignerFromConfigAndDB(conf); err != nil {
+	if s, err = sign.SignerFromConfigAndDB(conf, dbAccessor); err != nil {
 		log.Warningf("couldn't initialize signer: %v", err)
 	}
 
@@ -289,6 +442,12 @@ func serverMain(args []string, c cli.Config) error {
 		log.Warningf("couldn't initialize ocsp signer: %v", err)
 	}
 
+	// With both a cert db and an OCSP signer, store an OCSP response for
+	// every certificate as soon as it is issued.
+	if s != nil && ocspSigner != nil && dbAccessor != nil {
+		s.SetOCSPSigner(ocspSigner)
+	}
+
 	registerHandlers()
 
 	addr := net.JoinHostPort(conf.Address, strconv.Itoa(conf.Port))
@@ -1,11 +1,14 @@
 package serve
 
 import (
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
+	_ "github.com/mattn/go-sqlite3" // register sqlite3 driver
 	"github.com/ucosty/cfssl/cli"
 )
 
@@ -41,6 +44,14 @@ func TestServe(t *testing.T) {
 	expected[v1APIPath("crl")] = http.StatusNotFound
 	expected[v1APIPath("gencrl")] = http.StatusNotFound
 	expected[v1APIPath("revoke")] = http.StatusNotFound
+	expected[v1APIPath("bulkrevoke")] = http.StatusNotFound
+	expected[v1APIPath("unhold")] = http.StatusNotFound
+	expected[v1APIPath("revocationlog")] = http.StatusNotFound
+	expected[v1APIPath("renew")] = http.StatusNotFound
+	expected[v1APIPath("certificates")] = http.StatusNotFound
+	expected["/acme/"] = http.StatusNotFound
+	expected["/scep/"] = http.StatusNotFound
+	expected["/.well-known/est/"] = http.StatusNotFound
 
 	// Enabled endpoints should return '405 Method Not Allowed'
 	expected[v1APIPath("init_ca")] = http.StatusMethodNotAllowed
@@ -74,3 +85,25 @@ func TestServe(t *testing.T) {
 		t.Fatalf("There should be an error for argument")
 	}
 }
+
+func TestCheckDBSchema(t *testing.T) {
+	if err := checkDBSchema("../testdata/db-config.json"); err != nil {
+		t.Fatalf("expected the test db schema to be current: %v", err)
+	}
+
+	dir, err := ioutil.TempDir("", "cfssl-serve")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	dbConfig := filepath.Join(dir, "db-config.json")
+	body := `{"driver":"sqlite3","data_source":"` + filepath.Join(dir, "empty.db") + `"}`
+	if err = ioutil.WriteFile(dbConfig, []byte(body), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = checkDBSchema(dbConfig); err == nil {
+		t.Fatal("expected an error for a db with pending migrations")
+	}
+}
@@ -6,17 +6,13 @@ import (
 	"errors"
 	"io/ioutil"
 
-
-	// "github.com/ucosty/cfssl/certdb/dbconf"
-	// certsql "github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb"
 	certdbfactory "github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/cli"
 	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/log"
 	"github.com/ucosty/cfssl/signer"
 	"github.com/ucosty/cfssl/signer/universal"
-
-	// "github.com/jmoiron/sqlx"
 )
 
 // Usage text of 'cfssl sign'
@@ -41,8 +37,8 @@ var signerFlags = []string{"hostname", "csr", "ca", "ca-key", "config", "profile
 	"mutual-tls-cert", "mutual-tls-key", "db-config"}
 
 // SignerFromConfigAndDB takes the Config and creates the appropriate
-// signer.Signer object with a specified db
-func SignerFromConfigAndDB(c cli.Config) (signer.Signer, error) {
+// signer.Signer object with a specified cert db accessor, which may be nil.
+func SignerFromConfigAndDB(c cli.Config, dbAccessor certdb.Accessor) (signer.Signer, error) {
 	// If there is a config, use its signing policy. Otherwise create a default policy.
 	var policy *config.Signing
 	if c.CFG != nil {
@@ -86,7 +82,6 @@ func SignerFromConfigAndDB(c cli.Config) (signer.Signer, error) {
 		return nil, err
 	}
 
-	dbAccessor := certdbfactory.NewAccessor(c.DBConfigFile)
 	if dbAccessor != nil {
 		s.SetDBAccessor(dbAccessor)
 	}
@@ -95,9 +90,16 @@ func SignerFromConfigAndDB(c cli.Config) (signer.Signer, error) {
 }
 
 // SignerFromConfig takes the Config and creates the appropriate
-// signer.Signer object
+// signer.Signer object, backed by the cert db named by -db-config if any.
 func SignerFromConfig(c cli.Config) (s signer.Signer, err error) {
-	return SignerFromConfigAndDB(c)
+	var dbAccessor certdb.Accessor
+	if c.DBConfigFile != "" {
+		dbAccessor, err = certdbfactory.NewAccessor(c.DBConfigFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return SignerFromConfigAndDB(c, dbAccessor)
 }
 
 // signerMain is the main CLI of signer functionality.
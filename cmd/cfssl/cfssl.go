@@ -26,8 +26,10 @@ import (
 
 	"github.com/ucosty/cfssl/cli"
 	"github.com/ucosty/cfssl/cli/bundle"
+	"github.com/ucosty/cfssl/cli/certdb"
 	"github.com/ucosty/cfssl/cli/certinfo"
 	"github.com/ucosty/cfssl/cli/crl"
+	"github.com/ucosty/cfssl/cli/crlserve"
 	"github.com/ucosty/cfssl/cli/gencert"
 	"github.com/ucosty/cfssl/cli/gencrl"
 	"github.com/ucosty/cfssl/cli/genkey"
@@ -37,6 +39,7 @@ import (
 	"github.com/ucosty/cfssl/cli/ocspserve"
 	"github.com/ucosty/cfssl/cli/ocspsign"
 	"github.com/ucosty/cfssl/cli/printdefault"
+	"github.com/ucosty/cfssl/cli/renew"
 	"github.com/ucosty/cfssl/cli/revoke"
 	"github.com/ucosty/cfssl/cli/scan"
 	"github.com/ucosty/cfssl/cli/selfsign"
@@ -56,8 +59,10 @@ func main() {
 	// Register commands.
 	cmds := map[string]*cli.Command{
 		"bundle":         bundle.Command,
+		"certdb":         certdb.Command,
 		"certinfo":       certinfo.Command,
 		"crl":            crl.Command,
+		"crlserve":       crlserve.Command,
 		"sign":           sign.Command,
 		"serve":          serve.Command,
 		"version":        version.Command,
@@ -72,6 +77,7 @@ func main() {
 		"scan":           scan.Command,
 		"info":           info.Command,
 		"print-defaults": printdefaults.Command,
+		"renew":          renew.Command,
 		"revoke":         revoke.Command,
 	}
 
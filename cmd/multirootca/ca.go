@@ -8,9 +8,7 @@ import (
 	"net"
 	"net/http"
 
-
 	"github.com/ucosty/cfssl/api/info"
-	"github.com/ucosty/cfssl/certdb/sql"
 	"github.com/ucosty/cfssl/log"
 	"github.com/ucosty/cfssl/multiroot/config"
 	"github.com/ucosty/cfssl/signer"
@@ -31,8 +29,7 @@ func parseSigner(root *config.Root) (signer.Signer, error) {
 		}
 		s.SetPolicy(root.Config)
 		if root.DB != nil {
-			dbAccessor := sql.NewAccessor(root.DB)
-			s.SetDBAccessor(dbAccessor)
+			s.SetDBAccessor(root.DB)
 		}
 		return s, nil
 	default:
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/big"
 	"regexp"
 	"strconv"
 	"strings"
@@ -60,6 +61,33 @@ type AuthRemote struct {
 	AuthKeyName string `json:"auth_key"`
 }
 
+// CRLPartitions splits the CRL of a CA into partitions, each published at
+// its own distribution point. Certificates are assigned to a partition by
+// serial number ("serial", the default) or by the month they expire in
+// ("expiry").
+type CRLPartitions struct {
+	URLs []string `json:"urls"`
+	By   string   `json:"by"`
+}
+
+// Valid values of CRLPartitions.By
+const (
+	PartitionBySerial = "serial"
+	PartitionByExpiry = "expiry"
+)
+
+// URL returns the distribution point of the partition of the certificate
+// with the given serial number and expiry.
+func (p *CRLPartitions) URL(serial *big.Int, notAfter time.Time) string {
+	n := int64(len(p.URLs))
+	if p.By == PartitionByExpiry {
+		notAfter = notAfter.UTC()
+		month := int64(notAfter.Year())*12 + int64(notAfter.Month()) - 1
+		return p.URLs[month%n]
+	}
+	return p.URLs[new(big.Int).Mod(serial, big.NewInt(n)).Int64()]
+}
+
 // CAConstraint specifies various CA constraints on the signed certificate.
 // CAConstraint would verify against (and override) the CA
 // extensions in the given CSR.
@@ -72,23 +100,25 @@ type CAConstraint struct {
 // A SigningProfile stores information that the CA needs to store
 // signature policy.
 type SigningProfile struct {
-	Usage               []string     `json:"usages"`
-	IssuerURL           []string     `json:"issuer_urls"`
-	OCSP                string       `json:"ocsp_url"`
-	CRL                 string       `json:"crl_url"`
-	CAConstraint        CAConstraint `json:"ca_constraint"`
-	OCSPNoCheck         bool         `json:"ocsp_no_check"`
-	ExpiryString        string       `json:"expiry"`
-	BackdateString      string       `json:"backdate"`
-	AuthKeyName         string       `json:"auth_key"`
-	RemoteName          string       `json:"remote"`
-	NotBefore           time.Time    `json:"not_before"`
-	NotAfter            time.Time    `json:"not_after"`
-	NameWhitelistString string       `json:"name_whitelist"`
-	AuthRemote          AuthRemote   `json:"auth_remote"`
-	CTLogServers        []string     `json:"ct_log_servers"`
-	AllowedExtensions   []OID        `json:"allowed_extensions"`
-	CertStore           string       `json:"cert_store"`
+	Usage               []string       `json:"usages"`
+	IssuerURL           []string       `json:"issuer_urls"`
+	OCSP                string         `json:"ocsp_url"`
+	CRL                 string         `json:"crl_url"`
+	FreshestCRL         string         `json:"freshest_crl_url"`
+	CRLPartitions       *CRLPartitions `json:"crl_partitions"`
+	CAConstraint        CAConstraint   `json:"ca_constraint"`
+	OCSPNoCheck         bool           `json:"ocsp_no_check"`
+	ExpiryString        string         `json:"expiry"`
+	BackdateString      string         `json:"backdate"`
+	AuthKeyName         string         `json:"auth_key"`
+	RemoteName          string         `json:"remote"`
+	NotBefore           time.Time      `json:"not_before"`
+	NotAfter            time.Time      `json:"not_after"`
+	NameWhitelistString string         `json:"name_whitelist"`
+	AuthRemote          AuthRemote     `json:"auth_remote"`
+	CTLogServers        []string       `json:"ct_log_servers"`
+	AllowedExtensions   []OID          `json:"allowed_extensions"`
+	CertStore           string         `json:"cert_store"`
 
 	Policies                    []CertificatePolicy
 	Expiry                      time.Duration
@@ -279,6 +309,19 @@ func (p *SigningProfile) populate(cfg *Config) error {
 		p.NameWhitelist = rule
 	}
 
+	if p.CRLPartitions != nil {
+		if len(p.CRLPartitions.URLs) == 0 {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				errors.New("no CRL partition URLs specified"))
+		}
+		switch p.CRLPartitions.By {
+		case "", PartitionBySerial, PartitionByExpiry:
+		default:
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidPolicy,
+				errors.New("invalid CRL partitioning "+p.CRLPartitions.By))
+		}
+	}
+
 	p.ExtensionWhitelist = map[string]bool{}
 	for _, oid := range p.AllowedExtensions {
 		p.ExtensionWhitelist[asn1.ObjectIdentifier(oid).String()] = true
@@ -315,6 +358,22 @@ func (p *Signing) OverrideRemotes(remote string) error {
 	return nil
 }
 
+// PartitionsFor returns the CRL partitions of the named profile, or of
+// the default profile if the named one has none. It returns nil if the
+// CRL is not partitioned.
+func (p *Signing) PartitionsFor(profile string) *CRLPartitions {
+	if p == nil {
+		return nil
+	}
+	if sp := p.Profiles[profile]; sp != nil && sp.CRLPartitions != nil {
+		return sp.CRLPartitions
+	}
+	if p.Default != nil {
+		return p.Default.CRLPartitions
+	}
+	return nil
+}
+
 // SetClientCertKeyPairFromFile updates the properties to set client certificates for mutual
 // authenticated TLS remote requests
 func (p *Signing) SetClientCertKeyPairFromFile(certFile string, keyFile string) error {
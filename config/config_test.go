@@ -3,6 +3,7 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"math/big"
 	"testing"
 	"time"
 )
@@ -535,3 +536,55 @@ func TestValidCAConstraint(t *testing.T) {
 		}
 	}
 }
+
+var crlPartitionsConfig = `
+{
+	"signing": {
+		"default": {
+			"usages": ["digital signature"],
+			"expiry": "8000h",
+			"crl_partitions": {
+				"urls": ["http://crl.example.com/0.crl", "http://crl.example.com/1.crl", "http://crl.example.com/2.crl"],
+				"by": "%s"
+			}
+		},
+		"profiles": {
+			"client": {
+				"usages": ["client auth"],
+				"expiry": "8000h"
+			}
+		}
+	}
+}`
+
+func TestCRLPartitions(t *testing.T) {
+	if _, err := LoadConfig([]byte(fmt.Sprintf(crlPartitionsConfig, "issuer"))); err == nil {
+		t.Fatal("invalid CRL partitioning accepted")
+	}
+
+	cfg, err := LoadConfig([]byte(fmt.Sprintf(crlPartitionsConfig, "")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions := cfg.Signing.PartitionsFor("client")
+	if partitions == nil {
+		t.Fatal("profile doesn't fall back to the default CRL partitions")
+	}
+	if url := partitions.URL(big.NewInt(7), time.Now()); url != "http://crl.example.com/1.crl" {
+		t.Fatalf("wrong partition %s for serial 7", url)
+	}
+
+	cfg, err = LoadConfig([]byte(fmt.Sprintf(crlPartitionsConfig, PartitionByExpiry)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	partitions = cfg.Signing.PartitionsFor("")
+	notAfter := time.Date(2030, time.February, 28, 0, 0, 0, 0, time.UTC)
+	// 2030*12 + 1 months have passed since year 0, which is 1 mod 3.
+	if url := partitions.URL(big.NewInt(7), notAfter); url != "http://crl.example.com/1.crl" {
+		t.Fatalf("wrong partition %s for February 2030", url)
+	}
+	if partitions.URL(big.NewInt(7), notAfter) != partitions.URL(big.NewInt(8), notAfter.AddDate(0, 0, -27)) {
+		t.Fatal("certificates expiring in the same month are in different partitions")
+	}
+}
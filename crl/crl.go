@@ -3,9 +3,11 @@ package crl
 
 import (
 	"crypto"
-	"crypto/rand"
+	"crypto/sha1"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
 	"math/big"
 	"os"
 	"strconv"
@@ -13,6 +15,8 @@ import (
 	"time"
 
 	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/config"
+	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
 )
@@ -75,30 +79,270 @@ func NewCRLFromFile(serialList, issuerFile, keyFile []byte, expiryTime string) (
 }
 
 // NewCRLFromDB takes in a list of CertificateRecords, as well as the issuing certificate
-// of the CRL, and the private key. This function is then used to parse the records and generate a CRL
+// of the CRL, and the private key. This function is then used to parse the records and generate a CRL.
+// The revocation reasons of the records are included, but the CRL has no CRL number; use
+// NewCRLFromAccessor for CRLs numbered from the cert db.
 func NewCRLFromDB(certs []certdb.CertificateRecord, issuerCert *x509.Certificate, key crypto.Signer, expiryTime time.Duration) ([]byte, error) {
-	var revokedCerts []pkix.RevokedCertificate
+	now := time.Now()
+	return CreateCRL(RevokedCertificates(certs), key, issuerCert, Template{
+		ThisUpdate: now,
+		NextUpdate: now.Add(expiryTime),
+	})
+}
+
+// Options controls a CRL generated by NewCRLFromAccessor.
+type Options struct {
+	// Expiry is the time from now until the CRL's NextUpdate.
+	Expiry time.Duration
+	// DistributionPoint, if set, is the URL named by the CRL's Issuing
+	// Distribution Point extension. Each distribution point has its own
+	// sequence of CRL numbers.
+	DistributionPoint string
+	// Delta requests a delta CRL listing only the certificates revoked
+	// since the last full CRL of the distribution point.
+	Delta bool
+	// Partitions, if set, restricts the CRL to the certificates of the
+	// partition whose URL is DistributionPoint.
+	Partitions *config.CRLPartitions
+}
+
+// NewCRLFromAccessor generates a CRL of the revoked and unexpired
+// certificates in the cert db, numbered with the next CRL number persisted
+// there for the issuer and distribution point. Full CRLs are recorded as
+// the base of later delta CRLs; a delta CRL can only be generated once a
+// full CRL has been. Delta CRLs also list the certificates released from
+// certificateHold since the full CRL, with reason removeFromCRL.
+func NewCRLFromAccessor(dbAccessor certdb.Accessor, issuerCert *x509.Certificate, key crypto.Signer, opts Options) ([]byte, error) {
+	if opts.Partitions != nil && !isPartition(opts.Partitions, opts.DistributionPoint) {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+			errors.New("distribution point is not a CRL partition: "+opts.DistributionPoint))
+	}
+
+	// thisUpdate is taken before reading the cert db, so that the CRL
+	// does not claim to cover revocations made while it is generated.
+	now := time.Now()
+	certs, err := dbAccessor.GetRevokedAndUnexpiredCertificates()
+	if err != nil {
+		return nil, err
+	}
+	if opts.Partitions != nil {
+		certs = partition(certs, opts.Partitions)[opts.DistributionPoint]
+	}
+	return newCRL(dbAccessor, issuerCert, key, opts, certs, now)
+}
+
+// newCRL generates the CRL of NewCRLFromAccessor for certs, the revoked
+// and unexpired certificates of its distribution point read from the cert
+// db at now.
+func newCRL(dbAccessor certdb.Accessor, issuerCert *x509.Certificate, key crypto.Signer, opts Options, certs []certdb.CertificateRecord, now time.Time) ([]byte, error) {
+	aki := IssuerKeyID(issuerCert)
+
+	var base certdb.CRLRecord
+	if opts.Delta {
+		recs, err := dbAccessor.GetCRLRecord(aki, opts.DistributionPoint)
+		if err != nil {
+			return nil, err
+		}
+		if len(recs) == 0 || recs[0].BaseNumber == 0 {
+			return nil, cferr.Wrap(cferr.CertStoreError, cferr.RecordNotFound,
+				errors.New("no full CRL has been generated to base a delta CRL on"))
+		}
+		base = recs[0]
+	}
+
+	number, err := dbAccessor.NextCRLNumber(aki, opts.DistributionPoint)
+	if err != nil {
+		return nil, err
+	}
+
+	template := Template{
+		ThisUpdate:        now,
+		NextUpdate:        now.Add(opts.Expiry),
+		Number:            big.NewInt(number),
+		DistributionPoint: opts.DistributionPoint,
+	}
+	revoked := RevokedCertificates(certs)
+	if opts.Delta {
+		template.BaseNumber = big.NewInt(base.BaseNumber)
+		revoked = RevokedCertificates(revokedSince(certs, base.BaseThisUpdate))
+
+		released, err := releasedSince(dbAccessor, base.BaseThisUpdate, opts)
+		if err != nil {
+			return nil, err
+		}
+		revoked = append(revoked, released...)
+	}
+
+	crlBytes, err := CreateCRL(revoked, key, issuerCert, template)
+	if err != nil {
+		return nil, err
+	}
+
+	if !opts.Delta {
+		if err = dbAccessor.UpdateBaseCRL(aki, opts.DistributionPoint, number, now); err != nil {
+			return nil, err
+		}
+	}
+	return crlBytes, nil
+}
+
+// NewPartitionedCRLsFromAccessor generates a CRL for each of the
+// partitions, as NewCRLFromAccessor does for a single distribution point,
+// from one read of the cert db. The CRLs are keyed by the URL of their
+// partition.
+func NewPartitionedCRLsFromAccessor(dbAccessor certdb.Accessor, issuerCert *x509.Certificate, key crypto.Signer, opts Options, partitions *config.CRLPartitions) (map[string][]byte, error) {
+	now := time.Now()
+	certs, err := dbAccessor.GetRevokedAndUnexpiredCertificates()
+	if err != nil {
+		return nil, err
+	}
+	byURL := partition(certs, partitions)
+
+	crls := make(map[string][]byte, len(partitions.URLs))
+	opts.Partitions = partitions
+	for _, url := range partitions.URLs {
+		opts.DistributionPoint = url
+		crlBytes, err := newCRL(dbAccessor, issuerCert, key, opts, byURL[url], now)
+		if err != nil {
+			return nil, err
+		}
+		crls[url] = crlBytes
+	}
+	return crls, nil
+}
 
-	newExpiryTime := time.Now().Add(expiryTime)
+func isPartition(partitions *config.CRLPartitions, url string) bool {
+	for _, u := range partitions.URLs {
+		if u == url {
+			return true
+		}
+	}
+	return false
+}
+
+// partition splits certs by the URL of the partition they are assigned to.
+func partition(certs []certdb.CertificateRecord, partitions *config.CRLPartitions) map[string][]certdb.CertificateRecord {
+	byURL := make(map[string][]certdb.CertificateRecord, len(partitions.URLs))
+	for _, cr := range certs {
+		serial, ok := new(big.Int).SetString(cr.Serial, 10)
+		if !ok {
+			log.Warningf("skipping certificate with malformed serial number %q", cr.Serial)
+			continue
+		}
+		url := partitions.URL(serial, cr.Expiry)
+		byURL[url] = append(byURL[url], cr)
+	}
+	return byURL
+}
+
+// revokedSince returns the certificates revoked at or after t. Revocations
+// at the base CRL's thisUpdate may already be listed there; repeating them
+// in the delta CRL is harmless.
+func revokedSince(certs []certdb.CertificateRecord, t time.Time) []certdb.CertificateRecord {
+	var recent []certdb.CertificateRecord
+	for _, cr := range certs {
+		if !cr.RevokedAt.Before(t.Truncate(time.Second)) {
+			recent = append(recent, cr)
+		}
+	}
+	return recent
+}
+
+// reasonRemoveFromCRL is the RFC 5280 reason code with which delta CRLs
+// list certificates released from certificateHold.
+const reasonRemoveFromCRL = 8
+
+// releasedSince returns delta CRL entries, with reason removeFromCRL, for
+// the unexpired certificates released from certificateHold at or after t
+// and not revoked again since.
+func releasedSince(dbAccessor certdb.Accessor, t time.Time, opts Options) ([]pkix.RevokedCertificate, error) {
+	events, err := dbAccessor.ListRevocationEvents(t.Truncate(time.Second))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the latest release of each certificate is listed.
+	type certID struct{ serial, aki string }
+	latest := make(map[certID]certdb.RevocationEvent)
+	var order []certID
+	for _, ev := range events {
+		if ev.Action != certdb.ActionUnhold {
+			continue
+		}
+		id := certID{ev.Serial, ev.AKI}
+		if _, ok := latest[id]; !ok {
+			order = append(order, id)
+		}
+		latest[id] = ev
+	}
+
+	now := time.Now()
+	var entries []pkix.RevokedCertificate
+	for _, id := range order {
+		crs, err := dbAccessor.GetCertificate(id.serial, id.aki)
+		if err != nil {
+			return nil, err
+		}
+		if len(crs) != 1 || crs[0].Status == "revoked" || !now.Before(crs[0].Expiry) {
+			continue
+		}
 
-	// For every record, create a new revokedCertificate and add it to slice
+		serial, ok := new(big.Int).SetString(id.serial, 10)
+		if !ok {
+			log.Warningf("skipping certificate with malformed serial number %q", id.serial)
+			continue
+		}
+		if opts.Partitions != nil && opts.Partitions.URL(serial, crs[0].Expiry) != opts.DistributionPoint {
+			continue
+		}
+		ext, _ := reasonCodeExtension(reasonRemoveFromCRL)
+		entries = append(entries, pkix.RevokedCertificate{
+			SerialNumber:   serial,
+			RevocationTime: latest[id].CreatedAt,
+			Extensions:     []pkix.Extension{ext},
+		})
+	}
+	return entries, nil
+}
+
+// IssuerKeyID returns the hex encoded key identifier under which the CRL
+// numbers of issuer are stored: its subject key identifier, or the SHA-1
+// hash of its public key if it has none.
+func IssuerKeyID(issuer *x509.Certificate) string {
+	if len(issuer.SubjectKeyId) > 0 {
+		return hex.EncodeToString(issuer.SubjectKeyId)
+	}
+	sum := sha1.Sum(issuer.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:])
+}
+
+// RevokedCertificates converts certificate records to CRL entries. Entries
+// carry a reasonCode extension unless the reason is unspecified, which RFC
+// 5280 section 5.3.1 says should be left out.
+func RevokedCertificates(certs []certdb.CertificateRecord) []pkix.RevokedCertificate {
+	var revokedCerts []pkix.RevokedCertificate
 	for _, certRecord := range certs {
 		serialInt := new(big.Int)
 		serialInt.SetString(certRecord.Serial, 10)
-		tempCert := pkix.RevokedCertificate{
+		entry := pkix.RevokedCertificate{
 			SerialNumber:   serialInt,
 			RevocationTime: certRecord.RevokedAt,
 		}
-		revokedCerts = append(revokedCerts, tempCert)
+		if ext, ok := reasonCodeExtension(certRecord.Reason); ok {
+			entry.Extensions = []pkix.Extension{ext}
+		}
+		revokedCerts = append(revokedCerts, entry)
 	}
-
-	return CreateGenericCRL(revokedCerts, key, issuerCert, newExpiryTime)
+	return revokedCerts
 }
 
-// CreateGenericCRL is a helper function that takes in all of the information above, and then calls the createCRL
-// function. This outputs the bytes of the created CRL.
+// CreateGenericCRL is a helper function that takes in all of the information above, and then calls
+// CreateCRL. This outputs the bytes of the created CRL.
 func CreateGenericCRL(certList []pkix.RevokedCertificate, key crypto.Signer, issuingCert *x509.Certificate, expiryTime time.Time) ([]byte, error) {
-	crlBytes, err := issuingCert.CreateCRL(rand.Reader, key, certList, time.Now(), expiryTime)
+	crlBytes, err := CreateCRL(certList, key, issuingCert, Template{
+		ThisUpdate: time.Now(),
+		NextUpdate: expiryTime,
+	})
 	if err != nil {
 		log.Debug("error creating CRL: %s", err)
 	}
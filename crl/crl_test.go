@@ -1,9 +1,20 @@
 package crl
 
 import (
+	"bytes"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"io/ioutil"
+	"math/big"
 	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/config"
+	"github.com/ucosty/cfssl/helpers"
 )
 
 const (
@@ -75,3 +86,251 @@ func TestNewCRLFromFileWithoutRevocations(t *testing.T) {
 		t.Fatal("Wrong number of expired certificates")
 	}
 }
+
+func TestCreateCRL(t *testing.T) {
+	keyBytes, err := ioutil.ReadFile(tryTwoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certBytes, err := ioutil.ReadFile(tryTwoCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []certdb.CertificateRecord{
+		{Serial: "1", RevokedAt: time.Now(), Reason: 1},
+		{Serial: "2", RevokedAt: time.Now(), Reason: 0},
+	}
+	now := time.Now()
+	der, err := CreateCRL(RevokedCertificates(records), key, issuer, Template{
+		ThisUpdate:        now,
+		NextUpdate:        now.Add(time.Hour),
+		Number:            big.NewInt(42),
+		DistributionPoint: "http://crl.example.com/ca.crl",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certList, err := x509.ParseDERCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = issuer.CheckCRLSignature(certList); err != nil {
+		t.Fatalf("CRL signature does not verify: %v", err)
+	}
+	if certList.TBSCertList.Version != 1 {
+		t.Fatalf("want a v2 CRL, got version %d", certList.TBSCertList.Version)
+	}
+
+	extensions := map[string]pkix.Extension{}
+	for _, ext := range certList.TBSCertList.Extensions {
+		extensions[ext.Id.String()] = ext
+	}
+
+	var aki authorityKeyID
+	if _, err = asn1.Unmarshal(extensions[oidAuthorityKeyID.String()].Value, &aki); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(aki.ID, issuer.SubjectKeyId) {
+		t.Fatalf("want AKI %x, got %x", issuer.SubjectKeyId, aki.ID)
+	}
+
+	number := new(big.Int)
+	if _, err = asn1.Unmarshal(extensions[oidCRLNumber.String()].Value, &number); err != nil {
+		t.Fatal(err)
+	}
+	if number.Int64() != 42 {
+		t.Fatalf("want CRL number 42, got %s", number)
+	}
+
+	idp, ok := extensions[oidIssuingDistributionPoint.String()]
+	if !ok || !idp.Critical {
+		t.Fatal("want a critical Issuing Distribution Point extension")
+	}
+	if !bytes.Contains(idp.Value, []byte("http://crl.example.com/ca.crl")) {
+		t.Fatal("Issuing Distribution Point does not name the distribution point")
+	}
+
+	revoked := certList.TBSCertList.RevokedCertificates
+	if len(revoked) != 2 {
+		t.Fatalf("want 2 revoked certificates, got %d", len(revoked))
+	}
+	if len(revoked[0].Extensions) != 1 || !revoked[0].Extensions[0].Id.Equal(oidReasonCode) {
+		t.Fatal("want a reasonCode extension for keyCompromise")
+	}
+	var reason asn1.Enumerated
+	if _, err = asn1.Unmarshal(revoked[0].Extensions[0].Value, &reason); err != nil {
+		t.Fatal(err)
+	}
+	if reason != 1 {
+		t.Fatalf("want reason 1, got %d", reason)
+	}
+	if len(revoked[1].Extensions) != 0 {
+		t.Fatal("unspecified reason should not have a reasonCode extension")
+	}
+}
+
+// countingAccessor counts the reads of the revoked certificates.
+type countingAccessor struct {
+	certdb.Accessor
+	reads int
+}
+
+func (a *countingAccessor) GetRevokedAndUnexpiredCertificates() ([]certdb.CertificateRecord, error) {
+	a.reads++
+	return a.Accessor.GetRevokedAndUnexpiredCertificates()
+}
+
+func TestNewPartitionedCRLsFromAccessor(t *testing.T) {
+	db := testdb.SQLiteDB("../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+	for _, serial := range []string{"1", "2", "3"} {
+		err := dbAccessor.InsertCertificate(certdb.CertificateRecord{
+			Serial:    serial,
+			AKI:       "fake aki",
+			Expiry:    time.Now().AddDate(1, 0, 0),
+			PEM:       "revoked cert",
+			Status:    "revoked",
+			RevokedAt: time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	certBytes, err := ioutil.ReadFile(tryTwoCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := ioutil.ReadFile(tryTwoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	partitions := &config.CRLPartitions{
+		URLs: []string{"http://crl.example.com/0.crl", "http://crl.example.com/1.crl"},
+	}
+	counter := &countingAccessor{Accessor: dbAccessor}
+	crls, err := NewPartitionedCRLsFromAccessor(counter, cert, key, Options{Expiry: time.Hour}, partitions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counter.reads != 1 {
+		t.Fatalf("want the revoked certificates read once, got %d reads", counter.reads)
+	}
+
+	want := map[string][]int64{
+		"http://crl.example.com/0.crl": {2},
+		"http://crl.example.com/1.crl": {1, 3},
+	}
+	for url, serials := range want {
+		crl, err := x509.ParseDERCRL(crls[url])
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := crl.TBSCertList.RevokedCertificates
+		if len(entries) != len(serials) {
+			t.Fatalf("want %d entries in %s, got %d", len(serials), url, len(entries))
+		}
+		for i, serial := range serials {
+			if entries[i].SerialNumber.Int64() != serial {
+				t.Fatalf("want serial %d in %s, got %v", serial, url, entries[i].SerialNumber)
+			}
+		}
+	}
+
+	_, err = NewCRLFromAccessor(dbAccessor, cert, key, Options{
+		Expiry:            time.Hour,
+		DistributionPoint: "http://crl.example.com/ca.crl",
+		Partitions:        partitions,
+	})
+	if err == nil {
+		t.Fatal("expected an error generating the CRL of an unknown partition")
+	}
+}
+
+func TestDeltaCRLListsReleasedHolds(t *testing.T) {
+	db := testdb.SQLiteDB("../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+	err := dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial:    "1",
+		AKI:       "fake aki",
+		Expiry:    time.Now().AddDate(1, 0, 0),
+		PEM:       "held cert",
+		Status:    "revoked",
+		Reason:    certdb.ReasonCertificateHold,
+		RevokedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certBytes, err := ioutil.ReadFile(tryTwoCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyBytes, err := ioutil.ReadFile(tryTwoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = NewCRLFromAccessor(dbAccessor, cert, key, Options{Expiry: time.Hour}); err != nil {
+		t.Fatal(err)
+	}
+	if err = certdb.Unhold(dbAccessor, "1", "fake aki", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	deltaBytes, err := NewCRLFromAccessor(dbAccessor, cert, key, Options{Expiry: time.Hour, Delta: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	delta, err := x509.ParseDERCRL(deltaBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries := delta.TBSCertList.RevokedCertificates
+	if len(entries) != 1 || entries[0].SerialNumber.Int64() != 1 {
+		t.Fatalf("want the released certificate in the delta CRL, got %+v", entries)
+	}
+	if len(entries[0].Extensions) != 1 || !bytes.Equal(entries[0].Extensions[0].Value, []byte{0x0a, 0x01, 0x08}) {
+		t.Fatalf("want reason removeFromCRL, got %+v", entries[0].Extensions)
+	}
+
+	fullBytes, err := NewCRLFromAccessor(dbAccessor, cert, key, Options{Expiry: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := x509.ParseDERCRL(fullBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := len(full.TBSCertList.RevokedCertificates); n != 0 {
+		t.Fatalf("want the released certificate left off the full CRL, got %d entries", n)
+	}
+}
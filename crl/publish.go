@@ -0,0 +1,293 @@
+package crl
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/log"
+)
+
+// Names of the files a Publisher writes to its directory.
+const (
+	DERFileName = "crl.der"
+	PEMFileName = "crl.pem"
+)
+
+// A Publisher regenerates the CRL of an issuer from the cert db on an
+// interval, writes it to disk and serves the latest one over HTTP. A
+// partitioned Publisher does so for the CRLs of every partition at once.
+type Publisher struct {
+	dbAccessor  certdb.Accessor
+	issuer      *x509.Certificate
+	key         crypto.Signer
+	opts        Options
+	interval    time.Duration
+	partitioned bool
+
+	// crls holds the latest CRL of each distribution point.
+	crls map[string]*servedCRL
+}
+
+// A servedCRL is the latest CRL published for a distribution point.
+type servedCRL struct {
+	dir      string
+	interval time.Duration
+
+	mu        sync.RWMutex
+	der       []byte
+	pem       []byte
+	etag      string
+	published time.Time
+	thisUpd   time.Time
+	nextUpd   time.Time
+}
+
+// NewPublisher returns a Publisher of the CRLs of issuer, generated with
+// opts and republished every interval. If dir is not empty, each CRL is
+// also written there as DERFileName and PEMFileName.
+func NewPublisher(dbAccessor certdb.Accessor, issuer *x509.Certificate, key crypto.Signer, opts Options, dir string, interval time.Duration) *Publisher {
+	return &Publisher{
+		dbAccessor: dbAccessor,
+		issuer:     issuer,
+		key:        key,
+		opts:       opts,
+		interval:   interval,
+		crls: map[string]*servedCRL{
+			opts.DistributionPoint: {dir: dir, interval: interval},
+		},
+	}
+}
+
+// NewPartitionedPublisher returns a Publisher of the CRLs of every
+// partition in opts.Partitions, generated from one read of the cert db
+// with NewPartitionedCRLsFromAccessor and republished every interval.
+// They are served by Handler. If dirs has a directory for the URL of a
+// partition, its CRLs are also written there.
+func NewPartitionedPublisher(dbAccessor certdb.Accessor, issuer *x509.Certificate, key crypto.Signer, opts Options, dirs map[string]string, interval time.Duration) *Publisher {
+	p := &Publisher{
+		dbAccessor:  dbAccessor,
+		issuer:      issuer,
+		key:         key,
+		opts:        opts,
+		interval:    interval,
+		partitioned: true,
+		crls:        make(map[string]*servedCRL, len(opts.Partitions.URLs)),
+	}
+	for _, url := range opts.Partitions.URLs {
+		p.crls[url] = &servedCRL{dir: dirs[url], interval: interval}
+	}
+	return p
+}
+
+// Publish generates new CRLs, writes them to disk and makes them the ones
+// served. On failure the previous CRLs remain in place.
+func (p *Publisher) Publish() error {
+	var crls map[string][]byte
+	if p.partitioned {
+		var err error
+		crls, err = NewPartitionedCRLsFromAccessor(p.dbAccessor, p.issuer, p.key, p.opts, p.opts.Partitions)
+		if err != nil {
+			return err
+		}
+	} else {
+		der, err := NewCRLFromAccessor(p.dbAccessor, p.issuer, p.key, p.opts)
+		if err != nil {
+			return err
+		}
+		crls = map[string][]byte{p.opts.DistributionPoint: der}
+	}
+
+	for url, der := range crls {
+		if err := p.crls[url].set(der); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// set writes der to disk and makes it the CRL served.
+func (c *servedCRL) set(der []byte) error {
+	crl, err := x509.ParseDERCRL(der)
+	if err != nil {
+		return err
+	}
+
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der})
+	if c.dir != "" {
+		if err = writeFileAtomic(filepath.Join(c.dir, DERFileName), der); err != nil {
+			return err
+		}
+		if err = writeFileAtomic(filepath.Join(c.dir, PEMFileName), pemBytes); err != nil {
+			return err
+		}
+	}
+
+	sum := sha256.Sum256(der)
+	c.mu.Lock()
+	c.der = der
+	c.pem = pemBytes
+	c.etag = fmt.Sprintf("\"%s\"", hex.EncodeToString(sum[:]))
+	c.published = time.Now()
+	c.thisUpd = crl.TBSCertList.ThisUpdate
+	c.nextUpd = crl.TBSCertList.NextUpdate
+	c.mu.Unlock()
+	return nil
+}
+
+// Run publishes new CRLs every interval until stop is closed. Failures
+// are logged and retried at the next interval, while the previous CRLs
+// keep being served.
+func (p *Publisher) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(p.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		if err := p.Publish(); err != nil {
+			log.Errorf("failed to publish CRL: %v", err)
+		} else {
+			log.Info("published a new CRL")
+		}
+	}
+}
+
+// Handler returns the http.Handler serving the CRL published for the
+// distribution point url, as ServeHTTP does, or nil if p publishes none.
+func (p *Publisher) Handler(url string) http.Handler {
+	c, ok := p.crls[url]
+	if !ok {
+		return nil
+	}
+	return c
+}
+
+// ServeHTTP serves the latest CRL as DER with the application/pkix-crl
+// content type, or as PEM if the request path ends in ".pem". Responses
+// may be cached until the next scheduled publication, and conditional
+// requests are answered with 304 Not Modified. A partitioned Publisher
+// serves its CRLs with Handler instead.
+func (p *Publisher) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c, ok := p.crls[p.opts.DistributionPoint]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	c.ServeHTTP(w, r)
+}
+
+func (c *servedCRL) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	c.mu.RLock()
+	der, pemBytes, etag := c.der, c.pem, c.etag
+	published, thisUpdate, nextUpdate := c.published, c.thisUpd, c.nextUpd
+	c.mu.RUnlock()
+
+	if der == nil {
+		http.Error(w, "no CRL has been published yet", http.StatusServiceUnavailable)
+		return
+	}
+
+	body, contentType := der, "application/pkix-crl"
+	if strings.HasSuffix(r.URL.Path, ".pem") {
+		body, contentType = pemBytes, "application/x-pem-file"
+	}
+
+	// Clients may cache the CRL until it is replaced, but never beyond
+	// its nextUpdate.
+	expires := published.Add(c.interval)
+	if nextUpdate.Before(expires) {
+		expires = nextUpdate
+	}
+	maxAge := expires.Sub(time.Now()) / time.Second
+	if maxAge < 0 {
+		maxAge = 0
+	}
+
+	header := w.Header()
+	header.Set("Content-Type", contentType)
+	header.Set("Last-Modified", thisUpdate.UTC().Format(http.TimeFormat))
+	header.Set("Expires", expires.UTC().Format(http.TimeFormat))
+	header.Set("Cache-Control", fmt.Sprintf("max-age=%d, public, no-transform, must-revalidate", maxAge))
+	header.Set("ETag", etag)
+
+	if notModified(r, etag, thisUpdate) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	header.Set("Content-Length", fmt.Sprintf("%d", len(body)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method == "GET" {
+		w.Write(body)
+	}
+}
+
+// notModified reports whether a conditional request is satisfied by the
+// CRL with the given ETag and thisUpdate. If-None-Match takes precedence
+// over If-Modified-Since.
+func notModified(r *http.Request, etag string, thisUpdate time.Time) bool {
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	return !thisUpdate.Truncate(time.Second).After(ims)
+}
+
+// writeFileAtomic writes data to a temporary file next to path and
+// renames it into place, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	tmp := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp, 0644)
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
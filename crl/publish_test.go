@@ -0,0 +1,241 @@
+package crl
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
+	"github.com/ucosty/cfssl/config"
+	"github.com/ucosty/cfssl/helpers"
+)
+
+func newTestPublisher(t *testing.T, dir string) *Publisher {
+	db := testdb.SQLiteDB("../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+	err := dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial:    "1",
+		AKI:       "fake aki",
+		Expiry:    time.Now().AddDate(1, 0, 0),
+		PEM:       "revoked cert",
+		Status:    "revoked",
+		RevokedAt: time.Now(),
+		Reason:    1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := ioutil.ReadFile(tryTwoCert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyPEM, err := ioutil.ReadFile(tryTwoKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := helpers.ParsePrivateKeyPEM(keyPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return NewPublisher(dbAccessor, cert, key, Options{Expiry: 2 * time.Hour}, dir, time.Hour)
+}
+
+func TestPublishWritesFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crlpublish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	p := newTestPublisher(t, dir)
+	if err = p.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := ioutil.ReadFile(filepath.Join(dir, DERFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	crl, err := x509.ParseDERCRL(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crl.TBSCertList.RevokedCertificates) != 1 {
+		t.Fatalf("want one revoked certificate, got %d", len(crl.TBSCertList.RevokedCertificates))
+	}
+
+	pemBytes, err := ioutil.ReadFile(filepath.Join(dir, PEMFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || block.Type != "X509 CRL" || !bytes.Equal(block.Bytes, der) {
+		t.Fatal("PEM file doesn't hold the published CRL")
+	}
+
+	// Only the two CRL files are left behind.
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("want 2 files in the CRL directory, got %d", len(files))
+	}
+}
+
+func TestPublisherServeHTTP(t *testing.T) {
+	p := newTestPublisher(t, "")
+	ts := httptest.NewServer(p)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL + "/ca.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("want %d before the first publication, got %d", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	if err = p.Publish(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err = http.Get(ts.URL + "/ca.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("want %d, got %d", http.StatusOK, resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/pkix-crl" {
+		t.Fatalf("want content type application/pkix-crl, got %s", ct)
+	}
+	if _, err = x509.ParseDERCRL(der); err != nil {
+		t.Fatal(err)
+	}
+	for _, h := range []string{"ETag", "Last-Modified", "Expires", "Cache-Control"} {
+		if resp.Header.Get(h) == "" {
+			t.Fatalf("missing %s header", h)
+		}
+	}
+
+	req, err := http.NewRequest("GET", ts.URL+"/ca.crl", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("If-None-Match", resp.Header.Get("ETag"))
+	cached, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.Body.Close()
+	if cached.StatusCode != http.StatusNotModified {
+		t.Fatalf("want %d for a matching ETag, got %d", http.StatusNotModified, cached.StatusCode)
+	}
+
+	resp, err = http.Get(ts.URL + "/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil || !bytes.Equal(block.Bytes, der) {
+		t.Fatal("PEM response doesn't hold the published CRL")
+	}
+}
+
+func TestPartitionedPublisher(t *testing.T) {
+	single := newTestPublisher(t, "")
+	err := single.dbAccessor.InsertCertificate(certdb.CertificateRecord{
+		Serial:    "2",
+		AKI:       "fake aki",
+		Expiry:    time.Now().AddDate(1, 0, 0),
+		PEM:       "revoked cert",
+		Status:    "revoked",
+		RevokedAt: time.Now(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, err := ioutil.TempDir("", "crlpublish")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	partitions := &config.CRLPartitions{
+		URLs: []string{"http://crl.example.com/0.crl", "http://crl.example.com/1.crl"},
+	}
+	counter := &countingAccessor{Accessor: single.dbAccessor}
+	dirs := map[string]string{partitions.URLs[0]: dir}
+	p := NewPartitionedPublisher(counter, single.issuer, single.key, Options{Expiry: 2 * time.Hour, Partitions: partitions}, dirs, time.Hour)
+	if err = p.Publish(); err != nil {
+		t.Fatal(err)
+	}
+	if counter.reads != 1 {
+		t.Fatalf("want the revoked certificates read once per publication, got %d reads", counter.reads)
+	}
+	if p.Handler("http://crl.example.com/2.crl") != nil {
+		t.Fatal("want no handler for an unknown partition")
+	}
+
+	want := map[string]int64{
+		"http://crl.example.com/0.crl": 2,
+		"http://crl.example.com/1.crl": 1,
+	}
+	for url, serial := range want {
+		ts := httptest.NewServer(p.Handler(url))
+		resp, err := http.Get(ts.URL + "/partition.crl")
+		if err != nil {
+			t.Fatal(err)
+		}
+		der, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		crl, err := x509.ParseDERCRL(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries := crl.TBSCertList.RevokedCertificates
+		if len(entries) != 1 || entries[0].SerialNumber.Int64() != serial {
+			t.Fatalf("want serial %d alone in %s, got %d entries", serial, url, len(entries))
+		}
+	}
+
+	der, err := ioutil.ReadFile(filepath.Join(dir, DERFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = x509.ParseDERCRL(der); err != nil {
+		t.Fatal(err)
+	}
+}
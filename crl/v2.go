@@ -0,0 +1,200 @@
+package crl
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/ucosty/cfssl/helpers"
+)
+
+// Object identifiers of the CRL and CRL entry extensions from RFC 5280
+// sections 5.2 and 5.3.
+var (
+	oidAuthorityKeyID           = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidCRLNumber                = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidReasonCode               = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+)
+
+// signatureAlgorithms maps the algorithms chosen by helpers.SignerAlgo to
+// their object identifiers and hash functions.
+var signatureAlgorithms = map[x509.SignatureAlgorithm]struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+	rsa  bool
+}{
+	x509.SHA1WithRSA:     {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}, crypto.SHA1, true},
+	x509.SHA256WithRSA:   {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}, crypto.SHA256, true},
+	x509.SHA384WithRSA:   {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}, crypto.SHA384, true},
+	x509.SHA512WithRSA:   {asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}, crypto.SHA512, true},
+	x509.ECDSAWithSHA1:   {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}, crypto.SHA1, false},
+	x509.ECDSAWithSHA256: {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}, crypto.SHA256, false},
+	x509.ECDSAWithSHA384: {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}, crypto.SHA384, false},
+	x509.ECDSAWithSHA512: {asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}, crypto.SHA512, false},
+}
+
+// A Template describes the CRL built by CreateCRL.
+type Template struct {
+	// ThisUpdate and NextUpdate bound the validity of the CRL.
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	// Number is the CRL number. It is left out when nil.
+	Number *big.Int
+	// DistributionPoint, if set, is the URL named by a critical Issuing
+	// Distribution Point extension.
+	DistributionPoint string
+	// BaseNumber, if set, makes the CRL a delta CRL of the base CRL with
+	// that number, marked by a critical Delta CRL Indicator extension.
+	BaseNumber *big.Int
+}
+
+// The ASN.1 structures below follow RFC 5280 section 5.1. They are defined
+// here because crypto/x509 only creates v1 style CRLs without extensions.
+
+type certificateList struct {
+	TBSCertList        asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type tbsCertificateList struct {
+	Version             int `asn1:"optional,default:0"`
+	Signature           pkix.AlgorithmIdentifier
+	Issuer              asn1.RawValue
+	ThisUpdate          time.Time
+	NextUpdate          time.Time                 `asn1:"optional"`
+	RevokedCertificates []pkix.RevokedCertificate `asn1:"optional"`
+	Extensions          []pkix.Extension          `asn1:"tag:0,optional,explicit"`
+}
+
+type authorityKeyID struct {
+	ID []byte `asn1:"optional,tag:0"`
+}
+
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type issuingDistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+// CreateCRL creates a v2 CRL of certList signed by key on behalf of
+// issuingCert. Besides the extensions requested by template, the CRL
+// carries an Authority Key Identifier when issuingCert has a subject key
+// identifier.
+func CreateCRL(certList []pkix.RevokedCertificate, key crypto.Signer, issuingCert *x509.Certificate, template Template) ([]byte, error) {
+	sigAlg, ok := signatureAlgorithms[helpers.SignerAlgo(key)]
+	if !ok {
+		return nil, errors.New("unsupported CRL signing key")
+	}
+	algorithm := pkix.AlgorithmIdentifier{Algorithm: sigAlg.oid}
+	if sigAlg.rsa {
+		algorithm.Parameters = asn1.RawValue{Tag: asn1.TagNull}
+	}
+
+	extensions, err := crlExtensions(issuingCert, template)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 5280 requires UTC times; entries keep their extensions.
+	revoked := make([]pkix.RevokedCertificate, len(certList))
+	for i, entry := range certList {
+		revoked[i] = entry
+		revoked[i].RevocationTime = entry.RevocationTime.UTC()
+	}
+
+	tbs := tbsCertificateList{
+		Version:             1,
+		Signature:           algorithm,
+		Issuer:              asn1.RawValue{FullBytes: issuingCert.RawSubject},
+		ThisUpdate:          template.ThisUpdate.UTC(),
+		NextUpdate:          template.NextUpdate.UTC(),
+		RevokedCertificates: revoked,
+		Extensions:          extensions,
+	}
+
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	h := sigAlg.hash.New()
+	h.Write(tbsDER)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), sigAlg.hash)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(certificateList{
+		TBSCertList:        asn1.RawValue{FullBytes: tbsDER},
+		SignatureAlgorithm: algorithm,
+		SignatureValue:     asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+}
+
+// crlExtensions returns the CRL extensions for issuingCert and template.
+func crlExtensions(issuingCert *x509.Certificate, template Template) ([]pkix.Extension, error) {
+	var extensions []pkix.Extension
+
+	if len(issuingCert.SubjectKeyId) > 0 {
+		value, err := asn1.Marshal(authorityKeyID{ID: issuingCert.SubjectKeyId})
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidAuthorityKeyID, Value: value})
+	}
+
+	if template.Number != nil {
+		value, err := asn1.Marshal(template.Number)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidCRLNumber, Value: value})
+	}
+
+	if template.BaseNumber != nil {
+		value, err := asn1.Marshal(template.BaseNumber)
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidDeltaCRLIndicator, Critical: true, Value: value})
+	}
+
+	if template.DistributionPoint != "" {
+		value, err := asn1.Marshal(issuingDistributionPoint{
+			DistributionPoint: distributionPointName{
+				FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(template.DistributionPoint)}},
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		extensions = append(extensions, pkix.Extension{Id: oidIssuingDistributionPoint, Critical: true, Value: value})
+	}
+
+	return extensions, nil
+}
+
+// reasonCodeExtension returns the reasonCode entry extension for reason.
+// It returns false for unspecified (0) and for values RFC 5280 does not
+// define, including the unused value 7.
+func reasonCodeExtension(reason int) (pkix.Extension, bool) {
+	if reason <= 0 || reason == 7 || reason > 10 {
+		return pkix.Extension{}, false
+	}
+
+	value, err := asn1.Marshal(asn1.Enumerated(reason))
+	if err != nil {
+		return pkix.Extension{}, false
+	}
+	return pkix.Extension{Id: oidReasonCode, Value: value}, true
+}
@@ -0,0 +1,205 @@
+package pkcs7
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+
+	cferr "github.com/ucosty/cfssl/errors"
+)
+
+// Object identifiers of the supported content encryption algorithms.
+var (
+	OIDEncryptionAlgorithmDESCBC     = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 7}
+	OIDEncryptionAlgorithmDESEDE3CBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}
+	OIDEncryptionAlgorithmAES128CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	OIDEncryptionAlgorithmAES192CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	OIDEncryptionAlgorithmAES256CBC  = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// EnvelopedData holds content encrypted with a key that is itself
+// encrypted to each recipient.
+type EnvelopedData struct {
+	Raw                  asn1.RawContent
+	Version              int
+	RecipientInfos       []RecipientInfo `asn1:"set"`
+	EncryptedContentInfo EncryptedContentInfo
+}
+
+// RecipientInfo holds the content encryption key encrypted to the public
+// key of one recipient.
+type RecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  IssuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// contentCipher is a supported content encryption algorithm, used in CBC mode.
+type contentCipher struct {
+	keySize  int
+	newBlock func(key []byte) (cipher.Block, error)
+}
+
+// lookupContentCipher returns the contentCipher of alg.
+func lookupContentCipher(alg asn1.ObjectIdentifier) (contentCipher, error) {
+	switch {
+	case alg.Equal(OIDEncryptionAlgorithmDESCBC):
+		return contentCipher{8, des.NewCipher}, nil
+	case alg.Equal(OIDEncryptionAlgorithmDESEDE3CBC):
+		return contentCipher{24, des.NewTripleDESCipher}, nil
+	case alg.Equal(OIDEncryptionAlgorithmAES128CBC):
+		return contentCipher{16, aes.NewCipher}, nil
+	case alg.Equal(OIDEncryptionAlgorithmAES192CBC):
+		return contentCipher{24, aes.NewCipher}, nil
+	case alg.Equal(OIDEncryptionAlgorithmAES256CBC):
+		return contentCipher{32, aes.NewCipher}, nil
+	}
+	return contentCipher{}, errors.New("unsupported content encryption algorithm " + alg.String())
+}
+
+// errDecryptionFailed is the only error of Decrypt once the recipient
+// is found, so that callers cannot be used as a padding oracle on the
+// recipient's key.
+var errDecryptionFailed = errors.New("the message could not be decrypted")
+
+// Decrypt returns the content of ed, decrypting the content encryption
+// key with key, the RSA private key of the recipient cert. The key is
+// decrypted as a PKCS #1 v1.5 session key: a malformed one is replaced
+// with a random key, so that it fails like any other wrong key when the
+// content is decrypted, in constant time.
+func (ed *EnvelopedData) Decrypt(cert *x509.Certificate, key *rsa.PrivateKey) ([]byte, error) {
+	for _, ri := range ed.RecipientInfos {
+		if !ri.IssuerAndSerialNumber.identifies(cert) {
+			continue
+		}
+		if !ri.KeyEncryptionAlgorithm.Algorithm.Equal(oidEncryptionRSA) {
+			return nil, cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+		}
+		c, err := lookupContentCipher(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.DecodeFailed, err)
+		}
+
+		cek := make([]byte, c.keySize)
+		if _, err = rand.Read(cek); err != nil {
+			return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+		}
+		if err = rsa.DecryptPKCS1v15SessionKey(rand.Reader, key, ri.EncryptedKey, cek); err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.DecodeFailed, errDecryptionFailed)
+		}
+		content, err := ed.EncryptedContentInfo.decrypt(cek)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.DecodeFailed, errDecryptionFailed)
+		}
+		return content, nil
+	}
+	return nil, cferr.Wrap(cferr.CertificateError, cferr.DecodeFailed, errors.New("the certificate is not a recipient of the message"))
+}
+
+// decrypt returns the CBC encrypted content of eci, removing its padding.
+func (eci *EncryptedContentInfo) decrypt(key []byte) ([]byte, error) {
+	c, err := lookupContentCipher(eci.ContentEncryptionAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if len(key) != c.keySize {
+		return nil, errors.New("the content encryption key has the wrong size")
+	}
+	block, err := c.newBlock(key)
+	if err != nil {
+		return nil, err
+	}
+	var iv []byte
+	if _, err = asn1.Unmarshal(eci.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv); err != nil {
+		return nil, err
+	}
+	size := block.BlockSize()
+	content := eci.EncryptedContent
+	if len(iv) != size || len(content) == 0 || len(content)%size != 0 {
+		return nil, errors.New("malformed encrypted content")
+	}
+
+	plain := make([]byte, len(content))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, content)
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > size {
+		return nil, errors.New("invalid content padding")
+	}
+	for _, b := range plain[len(plain)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("invalid content padding")
+		}
+	}
+	return plain[:len(plain)-pad], nil
+}
+
+// Encrypt returns the DER encoding of an EnvelopedData ContentInfo
+// holding content encrypted with the algorithm alg for recipients, whose
+// keys must be RSA.
+func Encrypt(content []byte, recipients []*x509.Certificate, alg asn1.ObjectIdentifier) ([]byte, error) {
+	c, err := lookupContentCipher(alg)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	key := make([]byte, c.keySize)
+	if _, err = rand.Read(key); err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	block, err := c.newBlock(key)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	size := block.BlockSize()
+	iv := make([]byte, size)
+	if _, err = rand.Read(iv); err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	params, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+
+	pad := size - len(content)%size
+	encrypted := make([]byte, len(content)+pad)
+	copy(encrypted, content)
+	for i := len(content); i < len(encrypted); i++ {
+		encrypted[i] = byte(pad)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	ed := EnvelopedData{
+		EncryptedContentInfo: EncryptedContentInfo{
+			ContentType:                oidData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: alg, Parameters: asn1.RawValue{FullBytes: params}},
+			EncryptedContent:           encrypted,
+		},
+	}
+	for _, recipient := range recipients {
+		pub, ok := recipient.PublicKey.(*rsa.PublicKey)
+		if !ok {
+			return nil, cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+		}
+		encryptedKey, err := rsa.EncryptPKCS1v15(rand.Reader, pub, key)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+		}
+		ed.RecipientInfos = append(ed.RecipientInfos, RecipientInfo{
+			IssuerAndSerialNumber:  issuerAndSerialNumber(recipient),
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		})
+	}
+
+	der, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	return marshalContentInfo(oidEnvelopedData, der)
+}
@@ -16,10 +16,10 @@
 //			}
 //
 // There are 6 possible ContentTypes, data, signedData, envelopedData,
-// signedAndEnvelopedData, digestedData, and encryptedData.  Here signedData, Data, and encrypted
-// Data are implemented, as the degenerate case of signedData without a signature is the typical
-// format for transferring certificates and CRLS, and Data and encryptedData are used in PKCS #12
-// formats.
+// signedAndEnvelopedData, digestedData, and encryptedData.  Here signedData, Data, envelopedData
+// and encrypted Data are implemented, as the degenerate case of signedData without a signature is
+// the typical format for transferring certificates and CRLS, Data and encryptedData are used in
+// PKCS #12 formats, and signed envelopedData carries SCEP messages.
 // The ContentType signedData has the form:
 //
 //
@@ -32,10 +32,9 @@
 //				signerInfos SignerInfos
 //			}
 //
-// As of yet signerInfos and digestAlgorithms are not parsed, as they are not relevant to
-// this system's use of PKCS #7 data.  Version is an integer type, note that PKCS #7 is
-// recursive, this second layer of ContentInfo is similar ignored for our degenerate
-// usage.  The ExtendedCertificatesAndCertificates type consists of a sequence of choices
+// The signerInfos are parsed so that SignedData.Verify can check the signatures over
+// the content of the second layer of ContentInfo, which holds Data; digestAlgorithms
+// are not, as each signerInfo repeats its digest algorithm.  Version is an integer type.  The ExtendedCertificatesAndCertificates type consists of a sequence of choices
 // between PKCS #6 extended certificates and x509 certificates.  Any sequence consisting
 // of any number of extended certificates is not yet supported in this implementation.
 //
@@ -44,6 +43,14 @@
 // The ContentType encryptedData is the most complicated and its form can be gathered by
 // the go type below.  It essentially contains a raw octet string of encrypted data and an
 // algorithm identifier for use in decrypting this data.
+//
+// The ContentType envelopedData holds content encrypted with a random key, which is in
+// turn encrypted to the public key of each recipient.  Decrypt recovers the content with
+// the private key of one recipient.
+//
+// Besides parsing, DegenerateCertificates builds the degenerate signedData
+// used to transfer certificates, SignData builds signed Data and Encrypt
+// builds envelopedData.
 package pkcs7
 
 import (
@@ -61,8 +68,8 @@ type signedData struct {
 	Version          int
 	DigestAlgorithms asn1.RawValue
 	ContentInfo      asn1.RawValue
-	Certificates     asn1.RawValue `asn1:"optional" asn1:"tag:0"`
-	Crls             asn1.RawValue `asn1:"optional"`
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	Crls             asn1.RawValue `asn1:"optional,tag:1"`
 	SignerInfos      asn1.RawValue
 }
 
@@ -72,38 +79,44 @@ type initPKCS7 struct {
 	Content     asn1.RawValue `asn1:"tag:0,explicit,optional"`
 }
 
-// Object identifier strings of the three implemented PKCS7 types.
+// Object identifier strings of the four implemented PKCS7 types.
 const (
 	ObjIDData          = "1.2.840.113549.1.7.1"
 	ObjIDSignedData    = "1.2.840.113549.1.7.2"
+	ObjIDEnvelopedData = "1.2.840.113549.1.7.3"
 	ObjIDEncryptedData = "1.2.840.113549.1.7.6"
 )
 
-// PKCS7 represents the ASN1 PKCS #7 Content type.  It contains one of three
+// PKCS7 represents the ASN1 PKCS #7 Content type.  It contains one of four
 // possible types of Content objects, as denoted by the object identifier in
-// the ContentInfo field, the other two being nil.  SignedData
-// is the degenerate SignedData Content info without signature used
-// to hold certificates and crls.  Data is raw bytes, and EncryptedData
-// is as defined in PKCS #7 standard.
+// the ContentInfo field, the others being nil.  SignedData is typically
+// the degenerate SignedData Content info without signature used
+// to hold certificates and crls.  Data is raw bytes, and EnvelopedData and
+// EncryptedData are as defined in PKCS #7 standard.
 type PKCS7 struct {
 	Raw         asn1.RawContent
 	ContentInfo string
 	Content     Content
 }
 
-// Content implements three of the six possible PKCS7 data types.  Only one is non-nil.
+// Content implements four of the six possible PKCS7 data types.  Only one is non-nil.
 type Content struct {
 	Data          []byte
 	SignedData    SignedData
+	EnvelopedData EnvelopedData
 	EncryptedData EncryptedData
 }
 
-// SignedData defines the typical carrier of certificates and crls.
+// SignedData defines the typical carrier of certificates and crls.  When
+// it is signed, Content holds the signed data, if it is not detached, and
+// SignerInfos the signatures over it.
 type SignedData struct {
 	Raw          asn1.RawContent
 	Version      int
 	Certificates []*x509.Certificate
 	Crl          *pkix.CertificateList
+	Content      []byte
+	SignerInfos  []SignerInfo
 }
 
 // Data contains raw bytes.  Used as a subtype in PKCS12.
@@ -165,8 +178,31 @@ func ParsePKCS7(raw []byte) (msg *PKCS7, err error) {
 				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
 			}
 		}
+		var content initPKCS7
+		_, err = asn1.Unmarshal(signedData.ContentInfo.FullBytes, &content)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+		if len(content.Content.Bytes) != 0 {
+			_, err = asn1.Unmarshal(content.Content.Bytes, &msg.Content.SignedData.Content)
+			if err != nil {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+			}
+		}
+		msg.Content.SignedData.SignerInfos, err = parseSignerInfos(signedData.SignerInfos.Bytes)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
 		msg.Content.SignedData.Version = signedData.Version
 		msg.Content.SignedData.Raw = pkcs7.Content.Bytes
+	case msg.ContentInfo == ObjIDEnvelopedData:
+		msg.ContentInfo = "EnvelopedData"
+		var envelopedData EnvelopedData
+		_, err = asn1.Unmarshal(pkcs7.Content.Bytes, &envelopedData)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+		msg.Content.EnvelopedData = envelopedData
 	case msg.ContentInfo == ObjIDEncryptedData:
 		msg.ContentInfo = "EncryptedData"
 		var encryptedData EncryptedData
@@ -180,9 +216,72 @@ func ParsePKCS7(raw []byte) (msg *PKCS7, err error) {
 		msg.Content.EncryptedData = encryptedData
 
 	default:
-		return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("Attempt to parse PKCS# 7 Content not of type data, signed data or encrypted data"))
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("Attempt to parse PKCS# 7 Content not of type data, signed data, enveloped data or encrypted data"))
 	}
 
 	return msg, nil
 
 }
+
+// Types used for asn1 Marshaling.
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type degenerateSignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue
+	Crls             asn1.RawValue
+	SignerInfos      asn1.RawValue
+}
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+)
+
+// emptySet is the DER encoding of an empty SET.
+var emptySet = asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+
+// DegenerateCertificates returns the DER encoding of a degenerate
+// SignedData ContentInfo carrying certs, with neither content nor
+// signers. This is the "certs-only" message of EST and SCEP, encoded as
+// openssl crl2pkcs7 -nocrl does, with an empty list of CRLs.
+func DegenerateCertificates(certs []*x509.Certificate) ([]byte, error) {
+	var raw []byte
+	for _, cert := range certs {
+		raw = append(raw, cert.Raw...)
+	}
+
+	sd, err := asn1.Marshal(degenerateSignedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: raw},
+		Crls:             asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 1, IsCompound: true},
+		SignerInfos:      emptySet,
+	})
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// marshalContentInfo returns the DER encoding of a ContentInfo of type
+// contentType holding the encoded content.
+func marshalContentInfo(contentType asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	der, err := asn1.Marshal(contentInfo{
+		ContentType: contentType,
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: content},
+	})
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	return der, nil
+}
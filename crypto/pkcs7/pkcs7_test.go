@@ -0,0 +1,203 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+	"time"
+)
+
+const (
+	testBundlePKCS7 = "../../helpers/testdata/bundle_pkcs7.pem"
+	testCAFile      = "../../helpers/testdata/ca.pem"
+	testCAKeyFile   = "../../helpers/testdata/ca_key.pem"
+)
+
+func readPEM(t *testing.T, path string) []byte {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		t.Fatalf("no PEM block in %s", path)
+	}
+	return block.Bytes
+}
+
+// testCA returns the RSA test CA certificate and key.
+func testCA(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	cert, err := x509.ParseCertificate(readPEM(t, testCAFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(readPEM(t, testCAKeyFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key.(crypto.Signer)
+}
+
+// selfSigned returns a self-signed ECDSA certificate and its key.
+func selfSigned(t *testing.T) (*x509.Certificate, crypto.Signer) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "signer"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestDegenerateCertificates(t *testing.T) {
+	raw := readPEM(t, testBundlePKCS7)
+	msg, err := ParsePKCS7(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certs := msg.Content.SignedData.Certificates
+	if len(certs) < 2 {
+		t.Fatalf("expected a bundle, got %d certificates", len(certs))
+	}
+
+	der, err := DegenerateCertificates(certs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(der, raw) {
+		t.Fatal("the degenerate SignedData differs from the one openssl produced")
+	}
+	msg, err = ParsePKCS7(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.ContentInfo != "SignedData" || len(msg.Content.SignedData.Certificates) != len(certs) {
+		t.Fatalf("unexpected round trip %+v", msg)
+	}
+	for i, cert := range msg.Content.SignedData.Certificates {
+		if !cert.Equal(certs[i]) {
+			t.Fatalf("certificate %d differs", i)
+		}
+	}
+}
+
+func TestSignData(t *testing.T) {
+	rsaCert, rsaKey := testCA(t)
+	ecCert, ecKey := selfSigned(t)
+	oidTest := asn1.ObjectIdentifier{1, 2, 3, 4}
+	attr, err := NewAttribute(oidTest, "value")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		cert    *x509.Certificate
+		key     crypto.Signer
+		hash    crypto.Hash
+		content []byte
+	}{
+		{rsaCert, rsaKey, crypto.SHA256, []byte("content")},
+		{rsaCert, rsaKey, crypto.SHA1, nil},
+		{ecCert, ecKey, crypto.SHA512, []byte("content")},
+	} {
+		der, err := SignData(tc.content, tc.cert, tc.key, tc.hash, []Attribute{attr})
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ParsePKCS7(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sd := msg.Content.SignedData
+		if msg.ContentInfo != "SignedData" || !bytes.Equal(sd.Content, tc.content) || len(sd.SignerInfos) != 1 {
+			t.Fatalf("unexpected signed data %+v", msg)
+		}
+		signers, err := sd.Verify()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(signers) != 1 || !signers[0].Equal(tc.cert) {
+			t.Fatal("expected the signer certificate to be returned")
+		}
+		var value string
+		if err = sd.SignerInfos[0].UnmarshalAttribute(oidTest, &value); err != nil || value != "value" {
+			t.Fatalf("expected the authenticated attribute, got %q: %v", value, err)
+		}
+
+		sd.Content = []byte("tampered")
+		if _, err = sd.Verify(); err == nil {
+			t.Fatal("expected tampered content to fail verification")
+		}
+		sd.Content = tc.content
+		sd.SignerInfos[0].signedAttributes[len(sd.SignerInfos[0].signedAttributes)-1] ^= 1
+		if _, err = sd.Verify(); err == nil {
+			t.Fatal("expected tampered attributes to fail verification")
+		}
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	cert, key := testCA(t)
+	other, _ := selfSigned(t)
+	content := []byte("a message of more than one block")
+
+	for _, alg := range []asn1.ObjectIdentifier{
+		OIDEncryptionAlgorithmDESCBC,
+		OIDEncryptionAlgorithmDESEDE3CBC,
+		OIDEncryptionAlgorithmAES128CBC,
+		OIDEncryptionAlgorithmAES192CBC,
+		OIDEncryptionAlgorithmAES256CBC,
+	} {
+		der, err := Encrypt(content, []*x509.Certificate{cert}, alg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msg, err := ParsePKCS7(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.ContentInfo != "EnvelopedData" {
+			t.Fatalf("unexpected content type %s", msg.ContentInfo)
+		}
+		ed := msg.Content.EnvelopedData
+		if !ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Algorithm.Equal(alg) {
+			t.Fatalf("expected content encrypted with %v", alg)
+		}
+		plain, err := ed.Decrypt(cert, key.(*rsa.PrivateKey))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(plain, content) {
+			t.Fatalf("decrypted %q", plain)
+		}
+		if _, err = ed.Decrypt(other, key.(*rsa.PrivateKey)); err == nil {
+			t.Fatal("expected decryption for another recipient to fail")
+		}
+	}
+
+	if _, err := Encrypt(content, []*x509.Certificate{other}, OIDEncryptionAlgorithmAES128CBC); err == nil {
+		t.Fatal("expected encryption to an ECDSA key to fail")
+	}
+}
@@ -0,0 +1,345 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	// Register the digest algorithms of signed data.
+	_ "crypto/sha1"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+
+	cferr "github.com/ucosty/cfssl/errors"
+)
+
+var (
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	oidEncryptionRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// digestAlgorithms are the object identifiers of the supported digests.
+var digestAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 3, 14, 3, 2, 26},
+	crypto.SHA256: {2, 16, 840, 1, 101, 3, 4, 2, 1},
+	crypto.SHA384: {2, 16, 840, 1, 101, 3, 4, 2, 2},
+	crypto.SHA512: {2, 16, 840, 1, 101, 3, 4, 2, 3},
+}
+
+// ecdsaSignatureAlgorithms are the object identifiers of ECDSA signatures
+// with each supported digest.
+var ecdsaSignatureAlgorithms = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 2, 840, 10045, 4, 1},
+	crypto.SHA256: {1, 2, 840, 10045, 4, 3, 2},
+	crypto.SHA384: {1, 2, 840, 10045, 4, 3, 3},
+	crypto.SHA512: {1, 2, 840, 10045, 4, 3, 4},
+}
+
+// digestHash returns the hash function of a digest algorithm.
+func digestHash(alg asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for hash, oid := range digestAlgorithms {
+		if oid.Equal(alg) {
+			return hash, nil
+		}
+	}
+	return 0, errors.New("unsupported digest algorithm " + alg.String())
+}
+
+// IssuerAndSerialNumber identifies a certificate by its issuer and serial number.
+type IssuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// issuerAndSerialNumber returns the IssuerAndSerialNumber of cert.
+func issuerAndSerialNumber(cert *x509.Certificate) IssuerAndSerialNumber {
+	return IssuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	}
+}
+
+// identifies reports whether ias identifies cert.
+func (ias IssuerAndSerialNumber) identifies(cert *x509.Certificate) bool {
+	return ias.SerialNumber != nil && ias.SerialNumber.Cmp(cert.SerialNumber) == 0 &&
+		bytes.Equal(ias.Issuer.FullBytes, cert.RawIssuer)
+}
+
+// Attribute is an authenticated attribute of a signer, with a single value.
+type Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// NewAttribute returns the attribute oid whose value is the DER encoding of v.
+func NewAttribute(oid asn1.ObjectIdentifier, v interface{}) (Attribute, error) {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return Attribute{}, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	return Attribute{Type: oid, Value: asn1.RawValue{FullBytes: der}}, nil
+}
+
+// attribute is the encoding of an Attribute, whose values are a SET.
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue
+}
+
+// SignerInfo is the signature of one signer over the content of SignedData.
+type SignerInfo struct {
+	IssuerAndSerialNumber     IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   []Attribute
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+
+	// signedAttributes is the DER encoding of the authenticated attributes
+	// as a SET, which is what the signature covers.
+	signedAttributes []byte
+}
+
+// signerInfo is the encoding of a SignerInfo.
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     IssuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// UnmarshalAttribute parses the value of the authenticated attribute oid
+// into v, as asn1.Unmarshal does.
+func (si *SignerInfo) UnmarshalAttribute(oid asn1.ObjectIdentifier, v interface{}) error {
+	for _, attr := range si.AuthenticatedAttributes {
+		if !attr.Type.Equal(oid) {
+			continue
+		}
+		rest, err := asn1.Unmarshal(attr.Value.FullBytes, v)
+		if err == nil && len(rest) != 0 {
+			err = errors.New("trailing data after attribute " + oid.String())
+		}
+		if err != nil {
+			return cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, err)
+		}
+		return nil
+	}
+	return cferr.Wrap(cferr.CertificateError, cferr.ParseFailed, errors.New("missing attribute "+oid.String()))
+}
+
+// parseSignerInfos parses the contents of the signerInfos SET.
+func parseSignerInfos(raw []byte) ([]SignerInfo, error) {
+	var infos []SignerInfo
+	for len(raw) != 0 {
+		var si signerInfo
+		var err error
+		if raw, err = asn1.Unmarshal(raw, &si); err != nil {
+			return nil, err
+		}
+
+		info := SignerInfo{
+			IssuerAndSerialNumber:     si.IssuerAndSerialNumber,
+			DigestAlgorithm:           si.DigestAlgorithm,
+			DigestEncryptionAlgorithm: si.DigestEncryptionAlgorithm,
+			EncryptedDigest:           si.EncryptedDigest,
+		}
+		if len(si.AuthenticatedAttributes.FullBytes) != 0 {
+			// The signature covers the attributes with their
+			// universal SET tag rather than the implicit [0].
+			info.signedAttributes = append([]byte{0x31}, si.AuthenticatedAttributes.FullBytes[1:]...)
+			attrs := si.AuthenticatedAttributes.Bytes
+			for len(attrs) != 0 {
+				var attr attribute
+				if attrs, err = asn1.Unmarshal(attrs, &attr); err != nil {
+					return nil, err
+				}
+				if attr.Values.Tag != asn1.TagSet || len(attr.Values.Bytes) == 0 {
+					return nil, errors.New("attribute " + attr.Type.String() + " has no values")
+				}
+				var value asn1.RawValue
+				if _, err = asn1.Unmarshal(attr.Values.Bytes, &value); err != nil {
+					return nil, err
+				}
+				info.AuthenticatedAttributes = append(info.AuthenticatedAttributes, Attribute{Type: attr.Type, Value: value})
+			}
+		}
+		infos = append(infos, info)
+	}
+	return infos, nil
+}
+
+// Verify checks the signature of every signer over the content, and
+// returns the certificates of the signers, which the message must carry.
+// It does not check that the certificates are trusted.
+func (sd *SignedData) Verify() ([]*x509.Certificate, error) {
+	if len(sd.SignerInfos) == 0 {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, errors.New("the message is not signed"))
+	}
+
+	var signers []*x509.Certificate
+	for i := range sd.SignerInfos {
+		si := &sd.SignerInfos[i]
+		var cert *x509.Certificate
+		for _, c := range sd.Certificates {
+			if si.IssuerAndSerialNumber.identifies(c) {
+				cert = c
+				break
+			}
+		}
+		if cert == nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, errors.New("the certificate of a signer is missing"))
+		}
+
+		hash, err := digestHash(si.DigestAlgorithm.Algorithm)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, err)
+		}
+		signed := sd.Content
+		if si.signedAttributes != nil {
+			var digest []byte
+			if err = si.UnmarshalAttribute(oidAttributeMessageDigest, &digest); err != nil {
+				return nil, err
+			}
+			h := hash.New()
+			h.Write(sd.Content)
+			if !bytes.Equal(digest, h.Sum(nil)) {
+				return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, errors.New("the message digest does not match the content"))
+			}
+			signed = si.signedAttributes
+		}
+		if err = checkSignature(cert.PublicKey, hash, signed, si.EncryptedDigest); err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, err)
+		}
+		signers = append(signers, cert)
+	}
+	return signers, nil
+}
+
+// checkSignature verifies an RSA or ECDSA signature over signed.
+func checkSignature(pub crypto.PublicKey, hash crypto.Hash, signed, signature []byte) error {
+	h := hash.New()
+	h.Write(signed)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), signature)
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(pub, h.Sum(nil), signature) {
+			return errors.New("ECDSA verification failure")
+		}
+		return nil
+	}
+	return errors.New("unsupported signer public key")
+}
+
+// signedDataMessage is the encoding of signed SignedData.
+type signedDataMessage struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue
+	SignerInfos      []signerInfo `asn1:"set"`
+}
+
+// SignData returns the DER encoding of a SignedData ContentInfo holding
+// content, which may be nil, signed by key with the digest hash. Besides
+// the content type and digest, the signature authenticates attrs. cert,
+// the certificate of key, is included in the message.
+func SignData(content []byte, cert *x509.Certificate, key crypto.Signer, hash crypto.Hash, attrs []Attribute) ([]byte, error) {
+	digestAlgorithm, ok := digestAlgorithms[hash]
+	if !ok {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, errors.New("unsupported digest algorithm"))
+	}
+	var signatureAlgorithm pkix.AlgorithmIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: oidEncryptionRSA, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = pkix.AlgorithmIdentifier{Algorithm: ecdsaSignatureAlgorithms[hash]}
+	default:
+		return nil, cferr.New(cferr.PrivateKeyError, cferr.NotRSAOrECC)
+	}
+
+	h := hash.New()
+	h.Write(content)
+	contentType, err := NewAttribute(oidAttributeContentType, oidData)
+	if err != nil {
+		return nil, err
+	}
+	messageDigest, err := NewAttribute(oidAttributeMessageDigest, h.Sum(nil))
+	if err != nil {
+		return nil, err
+	}
+	encodedAttributes, err := marshalAttributes(append([]Attribute{contentType, messageDigest}, attrs...))
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	signedAttributes, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: encodedAttributes})
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+
+	h = hash.New()
+	h.Write(signedAttributes)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.PrivateKeyError, cferr.Unknown, err)
+	}
+
+	digestAlgorithmID := pkix.AlgorithmIdentifier{Algorithm: digestAlgorithm, Parameters: asn1.NullRawValue}
+	msg := signedDataMessage{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{digestAlgorithmID},
+		ContentInfo:      contentInfo{ContentType: oidData},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: cert.Raw},
+		SignerInfos: []signerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     issuerAndSerialNumber(cert),
+			DigestAlgorithm:           digestAlgorithmID,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: encodedAttributes},
+			DigestEncryptionAlgorithm: signatureAlgorithm,
+			EncryptedDigest:           signature,
+		}},
+	}
+	if content != nil {
+		data, err := asn1.Marshal(content)
+		if err != nil {
+			return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+		}
+		msg.ContentInfo.Content = asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: data}
+	}
+
+	sd, err := asn1.Marshal(msg)
+	if err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.Unknown, err)
+	}
+	return marshalContentInfo(oidSignedData, sd)
+}
+
+// marshalAttributes returns the concatenated encodings of attrs, sorted
+// as the members of a DER SET OF are.
+func marshalAttributes(attrs []Attribute) ([]byte, error) {
+	encoded := make([][]byte, len(attrs))
+	for i, attr := range attrs {
+		der, err := asn1.Marshal(attribute{
+			Type:   attr.Type,
+			Values: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: attr.Value.FullBytes},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded[i] = der
+	}
+	sort.Slice(encoded, func(i, j int) bool { return bytes.Compare(encoded[i], encoded[j]) < 0 })
+	return bytes.Join(encoded, nil), nil
+}
@@ -203,6 +203,10 @@ const (
 	// RecordNotFound occurs when a SQL query targeting on one unique
 	// record failes to update the specified row in the table.
 	RecordNotFound
+	// InvalidRecordStatus occurs when the status of a record does not
+	// allow the requested change, such as releasing a certificate that is
+	// not on hold.
+	InvalidRecordStatus
 )
 
 // The error interface implementation, which formats to a JSON object string.
@@ -45,3 +45,9 @@ func NewBadRequestMissingParameter(s string) *HTTPError {
 func NewBadRequestUnwantedParameter(s string) *HTTPError {
 	return NewBadRequestString(`Unwanted parameter "` + s + `"`)
 }
+
+// NewHTTPError returns a HttpError with the supplied status code and
+// message.
+func NewHTTPError(statusCode int, s string) *HTTPError {
+	return &HTTPError{statusCode, errors.New(s)}
+}
@@ -15,7 +15,8 @@ import (
 	"regexp"
 	"strings"
 
-	"github.com/ucosty/cfssl/certdb/dbconf"
+	"github.com/ucosty/cfssl/certdb"
+	"github.com/ucosty/cfssl/certdb/factory"
 	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/helpers/derhelpers"
@@ -24,7 +25,6 @@ import (
 
 	"github.com/cloudflare/redoctober/client"
 	"github.com/cloudflare/redoctober/core"
-	"github.com/jmoiron/sqlx"
 )
 
 // RawMap is shorthand for the type used as a map from string to raw Root struct.
@@ -104,7 +104,7 @@ type Root struct {
 	Certificate *x509.Certificate
 	Config      *config.Signing
 	ACL         whitelist.NetACL
-	DB          *sqlx.DB
+	DB          certdb.Accessor
 }
 
 // LoadRoot parses a config structure into a Root structure
@@ -157,7 +157,7 @@ func LoadRoot(cfg map[string]string) (*Root, error) {
 
 	dbConfig := cfg["dbconfig"]
 	if dbConfig != "" {
-		db, err := dbconf.DBFromConfig(dbConfig)
+		db, err := certdbfactory.NewAccessor(dbConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -2,7 +2,13 @@
 // signer.
 package config
 
-import "time"
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
 
 // Config contains configuration information required to set up an OCSP signer.
 type Config struct {
@@ -11,3 +17,38 @@ type Config struct {
 	KeyFile           string
 	Interval          time.Duration
 }
+
+// Issuer describes one issuer served by a multi-issuer OCSP responder.
+// The responder certificate and key are only needed to sign responses on
+// demand; without them, pre-signed responses are served.
+type Issuer struct {
+	CACertFile        string `json:"ca"`
+	ResponderCertFile string `json:"responder,omitempty"`
+	KeyFile           string `json:"responder_key,omitempty"`
+}
+
+// LoadIssuersFile reads a JSON array of issuers from path.
+func LoadIssuersFile(path string) ([]Issuer, error) {
+	body, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var issuers []Issuer
+	if err = json.Unmarshal(body, &issuers); err != nil {
+		return nil, fmt.Errorf("failed to parse issuers file %s: %v", path, err)
+	}
+
+	if len(issuers) == 0 {
+		return nil, errors.New("issuers file lists no issuers")
+	}
+	for i, issuer := range issuers {
+		if issuer.CACertFile == "" {
+			return nil, fmt.Errorf("issuer %d has no CA certificate", i)
+		}
+		if (issuer.ResponderCertFile == "") != (issuer.KeyFile == "") {
+			return nil, fmt.Errorf("issuer %s needs both a responder certificate and key", issuer.CACertFile)
+		}
+	}
+	return issuers, nil
+}
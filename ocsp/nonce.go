@@ -0,0 +1,167 @@
+package ocsp
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"time"
+)
+
+// NonceOID is the object identifier of the OCSP nonce extension (RFC 8954).
+var NonceOID = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// Nonce length limits from RFC 8954 section 2.1.
+const (
+	minNonceLength = 1
+	maxNonceLength = 32
+)
+
+// The ASN.1 structures below mirror golang.org/x/crypto/ocsp, which does not
+// expose request extensions or let responses carry responseExtensions.
+
+type nonceRequest struct {
+	TBSRequest nonceTBSRequest
+}
+
+type nonceTBSRequest struct {
+	Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+	RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+	RequestList       []asn1.RawValue
+	RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+}
+
+type responseASN1 struct {
+	Status   asn1.Enumerated
+	Response responseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type responseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type basicResponse struct {
+	TBSResponseData    responseData
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+	Certificates       []asn1.RawValue `asn1:"explicit,tag:0,optional"`
+}
+
+type responseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	RawResponderID     asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []asn1.RawValue
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// signatureHashes maps the signature algorithms used by
+// golang.org/x/crypto/ocsp to their hash functions.
+var signatureHashes = map[string]crypto.Hash{
+	"1.2.840.113549.1.1.5":  crypto.SHA1,   // sha1WithRSAEncryption
+	"1.2.840.113549.1.1.11": crypto.SHA256, // sha256WithRSAEncryption
+	"1.2.840.113549.1.1.12": crypto.SHA384, // sha384WithRSAEncryption
+	"1.2.840.113549.1.1.13": crypto.SHA512, // sha512WithRSAEncryption
+	"1.2.840.10045.4.1":     crypto.SHA1,   // ecdsa-with-SHA1
+	"1.2.840.10045.4.3.2":   crypto.SHA256, // ecdsa-with-SHA256
+	"1.2.840.10045.4.3.3":   crypto.SHA384, // ecdsa-with-SHA384
+	"1.2.840.10045.4.3.4":   crypto.SHA512, // ecdsa-with-SHA512
+}
+
+// RequestNonce returns the nonce carried by the DER encoded OCSP request,
+// or nil if it has none. It returns an error if the request cannot be
+// parsed or the nonce is outside the lengths allowed by RFC 8954.
+func RequestNonce(der []byte) ([]byte, error) {
+	var req nonceRequest
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		return nil, err
+	}
+
+	for _, ext := range req.TBSRequest.RequestExtensions {
+		if !ext.Id.Equal(NonceOID) {
+			continue
+		}
+
+		var nonce []byte
+		if rest, err := asn1.Unmarshal(ext.Value, &nonce); err != nil || len(rest) > 0 {
+			return nil, errors.New("malformed OCSP nonce")
+		}
+		if len(nonce) < minNonceLength || len(nonce) > maxNonceLength {
+			return nil, errors.New("OCSP nonce length is out of range")
+		}
+		return nonce, nil
+	}
+	return nil, nil
+}
+
+// ResponseNonce returns the nonce echoed in the responseExtensions of the
+// DER encoded OCSP response, or nil if it has none.
+func ResponseNonce(der []byte) ([]byte, error) {
+	var resp responseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+
+	for _, ext := range basic.TBSResponseData.ResponseExtensions {
+		if ext.Id.Equal(NonceOID) {
+			var nonce []byte
+			if _, err := asn1.Unmarshal(ext.Value, &nonce); err != nil {
+				return nil, err
+			}
+			return nonce, nil
+		}
+	}
+	return nil, nil
+}
+
+// addNonce adds a nonce response extension to the DER encoded OCSP
+// response and signs it again with key.
+func addNonce(der []byte, nonce []byte, key crypto.Signer) ([]byte, error) {
+	var resp responseASN1
+	if _, err := asn1.Unmarshal(der, &resp); err != nil {
+		return nil, err
+	}
+
+	var basic basicResponse
+	if _, err := asn1.Unmarshal(resp.Response.Response, &basic); err != nil {
+		return nil, err
+	}
+
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		return nil, err
+	}
+	basic.TBSResponseData.ResponseExtensions = append(basic.TBSResponseData.ResponseExtensions,
+		pkix.Extension{Id: NonceOID, Value: value})
+
+	tbs, err := asn1.Marshal(basic.TBSResponseData)
+	if err != nil {
+		return nil, err
+	}
+
+	hashFunc, ok := signatureHashes[basic.SignatureAlgorithm.Algorithm.String()]
+	if !ok {
+		return nil, errors.New("unsupported OCSP signature algorithm")
+	}
+
+	h := hashFunc.New()
+	h.Write(tbs)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hashFunc)
+	if err != nil {
+		return nil, err
+	}
+	basic.Signature = asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)}
+
+	resp.Response.Response, err = asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(resp)
+}
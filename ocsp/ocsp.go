@@ -17,6 +17,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/ucosty/cfssl/certdb"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
@@ -58,6 +59,9 @@ type SignRequest struct {
 	// in the OCSP response. Valid values are crypto.SHA1, crypto.SHA256, crypto.SHA384,
 	// and crypto.SHA512. If zero, the default is crypto.SHA1.
 	IssuerHash crypto.Hash
+	// Nonce, if set, is echoed in the response's nonce extension
+	// (RFC 8954).
+	Nonce []byte
 }
 
 // Signer represents a general signer of OCSP responses.  It is
@@ -196,5 +200,51 @@ func (s StandardSigner) Sign(req SignRequest) ([]byte, error) {
 		template.RevocationReason = req.Reason
 	}
 
-	return ocsp.CreateResponse(s.issuer, s.responder, template, s.key)
+	resp, err := ocsp.CreateResponse(s.issuer, s.responder, template, s.key)
+	if err != nil || len(req.Nonce) == 0 {
+		return resp, err
+	}
+	return addNonce(resp, req.Nonce, s.key)
+}
+
+// recordSignRequest builds a SignRequest reflecting the status of the
+// certificate record cr.
+func recordSignRequest(cr certdb.CertificateRecord) (SignRequest, error) {
+	cert, err := helpers.ParseCertificatePEM([]byte(cr.PEM))
+	if err != nil {
+		return SignRequest{}, err
+	}
+
+	req := SignRequest{
+		Certificate: cert,
+		Status:      cr.Status,
+	}
+	if cr.Status == "revoked" {
+		req.Reason = cr.Reason
+		req.RevokedAt = cr.RevokedAt
+	}
+	return req, nil
+}
+
+// StoreResponse signs an OCSP response reflecting the status of the
+// certificate record cr and upserts it into the cert db, so that responders
+// serving from the db answer for the certificate immediately. The stored
+// response expires at its NextUpdate time.
+func StoreResponse(s Signer, dbAccessor certdb.Accessor, cr certdb.CertificateRecord) error {
+	req, err := recordSignRequest(cr)
+	if err != nil {
+		return err
+	}
+
+	resp, err := s.Sign(req)
+	if err != nil {
+		return err
+	}
+
+	parsed, err := ocsp.ParseResponse(resp, nil)
+	if err != nil {
+		return err
+	}
+
+	return dbAccessor.UpsertOCSP(cr.Serial, cr.AKI, string(resp), parsed.NextUpdate)
 }
@@ -144,3 +144,44 @@ func TestSign(t *testing.T) {
 		t.Fatal("Error on revoked certificate")
 	}
 }
+
+func TestSignNonce(t *testing.T) {
+	req, dur := setup(t)
+	s, err := NewSignerFromFile(serverCertFile, serverCertFile, serverKeyFile, dur)
+	if err != nil {
+		t.Fatalf("Signer creation failed: %v", err)
+	}
+
+	issuerPEM, err := ioutil.ReadFile(serverCertFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(issuerPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	req.Nonce = []byte("0123456789abcdef")
+	respBytes, err := s.Sign(req)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	// The response is signed again after adding the nonce, so it must
+	// still verify against the issuer.
+	resp, err := ocsp.ParseResponse(respBytes, issuer)
+	if err != nil {
+		t.Fatalf("Failed to parse response with nonce: %v", err)
+	}
+	if resp.Status != ocsp.Good {
+		t.Fatalf("Expected status good, got %d", resp.Status)
+	}
+
+	nonce, err := ResponseNonce(respBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(nonce) != string(req.Nonce) {
+		t.Fatalf("Expected nonce %x, got %x", req.Nonce, nonce)
+	}
+}
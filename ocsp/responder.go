@@ -8,7 +8,12 @@
 package ocsp
 
 import (
+	"bytes"
+	"crypto"
 	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/base64"
 	"encoding/hex"
 	"fmt"
@@ -16,6 +21,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 	"time"
 
 	"github.com/ucosty/cfssl/certdb"
@@ -40,6 +46,14 @@ type Source interface {
 	Response(*ocsp.Request) ([]byte, bool)
 }
 
+// A NonceSource is a Source that can also sign a fresh response echoing a
+// request nonce. The Responder only uses ResponseWithNonce for requests
+// carrying a nonce; all other requests are answered by Response.
+type NonceSource interface {
+	Source
+	ResponseWithNonce(req *ocsp.Request, nonce []byte) ([]byte, bool)
+}
+
 // An InMemorySource is a map from serialNumber -> der(response)
 type InMemorySource map[string][]byte
 
@@ -109,6 +123,176 @@ func (src DBSource) Response(req *ocsp.Request) ([]byte, bool) {
 	return []byte(cur.Body), true
 }
 
+// LiveSource is a DBSource that signs responses on demand for requests
+// carrying a nonce. Nonce-less requests are still answered from the
+// pre-signed responses in the DB.
+type LiveSource struct {
+	DBSource
+	Signer Signer
+}
+
+// NewLiveSource creates a LiveSource signing with signer and reading
+// certificates and pre-signed responses through dbAccessor.
+func NewLiveSource(signer Signer, dbAccessor certdb.Accessor) NonceSource {
+	return LiveSource{
+		DBSource: DBSource{Accessor: dbAccessor},
+		Signer:   signer,
+	}
+}
+
+// ResponseWithNonce implements NonceSource. It signs a response for the
+// current status of the requested certificate that echoes nonce, and
+// returns false if the certificate is unknown or signing fails.
+func (src LiveSource) ResponseWithNonce(req *ocsp.Request, nonce []byte) ([]byte, bool) {
+	if req == nil || req.SerialNumber == nil {
+		return nil, false
+	}
+
+	if src.Accessor == nil || src.Signer == nil {
+		log.Errorf("No DB Accessor or OCSP signer")
+		return nil, false
+	}
+
+	aki := hex.EncodeToString(req.IssuerKeyHash)
+	cr, err := src.Accessor.GetCertificate(req.SerialNumber.String(), aki)
+	if err != nil {
+		log.Errorf("Error obtaining certificate: %s", err)
+		return nil, false
+	}
+	if len(cr) == 0 {
+		return nil, false
+	}
+
+	signReq, err := recordSignRequest(cr[0])
+	if err != nil {
+		log.Errorf("Error parsing certificate %s: %s", cr[0].Serial, err)
+		return nil, false
+	}
+	signReq.Nonce = nonce
+	signReq.IssuerHash = req.HashAlgorithm
+
+	resp, err := src.Signer.Sign(signReq)
+	if err != nil {
+		log.Errorf("Error signing OCSP response for %s: %s", cr[0].Serial, err)
+		return nil, false
+	}
+	return resp, true
+}
+
+// An IssuerSource routes requests to the Source of the issuer identified
+// by their IssuerNameHash and IssuerKeyHash, which may be computed with
+// SHA-1, SHA-256, SHA-384 or SHA-512. Requests for issuers it does not
+// know have no response, so the Responder answers them with unauthorized.
+//
+// Before a request is passed on, its IssuerKeyHash is replaced by the
+// issuer's subject key identifier, which is the AKI that certificates are
+// stored under in the cert db, so DB backed sources find certificates
+// regardless of the hash the client used.
+type IssuerSource struct {
+	issuers []issuerEntry
+}
+
+type issuerEntry struct {
+	nameHashes map[crypto.Hash][]byte
+	keyHashes  map[crypto.Hash][]byte
+	keyID      []byte
+	source     Source
+}
+
+// issuerHashes are the hash functions an IssuerSource matches requests
+// with.
+var issuerHashes = []crypto.Hash{crypto.SHA1, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+
+// NewIssuerSource creates an IssuerSource without any issuers.
+func NewIssuerSource() *IssuerSource {
+	return &IssuerSource{}
+}
+
+// AddIssuer routes requests for certificates issued by issuer to source.
+func (src *IssuerSource) AddIssuer(issuer *x509.Certificate, source Source) error {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if _, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki); err != nil {
+		return err
+	}
+
+	entry := issuerEntry{
+		nameHashes: make(map[crypto.Hash][]byte),
+		keyHashes:  make(map[crypto.Hash][]byte),
+		keyID:      issuer.SubjectKeyId,
+		source:     source,
+	}
+	for _, hash := range issuerHashes {
+		h := hash.New()
+		h.Write(issuer.RawSubject)
+		entry.nameHashes[hash] = h.Sum(nil)
+
+		h = hash.New()
+		h.Write(spki.PublicKey.RightAlign())
+		entry.keyHashes[hash] = h.Sum(nil)
+	}
+	if len(entry.keyID) == 0 {
+		entry.keyID = entry.keyHashes[crypto.SHA1]
+	}
+
+	src.issuers = append(src.issuers, entry)
+	return nil
+}
+
+// route returns the issuer of req and a copy of req to pass to its Source.
+func (src *IssuerSource) route(req *ocsp.Request) (*issuerEntry, *ocsp.Request) {
+	if req == nil {
+		return nil, nil
+	}
+
+	for i := range src.issuers {
+		entry := &src.issuers[i]
+		nameHash, ok := entry.nameHashes[req.HashAlgorithm]
+		if !ok {
+			return nil, nil
+		}
+		if bytes.Equal(nameHash, req.IssuerNameHash) &&
+			bytes.Equal(entry.keyHashes[req.HashAlgorithm], req.IssuerKeyHash) {
+			routed := *req
+			routed.IssuerKeyHash = entry.keyID
+			return entry, &routed
+		}
+	}
+	return nil, nil
+}
+
+// Response implements Source by passing req on to its issuer's Source.
+func (src *IssuerSource) Response(req *ocsp.Request) ([]byte, bool) {
+	if req == nil {
+		return nil, false
+	}
+	entry, routed := src.route(req)
+	if entry == nil {
+		log.Infof("OCSP request for unknown issuer: serial %x", req.SerialNumber)
+		return nil, false
+	}
+	return entry.source.Response(routed)
+}
+
+// ResponseWithNonce implements NonceSource. Requests for issuers whose
+// Source cannot sign on demand get the pre-signed response.
+func (src *IssuerSource) ResponseWithNonce(req *ocsp.Request, nonce []byte) ([]byte, bool) {
+	if req == nil {
+		return nil, false
+	}
+	entry, routed := src.route(req)
+	if entry == nil {
+		log.Infof("OCSP request for unknown issuer: serial %x", req.SerialNumber)
+		return nil, false
+	}
+	if nonceSource, ok := entry.source.(NonceSource); ok {
+		return nonceSource.ResponseWithNonce(routed, nonce)
+	}
+	return entry.source.Response(routed)
+}
+
 // NewSourceFromFile reads the named file into an InMemorySource.
 // The file read by this function must contain whitespace-separated OCSP
 // responses. Each OCSP response must be in base64-encoded DER form (i.e.,
@@ -224,9 +408,6 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 	response.Header().Add("Content-Type", "application/ocsp-response")
 
 	// Parse response as an OCSP request
-	// XXX: This fails if the request contains the nonce extension.
-	//      We don't intend to support nonces anyway, but maybe we
-	//      should return unauthorizedRequest instead of malformed.
 	ocspRequest, err := ocsp.ParseRequest(requestBody)
 	if err != nil {
 		log.Infof("Error decoding request body: %s", b64Body)
@@ -235,6 +416,31 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		return
 	}
 
+	nonce, err := RequestNonce(requestBody)
+	if err != nil {
+		log.Infof("Invalid nonce in request body %s: %s", b64Body, err)
+		response.WriteHeader(http.StatusBadRequest)
+		response.Write(malformedRequestErrorResponse)
+		return
+	}
+
+	// Requests with a nonce get a freshly signed response if the source
+	// can produce one; otherwise the nonce is ignored, as RFC 8954 allows,
+	// and the pre-signed response is returned.
+	if nonceSource, ok := rs.Source.(NonceSource); ok && nonce != nil {
+		ocspResponse, found := nonceSource.ResponseWithNonce(ocspRequest, nonce)
+		if !found {
+			log.Infof("No response found for request: serial %x, request body %s",
+				ocspRequest.SerialNumber, b64Body)
+			response.Write(unauthorizedErrorResponse)
+			return
+		}
+		// A nonce response is unique to its request and must not be cached.
+		response.WriteHeader(http.StatusOK)
+		response.Write(ocspResponse)
+		return
+	}
+
 	// Look up OCSP response from source
 	ocspResponse, found := rs.Source.Response(ocspRequest)
 	if !found {
@@ -252,9 +458,11 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		return
 	}
 
-	// Write OCSP response to response
-	response.Header().Add("Last-Modified", parsedResponse.ThisUpdate.Format(time.RFC1123))
-	response.Header().Add("Expires", parsedResponse.NextUpdate.Format(time.RFC1123))
+	// Write OCSP response to response. The caching headers follow RFC 5019
+	// section 6.2 so that the response can be served by HTTP caches until
+	// its NextUpdate.
+	response.Header().Add("Last-Modified", parsedResponse.ThisUpdate.UTC().Format(http.TimeFormat))
+	response.Header().Add("Expires", parsedResponse.NextUpdate.UTC().Format(http.TimeFormat))
 	now := rs.clk.Now()
 	maxAge := 0
 	if now.Before(parsedResponse.NextUpdate) {
@@ -272,17 +480,42 @@ func (rs Responder) ServeHTTP(response http.ResponseWriter, request *http.Reques
 		),
 	)
 	responseHash := sha256.Sum256(ocspResponse)
-	response.Header().Add("ETag", fmt.Sprintf("\"%X\"", responseHash))
+	etag := fmt.Sprintf("\"%X\"", responseHash)
+	response.Header().Add("ETag", etag)
 
 	// RFC 7232 says that a 304 response must contain the above
 	// headers if they would also be sent for a 200 for the same
 	// request, so we have to wait until here to do this
-	if etag := request.Header.Get("If-None-Match"); etag != "" {
-		if etag == fmt.Sprintf("\"%X\"", responseHash) {
-			response.WriteHeader(http.StatusNotModified)
-			return
-		}
+	if notModified(request, etag, parsedResponse.ThisUpdate) {
+		response.WriteHeader(http.StatusNotModified)
+		return
 	}
 	response.WriteHeader(http.StatusOK)
 	response.Write(ocspResponse)
 }
+
+// notModified evaluates the If-None-Match and If-Modified-Since headers of
+// request against a response with the given entity tag and ThisUpdate, as
+// described in RFC 7232 section 6. If-Modified-Since is ignored when
+// If-None-Match is present.
+func notModified(request *http.Request, etag string, thisUpdate time.Time) bool {
+	if inm := request.Header.Get("If-None-Match"); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+			if candidate == "*" || candidate == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if request.Method != "GET" {
+		return false
+	}
+	ims, err := http.ParseTime(request.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	// HTTP dates have a resolution of one second.
+	return !thisUpdate.Truncate(time.Second).After(ims)
+}
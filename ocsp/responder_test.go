@@ -1,6 +1,11 @@
 package ocsp
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
 	"encoding/hex"
 	"io/ioutil"
 	"net/http"
@@ -99,8 +104,8 @@ func TestCacheHeaders(t *testing.T) {
 		header string
 		value  string
 	}{
-		{"Last-Modified", "Tue, 20 Oct 2015 00:00:00 UTC"},
-		{"Expires", "Sun, 20 Oct 2030 00:00:00 UTC"},
+		{"Last-Modified", "Tue, 20 Oct 2015 00:00:00 GMT"},
+		{"Expires", "Sun, 20 Oct 2030 00:00:00 GMT"},
 		{"Cache-Control", "max-age=471398400, public, no-transform, must-revalidate"},
 		{"Etag", "\"8169FB0843B081A76E9F6F13FD70C8411597BEACF8B182136FFDD19FBD26140A\""},
 	}
@@ -133,6 +138,37 @@ func TestCacheHeaders(t *testing.T) {
 	if rw.Code != http.StatusNotModified {
 		t.Fatalf("Got wrong status code: expected %d, got %d", http.StatusNotModified, rw.Code)
 	}
+	if rw.Header().Get("Expires") != "Sun, 20 Oct 2030 00:00:00 GMT" {
+		t.Errorf("304 response is missing caching headers")
+	}
+
+	conditionalCases := []struct {
+		header, value string
+		expected      int
+	}{
+		{"If-None-Match", "\"ABCD\", W/\"8169FB0843B081A76E9F6F13FD70C8411597BEACF8B182136FFDD19FBD26140A\"", http.StatusNotModified},
+		{"If-None-Match", "*", http.StatusNotModified},
+		{"If-None-Match", "\"ABCD\"", http.StatusOK},
+		{"If-Modified-Since", "Tue, 20 Oct 2015 00:00:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Wed, 21 Oct 2015 00:00:00 GMT", http.StatusNotModified},
+		{"If-Modified-Since", "Mon, 19 Oct 2015 23:59:59 GMT", http.StatusOK},
+		{"If-Modified-Since", "not a date", http.StatusOK},
+	}
+	for _, tc := range conditionalCases {
+		rw = httptest.NewRecorder()
+		headers = http.Header{}
+		headers.Add(tc.header, tc.value)
+		responder.ServeHTTP(rw, &http.Request{
+			Method: "GET",
+			URL: &url.URL{
+				Path: "MEMwQTA/MD0wOzAJBgUrDgMCGgUABBSwLsMRhyg1dJUwnXWk++D57lvgagQU6aQ/7p6l5vLV13lgPJOmLiSOl6oCAhJN",
+			},
+			Header: headers,
+		})
+		if rw.Code != tc.expected {
+			t.Errorf("%s: %s: expected status %d, got %d", tc.header, tc.value, tc.expected, rw.Code)
+		}
+	}
 }
 
 func TestNewSourceFromFile(t *testing.T) {
@@ -310,3 +346,254 @@ func TestSqliteRealResponse(t *testing.T) {
 		t.Errorf("Error parsing response: %v", err)
 	}
 }
+
+// withNonce returns the DER encoded OCSP request der with a nonce
+// extension carrying nonce.
+func withNonce(t *testing.T, der, nonce []byte) []byte {
+	var req nonceRequest
+	if _, err := asn1.Unmarshal(der, &req); err != nil {
+		t.Fatal(err)
+	}
+
+	value, err := asn1.Marshal(nonce)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.TBSRequest.RequestExtensions = []pkix.Extension{{Id: NonceOID, Value: value}}
+
+	der, err = asn1.Marshal(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+func TestLiveSourceNonce(t *testing.T) {
+	db := testdb.SQLiteDB("testdata/sqlite_test.db")
+	accessor := sql.NewAccessor(db)
+
+	certContent, err := ioutil.ReadFile("testdata/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerContent, err := ioutil.ReadFile("testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(issuerContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	reqDER, err := goocsp.CreateRequest(cert, issuer, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, err := goocsp.ParseRequest(reqDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSignerFromFile("testdata/ca.pem", "testdata/ca.pem", "testdata/ca-key.pem", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := certdb.CertificateRecord{
+		Serial: req.SerialNumber.String(),
+		AKI:    hex.EncodeToString(req.IssuerKeyHash),
+		Status: "good",
+		Expiry: time.Now().Add(time.Hour),
+		PEM:    string(certContent),
+	}
+	if err = accessor.InsertCertificate(cr); err != nil {
+		t.Fatal(err)
+	}
+	if err = StoreResponse(signer, accessor, cr); err != nil {
+		t.Fatal(err)
+	}
+	presigned, _ := NewDBSource(accessor).Response(req)
+
+	responder := Responder{
+		Source: NewLiveSource(signer, accessor),
+		clk:    clock.NewFake(),
+	}
+	post := func(body []byte) *httptest.ResponseRecorder {
+		rw := httptest.NewRecorder()
+		responder.ServeHTTP(rw, &http.Request{
+			Method: "POST",
+			URL:    &url.URL{},
+			Body:   ioutil.NopCloser(bytes.NewReader(body)),
+		})
+		return rw
+	}
+
+	// Nonce-less requests get the pre-signed response.
+	rw := post(reqDER)
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", rw.Code)
+	}
+	if !bytes.Equal(rw.Body.Bytes(), presigned) {
+		t.Fatal("Nonce-less request did not get the pre-signed response")
+	}
+	if rw.Header().Get("ETag") == "" {
+		t.Fatal("Pre-signed response is missing an ETag")
+	}
+
+	// Requests with a nonce get a fresh response echoing it.
+	nonce := []byte("0123456789abcdef")
+	rw = post(withNonce(t, reqDER, nonce))
+	if rw.Code != http.StatusOK {
+		t.Fatalf("Unexpected status code %d", rw.Code)
+	}
+	if _, err = goocsp.ParseResponse(rw.Body.Bytes(), issuer); err != nil {
+		t.Fatalf("Error parsing response with nonce: %v", err)
+	}
+	got, err := ResponseNonce(rw.Body.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, nonce) {
+		t.Fatalf("Expected nonce %x, got %x", nonce, got)
+	}
+	if rw.Header().Get("ETag") != "" || rw.Header().Get("Cache-Control") != "max-age=0, no-cache" {
+		t.Fatal("Response with nonce must not be cacheable")
+	}
+
+	// GET requests carry the nonce as well.
+	rw = httptest.NewRecorder()
+	responder.ServeHTTP(rw, &http.Request{
+		Method: "GET",
+		URL:    &url.URL{Path: url.QueryEscape(base64.StdEncoding.EncodeToString(withNonce(t, reqDER, nonce)))},
+	})
+	if got, _ = ResponseNonce(rw.Body.Bytes()); !bytes.Equal(got, nonce) {
+		t.Fatalf("Expected nonce %x in GET response, got %x", nonce, got)
+	}
+
+	// Nonces longer than 32 bytes are rejected as malformed.
+	rw = post(withNonce(t, reqDER, bytes.Repeat([]byte{1}, 33)))
+	if rw.Code != http.StatusBadRequest || !bytes.Equal(rw.Body.Bytes(), malformedRequestErrorResponse) {
+		t.Fatalf("Expected malformed request response for oversized nonce, got %d", rw.Code)
+	}
+
+	// A source without nonce support ignores the nonce.
+	responder.Source = NewDBSource(accessor)
+	rw = post(withNonce(t, reqDER, nonce))
+	if !bytes.Equal(rw.Body.Bytes(), presigned) {
+		t.Fatal("DBSource did not return the pre-signed response for a nonce request")
+	}
+}
+
+func TestIssuerSource(t *testing.T) {
+	db := testdb.SQLiteDB("testdata/sqlite_test.db")
+	accessor := sql.NewAccessor(db)
+
+	certContent, err := ioutil.ReadFile("testdata/cert.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuerContent, err := ioutil.ReadFile("testdata/ca.pem")
+	if err != nil {
+		t.Fatal(err)
+	}
+	issuer, err := helpers.ParseCertificatePEM(issuerContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherContent, err := ioutil.ReadFile("testdata/server.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := helpers.ParseCertificatePEM(otherContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := NewSignerFromFile("testdata/ca.pem", "testdata/ca.pem", "testdata/ca-key.pem", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cr := certdb.CertificateRecord{
+		Serial: cert.SerialNumber.String(),
+		AKI:    hex.EncodeToString(cert.AuthorityKeyId),
+		Status: "good",
+		Expiry: time.Now().Add(time.Hour),
+		PEM:    string(certContent),
+	}
+	if err = accessor.InsertCertificate(cr); err != nil {
+		t.Fatal(err)
+	}
+	if err = StoreResponse(signer, accessor, cr); err != nil {
+		t.Fatal(err)
+	}
+
+	src := NewIssuerSource()
+	if err = src.AddIssuer(issuer, NewLiveSource(signer, accessor)); err != nil {
+		t.Fatal(err)
+	}
+	responder := Responder{
+		Source: src,
+		clk:    clock.NewFake(),
+	}
+
+	if _, found := src.Response(nil); found {
+		t.Fatal("expected no response for a nil request")
+	}
+	if _, found := src.ResponseWithNonce(nil, []byte("nonce")); found {
+		t.Fatal("expected no response for a nil request")
+	}
+
+	for _, hash := range []crypto.Hash{crypto.SHA1, crypto.SHA256} {
+		reqDER, err := goocsp.CreateRequest(cert, issuer, &goocsp.RequestOptions{Hash: hash})
+		if err != nil {
+			t.Fatal(err)
+		}
+		req, err := goocsp.ParseRequest(reqDER)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Pre-signed responses are found under the issuer's key
+		// identifier whatever hash the request uses.
+		if _, found := src.Response(req); !found {
+			t.Fatalf("No pre-signed response for a %v request", hash)
+		}
+
+		// Live responses use the request's hash in their CertID.
+		resp, found := src.ResponseWithNonce(req, []byte("nonce"))
+		if !found {
+			t.Fatalf("No live response for a %v request", hash)
+		}
+		parsed, err := goocsp.ParseResponseForCert(resp, cert, issuer)
+		if err != nil {
+			t.Fatalf("Error parsing live response for a %v request: %v", hash, err)
+		}
+		if parsed.IssuerHash != hash {
+			t.Fatalf("Expected a %v CertID, got %v", hash, parsed.IssuerHash)
+		}
+	}
+
+	// Requests for an issuer the source does not know are unauthorized.
+	reqDER, err := goocsp.CreateRequest(cert, other, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rw := httptest.NewRecorder()
+	responder.ServeHTTP(rw, &http.Request{
+		Method: "POST",
+		URL:    &url.URL{},
+		Body:   ioutil.NopCloser(bytes.NewReader(reqDER)),
+	})
+	if !bytes.Equal(rw.Body.Bytes(), unauthorizedErrorResponse) {
+		t.Fatal("Expected an unauthorized response for an unknown issuer")
+	}
+}
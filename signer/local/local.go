@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"crypto"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -19,13 +20,15 @@ import (
 	"net/http"
 	"net/mail"
 	"os"
-	
+	"time"
+
 	"github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/config"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/info"
 	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/ocsp"
 	"github.com/ucosty/cfssl/signer"
 	"github.com/google/certificate-transparency/go"
 	"github.com/google/certificate-transparency/go/client"
@@ -41,6 +44,7 @@ type Signer struct {
 	policy     *config.Signing
 	sigAlgo    x509.SignatureAlgorithm
 	dbAccessor certdb.Accessor
+	ocspSigner ocsp.Signer
 }
 
 // NewSigner creates a new Signer directly from a
@@ -195,11 +199,6 @@ func OverrideHosts(template *x509.Certificate, hosts []string) {
 // certificate or certificate request with the signing profile,
 // specified by profileName.
 func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
-	profile, err := signer.Profile(s, req.Profile)
-	if err != nil {
-		return
-	}
-
 	block, _ := pem.Decode([]byte(req.Request))
 	if block == nil {
 		return nil, cferr.New(cferr.CSRError, cferr.DecodeFailed)
@@ -214,6 +213,31 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 	if err != nil {
 		return nil, err
 	}
+	return s.issue(csrTemplate, req)
+}
+
+// Reissue signs a new certificate with the subject, public key and
+// subject alternative names of cert, which must have been issued by this
+// signer, with the signing profile of req. req.Request is ignored; proof
+// that the requester holds the key of cert is left to the caller.
+func (s *Signer) Reissue(cert *x509.Certificate, req signer.SignRequest) ([]byte, error) {
+	if s.ca == nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.BadRequest,
+			errors.New("a self-signing signer cannot reissue certificates"))
+	}
+	if err := cert.CheckSignatureFrom(s.ca); err != nil {
+		return nil, cferr.Wrap(cferr.CertificateError, cferr.VerifyFailed, err)
+	}
+	return s.issue(signer.ReissueTemplate(s, cert), req)
+}
+
+// issue signs the certificate template csrTemplate, parsed from a CSR or
+// an existing certificate, according to the signing profile of req.
+func (s *Signer) issue(csrTemplate *x509.Certificate, req signer.SignRequest) (cert []byte, err error) {
+	profile, err := signer.Profile(s, req.Profile)
+	if err != nil {
+		return
+	}
 
 	// Copy out only the fields from the CSR authorized by policy.
 	safeTemplate := x509.Certificate{}
@@ -390,15 +414,28 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 	}
 
 	if s.dbAccessor != nil {
+		parsedCert, err := helpers.ParseCertificatePEM(signedCert)
+		if err != nil {
+			return nil, err
+		}
+		fingerprint := sha256.Sum256(parsedCert.Raw)
+
 		var certRecord = certdb.CertificateRecord{
 			Serial: certTBS.SerialNumber.String(),
-			// this relies on the specific behavior of x509.CreateCertificate
-			// which updates certTBS AuthorityKeyId from the signer's SubjectKeyId
-			AKI:     hex.EncodeToString(certTBS.AuthorityKeyId),
-			CALabel: req.Label,
-			Status:  "good",
-			Expiry:  certTBS.NotAfter,
-			PEM:     string(signedCert),
+			// read the AKI back from the signed certificate, where
+			// x509.CreateCertificate sets it from the signer's SubjectKeyId
+			AKI:         hex.EncodeToString(parsedCert.AuthorityKeyId),
+			CALabel:     req.Label,
+			Status:      "good",
+			Expiry:      certTBS.NotAfter,
+			IssuedAt:    time.Now(),
+			CommonName:  parsedCert.Subject.CommonName,
+			SANs:        subjectAltNames(parsedCert),
+			Profile:     req.Profile,
+			NotBefore:   parsedCert.NotBefore,
+			Requester:   req.Requester,
+			Fingerprint: hex.EncodeToString(fingerprint[:]),
+			PEM:         string(signedCert),
 		}
 
 		err = s.dbAccessor.InsertCertificate(certRecord)
@@ -406,11 +443,36 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 			return nil, err
 		}
 		log.Debug("saved certificate with serial number ", certTBS.SerialNumber)
+
+		// The certificate is already issued and recorded, so a failure
+		// here is left for the next ocsprefresh run to fix.
+		if s.ocspSigner != nil {
+			if err := ocsp.StoreResponse(s.ocspSigner, s.dbAccessor, certRecord); err != nil {
+				log.Errorf("failed to store OCSP response for serial number %s: %v", certRecord.Serial, err)
+			} else {
+				log.Debug("saved OCSP response for serial number ", certTBS.SerialNumber)
+			}
+		}
 	}
 
 	return signedCert, nil
 }
 
+// subjectAltNames lists the DNS names, email addresses, IP addresses and
+// URIs in cert's subjectAltName extension.
+func subjectAltNames(cert *x509.Certificate) certdb.StringList {
+	var sans certdb.StringList
+	sans = append(sans, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	for _, uri := range cert.URIs {
+		sans = append(sans, uri.String())
+	}
+	return sans
+}
+
 func serializeSCTList(sctList []ct.SignedCertificateTimestamp) ([]byte, error) {
 	var buf bytes.Buffer
 	for _, sct := range sctList {
@@ -470,6 +532,12 @@ func (s *Signer) SetDBAccessor(dba certdb.Accessor) {
 	s.dbAccessor = dba
 }
 
+// SetOCSPSigner sets the OCSP signer used to store a "good" OCSP response
+// for each certificate recorded in the cert db.
+func (s *Signer) SetOCSPSigner(ocspSigner ocsp.Signer) {
+	s.ocspSigner = ocspSigner
+}
+
 // SetReqModifier does nothing for local
 func (s *Signer) SetReqModifier(func(*http.Request, []byte)) {
 	// noop
@@ -2,6 +2,7 @@ package local
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"encoding/asn1"
@@ -10,6 +11,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"reflect"
 	"regexp"
 	"sort"
@@ -17,12 +19,16 @@ import (
 	"testing"
 	"time"
 
+	"github.com/ucosty/cfssl/certdb/sql"
+	"github.com/ucosty/cfssl/certdb/testdb"
 	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/csr"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/ocsp"
 	"github.com/ucosty/cfssl/signer"
+	stdocsp "golang.org/x/crypto/ocsp"
 )
 
 const (
@@ -706,6 +712,100 @@ func TestOverwriteHosts(t *testing.T) {
 
 }
 
+func TestSignRecordsMetadata(t *testing.T) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	s := newCustomSigner(t, testECDSACaFile, testECDSACaKeyFile)
+	s.SetDBAccessor(sql.NewAccessor(db))
+
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{
+		Hosts:     []string{"example.com", "127.0.0.1"},
+		Request:   string(csrPEM),
+		Subject:   &signer.Subject{CN: "example.com"},
+		Label:     "ca",
+		Requester: "client-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	crs, err := s.dbAccessor.GetCertificate(cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(crs) != 1 {
+		t.Fatalf("expected one certificate record, got %d", len(crs))
+	}
+
+	cr := crs[0]
+	if cr.CommonName != "example.com" || cr.Requester != "client-key" || cr.CALabel != "ca" {
+		t.Fatalf("unexpected certificate record %+v", cr)
+	}
+	if !reflect.DeepEqual([]string(cr.SANs), []string{"example.com", "127.0.0.1"}) {
+		t.Fatalf("unexpected SANs %v", cr.SANs)
+	}
+	if !cr.NotBefore.Equal(cert.NotBefore) || cr.IssuedAt.IsZero() {
+		t.Fatalf("unexpected timestamps in %+v", cr)
+	}
+	fingerprint := sha256.Sum256(cert.Raw)
+	if cr.Fingerprint != hex.EncodeToString(fingerprint[:]) {
+		t.Fatalf("unexpected fingerprint %s", cr.Fingerprint)
+	}
+}
+
+func TestSignStoresOCSP(t *testing.T) {
+	db := testdb.SQLiteDB("../../certdb/testdb/certstore_development.db")
+	dbAccessor := sql.NewAccessor(db)
+	s := newCustomSigner(t, testECDSACaFile, testECDSACaKeyFile)
+	s.SetDBAccessor(dbAccessor)
+
+	ocspSigner, err := ocsp.NewSignerFromFile(testECDSACaFile, testECDSACaFile, testECDSACaKeyFile, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetOCSPSigner(ocspSigner)
+
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certPEM, err := s.Sign(signer.SignRequest{Hosts: []string{"example.com"}, Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ocsps, err := dbAccessor.GetOCSP(cert.SerialNumber.String(), hex.EncodeToString(cert.AuthorityKeyId))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ocsps) != 1 {
+		t.Fatalf("expected one stored OCSP response, got %d", len(ocsps))
+	}
+
+	resp, err := stdocsp.ParseResponse([]byte(ocsps[0].Body), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != stdocsp.Good || resp.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+		t.Fatalf("unexpected OCSP response %+v", resp)
+	}
+}
+
 func expectOneValueOf(t *testing.T, s []string, e, n string) {
 	if len(s) != 1 {
 		t.Fatalf("Expected %s to have a single value, but it has %d values", n, len(s))
@@ -1234,3 +1334,68 @@ func TestCTSuccess(t *testing.T) {
 		t.Fatal("Expected CT log submission success")
 	}
 }
+
+func TestReissue(t *testing.T) {
+	s := newTestSigner(t)
+
+	csrPEM, err := ioutil.ReadFile(testCSR)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	oldPEM, err := s.Sign(signer.SignRequest{
+		Hosts:   []string{"example.com", "127.0.0.1"},
+		Request: string(csrPEM),
+		Subject: &signer.Subject{CN: "example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	old, err := helpers.ParseCertificatePEM(oldPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sign only takes CSRs: reissuing needs proof of possession.
+	if _, err = s.Sign(signer.SignRequest{Request: string(oldPEM)}); err == nil {
+		t.Fatal("expected Sign to refuse a certificate")
+	}
+
+	newPEM, err := s.Reissue(old, signer.SignRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(newPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cert.SerialNumber.Cmp(old.SerialNumber) == 0 {
+		t.Fatal("the reissued certificate has the serial number of the old one")
+	}
+	if cert.Subject.CommonName != "example.com" {
+		t.Fatalf("unexpected subject %v", cert.Subject)
+	}
+	if !reflect.DeepEqual(cert.DNSNames, old.DNSNames) || len(cert.IPAddresses) != 1 || !cert.IPAddresses[0].Equal(old.IPAddresses[0]) {
+		t.Fatalf("unexpected SANs %v %v", cert.DNSNames, cert.IPAddresses)
+	}
+	if !bytes.Equal(cert.RawSubjectPublicKeyInfo, old.RawSubjectPublicKeyInfo) {
+		t.Fatal("the reissued certificate has a different public key")
+	}
+
+	uri, _ := url.Parse("spiffe://example.com/service")
+	tpl := signer.ReissueTemplate(s, &x509.Certificate{URIs: []*url.URL{uri}})
+	if len(tpl.URIs) != 1 || tpl.URIs[0] != uri {
+		t.Fatalf("expected the URI SANs to be kept, got %v", tpl.URIs)
+	}
+
+	// A certificate from another CA cannot be reissued.
+	other := newCustomSigner(t, testECDSACaFile, testECDSACaKeyFile)
+	_, err = other.Reissue(old, signer.SignRequest{})
+	if err == nil {
+		t.Fatal("expected reissuing a certificate of another CA to fail")
+	}
+	if !strings.Contains(err.Error(), `"code":1200`) {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
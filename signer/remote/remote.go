@@ -1,10 +1,12 @@
 package remote
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"errors"
 	"net/http"
+	"sync"
 
 	"github.com/ucosty/cfssl/api/client"
 	"github.com/ucosty/cfssl/certdb"
@@ -12,6 +14,7 @@ import (
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/info"
+	"github.com/ucosty/cfssl/ocsp"
 	"github.com/ucosty/cfssl/signer"
 )
 
@@ -20,6 +23,18 @@ import (
 type Signer struct {
 	policy      *config.Signing
 	reqModifier func(*http.Request, []byte)
+
+	// mu guards remotes, the remote of each signing profile, which is
+	// reused so that a group of servers keeps track of their health.
+	mu      sync.Mutex
+	remotes map[*config.SigningProfile]profileRemote
+}
+
+// A profileRemote is the remote of a signing profile, for the server
+// address the profile had when it was set up.
+type profileRemote struct {
+	addr   string
+	remote client.Remote
 }
 
 // NewSigner creates a new remote Signer directly from a
@@ -42,7 +57,13 @@ func NewSigner(policy *config.Signing) (*Signer, error) {
 // csr, and profileName are used as with a local signing operation, and
 // the label is used to select a signing root in a multi-root CA.
 func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
-	resp, err := s.remoteOp(req, req.Profile, "sign")
+	return s.SignContext(context.Background(), req)
+}
+
+// SignContext is Sign with a context, which cancels the request and
+// whose deadline bounds it.
+func (s *Signer) SignContext(ctx context.Context, req signer.SignRequest) (cert []byte, err error) {
+	resp, err := s.remoteOp(ctx, req, req.Profile, "sign")
 	if err != nil {
 		return
 	}
@@ -55,7 +76,12 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 // Info sends an info request to the remote CFSSL server, receiving an
 // Resp struct or an error in response.
 func (s *Signer) Info(req info.Req) (resp *info.Resp, err error) {
-	respInterface, err := s.remoteOp(req, req.Profile, "info")
+	return s.InfoContext(context.Background(), req)
+}
+
+// InfoContext is Info with a context.
+func (s *Signer) InfoContext(ctx context.Context, req info.Req) (resp *info.Resp, err error) {
+	respInterface, err := s.remoteOp(ctx, req, req.Profile, "info")
 	if err != nil {
 		return
 	}
@@ -66,7 +92,7 @@ func (s *Signer) Info(req info.Req) (resp *info.Resp, err error) {
 }
 
 // Helper function to perform a remote sign or info request.
-func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interface{}, err error) {
+func (s *Signer) remoteOp(ctx context.Context, req interface{}, profile, target string) (resp interface{}, err error) {
 	jsonData, err := json.Marshal(req)
 	if err != nil {
 		return nil, cferr.Wrap(cferr.APIClientError, cferr.JSONError, err)
@@ -77,21 +103,20 @@ func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interfa
 		return
 	}
 
-	server := client.NewServerTLS(p.RemoteServer, helpers.CreateTLSConfig(p.RemoteCAs, p.ClientCert))
-	if server == nil {
+	remote := s.remote(p)
+	if remote == nil {
 		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
 			errors.New("failed to connect to remote"))
 	}
-
-	server.SetReqModifier(s.reqModifier)
+	server := client.AsContextRemote(remote)
 
 	// There's no auth provider for the "info" method
 	if target == "info" {
-		resp, err = server.Info(jsonData)
+		resp, err = server.InfoContext(ctx, jsonData)
 	} else if p.RemoteProvider != nil {
-		resp, err = server.AuthSign(jsonData, nil, p.RemoteProvider)
+		resp, err = server.AuthSignContext(ctx, jsonData, nil, p.RemoteProvider)
 	} else {
-		resp, err = server.Sign(jsonData)
+		resp, err = server.SignContext(ctx, jsonData)
 	}
 
 	if err != nil {
@@ -101,6 +126,27 @@ func (s *Signer) remoteOp(req interface{}, profile, target string) (resp interfa
 	return
 }
 
+// remote returns the remote of the signing profile p, setting it up on
+// first use and again if the remote server of p has changed.
+func (s *Signer) remote(p *config.SigningProfile) client.Remote {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if pr, ok := s.remotes[p]; ok && pr.addr == p.RemoteServer {
+		return pr.remote
+	}
+
+	remote := client.NewServerTLS(p.RemoteServer, helpers.CreateTLSConfig(p.RemoteCAs, p.ClientCert))
+	if remote == nil {
+		return nil
+	}
+	remote.SetReqModifier(s.reqModifier)
+	if s.remotes == nil {
+		s.remotes = map[*config.SigningProfile]profileRemote{}
+	}
+	s.remotes[p] = profileRemote{addr: p.RemoteServer, remote: remote}
+	return remote
+}
+
 // SigAlgo returns the RSA signer's signature algorithm.
 func (s *Signer) SigAlgo() x509.SignatureAlgorithm {
 	// TODO: implement this as a remote info call
@@ -109,7 +155,10 @@ func (s *Signer) SigAlgo() x509.SignatureAlgorithm {
 
 // SetPolicy sets the signer's signature policy.
 func (s *Signer) SetPolicy(policy *config.Signing) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.policy = policy
+	s.remotes = nil
 }
 
 // SetDBAccessor sets the signers' cert db accessor
@@ -117,9 +166,18 @@ func (s *Signer) SetDBAccessor(dba certdb.Accessor) {
 	// noop
 }
 
+// SetOCSPSigner does nothing for remote; the remote CA stores its own
+// OCSP responses.
+func (s *Signer) SetOCSPSigner(ocsp.Signer) {
+	// noop
+}
+
 // SetReqModifier sets the function to call to modify the HTTP request prior to sending it
 func (s *Signer) SetReqModifier(mod func(*http.Request, []byte)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	s.reqModifier = mod
+	s.remotes = nil
 }
 
 // Policy returns the signer's policy.
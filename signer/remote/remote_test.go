@@ -2,6 +2,7 @@ package remote
 
 import (
 	"bytes"
+	"context"
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
@@ -248,6 +249,32 @@ func TestRemoteSignBadServerAndOverride(t *testing.T) {
 
 }
 
+func TestRemoteSignContext(t *testing.T) {
+	hung := make(chan struct{})
+	remoteServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-hung
+	}))
+	defer remoteServer.Close()
+	defer close(hung)
+
+	remoteConfig := testsuite.NewConfig(t, []byte(validMinimalRemoteConfig))
+	remoteConfig.Signing.OverrideRemotes(remoteServer.URL)
+	s := newRemoteSigner(t, remoteConfig.Signing)
+
+	csr, err := ioutil.ReadFile("../local/testdata/rsa2048.csr")
+	if err != nil {
+		t.Fatal("CSR loading error:", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err = s.SignContext(ctx, signer.SignRequest{Request: string(csr)}); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+	if _, err = s.InfoContext(ctx, info.Req{}); err == nil {
+		t.Fatal("expected the request to time out")
+	}
+}
+
 // helper functions
 func newRemoteSigner(t *testing.T, policy *config.Signing) *Signer {
 	s, err := NewSigner(policy)
@@ -22,6 +22,7 @@ import (
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/helpers"
 	"github.com/ucosty/cfssl/info"
+	"github.com/ucosty/cfssl/ocsp"
 )
 
 // Subject contains the information that should be used to override the
@@ -47,6 +48,10 @@ type Extension struct {
 // long as they are in the ExtensionWhitelist for the signer's policy.
 // Extensions requested in the CSR are ignored, except for those processed by
 // ParseCertificateRequest (mainly subjectAltName).
+//
+// Requester identifies the authenticated client for the certificate
+// database. It is set by the server handling the request and is never read
+// from or sent over the wire.
 type SignRequest struct {
 	Hosts       []string    `json:"hosts"`
 	Request     string      `json:"certificate_request"`
@@ -56,6 +61,7 @@ type SignRequest struct {
 	Label       string      `json:"label"`
 	Serial      *big.Int    `json:"serial,omitempty"`
 	Extensions  []Extension `json:"extensions,omitempty"`
+	Requester   string      `json:"-"`
 }
 
 // appendIf appends to a if s is not an empty string.
@@ -97,12 +103,21 @@ type Signer interface {
 	Info(info.Req) (*info.Resp, error)
 	Policy() *config.Signing
 	SetDBAccessor(certdb.Accessor)
+	SetOCSPSigner(ocsp.Signer)
 	SetPolicy(*config.Signing)
 	SigAlgo() x509.SignatureAlgorithm
 	Sign(req SignRequest) (cert []byte, err error)
 	SetReqModifier(func(*http.Request, []byte))
 }
 
+// A Reissuer is a Signer that can reissue a certificate it has issued
+// without a CSR. Proof that the requester holds the certificate's key is
+// left to the caller, so it must not be reachable from Sign.
+type Reissuer interface {
+	Signer
+	Reissue(cert *x509.Certificate, req SignRequest) ([]byte, error)
+}
+
 // Profile gets the specific profile from the signer
 func Profile(s Signer, profile string) (*config.SigningProfile, error) {
 	var p *config.SigningProfile
@@ -202,6 +217,21 @@ func ParseCertificateRequest(s Signer, csrBytes []byte) (template *x509.Certific
 	return
 }
 
+// ReissueTemplate returns a template for renewing cert: a certificate
+// with the same subject, public key and subject alternative names.
+func ReissueTemplate(s Signer, cert *x509.Certificate) *x509.Certificate {
+	return &x509.Certificate{
+		Subject:            cert.Subject,
+		PublicKeyAlgorithm: cert.PublicKeyAlgorithm,
+		PublicKey:          cert.PublicKey,
+		SignatureAlgorithm: s.SigAlgo(),
+		DNSNames:           cert.DNSNames,
+		IPAddresses:        cert.IPAddresses,
+		EmailAddresses:     cert.EmailAddresses,
+		URIs:               cert.URIs,
+	}
+}
+
 type subjectPublicKeyInfo struct {
 	Algorithm        pkix.AlgorithmIdentifier
 	SubjectPublicKey asn1.BitString
@@ -245,6 +275,7 @@ func FillTemplate(template *x509.Certificate, defaultProfile, profile *config.Si
 		notBefore       time.Time
 		notAfter        time.Time
 		crlURL, ocspURL string
+		freshestCRLURL  string
 		issuerURL       = profile.IssuerURL
 	)
 
@@ -267,6 +298,13 @@ func FillTemplate(template *x509.Certificate, defaultProfile, profile *config.Si
 	if crlURL = profile.CRL; crlURL == "" {
 		crlURL = defaultProfile.CRL
 	}
+	partitions := profile.CRLPartitions
+	if partitions == nil {
+		partitions = defaultProfile.CRLPartitions
+	}
+	if freshestCRLURL = profile.FreshestCRL; freshestCRLURL == "" {
+		freshestCRLURL = defaultProfile.FreshestCRL
+	}
 	if ocspURL = profile.OCSP; ocspURL == "" {
 		ocspURL = defaultProfile.OCSP
 	}
@@ -288,6 +326,14 @@ func FillTemplate(template *x509.Certificate, defaultProfile, profile *config.Si
 		notAfter = notBefore.Add(expiry).UTC()
 	}
 
+	if partitions != nil {
+		if template.SerialNumber == nil && partitions.By != config.PartitionByExpiry {
+			return cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+				errors.New("CRL partitions by serial number need the certificate's serial number"))
+		}
+		crlURL = partitions.URL(template.SerialNumber, notAfter)
+	}
+
 	template.NotBefore = notBefore
 	template.NotAfter = notAfter
 	template.KeyUsage = ku
@@ -310,6 +356,12 @@ func FillTemplate(template *x509.Certificate, defaultProfile, profile *config.Si
 	if crlURL != "" {
 		template.CRLDistributionPoints = []string{crlURL}
 	}
+	if freshestCRLURL != "" {
+		err = addFreshestCRL(template, freshestCRLURL)
+		if err != nil {
+			return cferr.Wrap(cferr.PolicyError, cferr.Unknown, err)
+		}
+	}
 
 	if len(issuerURL) != 0 {
 		template.IssuingCertificateURL = issuerURL
@@ -366,8 +418,42 @@ var (
 	// SCTListOID is the object ID for the Signed Certificate Timestamp certificate extension
 	// https://tools.ietf.org/html/rfc6962#page-14
 	SCTListOID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+
+	// FreshestCRLOID is the object ID of the Freshest CRL (delta CRL
+	// distribution point) extension
+	// https://tools.ietf.org/html/rfc5280#section-4.2.1.15
+	FreshestCRLOID = asn1.ObjectIdentifier{2, 5, 29, 46}
 )
 
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+}
+
+// addFreshestCRL adds a Freshest CRL extension pointing at the given delta
+// CRL URL. It has the syntax of the CRL Distribution Points extension, which
+// Go's x509 library only supports for the latter.
+func addFreshestCRL(template *x509.Certificate, url string) error {
+	asn1Bytes, err := asn1.Marshal([]distributionPoint{{
+		DistributionPoint: distributionPointName{
+			FullName: []asn1.RawValue{{Tag: 6, Class: asn1.ClassContextSpecific, Bytes: []byte(url)}},
+		},
+	}})
+	if err != nil {
+		return err
+	}
+
+	template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+		Id:       FreshestCRLOID,
+		Critical: false,
+		Value:    asn1Bytes,
+	})
+	return nil
+}
+
 // addPolicies adds Certificate Policies and optional Policy Qualifiers to a
 // certificate, based on the input config. Go's x509 library allows setting
 // Certificate Policies easily, but does not support nested Policy Qualifiers
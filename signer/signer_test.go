@@ -2,12 +2,17 @@ package signer
 
 import (
 	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
 	"crypto/x509"
 	"encoding/asn1"
 	"encoding/hex"
 	"fmt"
+	"math/big"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/ucosty/cfssl/config"
 	"github.com/ucosty/cfssl/csr"
@@ -72,6 +77,66 @@ func TestAddPolicies(t *testing.T) {
 	}
 }
 
+func TestAddFreshestCRL(t *testing.T) {
+	var cert x509.Certificate
+	if err := addFreshestCRL(&cert, "http://crl.example.com/delta.crl"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(cert.ExtraExtensions) != 1 {
+		t.Fatal("No extension added")
+	}
+	ext := cert.ExtraExtensions[0]
+	if !reflect.DeepEqual(ext.Id, FreshestCRLOID) {
+		t.Fatalf("Wrong OID for freshest CRL %v", ext.Id)
+	}
+	if ext.Critical {
+		t.Fatal("Freshest CRL marked critical")
+	}
+
+	expectedBytes, _ := hex.DecodeString("30283026a024a0228620687474703a2f2f63726c2e6578616d706c652e636f6d2f64656c74612e63726c")
+	if !bytes.Equal(ext.Value, expectedBytes) {
+		t.Fatalf("Value didn't match expected bytes: got %s, expected %s",
+			hex.EncodeToString(ext.Value), hex.EncodeToString(expectedBytes))
+	}
+}
+
+func TestFillTemplateCRLPartition(t *testing.T) {
+	profile := &config.SigningProfile{
+		Usage:  []string{"digital signature"},
+		Expiry: time.Hour,
+		CRL:    "http://crl.example.com/ca.crl",
+		CRLPartitions: &config.CRLPartitions{
+			URLs: []string{"http://crl.example.com/0.crl", "http://crl.example.com/1.crl"},
+		},
+	}
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{SerialNumber: big.NewInt(5), PublicKey: priv.Public()}
+	if err := FillTemplate(template, profile, profile); err != nil {
+		t.Fatal(err)
+	}
+	if len(template.CRLDistributionPoints) != 1 || template.CRLDistributionPoints[0] != "http://crl.example.com/1.crl" {
+		t.Fatalf("want the distribution point of partition 1, got %v", template.CRLDistributionPoints)
+	}
+
+	// Without a serial number, only partitions by expiry can be chosen.
+	template = &x509.Certificate{PublicKey: priv.Public()}
+	if err := FillTemplate(template, profile, profile); err == nil {
+		t.Fatal("expected partitioning by serial number to fail without one")
+	}
+	profile.CRLPartitions.By = config.PartitionByExpiry
+	if err := FillTemplate(template, profile, profile); err != nil {
+		t.Fatal(err)
+	}
+	if len(template.CRLDistributionPoints) != 1 || template.CRLDistributionPoints[0] == profile.CRL {
+		t.Fatalf("want the distribution point of a partition, got %v", template.CRLDistributionPoints)
+	}
+}
+
 func TestAddPoliciesWithQualifiers(t *testing.T) {
 	var cert x509.Certificate
 	addPolicies(&cert, []config.CertificatePolicy{
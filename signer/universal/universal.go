@@ -3,12 +3,14 @@ package universal
 
 import (
 	"crypto/x509"
+	"errors"
 	"net/http"
 
 	"github.com/ucosty/cfssl/certdb"
 	"github.com/ucosty/cfssl/config"
 	cferr "github.com/ucosty/cfssl/errors"
 	"github.com/ucosty/cfssl/info"
+	"github.com/ucosty/cfssl/ocsp"
 	"github.com/ucosty/cfssl/signer"
 	"github.com/ucosty/cfssl/signer/local"
 	"github.com/ucosty/cfssl/signer/remote"
@@ -172,6 +174,22 @@ func (s *Signer) Sign(req signer.SignRequest) (cert []byte, err error) {
 
 }
 
+// Reissue reissues cert with the local signer. Certificates of profiles
+// signed by a remote server cannot be reissued.
+func (s *Signer) Reissue(cert *x509.Certificate, req signer.SignRequest) ([]byte, error) {
+	profile, err := s.getMatchingProfile(req.Profile)
+	if err != nil {
+		return nil, err
+	}
+
+	reissuer, ok := s.local.(signer.Reissuer)
+	if profile.RemoteServer != "" || !ok {
+		return nil, cferr.Wrap(cferr.PolicyError, cferr.InvalidRequest,
+			errors.New("the certificate's profile is signed remotely and cannot be reissued"))
+	}
+	return reissuer.Reissue(cert, req)
+}
+
 // Info sends an info request to the remote or local CFSSL server
 // receiving an Resp struct or an error in response.
 func (s *Signer) Info(req info.Req) (resp *info.Resp, err error) {
@@ -193,6 +211,12 @@ func (s *Signer) SetDBAccessor(dba certdb.Accessor) {
 	s.remote.SetDBAccessor(dba)
 }
 
+// SetOCSPSigner sets the OCSP signer used alongside the cert db accessor.
+func (s *Signer) SetOCSPSigner(ocspSigner ocsp.Signer) {
+	s.local.SetOCSPSigner(ocspSigner)
+	s.remote.SetOCSPSigner(ocspSigner)
+}
+
 // SetReqModifier sets the function to call to modify the HTTP request prior to sending it
 func (s *Signer) SetReqModifier(mod func(*http.Request, []byte)) {
 	s.local.SetReqModifier(mod)
@@ -0,0 +1,649 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ucosty/cfssl/api/acme"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// ACMETimeout bounds how long SignCSR waits for an ACME order to be
+// validated and its certificate issued. SignCSRContext is bounded by its
+// context instead.
+var ACMETimeout = 5 * time.Minute
+
+// acmePollInterval is how often pending authorizations and orders are
+// polled.
+var acmePollInterval = time.Second
+
+// maxACMEResponse bounds the size of the responses of the ACME CA.
+const maxACMEResponse = 1 << 20
+
+const acmeChallengePath = "/.well-known/acme-challenge/"
+
+// ACME obtains certificates from an ACME (RFC 8555) CA, such as Let's
+// Encrypt or cfssl serve -acme. Control of the DNS names of each CSR is
+// proven with http-01 challenges, which ACME answers itself.
+type ACME struct {
+	// Directory is the URL of the directory of the ACME CA.
+	Directory string
+
+	// Contact holds the URLs, such as mailto:ops@example.com, given
+	// to the CA when the account is created.
+	Contact []string
+
+	// TermsOfServiceAgreed tells the CA, when the account is created,
+	// that its terms of service have been agreed to. CAs that have
+	// terms, such as Let's Encrypt, refuse accounts without it.
+	TermsOfServiceAgreed bool
+
+	// ChallengeAddress, if not empty, is the address on which http-01
+	// challenges are answered while an order is pending; the CA
+	// expects them on port 80 of each name. Otherwise, HTTPHandler
+	// must be served there.
+	ChallengeAddress string
+
+	// CACertificateFile, if not empty, holds the PEM certificate that
+	// CACertificate returns until a certificate has been issued.
+	CACertificateFile string
+
+	// Client makes the requests to the CA.
+	Client *http.Client
+
+	key crypto.Signer
+
+	// mu serialises orders, and guards the account and issuer.
+	mu     sync.Mutex
+	dir    *acmeDirectory
+	kid    string
+	issuer []byte
+
+	nonceMu sync.Mutex
+	nonces  []string
+
+	tokenMu sync.Mutex
+	tokens  map[string]string
+}
+
+type acmeDirectory struct {
+	NewNonce   string `json:"newNonce"`
+	NewAccount string `json:"newAccount"`
+	NewOrder   string `json:"newOrder"`
+}
+
+type acmeOrder struct {
+	Status         string        `json:"status"`
+	Authorizations []string      `json:"authorizations"`
+	Finalize       string        `json:"finalize"`
+	Certificate    string        `json:"certificate"`
+	Error          *acme.Problem `json:"error"`
+}
+
+type acmeAuthorization struct {
+	Identifier acme.Identifier `json:"identifier"`
+	Status     string          `json:"status"`
+	Challenges []struct {
+		Type   string        `json:"type"`
+		URL    string        `json:"url"`
+		Token  string        `json:"token"`
+		Status string        `json:"status"`
+		Error  *acme.Problem `json:"error"`
+	} `json:"challenges"`
+}
+
+// NewACME returns an ACME for the CA whose directory is at directory,
+// with the account key key, which must be an RSA or ECDSA private key.
+func NewACME(directory string, key crypto.Signer) (*ACME, error) {
+	if _, err := acme.NewJSONWebKey(key.Public()); err != nil {
+		return nil, fmt.Errorf("transport: unsupported ACME account key: %v", err)
+	}
+	return &ACME{
+		Directory: directory,
+		Client:    &http.Client{Timeout: 30 * time.Second},
+		key:       key,
+		tokens:    map[string]string{},
+	}, nil
+}
+
+// NewACMEProvider returns an ACME configured by the "acme" profile of id,
+// which takes the following keys:
+//
+//	directory         the URL of the ACME directory (required)
+//	account-key       a PEM file holding the account key, generated if
+//	                  it does not exist; the key is not kept otherwise
+//	contact           comma-separated contact URLs of the account
+//	agree-tos         "true" to agree to the CA's terms of service
+//	challenge-address the address to answer http-01 challenges on
+//	ca-certificate    a PEM file holding the CA certificate
+//	tls-remote-ca     a PEM bundle of the roots of the CA's TLS server
+func NewACMEProvider(id *core.Identity) (*ACME, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+	profile := id.Profiles["acme"]
+	if profile["directory"] == "" {
+		return nil, errors.New("transport: the acme profile has no directory")
+	}
+
+	key, err := loadACMEAccountKey(profile["account-key"])
+	if err != nil {
+		return nil, err
+	}
+	a, err := NewACME(profile["directory"], key)
+	if err != nil {
+		return nil, err
+	}
+	if contact := profile["contact"]; contact != "" {
+		for _, c := range strings.Split(contact, ",") {
+			a.Contact = append(a.Contact, strings.TrimSpace(c))
+		}
+	}
+	if agree := profile["agree-tos"]; agree != "" {
+		if a.TermsOfServiceAgreed, err = strconv.ParseBool(agree); err != nil {
+			return nil, fmt.Errorf("transport: invalid agree-tos %q in the acme profile", agree)
+		}
+	}
+	a.ChallengeAddress = profile["challenge-address"]
+	a.CACertificateFile = profile["ca-certificate"]
+
+	if profile["tls-remote-ca"] != "" {
+		roots, err := helpers.LoadPEMCertPool(profile["tls-remote-ca"])
+		if err != nil {
+			return nil, err
+		}
+		a.Client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tls.Config{RootCAs: roots},
+		}
+	}
+	return a, nil
+}
+
+// loadACMEAccountKey loads the account key from path, generating and
+// storing an ECDSA P-256 key if it does not exist. With an empty path,
+// a key is generated for the lifetime of the process.
+func loadACMEAccountKey(path string) (crypto.Signer, error) {
+	if path != "" {
+		keyPEM, err := ioutil.ReadFile(path)
+		if err == nil {
+			return helpers.ParsePrivateKeyPEM(keyPEM)
+		}
+		if !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	if path != "" {
+		der, err := x509.MarshalECPrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der})
+		if err = ioutil.WriteFile(path, keyPEM, 0600); err != nil {
+			return nil, err
+		}
+		log.Infof("transport: generated ACME account key %s", path)
+	}
+	return key, nil
+}
+
+// HTTPHandler answers the http-01 challenges of the pending orders.
+func (a *ACME) HTTPHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.tokenMu.Lock()
+		keyAuthorization, ok := a.tokens[strings.TrimPrefix(r.URL.Path, acmeChallengePath)]
+		a.tokenMu.Unlock()
+		if !ok || !strings.HasPrefix(r.URL.Path, acmeChallengePath) {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(keyAuthorization))
+	})
+}
+
+// SignCSR obtains a certificate for the DNS names of a PEM CSR, whose
+// common name, if any, must be one of them.
+func (a *ACME) SignCSR(csrPEM []byte) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), ACMETimeout)
+	defer cancel()
+	return a.SignCSRContext(ctx, csrPEM)
+}
+
+// SignCSRContext is SignCSR with a context.
+func (a *ACME) SignCSRContext(ctx context.Context, csrPEM []byte) ([]byte, error) {
+	p, _ := pem.Decode(csrPEM)
+	if p == nil || p.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("transport: invalid PEM-encoded certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	names := csr.DNSNames
+	if cn := csr.Subject.CommonName; cn != "" && !containsName(names, cn) {
+		names = append([]string{cn}, names...)
+	}
+	if len(names) == 0 {
+		return nil, errors.New("transport: an ACME certificate request needs DNS names")
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err = a.register(ctx); err != nil {
+		return nil, err
+	}
+
+	var identifiers []acme.Identifier
+	for _, name := range names {
+		identifiers = append(identifiers, acme.Identifier{Type: "dns", Value: name})
+	}
+	var o acmeOrder
+	hdr, err := a.post(ctx, a.dir.NewOrder, map[string]interface{}{"identifiers": identifiers}, &o)
+	if err != nil {
+		return nil, err
+	}
+	orderURL := hdr.Get("Location")
+
+	if err = a.authorize(ctx, o.Authorizations); err != nil {
+		return nil, err
+	}
+
+	_, err = a.post(ctx, o.Finalize, map[string]string{"csr": base64.RawURLEncoding.EncodeToString(csr.Raw)}, &o)
+	if err != nil {
+		return nil, err
+	}
+	for o.Status != acme.StatusValid {
+		if o.Status == acme.StatusInvalid {
+			return nil, fmt.Errorf("transport: the ACME order failed: %v", o.Error)
+		}
+		if err = a.wait(ctx); err != nil {
+			return nil, err
+		}
+		if _, err = a.post(ctx, orderURL, nil, &o); err != nil {
+			return nil, err
+		}
+	}
+
+	chain, err := a.fetch(ctx, o.Certificate)
+	if err != nil {
+		return nil, err
+	}
+	leaf, rest := pem.Decode(chain)
+	if leaf == nil || leaf.Type != "CERTIFICATE" {
+		return nil, errors.New("transport: the ACME CA returned no certificate")
+	}
+	if issuer, _ := pem.Decode(rest); issuer != nil && issuer.Type == "CERTIFICATE" {
+		a.issuer = pem.EncodeToMemory(issuer)
+	}
+	return pem.EncodeToMemory(leaf), nil
+}
+
+// CACertificate returns the issuer of the last certificate obtained, or
+// the contents of CACertificateFile before then.
+func (a *ACME) CACertificate() ([]byte, error) {
+	return a.CACertificateContext(context.Background())
+}
+
+// CACertificateContext is CACertificate with a context; ACME has no
+// request for the CA certificate, so it is not used.
+func (a *ACME) CACertificateContext(ctx context.Context) ([]byte, error) {
+	a.mu.Lock()
+	issuer := a.issuer
+	a.mu.Unlock()
+	if issuer != nil {
+		return issuer, nil
+	}
+	if a.CACertificateFile != "" {
+		return ioutil.ReadFile(a.CACertificateFile)
+	}
+	return nil, errors.New("transport: the ACME CA certificate is not known until a certificate is issued")
+}
+
+func containsName(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// register fetches the directory and finds or creates the account of the
+// key, once. The caller must hold a.mu.
+func (a *ACME) register(ctx context.Context) error {
+	if a.dir == nil {
+		req, err := http.NewRequestWithContext(ctx, "GET", a.Directory, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := a.Client.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		var dir acmeDirectory
+		if err = json.NewDecoder(io.LimitReader(resp.Body, maxACMEResponse)).Decode(&dir); err != nil {
+			return fmt.Errorf("transport: invalid ACME directory: %v", err)
+		}
+		a.dir = &dir
+	}
+
+	if a.kid == "" {
+		hdr, err := a.post(ctx, a.dir.NewAccount, map[string]interface{}{
+			"contact":              a.Contact,
+			"termsOfServiceAgreed": a.TermsOfServiceAgreed,
+		}, nil)
+		if err != nil {
+			return err
+		}
+		a.kid = hdr.Get("Location")
+		if a.kid == "" {
+			return errors.New("transport: the ACME CA returned no account URL")
+		}
+	}
+	return nil
+}
+
+// authorize completes the pending authorizations with their http-01
+// challenges.
+func (a *ACME) authorize(ctx context.Context, urls []string) error {
+	if a.ChallengeAddress != "" {
+		ln, err := net.Listen("tcp", a.ChallengeAddress)
+		if err != nil {
+			return err
+		}
+		srv := &http.Server{Handler: a.HTTPHandler()}
+		go srv.Serve(ln)
+		defer srv.Close()
+	}
+
+	jwk, err := acme.NewJSONWebKey(a.key.Public())
+	if err != nil {
+		return err
+	}
+	thumbprint := jwk.Thumbprint()
+
+	for _, url := range urls {
+		var authz acmeAuthorization
+		if _, err = a.post(ctx, url, nil, &authz); err != nil {
+			return err
+		}
+		if authz.Status == acme.StatusValid {
+			continue
+		}
+
+		var challengeURL, token string
+		for _, chal := range authz.Challenges {
+			if chal.Type == acme.ChallengeHTTP01 {
+				challengeURL, token = chal.URL, chal.Token
+			}
+		}
+		if challengeURL == "" {
+			return fmt.Errorf("transport: the ACME CA offers no http-01 challenge for %s", authz.Identifier.Value)
+		}
+
+		a.tokenMu.Lock()
+		a.tokens[token] = token + "." + thumbprint
+		a.tokenMu.Unlock()
+		err = a.complete(ctx, url, challengeURL, &authz)
+		a.tokenMu.Lock()
+		delete(a.tokens, token)
+		a.tokenMu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// complete responds to the challenge of an authorization and waits for
+// it to be validated.
+func (a *ACME) complete(ctx context.Context, authzURL, challengeURL string, authz *acmeAuthorization) error {
+	if _, err := a.post(ctx, challengeURL, struct{}{}, nil); err != nil {
+		return err
+	}
+	for {
+		if _, err := a.post(ctx, authzURL, nil, authz); err != nil {
+			return err
+		}
+		switch authz.Status {
+		case acme.StatusValid:
+			return nil
+		case acme.StatusPending:
+		default:
+			for _, chal := range authz.Challenges {
+				if chal.Error != nil {
+					return fmt.Errorf("transport: ACME validation of %s failed: %v", authz.Identifier.Value, chal.Error)
+				}
+			}
+			return fmt.Errorf("transport: the ACME authorization of %s is %s", authz.Identifier.Value, authz.Status)
+		}
+		if err := a.wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+func (a *ACME) wait(ctx context.Context) error {
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(acmePollInterval):
+		return nil
+	}
+}
+
+// fetch returns the body of a POST-as-GET request.
+func (a *ACME) fetch(ctx context.Context, url string) ([]byte, error) {
+	var body []byte
+	_, err := a.post(ctx, url, nil, &body)
+	return body, err
+}
+
+// post sends payload, or a POST-as-GET if it is nil, to url in a JWS and
+// decodes the JSON response into out; a *[]byte out receives the raw
+// response instead. It retries once after a bad nonce.
+func (a *ACME) post(ctx context.Context, url string, payload interface{}, out interface{}) (http.Header, error) {
+	var body []byte
+	if payload != nil {
+		var err error
+		if body, err = json.Marshal(payload); err != nil {
+			return nil, err
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		nonce, err := a.nonce(ctx)
+		if err != nil {
+			return nil, err
+		}
+		jws, err := a.sign(url, nonce, body)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader(jws))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/jose+json")
+		resp, err := a.Client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxACMEResponse))
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		a.saveNonce(resp.Header.Get("Replay-Nonce"))
+
+		if resp.StatusCode >= 400 {
+			prob := &acme.Problem{Status: resp.StatusCode}
+			if json.Unmarshal(data, prob) != nil || prob.Type == "" {
+				prob.Detail = string(data)
+			}
+			if prob.Type == "urn:ietf:params:acme:error:badNonce" && attempt == 0 {
+				continue
+			}
+			return nil, fmt.Errorf("transport: ACME request to %s failed: %v", url, prob)
+		}
+
+		switch out := out.(type) {
+		case nil:
+		case *[]byte:
+			*out = data
+		default:
+			if err = json.Unmarshal(data, out); err != nil {
+				return nil, fmt.Errorf("transport: invalid ACME response from %s: %v", url, err)
+			}
+		}
+		return resp.Header, nil
+	}
+}
+
+// nonce returns a nonce saved from a previous response, or a new one.
+func (a *ACME) nonce(ctx context.Context) (string, error) {
+	a.nonceMu.Lock()
+	if n := len(a.nonces); n > 0 {
+		nonce := a.nonces[n-1]
+		a.nonces = a.nonces[:n-1]
+		a.nonceMu.Unlock()
+		return nonce, nil
+	}
+	a.nonceMu.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", a.dir.NewNonce, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := a.Client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	nonce := resp.Header.Get("Replay-Nonce")
+	if nonce == "" {
+		return "", errors.New("transport: the ACME CA returned no nonce")
+	}
+	return nonce, nil
+}
+
+func (a *ACME) saveNonce(nonce string) {
+	if nonce == "" {
+		return
+	}
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+	if len(a.nonces) < 16 {
+		a.nonces = append(a.nonces, nonce)
+	}
+}
+
+// sign returns the flattened JWS of payload for url, identified by the
+// account URL once there is one and by the key itself before.
+func (a *ACME) sign(url, nonce string, payload []byte) ([]byte, error) {
+	var alg string
+	var hash crypto.Hash
+	size := 0
+	switch pub := a.key.Public().(type) {
+	case *rsa.PublicKey:
+		alg, hash = "RS256", crypto.SHA256
+	case *ecdsa.PublicKey:
+		size = (pub.Curve.Params().BitSize + 7) / 8
+		switch pub.Curve {
+		case elliptic.P256():
+			alg, hash = "ES256", crypto.SHA256
+		case elliptic.P384():
+			alg, hash = "ES384", crypto.SHA384
+		case elliptic.P521():
+			alg, hash = "ES512", crypto.SHA512
+		default:
+			return nil, errors.New("transport: unsupported ACME account key curve")
+		}
+	default:
+		return nil, errors.New("transport: unsupported ACME account key")
+	}
+
+	header := map[string]interface{}{"alg": alg, "nonce": nonce, "url": url}
+	if a.kid != "" {
+		header["kid"] = a.kid
+	} else {
+		jwk, err := acme.NewJSONWebKey(a.key.Public())
+		if err != nil {
+			return nil, err
+		}
+		header["jwk"] = jwk
+	}
+	protected, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+
+	jws := acme.JWS{
+		Protected: base64.RawURLEncoding.EncodeToString(protected),
+		Payload:   base64.RawURLEncoding.EncodeToString(payload),
+	}
+	var digest []byte
+	signingInput := []byte(jws.Protected + "." + jws.Payload)
+	switch hash {
+	case crypto.SHA256:
+		sum := sha256.Sum256(signingInput)
+		digest = sum[:]
+	case crypto.SHA384:
+		sum := sha512.Sum384(signingInput)
+		digest = sum[:]
+	case crypto.SHA512:
+		sum := sha512.Sum512(signingInput)
+		digest = sum[:]
+	}
+	sig, err := a.key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+	if size > 0 {
+		// JWS carries the fixed-size r || s rather than ASN.1.
+		var ecSig struct{ R, S *big.Int }
+		if _, err = asn1.Unmarshal(sig, &ecSig); err != nil {
+			return nil, err
+		}
+		sig = make([]byte, 2*size)
+		ecSig.R.FillBytes(sig[:size])
+		ecSig.S.FillBytes(sig[size:])
+	}
+	jws.Signature = base64.RawURLEncoding.EncodeToString(sig)
+	return json.Marshal(jws)
+}
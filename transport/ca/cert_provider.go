@@ -3,6 +3,13 @@
 // by some certificate authority.
 package ca
 
+import (
+	"context"
+	"errors"
+
+	"github.com/ucosty/cfssl/transport/core"
+)
+
 // A CertificateAuthority is capable of signing certificates given
 // certificate signing requests.
 type CertificateAuthority interface {
@@ -14,3 +21,37 @@ type CertificateAuthority interface {
 	// certificate.
 	CACertificate() (cert []byte, err error)
 }
+
+// A ContextCertificateAuthority is a CertificateAuthority whose requests
+// can be cancelled and bounded by a context.
+type ContextCertificateAuthority interface {
+	CertificateAuthority
+
+	// SignCSRContext is SignCSR with a context.
+	SignCSRContext(ctx context.Context, csrPEM []byte) (cert []byte, err error)
+
+	// CACertificateContext is CACertificate with a context.
+	CACertificateContext(ctx context.Context) (cert []byte, err error)
+}
+
+// New returns the CertificateAuthority selected by the profiles of id:
+// an ACME CA with an "acme" profile, a Spool with a "spool" profile, and
+// a CFSSL CA otherwise.
+func New(id *core.Identity) (CertificateAuthority, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	_, acme := id.Profiles["acme"]
+	_, spool := id.Profiles["spool"]
+	switch {
+	case acme && spool:
+		return nil, errors.New("transport: the acme and spool profiles are mutually exclusive")
+	case acme:
+		return NewACMEProvider(id)
+	case spool:
+		return NewSpoolProvider(id)
+	default:
+		return NewCFSSLProvider(id, nil)
+	}
+}
@@ -0,0 +1,304 @@
+package ca
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ucosty/cfssl/api/acme"
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/signer"
+	"github.com/ucosty/cfssl/signer/local"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+const (
+	testCaFile    = "../../signer/local/testdata/ca.pem"
+	testCaKeyFile = "../../signer/local/testdata/ca_key.pem"
+)
+
+func newTestSigner(t *testing.T) *local.Signer {
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func newTestCSR(t *testing.T, cn string, names ...string) []byte {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: cn},
+		DNSNames: names,
+	}, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: der})
+}
+
+// newTestACME returns an ACME of a stand-in ACME CA, whose http-01
+// validations are made of the challenges server, which serves the ACME's
+// own HTTPHandler.
+func newTestACME(t *testing.T, accountKey string) (*ACME, *httptest.Server, func()) {
+	challenges := httptest.NewServer(nil)
+	srv := acme.NewServer(newTestSigner(t), "", "/acme/")
+	srv.Validators = map[string]acme.Validator{
+		acme.ChallengeHTTP01: acme.ValidatorFunc(func(domain, token, keyAuthorization string) error {
+			resp, err := http.Get(challenges.URL + acmeChallengePath + token)
+			if err != nil {
+				return err
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != keyAuthorization {
+				return fmt.Errorf("unexpected key authorization %q", body)
+			}
+			return nil
+		}),
+	}
+	ca := httptest.NewServer(srv)
+
+	a, err := NewACMEProvider(&core.Identity{Profiles: map[string]map[string]string{
+		"acme": {
+			"directory":   ca.URL + "/acme/directory",
+			"account-key": accountKey,
+			"contact":     "mailto:ops@example.com",
+			"agree-tos":   "true",
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenges.Config.Handler = a.HTTPHandler()
+	return a, challenges, func() {
+		ca.Close()
+		challenges.Close()
+	}
+}
+
+func TestACME(t *testing.T) {
+	acmePollInterval = 10 * time.Millisecond
+	accountKey := filepath.Join(t.TempDir(), "account.key")
+	a, _, done := newTestACME(t, accountKey)
+	defer done()
+
+	if _, err := a.CACertificate(); err == nil {
+		t.Fatal("expected the CA certificate to be unknown before issuance")
+	}
+
+	certPEM, err := a.SignCSR(newTestCSR(t, "svc.example.com", "svc.example.com", "www.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cert.DNSNames) != 2 {
+		t.Fatalf("expected a certificate for both names, got %v", cert.DNSNames)
+	}
+
+	caPEM, err := a.CACertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	caCert, err := helpers.ParseCertificatePEM(caPEM)
+	if err != nil || cert.CheckSignatureFrom(caCert) != nil {
+		t.Fatalf("expected the issuer of the certificate: %v", err)
+	}
+
+	// The account is reused, and its key stored for the next run.
+	kid := a.kid
+	if _, err = a.SignCSR(newTestCSR(t, "other.example.com")); err != nil {
+		t.Fatal(err)
+	}
+	if a.kid != kid {
+		t.Fatal("expected the account to be reused")
+	}
+	key, err := loadACMEAccountKey(accountKey)
+	if err != nil || !key.Public().(*ecdsa.PublicKey).Equal(a.key.Public()) {
+		t.Fatalf("expected the account key to be stored: %v", err)
+	}
+
+	if _, err = a.SignCSR(newTestCSR(t, "")); err == nil {
+		t.Fatal("expected a CSR without names to be rejected")
+	}
+}
+
+func TestACMEFailedValidation(t *testing.T) {
+	acmePollInterval = 10 * time.Millisecond
+	a, challenges, done := newTestACME(t, "")
+	defer done()
+
+	challenges.Config.Handler = http.NotFoundHandler()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := a.SignCSRContext(ctx, newTestCSR(t, "svc.example.com"))
+	if err == nil || !strings.Contains(err.Error(), "svc.example.com") {
+		t.Fatalf("expected the validation to fail, got %v", err)
+	}
+	if len(a.tokens) != 0 {
+		t.Fatal("expected the challenge token to be forgotten")
+	}
+}
+
+// signSpooled signs the CSRs dropped into dir, as the operator of a
+// spool would.
+func signSpooled(t *testing.T, dir string, s signer.Signer) {
+	csrs, _ := filepath.Glob(filepath.Join(dir, "*.csr"))
+	for _, csrFile := range csrs {
+		csrPEM, err := ioutil.ReadFile(csrFile)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		certPEM, err := s.Sign(signer.SignRequest{Hosts: []string{"svc.example.com"}, Request: string(csrPEM)})
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		ioutil.WriteFile(strings.TrimSuffix(csrFile, ".csr")+".pem", certPEM, 0644)
+	}
+}
+
+func TestSpool(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := NewSpoolProvider(&core.Identity{Profiles: map[string]map[string]string{
+		"spool": {
+			"directory":      dir,
+			"poll-interval":  "10ms",
+			"timeout":        "10s",
+			"ca-certificate": testCaFile,
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := newTestSigner(t)
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		for {
+			select {
+			case <-stop:
+				return
+			case <-time.After(20 * time.Millisecond):
+				signSpooled(t, dir, s)
+			}
+		}
+	}()
+
+	certPEM, err := sp.SignCSR(newTestCSR(t, "svc.example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = helpers.ParseCertificatePEM(certPEM); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+		t.Fatalf("expected the spool to be emptied, got %d files", len(files))
+	}
+	if _, err = sp.CACertificate(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestSpoolTimeout(t *testing.T) {
+	dir := t.TempDir()
+	sp, err := NewSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sp.Timeout != DefaultSpoolTimeout {
+		t.Fatalf("expected the default timeout, got %v", sp.Timeout)
+	}
+	sp.PollInterval = 10 * time.Millisecond
+	sp.Timeout = 50 * time.Millisecond
+
+	csrPEM := newTestCSR(t, "svc.example.com")
+	if _, err = sp.SignCSR(csrPEM); err != ErrSpoolTimeout {
+		t.Fatalf("expected ErrSpoolTimeout, got %v", err)
+	}
+	csrs, _ := filepath.Glob(filepath.Join(dir, "*.csr"))
+	if len(csrs) != 1 {
+		t.Fatal("expected the CSR to be left in the spool")
+	}
+
+	// A certificate not signed by the CA is rejected.
+	sp.CACertificateFile = "../../signer/local/testdata/ecdsa256_ca.pem"
+	certPEM, err := newTestSigner(t).Sign(signer.SignRequest{Hosts: []string{"svc.example.com"}, Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(strings.TrimSuffix(csrs[0], ".csr")+".pem", certPEM, 0644)
+	if _, err = sp.SignCSR(csrPEM); err == nil || err == ErrSpoolTimeout {
+		t.Fatalf("expected the certificate to be rejected, got %v", err)
+	}
+	sp.CACertificateFile = testCaFile
+
+	// A certificate for another key is rejected.
+	certPEM, err = newTestSigner(t).Sign(signer.SignRequest{Hosts: []string{"svc.example.com"}, Request: string(newTestCSR(t, "svc.example.com"))})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioutil.WriteFile(strings.TrimSuffix(csrs[0], ".csr")+".pem", certPEM, 0644)
+	if _, err = sp.SignCSR(csrPEM); err == nil || err == ErrSpoolTimeout {
+		t.Fatalf("expected the certificate to be rejected, got %v", err)
+	}
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	profiles := map[string]map[string]string{
+		"acme":  {"directory": "https://ca.example.com/acme/directory"},
+		"spool": {"directory": dir},
+	}
+	if _, err := New(&core.Identity{Profiles: profiles}); err == nil {
+		t.Fatal("expected the acme and spool profiles to be exclusive")
+	}
+
+	if c, err := New(&core.Identity{Profiles: map[string]map[string]string{"acme": profiles["acme"]}}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.(*ACME); !ok {
+		t.Fatalf("expected an ACME, got %T", c)
+	}
+	if c, err := New(&core.Identity{Profiles: map[string]map[string]string{"spool": profiles["spool"]}}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.(*Spool); !ok {
+		t.Fatalf("expected a Spool, got %T", c)
+	}
+	if _, err := New(&core.Identity{Profiles: map[string]map[string]string{"spool": {}}}); err == nil {
+		t.Fatal("expected a spool profile without a directory to be rejected")
+	}
+	if c, err := New(&core.Identity{Profiles: map[string]map[string]string{
+		"cfssl": {"remote": "127.0.0.1:8888"},
+	}}); err != nil {
+		t.Fatal(err)
+	} else if _, ok := c.(*CFSSL); !ok {
+		t.Fatalf("expected a CFSSL, got %T", c)
+	}
+	if _, err := New(nil); err == nil {
+		t.Fatal("expected a nil identity to be rejected")
+	}
+
+	// Both providers are context-aware.
+	var _ ContextCertificateAuthority = &ACME{}
+	var _ ContextCertificateAuthority = &Spool{}
+}
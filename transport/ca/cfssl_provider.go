@@ -1,6 +1,7 @@
 package ca
 
 import (
+	"context"
 	"crypto/x509"
 	"encoding/json"
 	"encoding/pem"
@@ -196,6 +197,12 @@ type CFSSL struct {
 
 // SignCSR requests a certificate from a CFSSL signer.
 func (cap *CFSSL) SignCSR(csrPEM []byte) (cert []byte, err error) {
+	return cap.SignCSRContext(context.Background(), csrPEM)
+}
+
+// SignCSRContext is SignCSR with a context, which cancels the request
+// and whose deadline bounds it.
+func (cap *CFSSL) SignCSRContext(ctx context.Context, csrPEM []byte) (cert []byte, err error) {
 	p, _ := pem.Decode(csrPEM)
 	if p == nil || p.Type != "CERTIFICATE REQUEST" {
 		return nil, errors.New("transport: invalid PEM-encoded certificate signing request")
@@ -225,15 +232,21 @@ func (cap *CFSSL) SignCSR(csrPEM []byte) (cert []byte, err error) {
 		return nil, err
 	}
 
+	remote := client.AsContextRemote(cap.remote)
 	if cap.provider != nil {
-		return cap.remote.AuthSign(out, nil, cap.provider)
+		return remote.AuthSignContext(ctx, out, nil, cap.provider)
 	}
 
-	return cap.remote.Sign(out)
+	return remote.SignContext(ctx, out)
 }
 
 // CACertificate returns the certificate for a CFSSL CA.
 func (cap *CFSSL) CACertificate() ([]byte, error) {
+	return cap.CACertificateContext(context.Background())
+}
+
+// CACertificateContext is CACertificate with a context.
+func (cap *CFSSL) CACertificateContext(ctx context.Context) ([]byte, error) {
 	req := &info.Req{
 		Label:   cap.Label,
 		Profile: cap.Profile,
@@ -243,7 +256,7 @@ func (cap *CFSSL) CACertificate() ([]byte, error) {
 		return nil, err
 	}
 
-	resp, err := cap.remote.Info(out)
+	resp, err := client.AsContextRemote(cap.remote).InfoContext(ctx, out)
 	if err != nil {
 		return nil, err
 	}
@@ -0,0 +1,249 @@
+package ca
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ucosty/cfssl/helpers"
+	"github.com/ucosty/cfssl/log"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// ErrSpoolTimeout is returned when no certificate has been dropped into
+// a spool directory for a CSR before the spool's timeout.
+var ErrSpoolTimeout = errors.New("transport: timed out waiting for a signed certificate in the spool")
+
+// DefaultSpoolTimeout is how long SignCSR waits for a certificate if the
+// spool has no timeout.
+const DefaultSpoolTimeout = 24 * time.Hour
+
+// Spool provides support for signing certificates out of band, for CAs
+// that cannot be reached from the host, such as air-gapped ones. Each
+// CSR is written to the spool directory as <name>.csr, where name is the
+// hex SHA-256 of its DER; the operator carries it to the CA and drops
+// the signed certificate in the directory as <name>.pem. The CA
+// certificate is read from the directory's ca.pem unless another file is
+// given, and certificates not signed by it are rejected.
+type Spool struct {
+	// Directory is the spool directory.
+	Directory string
+
+	// PollInterval is how often the directory is checked for a
+	// certificate.
+	PollInterval time.Duration
+
+	// Timeout bounds how long SignCSR waits for a certificate;
+	// DefaultSpoolTimeout is used if it is zero. SignCSRContext is
+	// bounded by its context instead.
+	Timeout time.Duration
+
+	// CACertificateFile holds the PEM certificate of the CA.
+	CACertificateFile string
+}
+
+// NewSpool returns a Spool of the directory, which is created if it does
+// not exist.
+func NewSpool(dir string) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &Spool{
+		Directory:         dir,
+		PollInterval:      5 * time.Second,
+		Timeout:           DefaultSpoolTimeout,
+		CACertificateFile: filepath.Join(dir, "ca.pem"),
+	}, nil
+}
+
+// NewSpoolProvider returns a Spool configured by the "spool" profile of
+// id, which takes the following keys:
+//
+//	directory      the spool directory (required)
+//	poll-interval  how often to check for a certificate, such as 30s
+//	timeout        how long to wait for a certificate, such as 24h
+//	ca-certificate a PEM file holding the CA certificate
+func NewSpoolProvider(id *core.Identity) (*Spool, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+	profile := id.Profiles["spool"]
+	if profile["directory"] == "" {
+		return nil, errors.New("transport: the spool profile has no directory")
+	}
+
+	s, err := NewSpool(profile["directory"])
+	if err != nil {
+		return nil, err
+	}
+	if interval := profile["poll-interval"]; interval != "" {
+		if s.PollInterval, err = time.ParseDuration(interval); err != nil {
+			return nil, err
+		}
+		if s.PollInterval <= 0 {
+			return nil, errors.New("transport: the spool poll interval must be positive")
+		}
+	}
+	if timeout := profile["timeout"]; timeout != "" {
+		if s.Timeout, err = time.ParseDuration(timeout); err != nil {
+			return nil, err
+		}
+		if s.Timeout <= 0 {
+			return nil, errors.New("transport: the spool timeout must be positive")
+		}
+	}
+	if profile["ca-certificate"] != "" {
+		s.CACertificateFile = profile["ca-certificate"]
+	}
+	return s, nil
+}
+
+// SignCSR writes a PEM CSR to the spool and waits for its certificate.
+func (s *Spool) SignCSR(csrPEM []byte) ([]byte, error) {
+	timeout := s.Timeout
+	if timeout <= 0 {
+		timeout = DefaultSpoolTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return s.SignCSRContext(ctx, csrPEM)
+}
+
+// SignCSRContext is SignCSR with a context. When the context expires,
+// ErrSpoolTimeout is returned and the CSR is left in the spool, so a
+// later request for the same CSR picks up its certificate.
+func (s *Spool) SignCSRContext(ctx context.Context, csrPEM []byte) ([]byte, error) {
+	p, _ := pem.Decode(csrPEM)
+	if p == nil || p.Type != "CERTIFICATE REQUEST" {
+		return nil, errors.New("transport: invalid PEM-encoded certificate signing request")
+	}
+	csr, err := x509.ParseCertificateRequest(p.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(csr.Raw)
+	name := filepath.Join(s.Directory, hex.EncodeToString(sum[:]))
+	if err = writeFileAtomic(name+".csr", pem.EncodeToMemory(p)); err != nil {
+		return nil, err
+	}
+	log.Infof("transport: waiting for the certificate of %s.csr", name)
+
+	interval := s.PollInterval
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		certPEM, err := s.collect(name, csr)
+		if err != nil || certPEM != nil {
+			return certPEM, err
+		}
+
+		select {
+		case <-ctx.Done():
+			if ctx.Err() == context.DeadlineExceeded {
+				return nil, ErrSpoolTimeout
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// collect returns the certificate dropped into the spool for the CSR at
+// name, removing both, or nil if there is none yet. A file that does not
+// parse is taken to be still being written. The certificate must be for
+// the key of the CSR and signed by a certificate of CACertificateFile.
+func (s *Spool) collect(name string, csr *x509.CertificateRequest) ([]byte, error) {
+	certPEM, err := ioutil.ReadFile(name + ".pem")
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, nil
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	csrPub, err := x509.MarshalPKIXPublicKey(csr.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(pub, csrPub) {
+		return nil, errors.New("transport: the certificate in the spool does not match the key of the CSR")
+	}
+	if err = s.checkIssuer(cert); err != nil {
+		return nil, err
+	}
+
+	os.Remove(name + ".csr")
+	os.Remove(name + ".pem")
+	return helpers.EncodeCertificatePEM(cert), nil
+}
+
+// checkIssuer checks that cert is signed by one of the certificates of
+// CACertificateFile. Their validity is not checked, as the clock of an
+// air-gapped CA may differ from the host's.
+func (s *Spool) checkIssuer(cert *x509.Certificate) error {
+	caPEM, err := s.CACertificate()
+	if err != nil {
+		return err
+	}
+	cas, err := helpers.ParseCertificatesPEM(caPEM)
+	if err != nil {
+		return err
+	}
+	for _, ca := range cas {
+		if cert.CheckSignatureFrom(ca) == nil {
+			return nil
+		}
+	}
+	return errors.New("transport: the certificate in the spool is not signed by the CA")
+}
+
+// writeFileAtomic writes data to a temporary file in the directory of
+// path and renames it to path, so that the file is never seen partially
+// written.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := ioutil.TempFile(filepath.Dir(path), ".spool")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), path)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+// CACertificate returns the contents of CACertificateFile.
+func (s *Spool) CACertificate() ([]byte, error) {
+	return s.CACertificateContext(context.Background())
+}
+
+// CACertificateContext is CACertificate with a context, which is not
+// used.
+func (s *Spool) CACertificateContext(ctx context.Context) ([]byte, error) {
+	return ioutil.ReadFile(s.CACertificateFile)
+}
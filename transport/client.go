@@ -27,15 +27,15 @@ func envOrDefault(key, def string) string {
 
 var (
 	// NewKeyProvider is the function used to build key providers
-	// from some identity.
+	// from some identity; see kp.New for how it is chosen.
 	NewKeyProvider = func(id *core.Identity) (kp.KeyProvider, error) {
-		return kp.NewStandardProvider(id)
+		return kp.New(id)
 	}
 
 	// NewCA is used to load a configuration for a certificate
-	// authority.
+	// authority; see ca.New for how it is chosen.
 	NewCA = func(id *core.Identity) (ca.CertificateAuthority, error) {
-		return ca.NewCFSSLProvider(id, nil)
+		return ca.New(id)
 	}
 )
 
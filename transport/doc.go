@@ -22,7 +22,36 @@
 //              },
 //     }
 //
+// The "cfssl" profile configures the CFSSL CA that signs the
+// certificate. Alternatively, an "acme" profile obtains it from an ACME
+// CA, answering http-01 challenges for the names of the request:
 //
+//                      "acme": {
+//                              "directory":         "https://ca.example.net/acme/directory",
+//                              "account-key":       "acme-account.key",
+//                              "contact":           "mailto:ops@example.net",
+//                              "agree-tos":         "true",
+//                              "challenge-address": ":80",
+//                      },
+//
+// and a "spool" profile, for CAs that cannot be reached, writes each
+// CSR to a directory and waits for the signed certificate to be dropped
+// next to it (see ca.Spool):
+//
+//                      "spool": {
+//                              "directory": "/var/spool/transport",
+//                              "timeout":   "72h",
+//                      },
+//
+// Keys are kept as PEM files at the "paths" profile by default. A
+// "memory" profile keeps the key in memory only, and a "pkcs11"
+// profile uses a key on a PKCS #11 token, when built with the pkcs11
+// tag (see kp.New):
+//
+//                      "pkcs11": {
+//                              "uri":         "pkcs11:token=transport;object=tls?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-source=file:/etc/transport/pin",
+//                              "certificate": "client.pem",
+//                      },
 //
 // The New function will return a transport built using the
 // NewKeyProvider and NewCA functions. These functions may be changed
@@ -6,7 +6,9 @@
 // allow switching out how private keys and their associated
 // certificates are managed, such as supporting PKCS #11. The
 // StandardProvider provides disk-backed PEM-encoded certificates and
-// private keys. DiskFallback is a provider that will attempt to
+// private keys. The MemoryProvider keeps its key in memory only, and
+// the PKCS11Provider uses a key that never leaves a PKCS #11 token,
+// such as an HSM. DiskFallback is a provider that will attempt to
 // retrieve the certificate from a CA first, falling back to a
 // disk-backed pair. This is useful for test a CA while providing a
 // failover solution.
@@ -91,6 +93,28 @@ type KeyProvider interface {
 	X509KeyPair() (tls.Certificate, error)
 }
 
+// New returns the KeyProvider selected by the profiles of id: a
+// PKCS11Provider with a "pkcs11" profile, a MemoryProvider with a
+// "memory" profile, and a StandardProvider otherwise.
+func New(id *core.Identity) (KeyProvider, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	_, pkcs11 := id.Profiles["pkcs11"]
+	_, memory := id.Profiles["memory"]
+	switch {
+	case pkcs11 && memory:
+		return nil, errors.New("transport: the pkcs11 and memory profiles are mutually exclusive")
+	case pkcs11:
+		return NewPKCS11Provider(id)
+	case memory:
+		return NewMemoryProvider(), nil
+	default:
+		return NewStandardProvider(id)
+	}
+}
+
 // StandardPaths contains a path to a key file and certificate file.
 type StandardPaths struct {
 	KeyFile  string `json:"private_key"`
@@ -185,33 +209,16 @@ func (sp *StandardProvider) Persistent() bool {
 	return sp.Paths.KeyFile != "" && sp.Paths.CertFile != ""
 }
 
-// Generate generates a new private key.
-func (sp *StandardProvider) Generate(algo string, size int) (err error) {
-	sp.resetKey()
-	sp.resetCert()
-
-	algo = strings.ToLower(algo)
-	switch algo {
+// generateKey generates a new RSA or ECDSA private key.
+func generateKey(algo string, size int) (crypto.Signer, error) {
+	switch strings.ToLower(algo) {
 	case "rsa":
-		var priv *rsa.PrivateKey
 		if size < 2048 {
-			return errors.New("transport: RSA keys must be at least 2048 bits")
+			return nil, errors.New("transport: RSA keys must be at least 2048 bits")
 		}
 
-		priv, err = rsa.GenerateKey(rand.Reader, size)
-		if err != nil {
-			return err
-		}
-
-		keyPEM := x509.MarshalPKCS1PrivateKey(priv)
-		p := &pem.Block{
-			Type:  "RSA PRIVATE KEY",
-			Bytes: keyPEM,
-		}
-		sp.internal.keyPEM = pem.EncodeToMemory(p)
-		sp.internal.priv = priv
+		return rsa.GenerateKey(rand.Reader, size)
 	case "ecdsa":
-		var priv *ecdsa.PrivateKey
 		var curve elliptic.Curve
 		switch size {
 		case curveP256:
@@ -221,30 +228,39 @@ func (sp *StandardProvider) Generate(algo string, size int) (err error) {
 		case curveP521:
 			curve = elliptic.P521()
 		default:
-			return errors.New("transport: invalid elliptic curve key size; only 256-, 384-, and 521-bit keys are accepted")
+			return nil, errors.New("transport: invalid elliptic curve key size; only 256-, 384-, and 521-bit keys are accepted")
 		}
 
-		priv, err = ecdsa.GenerateKey(curve, rand.Reader)
-		if err != nil {
-			return err
-		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	default:
+		return nil, errors.New("transport: invalid key algorithm; only RSA and ECDSA are supported")
+	}
+}
 
-		var keyPEM []byte
-		keyPEM, err = x509.MarshalECPrivateKey(priv)
+// Generate generates a new private key.
+func (sp *StandardProvider) Generate(algo string, size int) (err error) {
+	sp.resetKey()
+	sp.resetCert()
+
+	priv, err := generateKey(algo, size)
+	if err != nil {
+		return err
+	}
+
+	p := &pem.Block{}
+	switch priv := priv.(type) {
+	case *rsa.PrivateKey:
+		p.Type = "RSA PRIVATE KEY"
+		p.Bytes = x509.MarshalPKCS1PrivateKey(priv)
+	case *ecdsa.PrivateKey:
+		p.Type = "EC PRIVATE KEY"
+		p.Bytes, err = x509.MarshalECPrivateKey(priv)
 		if err != nil {
 			return err
 		}
-
-		p := &pem.Block{
-			Type:  "EC PRIVATE KEY",
-			Bytes: keyPEM,
-		}
-		sp.internal.keyPEM = pem.EncodeToMemory(p)
-
-		sp.internal.priv = priv
-	default:
-		return errors.New("transport: invalid key algorithm; only RSA and ECDSA are supported")
 	}
+	sp.internal.keyPEM = pem.EncodeToMemory(p)
+	sp.internal.priv = priv
 
 	return nil
 }
@@ -0,0 +1,68 @@
+package kp
+
+import (
+	"errors"
+
+	"github.com/ucosty/cfssl/csr"
+)
+
+// MemoryProvider keeps its private key and certificate in memory
+// only: the key is never marshaled, and is lost when the process
+// exits, so that a new key is generated and certified on every start.
+type MemoryProvider struct {
+	signerProvider
+}
+
+// NewMemoryProvider returns a MemoryProvider without a key.
+func NewMemoryProvider() *MemoryProvider {
+	return &MemoryProvider{}
+}
+
+// Check is provided to implement the KeyProvider interface; a
+// MemoryProvider needs no setup.
+func (mp *MemoryProvider) Check() error {
+	return nil
+}
+
+// Persistent returns false: nothing is stored.
+func (mp *MemoryProvider) Persistent() bool {
+	return false
+}
+
+// Generate generates a new private key, invalidating the certificate.
+func (mp *MemoryProvider) Generate(algo string, size int) error {
+	mp.reset(nil)
+
+	priv, err := generateKey(algo, size)
+	if err != nil {
+		return err
+	}
+	mp.reset(priv)
+	return nil
+}
+
+// CertificateRequest produces a CSR for the provider's key, generating
+// one from the request's key request if there is none.
+func (mp *MemoryProvider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	if mp.priv == nil {
+		if req.KeyRequest == nil {
+			return nil, errors.New("transport: invalid key request in csr.CertificateRequest")
+		}
+		if err := mp.Generate(req.KeyRequest.Algo(), req.KeyRequest.Size()); err != nil {
+			return nil, err
+		}
+	}
+	return mp.certificateRequest(req)
+}
+
+// Load is provided to implement the KeyProvider interface; there is
+// nothing to load.
+func (mp *MemoryProvider) Load() error {
+	return nil
+}
+
+// Store is provided to implement the KeyProvider interface; there is
+// nothing to store.
+func (mp *MemoryProvider) Store() error {
+	return nil
+}
@@ -0,0 +1,129 @@
+package kp
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"testing"
+
+	"github.com/ucosty/cfssl/csr"
+	"github.com/ucosty/cfssl/signer"
+	"github.com/ucosty/cfssl/signer/local"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+const (
+	testCaFile    = "../../signer/local/testdata/ca.pem"
+	testCaKeyFile = "../../signer/local/testdata/ca_key.pem"
+)
+
+// certify has the test CA sign a CSR produced by the provider.
+func certify(t *testing.T, p KeyProvider, req *csr.CertificateRequest) []byte {
+	s, err := local.NewSignerFromFile(testCaFile, testCaKeyFile, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	csrPEM, err := p.CertificateRequest(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certPEM, err := s.Sign(signer.SignRequest{Hosts: req.Hosts, Request: string(csrPEM)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return certPEM
+}
+
+func TestMemoryProvider(t *testing.T) {
+	mp := NewMemoryProvider()
+	if mp.Persistent() || mp.Ready() {
+		t.Fatal("memory provider should be neither persistent nor ready")
+	}
+	if _, err := mp.SignCSR(&x509.CertificateRequest{}); err != ErrNoKey {
+		t.Fatalf("expected ErrNoKey, got %v", err)
+	}
+
+	req := &csr.CertificateRequest{
+		CN:         "localhost",
+		Hosts:      []string{"localhost"},
+		KeyRequest: csr.NewBasicKeyRequest(),
+	}
+	certPEM := certify(t, mp, req)
+	if err := mp.SetCertificatePEM(certPEM); err != nil {
+		t.Fatal(err)
+	}
+	if !mp.Ready() || mp.Certificate().Subject.CommonName != "localhost" {
+		t.Fatal("memory provider should be ready")
+	}
+
+	cert, err := mp.X509KeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.Leaf == nil || cert.PrivateKey == nil {
+		t.Fatal("expected a parsed leaf and the private key")
+	}
+
+	// The server's handshake is signed with the provider's key.
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	go func() {
+		server := tls.Server(serverConn, &tls.Config{Certificates: []tls.Certificate{cert}})
+		server.Handshake()
+		server.Close()
+	}()
+	client := tls.Client(clientConn, &tls.Config{InsecureSkipVerify: true})
+	if err = client.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	if !client.ConnectionState().PeerCertificates[0].Equal(mp.Certificate()) {
+		t.Fatal("expected the provider's certificate")
+	}
+
+	der, err := mp.SignCSR(&x509.CertificateRequest{DNSNames: []string{"localhost"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if csr, err := x509.ParseCertificateRequest(der); err != nil || csr.CheckSignature() != nil {
+		t.Fatalf("expected a signed CSR: %v", err)
+	}
+
+	// A new key invalidates the certificate, which no longer matches.
+	if err = mp.Generate("ecdsa", 384); err != nil {
+		t.Fatal(err)
+	}
+	if mp.Ready() {
+		t.Fatal("a new key should invalidate the certificate")
+	}
+	if err = mp.SetCertificatePEM(certPEM); err == nil {
+		t.Fatal("expected a certificate for another key to be rejected")
+	}
+	if err = mp.Generate("rsa", 1024); err == nil {
+		t.Fatal("expected a short RSA key to be rejected")
+	}
+}
+
+func TestNew(t *testing.T) {
+	p, err := New(&core.Identity{Profiles: map[string]map[string]string{"memory": {}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*MemoryProvider); !ok {
+		t.Fatalf("expected a MemoryProvider, got %T", p)
+	}
+
+	p, err = New(testIdentity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.(*StandardProvider); !ok {
+		t.Fatalf("expected a StandardProvider, got %T", p)
+	}
+
+	if _, err = New(&core.Identity{Profiles: map[string]map[string]string{"memory": {}, "pkcs11": {}}}); err == nil {
+		t.Fatal("expected the pkcs11 and memory profiles to be exclusive")
+	}
+	if _, err = New(nil); err == nil {
+		t.Fatal("expected a nil identity to be rejected")
+	}
+}
@@ -0,0 +1,200 @@
+// +build pkcs11
+
+package kp
+
+import (
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/ucosty/cfssl/crypto/pkcs11key"
+	"github.com/ucosty/cfssl/csr"
+	"github.com/ucosty/cfssl/helpers/pkcs11uri"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// PKCS11Enabled is set to true if PKCS #11 support is present.
+const PKCS11Enabled = true
+
+// PKCS11Provider uses a private key held on a PKCS #11 token, such as
+// an HSM, which never leaves it. The key must be provisioned on the
+// token beforehand, with the token's own tools: Generate reopens it
+// rather than creating a new one. If CertFile is set, the certificate
+// is stored there.
+type PKCS11Provider struct {
+	signerProvider
+	Config   pkcs11key.Config
+	CertFile string
+
+	key *pkcs11key.Key
+}
+
+// NewPKCS11Provider sets up a PKCS11Provider from the "pkcs11"
+// profile of id, which takes the following keys:
+//
+//	uri         a PKCS #11 URI naming the module, token and key, such
+//	            as pkcs11:token=transport;object=tls?module-path=
+//	            /usr/lib/softhsm/libsofthsm2.so&pin-source=file:/etc/pin
+//	certificate the path the certificate is stored at, if any
+func NewPKCS11Provider(id *core.Identity) (KeyProvider, error) {
+	if id == nil {
+		return nil, errors.New("transport: the identity hasn't been initialised. Has it been loaded from disk?")
+	}
+
+	profile := id.Profiles["pkcs11"]
+	cfg, err := pkcs11uri.Parse(profile["uri"])
+	if err != nil {
+		return nil, err
+	}
+
+	pp := &PKCS11Provider{
+		Config:   *cfg,
+		CertFile: profile["certificate"],
+	}
+	if err = pp.Check(); err != nil {
+		return nil, err
+	}
+	return pp, nil
+}
+
+// Check ensures that the configuration names a module and a key.
+func (pp *PKCS11Provider) Check() error {
+	if pp.Config.Module == "" {
+		return errors.New("transport: the PKCS #11 provider has no module")
+	}
+	if pp.Config.PrivateKeyLabel == "" {
+		return errors.New("transport: the PKCS #11 provider has no private key label")
+	}
+	return nil
+}
+
+// Persistent returns true if the certificate is stored on disk; the
+// key always persists on the token.
+func (pp *PKCS11Provider) Persistent() bool {
+	return pp.CertFile != ""
+}
+
+// open opens a session with the token for its key. An open session
+// is reused, so that certificates already handed out by X509KeyPair
+// keep working.
+func (pp *PKCS11Provider) open() error {
+	if pp.key != nil {
+		return nil
+	}
+
+	key, err := pkcs11key.New(pp.Config.Module, pp.Config.TokenLabel, pp.Config.PIN, pp.Config.PrivateKeyLabel)
+	if err != nil {
+		return err
+	}
+	pp.key = key
+	pp.reset(key)
+	return nil
+}
+
+// reopen replaces a session that has failed, keeping the certificate:
+// the key on the token is the same.
+func (pp *PKCS11Provider) reopen() error {
+	cert, certPEM := pp.cert, pp.certPEM
+	pp.Close()
+	if err := pp.open(); err != nil {
+		return err
+	}
+	pp.cert, pp.certPEM = cert, certPEM
+	return nil
+}
+
+// Close closes the session with the token, if any.
+func (pp *PKCS11Provider) Close() {
+	if pp.key != nil {
+		pp.key.Destroy()
+		pp.key = nil
+	}
+	pp.reset(nil)
+}
+
+// Generate opens the token's key, invalidating the certificate. As
+// the key cannot be created here, an error is returned if it is not
+// of the requested algorithm and size.
+func (pp *PKCS11Provider) Generate(algo string, size int) error {
+	if err := pp.open(); err != nil {
+		return err
+	}
+	pp.reset(pp.key)
+
+	switch pub := pp.priv.Public().(type) {
+	case *rsa.PublicKey:
+		if strings.ToLower(algo) == "rsa" && pub.N.BitLen() == size {
+			return nil
+		}
+	case *ecdsa.PublicKey:
+		if strings.ToLower(algo) == "ecdsa" && pub.Curve.Params().BitSize == size {
+			return nil
+		}
+	}
+	return errors.New("transport: the PKCS #11 key does not match the key request; keys must be provisioned on the token")
+}
+
+// CertificateRequest produces a CSR for the token's key. If the
+// session fails, it is reopened and the request retried once.
+func (pp *PKCS11Provider) CertificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	if err := pp.open(); err != nil {
+		return nil, err
+	}
+	csrPEM, err := pp.certificateRequest(req)
+	if err == nil {
+		return csrPEM, nil
+	}
+	if err = pp.reopen(); err != nil {
+		return nil, err
+	}
+	return pp.certificateRequest(req)
+}
+
+// SignCSR signs a templated CSR with the token's key. If the session
+// fails, it is reopened and the request retried once.
+func (pp *PKCS11Provider) SignCSR(tpl *x509.CertificateRequest) ([]byte, error) {
+	if err := pp.open(); err != nil {
+		return nil, err
+	}
+	der, err := pp.signerProvider.SignCSR(tpl)
+	if err == nil {
+		return der, nil
+	}
+	if err = pp.reopen(); err != nil {
+		return nil, err
+	}
+	return pp.signerProvider.SignCSR(tpl)
+}
+
+// Load opens the token's key, reusing an open session, and loads the certificate from disk.
+func (pp *PKCS11Provider) Load() error {
+	if err := pp.open(); err != nil {
+		return err
+	}
+	if !pp.Persistent() {
+		return nil
+	}
+
+	certPEM, err := ioutil.ReadFile(pp.CertFile)
+	if os.IsNotExist(err) {
+		return ErrCertificateUnavailable
+	} else if err != nil {
+		return err
+	}
+	return pp.SetCertificatePEM(certPEM)
+}
+
+// Store writes the certificate to disk, if necessary.
+func (pp *PKCS11Provider) Store() error {
+	if !pp.Ready() {
+		return errors.New("transport: provider does not have a key and certificate")
+	}
+	if !pp.Persistent() {
+		return nil
+	}
+	return ioutil.WriteFile(pp.CertFile, pp.certPEM, 0644)
+}
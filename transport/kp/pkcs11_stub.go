@@ -0,0 +1,18 @@
+// +build !pkcs11
+
+package kp
+
+import (
+	"errors"
+
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// PKCS11Enabled is set to true if PKCS #11 support is present.
+const PKCS11Enabled = false
+
+// NewPKCS11Provider always returns an error. If PKCS #11 support is
+// needed, the program should be built with the `pkcs11` build tag.
+func NewPKCS11Provider(id *core.Identity) (KeyProvider, error) {
+	return nil, errors.New("transport: PKCS #11 support is not present; build with the pkcs11 tag")
+}
@@ -0,0 +1,70 @@
+// +build pkcs11
+
+package kp
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ucosty/cfssl/csr"
+	"github.com/ucosty/cfssl/transport/core"
+)
+
+// TestPKCS11Provider needs a token with an ECDSA P-256 key, such as one
+// made with SoftHSM:
+//
+//	softhsm2-util --init-token --free --label transport --pin 1234 --so-pin 1234
+//	pkcs11-tool --module /usr/lib/softhsm/libsofthsm2.so --token-label transport \
+//	    --login --pin 1234 --keypairgen --key-type EC:prime256v1 --label tls
+//	PKCS11_TEST_URI='pkcs11:token=transport;object=tls?module-path=/usr/lib/softhsm/libsofthsm2.so&pin-value=1234' \
+//	    go test -tags pkcs11 ./transport/kp
+func TestPKCS11Provider(t *testing.T) {
+	uri := os.Getenv("PKCS11_TEST_URI")
+	if uri == "" {
+		t.Skip("PKCS11_TEST_URI is not set")
+	}
+
+	certFile := filepath.Join(t.TempDir(), "cert.pem")
+	p, err := New(&core.Identity{Profiles: map[string]map[string]string{
+		"pkcs11": {"uri": uri, "certificate": certFile},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pp := p.(*PKCS11Provider)
+	defer pp.Close()
+
+	if err = pp.Load(); err != ErrCertificateUnavailable {
+		t.Fatalf("expected ErrCertificateUnavailable, got %v", err)
+	}
+	if err = pp.Generate("rsa", 2048); err == nil {
+		t.Fatal("expected a key request for another key to be rejected")
+	}
+	if err = pp.Generate("ecdsa", 256); err != nil {
+		t.Fatal(err)
+	}
+
+	req := &csr.CertificateRequest{CN: "localhost", Hosts: []string{"localhost"}}
+	if err = pp.SetCertificatePEM(certify(t, pp, req)); err != nil {
+		t.Fatal(err)
+	}
+	cert, err := pp.X509KeyPair()
+	if err != nil || cert.PrivateKey != pp.priv {
+		t.Fatalf("expected the token's key in the key pair: %v", err)
+	}
+	if err = pp.Store(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Reloading reuses the session, so the key pair stays usable.
+	if err = pp.Load(); err != nil || cert.PrivateKey != pp.priv {
+		t.Fatalf("expected the session to be reused: %v", err)
+	}
+
+	// The certificate is reloaded for the token's key.
+	pp.Close()
+	if err = pp.Load(); err != nil || !pp.Ready() {
+		t.Fatalf("expected the key and certificate to be loaded: %v", err)
+	}
+}
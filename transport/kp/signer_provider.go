@@ -0,0 +1,118 @@
+package kp
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+
+	"github.com/ucosty/cfssl/csr"
+	"github.com/ucosty/cfssl/helpers"
+)
+
+// ErrNoKey is returned when a provider is asked to use its key before
+// one has been generated or loaded.
+var ErrNoKey = errors.New("transport: the provider has no private key")
+
+// signerProvider holds a crypto.Signer and its certificate, without
+// ever marshaling the key. It implements the parts of KeyProvider that
+// do not depend on where the key is kept.
+type signerProvider struct {
+	priv    crypto.Signer
+	cert    *x509.Certificate
+	certPEM []byte
+}
+
+func (p *signerProvider) reset(priv crypto.Signer) {
+	p.priv = priv
+	p.cert = nil
+	p.certPEM = nil
+}
+
+// Certificate returns the associated certificate, or nil if one isn't
+// ready.
+func (p *signerProvider) Certificate() *x509.Certificate {
+	return p.cert
+}
+
+// Ready returns true if the provider has a key and certificate.
+func (p *signerProvider) Ready() bool {
+	return p.priv != nil && p.cert != nil
+}
+
+// SetCertificatePEM receives a PEM-encoded certificate for the
+// provider's key.
+func (p *signerProvider) SetCertificatePEM(certPEM []byte) error {
+	cert, err := p.parseCertificatePEM(certPEM)
+	if err != nil {
+		return err
+	}
+
+	p.cert = cert
+	p.certPEM = certPEM
+	return nil
+}
+
+// parseCertificatePEM parses a PEM-encoded certificate, checking that
+// it is for the provider's key.
+func (p *signerProvider) parseCertificatePEM(certPEM []byte) (*x509.Certificate, error) {
+	if p.priv == nil {
+		return nil, ErrNoKey
+	}
+
+	cert, err := helpers.ParseCertificatePEM(certPEM)
+	if err != nil {
+		return nil, errors.New("transport: invalid certificate")
+	}
+
+	certPub, err := x509.MarshalPKIXPublicKey(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pub, err := x509.MarshalPKIXPublicKey(p.priv.Public())
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(certPub, pub) {
+		return nil, errors.New("transport: the certificate does not match the provider's key")
+	}
+	return cert, nil
+}
+
+// SignalFailure is provided to implement the KeyProvider interface,
+// and always returns false.
+func (p *signerProvider) SignalFailure(err error) bool {
+	return false
+}
+
+// SignCSR takes a template certificate request and signs it.
+func (p *signerProvider) SignCSR(tpl *x509.CertificateRequest) ([]byte, error) {
+	if p.priv == nil {
+		return nil, ErrNoKey
+	}
+	return x509.CreateCertificateRequest(rand.Reader, tpl, p.priv)
+}
+
+// certificateRequest generates a CSR for the provider's key.
+func (p *signerProvider) certificateRequest(req *csr.CertificateRequest) ([]byte, error) {
+	if p.priv == nil {
+		return nil, ErrNoKey
+	}
+	return csr.Generate(p.priv, req)
+}
+
+// X509KeyPair returns a tls.Certificate whose private key is the
+// provider's crypto.Signer, with a parsed Leaf certificate.
+func (p *signerProvider) X509KeyPair() (tls.Certificate, error) {
+	if !p.Ready() {
+		return tls.Certificate{}, errors.New("transport: provider does not have a key and certificate")
+	}
+
+	return tls.Certificate{
+		Certificate: [][]byte{p.cert.Raw},
+		PrivateKey:  p.priv,
+		Leaf:        p.cert,
+	}, nil
+}